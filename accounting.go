@@ -0,0 +1,152 @@
+package httpmux
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Traffic accounting (server)
+//
+//   accounting:
+//     file: /var/lib/picotun/usage.jsonl
+//     interval: 300          # seconds between lines (default 300)
+//
+// Every visitor and forward connection the server relays is counted —
+// connections, bytes in and out — on its map, on the session that
+// carried it and on the session's account: the users: entry it
+// authenticated as, else its client_name, else "default". Maps and
+// accounts are in GET /api/stats and /metrics, sessions in
+// GET /api/sessions. Bonded connections span sessions and are counted
+// on their map only.
+//
+// With accounting.file every interval appends one UsageRecord line
+// with what was relayed since the previous line (and a last one on
+// shutdown), so lines from several runs can simply be summed for
+// billing or capacity planning.
+// ═══════════════════════════════════════════════════════════════
+
+type AccountingConfig struct {
+	File     string `yaml:"file"`     // "" = no usage file
+	Interval int    `yaml:"interval"` // seconds, default 300
+}
+
+const defaultAccountingInterval = 300
+
+// UsageRecord is one line of the accounting file: traffic during
+// [Since, Time).
+type UsageRecord struct {
+	Time     time.Time                   `json:"time"`
+	Since    time.Time                   `json:"since"`
+	Maps     map[string]MapStatsSnapshot `json:"maps,omitempty"`
+	Accounts map[string]MapStatsSnapshot `json:"accounts,omitempty"`
+}
+
+// account is who ss's traffic is billed to.
+func (ss *serverSession) account() string {
+	if ss.user != "" {
+		return ss.user
+	}
+	if name := ss.clientName(); name != "" {
+		return name
+	}
+	return "default"
+}
+
+// meter counts one relayed connection on ss and its account and
+// returns their counters for countedConn. nil ss gives nil counters.
+func (s *Server) meter(ss *serverSession) (sess, acct *mapStats) {
+	if ss == nil {
+		return nil, nil
+	}
+	acct = s.stats.accountEntry(ss.account())
+	atomic.AddInt64(&ss.traffic.conns, 1)
+	atomic.AddInt64(&acct.conns, 1)
+	return &ss.traffic, acct
+}
+
+// usageFile appends usage records to accounting.file.
+type usageFile struct {
+	path  string
+	every time.Duration
+	stats *Stats
+
+	mu   sync.Mutex
+	prev StatsSnapshot // what the last record was diffed against
+}
+
+// newUsageFile returns nil unless accounting.file is set.
+func newUsageFile(cfg *Config, stats *Stats) *usageFile {
+	a := cfg.Accounting
+	if a.File == "" {
+		return nil
+	}
+	every := time.Duration(a.Interval) * time.Second
+	if every <= 0 {
+		every = defaultAccountingInterval * time.Second
+	}
+	u := &usageFile{path: a.File, every: every, stats: stats, prev: stats.Snapshot()}
+	u.prev.Time = stats.start
+	return u
+}
+
+// run writes a record every interval until shutdown begins; Shutdown
+// writes the last one once the relays have drained.
+func (u *usageFile) run(life *lifecycle) {
+	if u == nil {
+		return
+	}
+	log.Printf("[USAGE] writing usage to %s every %v", u.path, u.every)
+	for life.sleep(u.every) {
+		u.write()
+	}
+}
+
+// write appends what was relayed since the previous record.
+func (u *usageFile) write() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	cur := u.stats.Snapshot()
+	rec := UsageRecord{
+		Time:     cur.Time,
+		Since:    u.prev.Time,
+		Maps:     usageDelta(cur.Maps, u.prev.Maps),
+		Accounts: usageDelta(cur.Accounts, u.prev.Accounts),
+	}
+	data, err := json.Marshal(rec)
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(u.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			_, err = f.Write(append(data, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		u.stats.incError("usage_file")
+		logDedupf("usage", "[USAGE] %s: %v", u.path, err)
+		return // the traffic goes into the next record
+	}
+	u.prev = cur
+}
+
+// usageDelta returns cur - prev, leaving out entries without traffic.
+func usageDelta(cur, prev map[string]MapStatsSnapshot) map[string]MapStatsSnapshot {
+	out := map[string]MapStatsSnapshot{}
+	for k, c := range cur {
+		p := prev[k]
+		d := MapStatsSnapshot{Conns: c.Conns - p.Conns, BytesIn: c.BytesIn - p.BytesIn, BytesOut: c.BytesOut - p.BytesOut}
+		if d != (MapStatsSnapshot{}) {
+			out[k] = d
+		}
+	}
+	return out
+}
@@ -0,0 +1,232 @@
+package httpmux
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Forward stream ACL (server)
+//
+//   acl:
+//     default: allow                  # allow (default) | deny
+//     rules:
+//       - { action: deny,  cidr: "10.0.0.0/8" }
+//       - { action: deny,  cidr: "127.0.0.0/8" }
+//       - { action: deny,  domain: ".internal" }
+//       - { action: allow, ports: "80,443,8000-8100" }
+//
+// Rules are checked in order and the first match decides; a rule
+// matches when every field it sets matches (cidr, domain suffix,
+// ports). Applies to forward streams and SOCKS5 UDP associations.
+//
+// Domain targets are resolved on the server before cidr rules are
+// checked, and the connection goes to the address that was checked,
+// so a name pointing at a denied range (or re-pointed to one between
+// check and dial) can't get around the rules.
+// ═══════════════════════════════════════════════════════════════
+
+type ACLConfig struct {
+	Default string    `yaml:"default"`
+	Rules   []ACLRule `yaml:"rules"`
+}
+
+type ACLRule struct {
+	Action string `yaml:"action"` // allow | deny
+	CIDR   string `yaml:"cidr"`
+	Domain string `yaml:"domain"` // suffix: ".corp" or "example.com"
+	Ports  string `yaml:"ports"`  // "22", "80,443", "8000-8100"
+}
+
+type portRange struct{ lo, hi int }
+
+type aclRule struct {
+	allow  bool
+	net    *net.IPNet
+	domain string
+	ports  []portRange
+}
+
+type acl struct {
+	allowDefault bool
+	rules        []aclRule
+	needsIP      bool // some rule has a cidr: resolve names first
+	resolver     *resolver
+}
+
+// newACL compiles cfg. A nil *acl allows everything.
+func newACL(cfg *ACLConfig) (*acl, error) {
+	def := strings.ToLower(strings.TrimSpace(cfg.Default))
+	if len(cfg.Rules) == 0 && def != "deny" {
+		return nil, nil
+	}
+	a := &acl{}
+	switch def {
+	case "", "allow":
+		a.allowDefault = true
+	case "deny":
+	default:
+		return nil, fmt.Errorf("default %q: want allow or deny", cfg.Default)
+	}
+	for i, r := range cfg.Rules {
+		var ar aclRule
+		switch strings.ToLower(strings.TrimSpace(r.Action)) {
+		case "allow":
+			ar.allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("rule %d: action %q: want allow or deny", i+1, r.Action)
+		}
+		if c := strings.TrimSpace(r.CIDR); c != "" {
+			if !strings.Contains(c, "/") {
+				if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+					c += "/32"
+				} else {
+					c += "/128"
+				}
+			}
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i+1, err)
+			}
+			ar.net = n
+			a.needsIP = true
+		}
+		ar.domain = strings.ToLower(strings.TrimSpace(r.Domain))
+		if p := strings.TrimSpace(r.Ports); p != "" {
+			pr, err := parsePortRanges(p)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i+1, err)
+			}
+			ar.ports = pr
+		}
+		a.rules = append(a.rules, ar)
+	}
+	return a, nil
+}
+
+func parsePortRanges(s string) ([]portRange, error) {
+	var out []portRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("ports %q", s)
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+				return nil, fmt.Errorf("ports %q", s)
+			}
+		}
+		if a < 0 || b > 65535 || a > b {
+			return nil, fmt.Errorf("ports %q", s)
+		}
+		out = append(out, portRange{a, b})
+	}
+	return out, nil
+}
+
+// matches reports whether r applies to host (lower-case name, or ""),
+// ip (nil when unresolved) and port.
+func (r *aclRule) matches(host string, ip net.IP, port int) bool {
+	if r.net != nil && (ip == nil || !r.net.Contains(ip)) {
+		return false
+	}
+	if r.domain != "" {
+		if host == "" {
+			return false
+		}
+		d := strings.TrimPrefix(r.domain, ".")
+		if host != d && !strings.HasSuffix(host, "."+d) {
+			return false
+		}
+	}
+	if len(r.ports) > 0 {
+		in := false
+		for _, p := range r.ports {
+			if port >= p.lo && port <= p.hi {
+				in = true
+				break
+			}
+		}
+		if !in {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *acl) decide(host string, ip net.IP, port int) bool {
+	for i := range a.rules {
+		if a.rules[i].matches(host, ip, port) {
+			return a.rules[i].allow
+		}
+	}
+	return a.allowDefault
+}
+
+// check returns the address to dial for addr ("host:port"), or false
+// if the rules refuse it. Resolved names come back as "ip:port".
+func (a *acl) check(addr string) (string, bool) {
+	if a == nil {
+		return addr, true
+	}
+	h, ps, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false
+	}
+	port, err := strconv.Atoi(ps)
+	if err != nil {
+		return "", false
+	}
+	if ip := net.ParseIP(h); ip != nil {
+		return addr, a.decide("", ip, port)
+	}
+	host := strings.ToLower(strings.TrimSuffix(h, "."))
+	if !a.needsIP {
+		return addr, a.decide(host, nil, port)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ips, err := a.resolver.lookupIP(ctx, host)
+	cancel()
+	if err != nil {
+		return "", false
+	}
+	for _, ip := range ips {
+		if a.decide(host, ip, port) {
+			return net.JoinHostPort(ip.String(), ps), true
+		}
+	}
+	return "", false
+}
+
+// aclCheck applies the server's ACL to a forward target and logs
+// refusals.
+func (s *Server) aclCheck(network, addr string) (string, bool) {
+	dial, ok := s.acl.check(addr)
+	if !ok {
+		s.stats.incError("acl_denied")
+		if s.Verbose {
+			logDedupf("acl"+addr, "[ACL] denied %s://%s", network, addr)
+		}
+	}
+	return dial, ok
+}
+
+func logACL(a *acl) {
+	if a == nil {
+		return
+	}
+	def := "allow"
+	if !a.allowDefault {
+		def = "deny"
+	}
+	log.Printf("[ACL] %d rule(s), default %s", len(a.rules), def)
+}
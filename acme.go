@@ -0,0 +1,88 @@
+package httpmux
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Server TLS (httpsmux / wssmux)
+//
+// Certificates come from one of:
+//   • acme: block — obtained and renewed automatically from
+//     Let's Encrypt (or any ACME directory) for the fake domain
+//   • cert_file / key_file — static PEM files
+//
+//   acme:
+//     enabled: true
+//     email: "ops@example.com"
+//     domains: ["cdn.example.com"]   # default: mimic.fake_domain
+//     challenge: "tls-alpn-01"       # or "http-01" (needs :80)
+//
+// Only http/1.1 is offered over ALPN: the tunnel upgrade needs a
+// hijackable connection, which HTTP/2 can't provide.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	acmeChallengeTLSALPN = "tls-alpn-01"
+	acmeChallengeHTTP    = "http-01"
+)
+
+type ACMEConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Email        string   `yaml:"email"`
+	Domains      []string `yaml:"domains"`
+	CacheDir     string   `yaml:"cache_dir"`
+	Challenge    string   `yaml:"challenge"`
+	HTTPAddr     string   `yaml:"http_addr"`     // http-01 listener
+	DirectoryURL string   `yaml:"directory_url"` // "" = Let's Encrypt production
+}
+
+func applyACMEDefaults(c *Config) {
+	a := &c.ACME
+	if !a.Enabled {
+		return
+	}
+	a.Challenge = strings.ToLower(strings.TrimSpace(a.Challenge))
+	if a.Challenge == "" {
+		a.Challenge = acmeChallengeTLSALPN
+	}
+	if a.CacheDir == "" {
+		a.CacheDir = "/var/lib/picotun/acme"
+	}
+	if a.HTTPAddr == "" {
+		a.HTTPAddr = ":80"
+	}
+	if len(a.Domains) == 0 && c.Mimic.FakeDomain != "" {
+		a.Domains = []string{c.Mimic.FakeDomain}
+	}
+}
+
+// serverTLSConfig returns the TLS config for the tunnel listeners, or
+// nil when the server should speak plain HTTP.
+func (s *Server) serverTLSConfig() (*tls.Config, error) {
+	cfg := s.Config
+	if !TransportUsesTLS(cfg.Transport) {
+		return nil, nil
+	}
+
+	if cfg.ACME.Enabled {
+		return s.acmeTLSConfig()
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load cert_file/key_file: %w", err)
+		}
+		log.Printf("[TLS] using %s", cfg.CertFile)
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"http/1.1"},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	}
+	log.Printf("[TLS] %s without cert_file or acme — serving plain HTTP (expecting TLS termination in front)", cfg.Transport)
+	return nil, nil
+}
@@ -0,0 +1,53 @@
+//go:build !no_acme
+
+package httpmux
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func (s *Server) acmeTLSConfig() (*tls.Config, error) {
+	a := &s.Config.ACME
+	if len(a.Domains) == 0 {
+		return nil, fmt.Errorf("acme: no domains (set acme.domains or mimic.fake_domain)")
+	}
+	if a.Challenge != acmeChallengeTLSALPN && a.Challenge != acmeChallengeHTTP {
+		return nil, fmt.Errorf("acme: unknown challenge %q", a.Challenge)
+	}
+
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(a.CacheDir),
+		HostPolicy: autocert.HostWhitelist(a.Domains...),
+		Email:      a.Email,
+	}
+	if a.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: a.DirectoryURL}
+	}
+
+	if a.Challenge == acmeChallengeHTTP {
+		srv := &http.Server{
+			Addr:    a.HTTPAddr,
+			Handler: m.HTTPHandler(http.HandlerFunc(s.handleDecoy)),
+		}
+		s.life.track(srv)
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !s.life.isClosing() {
+				log.Printf("[ACME] http-01 listener %s: %v", a.HTTPAddr, err)
+			}
+		}()
+	}
+
+	log.Printf("[ACME] %s for %v (cache %s)", a.Challenge, a.Domains, a.CacheDir)
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"http/1.1", acme.ALPNProto},
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
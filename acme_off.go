@@ -0,0 +1,12 @@
+//go:build no_acme
+
+package httpmux
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+func (s *Server) acmeTLSConfig() (*tls.Config, error) {
+	return nil, fmt.Errorf("acme: this binary was built with -tags no_acme; use cert_file/key_file")
+}
@@ -0,0 +1,94 @@
+package httpmux
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Admin API (server)
+//
+//   admin:
+//     listen: "127.0.0.1:9090"
+//     token: "long-random-string"   # required
+//
+// Every /api/ and /metrics request needs "Authorization: Bearer <token>".
+//
+//   GET    /api/sessions                  live sessions
+//   DELETE /api/sessions/{id}             kick one
+//   GET    /api/maps                      listening maps
+//   POST   /api/maps                      add a map (maps: entry as JSON)
+//   DELETE /api/maps?type=tcp&bind=8080   stop a map
+//   GET    /api/config                    effective config, secrets redacted
+//   GET    /api/stats                     traffic counters
+//   GET    /api/history                   recent session up/down events
+//   GET    /metrics                       the stats for Prometheus
+//   GET    /                              dashboard (see dashboard.go)
+//
+// Maps added here live until restart; they are not written back to
+// the config file. Removing a map stops new visitors only, open
+// connections run to completion.
+//
+// Built with -tags no_admin the API is left out of the binary.
+// ═══════════════════════════════════════════════════════════════
+
+type AdminConfig struct {
+	Listen string `yaml:"listen"` // "" = disabled
+	Token  string `yaml:"token"`
+}
+
+func (s *Server) startAdmin() {
+	a := &s.Config.Admin
+	if a.Listen == "" {
+		return
+	}
+	if a.Token == "" {
+		log.Printf("[ADMIN] admin.listen set without admin.token — not starting")
+		return
+	}
+	handler := s.adminHandler()
+	if handler == nil {
+		log.Printf("[ADMIN] this binary was built without the admin API (no_admin)")
+		return
+	}
+	srv := &http.Server{
+		Addr:              a.Listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.life.track(srv)
+	log.Printf("[ADMIN] API on %s", a.Listen)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[ADMIN] %v", err)
+		}
+	}()
+}
+
+func (s *Server) adminAuth(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.Config.Admin.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			adminError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func adminJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func adminError(w http.ResponseWriter, code int, msg string) {
+	adminJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,391 @@
+//go:build !no_admin
+
+package httpmux
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+func (s *Server) adminHandler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/sessions", s.adminSessions)
+	api.HandleFunc("DELETE /api/sessions/{id}", s.adminKick)
+	api.HandleFunc("GET /api/maps", s.adminMaps)
+	api.HandleFunc("POST /api/maps", s.adminAddMap)
+	api.HandleFunc("DELETE /api/maps", s.adminRemoveMap)
+	api.HandleFunc("GET /api/config", s.adminConfig)
+	api.HandleFunc("GET /api/stats", s.adminStats)
+	api.HandleFunc("GET /api/history", s.adminHistory)
+	api.HandleFunc("GET /api/users", s.adminUsers)
+	api.HandleFunc("GET /api/session-log", s.adminSessionLog)
+	api.HandleFunc("GET /api/probes", s.adminProbes)
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/", s.adminAuth(api))
+	mux.Handle("GET /metrics", s.adminAuth(http.HandlerFunc(s.adminMetrics)))
+	// The page itself holds no data; it asks for the token and sends
+	// it with every API call.
+	mux.HandleFunc("GET /{$}", serveDashboard)
+	return mux
+}
+
+// ──────────── Sessions ────────────
+
+type adminSession struct {
+	ID        uint64  `json:"id"`
+	Remote    string  `json:"remote"`
+	User      string  `json:"user,omitempty"`
+	Name      string  `json:"name,omitempty"`
+	Tag       string  `json:"tag,omitempty"`
+	Proto     string  `json:"proto"`
+	UptimeSec int64   `json:"uptime_sec"`
+	Streams   int64   `json:"streams"`
+	RTTms     float64 `json:"rtt_ms,omitempty"`
+	Account   string  `json:"account"`
+	Conns     int64   `json:"conns"`
+	BytesIn   int64   `json:"bytes_in"`
+	BytesOut  int64   `json:"bytes_out"`
+}
+
+func (s *Server) adminSessions(w http.ResponseWriter, r *http.Request) {
+	s.poolMu.RLock()
+	out := make([]adminSession, 0, len(s.sessions))
+	for _, ss := range s.sessions {
+		t := ss.traffic.snapshot()
+		out = append(out, adminSession{
+			ID:        ss.id,
+			Remote:    ss.remote,
+			User:      ss.user,
+			Name:      ss.clientName(),
+			Tag:       ss.tag(),
+			Proto:     ss.info.Load().protoString(),
+			UptimeSec: int64(time.Since(ss.created).Seconds()),
+			Streams:   atomic.LoadInt64(&ss.streams),
+			RTTms:     float64(atomic.LoadInt64(&ss.rtt)) / 1e6,
+			Account:   ss.account(),
+			Conns:     t.Conns,
+			BytesIn:   t.BytesIn,
+			BytesOut:  t.BytesOut,
+		})
+	}
+	s.poolMu.RUnlock()
+	adminJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) adminKick(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		adminError(w, http.StatusBadRequest, "bad session id")
+		return
+	}
+	var victim *serverSession
+	s.poolMu.RLock()
+	for _, ss := range s.sessions {
+		if ss.id == id {
+			victim = ss
+			break
+		}
+	}
+	s.poolMu.RUnlock()
+	if victim == nil {
+		adminError(w, http.StatusNotFound, "no such session")
+		return
+	}
+	victim.sess.Close()
+	s.removeSession(victim)
+	log.Printf("[ADMIN] kicked session %d (%s%s)", id, victim.remote, victim.userTag())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ──────────── Stats ────────────
+
+type adminStatsReply struct {
+	StatsSnapshot
+	ActiveConns int64 `json:"active_conns"`
+	Sessions    int64 `json:"sessions"`
+}
+
+func (s *Server) adminStats(w http.ResponseWriter, r *http.Request) {
+	reply := adminStatsReply{StatsSnapshot: s.stats.Snapshot()}
+	reply.ActiveConns, reply.Sessions = s.stats.Active()
+	adminJSON(w, http.StatusOK, reply)
+}
+
+func (s *Server) adminHistory(w http.ResponseWriter, r *http.Request) {
+	adminJSON(w, http.StatusOK, s.stats.History())
+}
+
+// ──────────── State store ────────────
+
+func (s *Server) adminUsers(w http.ResponseWriter, r *http.Request) {
+	if s.state == nil {
+		adminError(w, http.StatusNotFound, "no state.path configured")
+		return
+	}
+	users, err := s.state.Users()
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	adminJSON(w, http.StatusOK, users)
+}
+
+// adminSessionLog serves the stored session log, newest ?limit=
+// (default 500) entries.
+func (s *Server) adminSessionLog(w http.ResponseWriter, r *http.Request) {
+	if s.state == nil {
+		adminError(w, http.StatusNotFound, "no state.path configured")
+		return
+	}
+	limit := 500
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			adminError(w, http.StatusBadRequest, "bad limit")
+			return
+		}
+		limit = n
+	}
+	events, err := s.state.db.sessionLog(limit)
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	adminJSON(w, http.StatusOK, events)
+}
+
+// adminProbes lists the hosts the probe detector scored (decoy.go),
+// with honeypot passwords masked.
+func (s *Server) adminProbes(w http.ResponseWriter, r *http.Request) {
+	adminJSON(w, http.StatusOK, s.probes.snapshot())
+}
+
+// adminMetrics serves the counters in the Prometheus text format.
+func (s *Server) adminMetrics(w http.ResponseWriter, r *http.Request) {
+	snap := s.stats.Snapshot()
+	conns, sessions := s.stats.Active()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("picotun_uptime_seconds", "gauge", "Seconds since the server started.")
+	fmt.Fprintf(w, "picotun_uptime_seconds %d\n", snap.UptimeSec)
+	metric("picotun_sessions", "gauge", "Tunnel sessions up.")
+	fmt.Fprintf(w, "picotun_sessions %d\n", sessions)
+	metric("picotun_active_connections", "gauge", "Connections being relayed.")
+	fmt.Fprintf(w, "picotun_active_connections %d\n", conns)
+	metric("picotun_rtt_seconds", "gauge", "Smoothed tunnel round trip.")
+	fmt.Fprintf(w, "picotun_rtt_seconds %g\n", snap.RTTms/1000)
+	metric("picotun_bytes_total", "counter", "Bytes relayed; in is towards the tunnel.")
+	fmt.Fprintf(w, "picotun_bytes_total{direction=\"in\"} %d\n", snap.BytesIn)
+	fmt.Fprintf(w, "picotun_bytes_total{direction=\"out\"} %d\n", snap.BytesOut)
+
+	traffic := func(prefix, label string, entries map[string]MapStatsSnapshot) {
+		names := make([]string, 0, len(entries))
+		for k := range entries {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		metric(prefix+"_connections_total", "counter", "Connections relayed per "+label+".")
+		for _, k := range names {
+			fmt.Fprintf(w, "%s_connections_total{%s=%q} %d\n", prefix, label, k, entries[k].Conns)
+		}
+		metric(prefix+"_bytes_total", "counter", "Bytes relayed per "+label+".")
+		for _, k := range names {
+			fmt.Fprintf(w, "%s_bytes_total{%s=%q,direction=\"in\"} %d\n", prefix, label, k, entries[k].BytesIn)
+			fmt.Fprintf(w, "%s_bytes_total{%s=%q,direction=\"out\"} %d\n", prefix, label, k, entries[k].BytesOut)
+		}
+	}
+	traffic("picotun_map", "map", snap.Maps)
+	traffic("picotun_account", "account", snap.Accounts)
+	maps := make([]string, 0, len(snap.Maps))
+	for k := range snap.Maps {
+		maps = append(maps, k)
+	}
+	sort.Strings(maps)
+	metric("picotun_map_udp_dropped_total", "counter", "UDP packets dropped at a full flow queue per map.")
+	for _, k := range maps {
+		if snap.Maps[k].Dropped > 0 {
+			fmt.Fprintf(w, "picotun_map_udp_dropped_total{map=%q} %d\n", k, snap.Maps[k].Dropped)
+		}
+	}
+
+	kinds := make([]string, 0, len(snap.Errors))
+	for k := range snap.Errors {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	metric("picotun_errors_total", "counter", "Errors by kind.")
+	for _, k := range kinds {
+		fmt.Fprintf(w, "picotun_errors_total{kind=%q} %d\n", k, snap.Errors[k])
+	}
+}
+
+// ──────────── Maps ────────────
+
+type adminMap struct {
+	Type    string `json:"type"`
+	Bind    string `json:"bind"`
+	Target  string `json:"target"`
+	Runtime bool   `json:"runtime"`
+	Client  string `json:"client,omitempty"` // id of the client that exposed it
+}
+
+func (s *Server) adminMaps(w http.ResponseWriter, r *http.Request) {
+	s.mapsMu.Lock()
+	out := make([]adminMap, 0, len(s.maps))
+	for _, am := range s.maps {
+		out = append(out, adminMap{Type: am.network, Bind: am.bind, Target: am.target, Runtime: am.runtime, Client: am.owner})
+	}
+	s.mapsMu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bind != out[j].Bind {
+			return out[i].Bind < out[j].Bind
+		}
+		return out[i].Type < out[j].Type
+	})
+	adminJSON(w, http.StatusOK, out)
+}
+
+// adminAddMap takes a maps: entry. The body is parsed as YAML, which
+// also accepts JSON, so field names are the config's ("idle_keep").
+func (s *Server) adminAddMap(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var pm PortMap
+	if err := yaml.Unmarshal(body, &pm); err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pm.Compress = strings.ToLower(strings.TrimSpace(pm.Compress))
+	if !validCompress(pm.Compress) {
+		adminError(w, http.StatusBadRequest, fmt.Sprintf("unknown compress %q", pm.Compress))
+		return
+	}
+	if _, err := parseRate(pm.Rate); err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	pm.Priority = strings.ToLower(strings.TrimSpace(pm.Priority))
+	if !validPriority(pm.Priority) {
+		adminError(w, http.StatusBadRequest, fmt.Sprintf("unknown priority %q", pm.Priority))
+		return
+	}
+	if len(pm.VHosts) > 0 && len(pm.TLSPassthrough) > 0 {
+		adminError(w, http.StatusBadRequest, "vhosts and tls_passthrough are exclusive")
+		return
+	}
+	if pm.TLSPassthrough, err = normalizeRoutes(pm.TLSPassthrough); err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if pm.VHosts, err = normalizeRoutes(pm.VHosts); err != nil {
+		adminError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !validMSS(pm.MSS) {
+		adminError(w, http.StatusBadRequest, fmt.Sprintf("mss %d: want %d-%d", pm.MSS, mssMin, mssMax))
+		return
+	}
+	kind := strings.ToLower(strings.TrimSpace(pm.Type))
+	target := strings.TrimSpace(pm.Target)
+	if kind == "echo" {
+		kind, target = "tcp", echoTarget
+	}
+	bind, target, ok := SplitMap(pm.Bind + "->" + target)
+	if !ok {
+		adminError(w, http.StatusBadRequest, "bind and target are required")
+		return
+	}
+	var networks []string
+	switch kind {
+	case "", "tcp":
+		networks = []string{"tcp"}
+	case "udp":
+		networks = []string{"udp"}
+	case "both":
+		networks = []string{"tcp", "udp"}
+	default:
+		adminError(w, http.StatusBadRequest, fmt.Sprintf("unknown type %q", pm.Type))
+		return
+	}
+	for i, network := range networks {
+		if err := s.openMap(network, bind, target, &pm); err != nil {
+			for _, opened := range networks[:i] {
+				s.closeMap(opened, bind)
+			}
+			adminError(w, http.StatusConflict, err.Error())
+			return
+		}
+	}
+	s.pushMapsAll()
+	log.Printf("[ADMIN] added map %s %s → %s", strings.Join(networks, "+"), bind, target)
+	adminJSON(w, http.StatusCreated, adminMap{Type: strings.Join(networks, "+"), Bind: bind, Target: target, Runtime: true})
+}
+
+func (s *Server) adminRemoveMap(w http.ResponseWriter, r *http.Request) {
+	network := strings.ToLower(r.URL.Query().Get("type"))
+	if network == "" {
+		network = "tcp"
+	}
+	bind, _, ok := SplitMap(r.URL.Query().Get("bind") + "->x")
+	if !ok || (network != "tcp" && network != "udp") {
+		adminError(w, http.StatusBadRequest, "want ?type=tcp|udp&bind=...")
+		return
+	}
+	if !s.closeMap(network, bind) {
+		adminError(w, http.StatusNotFound, "no such map")
+		return
+	}
+	s.pushMapsAll()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ──────────── Config ────────────
+
+func (s *Server) adminConfig(w http.ResponseWriter, r *http.Request) {
+	c := *s.Config
+	if c.PSK != "" {
+		c.PSK = redacted
+	}
+	c.Admin.Token = redacted
+	c.Users = append([]UserConfig(nil), c.Users...)
+	for i := range c.Users {
+		c.Users[i].PSK = redacted
+	}
+	// Runtime maps are listed after the file's; "both" maps share one entry.
+	var extra []PortMap
+	seen := map[*PortMap]bool{}
+	s.mapsMu.Lock()
+	for _, am := range s.maps {
+		if am.runtime && !seen[am.pm] {
+			seen[am.pm] = true
+			extra = append(extra, *am.pm)
+		}
+	}
+	s.mapsMu.Unlock()
+	sort.Slice(extra, func(i, j int) bool { return extra[i].Bind < extra[j].Bind })
+	c.Maps = append(append([]PortMap(nil), c.Maps...), extra...)
+	out, err := yaml.Marshal(&c)
+	if err != nil {
+		adminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+}
@@ -0,0 +1,7 @@
+//go:build no_admin
+
+package httpmux
+
+import "net/http"
+
+func (s *Server) adminHandler() http.Handler { return nil }
@@ -0,0 +1,109 @@
+package httpmux
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Aggressive pool (aggressive_pool, set by the speed and gaming
+// profiles)
+//
+//   paths:
+//     - { transport: httpmux, addr: "1.2.3.4:2020", aggressive_pool: true }
+//
+// A path with aggressive_pool trades a few extra connections for less
+// time without sessions:
+//
+//   ① every connect races two dials, the second started heStagger
+//      after the first (or when it fails); the loser is closed
+//   ② a lost session is replaced right away instead of after
+//      retry_interval and the graduated backoff — until
+//      maxFailsBeforeSwitch connects in a row fail, then the usual
+//      backoff applies so a down server isn't hammered
+//   ③ when the path's sessions carry spareLoad streams each on
+//      average, a spare session is opened (up to connection_pool
+//      spares); spares are retired, draining like session_max_age,
+//      once the load falls under half that
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	spareLoad  = 32 // average streams per session that opens a spare
+	spareCheck = 2 * time.Second
+)
+
+// raceConnect runs dial twice, staggered, and keeps the first
+// connection.
+func raceConnect(ctx context.Context, timeout time.Duration, dial func() (net.Conn, error)) (net.Conn, error) {
+	return raceDial(ctx, []string{"", ""}, time.Now().Add(timeout),
+		func(context.Context, string, time.Duration) (net.Conn, error) { return dial() })
+}
+
+// aggressiveRetry reports whether a worker on path should reconnect
+// without backing off after failCount quick failures.
+func aggressiveRetry(path PathConfig, failCount int) bool {
+	return path.AggressivePool && failCount < maxFailsBeforeSwitch
+}
+
+// spareMonitor opens and retires spare sessions on aggressive_pool
+// paths until shutdown. nextID numbers the spares' log lines after the
+// pool workers.
+func (c *Client) spareMonitor(ctx context.Context, nextID int) {
+	enabled := false
+	for _, p := range c.paths {
+		enabled = enabled || p.AggressivePool
+	}
+	if !enabled {
+		return
+	}
+	spares := make([]atomic.Int32, len(c.paths)) // open or connecting
+	for c.life.sleep(spareCheck) {
+		sessions := make([]int, len(c.paths))
+		streams := make([]int, len(c.paths))
+		spare := make([]*clientSession, len(c.paths))
+		c.sessMu.RLock()
+		for _, cs := range c.sessions {
+			sessions[cs.path]++
+			streams[cs.path] += cs.sess.NumStreams()
+			if cs.spare {
+				spare[cs.path] = cs
+			}
+		}
+		c.sessMu.RUnlock()
+
+		for p, path := range c.paths {
+			if !path.AggressivePool || sessions[p] == 0 {
+				continue
+			}
+			switch {
+			case streams[p] >= spareLoad*sessions[p] && int(spares[p].Load()) < c.poolSize(path):
+				spares[p].Add(1)
+				log.Printf("[POOL] path %d: %d streams on %d session(s), opening a spare", p, streams[p], sessions[p])
+				go c.spareWorker(ctx, nextID, p, &spares[p])
+				nextID++
+			case spare[p] != nil && streams[p] < spareLoad/2*sessions[p]:
+				go c.retireSession(spare[p])
+			}
+		}
+	}
+}
+
+// spareWorker runs one spare session on pathIdx; it is not replaced
+// when it ends.
+func (c *Client) spareWorker(ctx context.Context, id, pathIdx int, count *atomic.Int32) {
+	defer guardPanic("spare worker")
+	defer count.Add(-1)
+	err := c.connectAndServe(ctx, id, pathIdx, c.paths[pathIdx], nil, true)
+	var ae agedError
+	if errors.As(err, &ae) {
+		c.retireSession(ae.cs)
+		return
+	}
+	if err != nil && c.verbose && !c.life.isClosing() {
+		logDedupf("", "[POOL#%d] spare: %v", id, err)
+	}
+}
@@ -0,0 +1,322 @@
+package httpmux
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// PSK-authenticated key exchange (tunnel authentication)
+//
+// Knowing the fake domain and sending a websocket upgrade is not
+// enough to get a smux session. Right after the 101 the server sends
+// a random nonce and an ephemeral X25519 key; the client answers with
+// its own ephemeral key and an HMAC over the whole transcript. All
+// messages are raw random-looking bytes, so the exchange adds no
+// plaintext markers to the wire. A wrong proof closes the conn before
+// EncryptedConn or smux state is ever allocated.
+//
+//   server → client : [32B nonce][32B server X25519 pub]
+//   client → server : [32B client X25519 pub]
+//                     [32B HMAC(psk, authLabel || nonce || spub || cpub || mode)]
+//
+//   session key = HMAC(psk, keyLabel || X25519(priv, peer) || nonce)
+//
+// The AES key is fresh per connection and the ephemeral private keys
+// are discarded, so a PSK leaked later can't decrypt captured traffic
+// (forward secrecy). The PSK in the key derivation means a MITM that
+// swaps public keys can't derive the key either. mode is the path's
+// encryption mode (integrity.go); the server tries each one it allows.
+//
+// With auth_time_skew (seconds, set on both ends) the client also
+// sends the time, and the server answers a valid proof with its own:
+//
+//   client → server : [32B client pub][8B time ^ HMAC(psk, timeLabel || nonce)]
+//                     [32B HMAC(psk, authLabelTimed || ... || mode || time)]
+//   server → client : [8B time ^ HMAC(session key, timeLabel)]
+//
+// Each end rejects the other when the clocks are further apart than
+// its auth_time_skew and says so in the log ("client clock 3h ahead"),
+// instead of a wrong-PSK or decrypt error. The fresh server nonce
+// already stops a captured handshake from being replayed as is; the
+// time also bounds how long a proof held back by a middlebox stays
+// good. Both times are masked, so the wire still carries no plaintext
+// marker, and a peer without the PSK learns neither. The server's
+// answer costs the client one round trip before the session starts.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	authNonceSize = 32
+	authPubSize   = 32
+	authProofSize = sha256.Size
+	authTimeout   = 10 * time.Second
+)
+
+const authTimeSize = 8
+
+var (
+	authLabel      = []byte("picotun-auth-v2")
+	authLabelTimed = []byte("picotun-auth-v3")
+	keyLabel       = []byte("picotun-key-v1")
+	timeLabel      = []byte("picotun-time-v1")
+)
+
+var errAuthFailed = errors.New("auth: invalid proof")
+
+func newAuthNonce() ([]byte, error) {
+	nonce := make([]byte, authNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("auth nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// authProof is the client's proof; stamp is its time, nil without
+// auth_time_skew.
+func authProof(psk string, nonce, serverPub, clientPub []byte, mode byte, stamp []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(psk))
+	if stamp != nil {
+		mac.Write(authLabelTimed)
+	} else {
+		mac.Write(authLabel)
+	}
+	mac.Write(nonce)
+	mac.Write(serverPub)
+	mac.Write(clientPub)
+	mac.Write([]byte{mode})
+	mac.Write(stamp)
+	return mac.Sum(nil)
+}
+
+// maskTime XORs a big-endian Unix time with HMAC(key, timeLabel ||
+// salt); it both masks and unmasks.
+func maskTime(b []byte, key, salt []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(timeLabel)
+	mac.Write(salt)
+	pad := mac.Sum(nil)
+	out := make([]byte, authTimeSize)
+	for i := range out {
+		out[i] = b[i] ^ pad[i]
+	}
+	return out
+}
+
+func timeStamp(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.Unix()))
+}
+
+func stampTime(b []byte) time.Time {
+	return time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+}
+
+// authSkew is cfg's auth_time_skew.
+func authSkew(cfg *Config) time.Duration {
+	return time.Duration(cfg.AuthTimeSkew) * time.Second
+}
+
+// clockSkewError is a peer whose proof was good but whose clock is
+// further off than auth_time_skew.
+type clockSkewError struct {
+	peer   string        // "client" or "server"
+	offset time.Duration // peer's clock minus ours
+	max    time.Duration
+}
+
+func (e *clockSkewError) Error() string {
+	dir, off := "ahead of", e.offset
+	if off < 0 {
+		dir, off = "behind", -off
+	}
+	return fmt.Sprintf("auth: %s clock is %v %s this machine's (auth_time_skew %v); sync both with NTP",
+		e.peer, off.Round(time.Second), dir, e.max)
+}
+
+// checkSkew returns a clockSkewError when peer's time is more than
+// max away from now.
+func checkSkew(who string, peer time.Time, max time.Duration) error {
+	off := time.Until(peer)
+	if off > max || off < -max {
+		return &clockSkewError{peer: who, offset: off, max: max}
+	}
+	return nil
+}
+
+func sessionKey(psk string, shared, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(psk))
+	mac.Write(keyLabel)
+	mac.Write(shared)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// serverHello is the server's half of the exchange for one connection.
+type serverHello struct {
+	nonce []byte
+	priv  *ecdh.PrivateKey
+}
+
+func newServerHello() (*serverHello, error) {
+	nonce, err := newAuthNonce()
+	if err != nil {
+		return nil, err
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("auth key: %w", err)
+	}
+	return &serverHello{nonce: nonce, priv: priv}, nil
+}
+
+// bytes is what the server sends right after the 101 head.
+func (h *serverHello) bytes() []byte {
+	return append(append([]byte(nil), h.nonce...), h.priv.PublicKey().Bytes()...)
+}
+
+// ──────────── Credentials (multi-user) ────────────
+
+// authCredential is one PSK the server accepts. user is empty for the
+// single shared top-level psk.
+type authCredential struct {
+	user        string
+	psk         string
+	maxSessions int
+}
+
+// buildCredentials returns the credentials a server accepts: the
+// enabled entries of users: if any are configured, otherwise the
+// top-level psk alone.
+func buildCredentials(cfg *Config) []authCredential {
+	if len(cfg.Users) == 0 {
+		return []authCredential{{psk: cfg.PSK}}
+	}
+	var creds []authCredential
+	for _, u := range cfg.Users {
+		if u.Disabled || u.PSK == "" {
+			continue
+		}
+		creds = append(creds, authCredential{
+			user:        u.Name,
+			psk:         u.PSK,
+			maxSessions: u.MaxSessions,
+		})
+	}
+	return creds
+}
+
+// serverVerifyAuth reads the client's key and proof for hello (already
+// sent with the 101) and returns the credential and mode it was made
+// with plus the derived session key. Every credential/mode pair is
+// checked so timing doesn't reveal the user's position. skew is
+// auth_time_skew, 0 = the client sends no time.
+func serverVerifyAuth(conn net.Conn, creds []authCredential, modes []byte, hello *serverHello, skew time.Duration) (*authCredential, byte, []byte, error) {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	size := authPubSize + authProofSize
+	if skew > 0 {
+		size += authTimeSize
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, 0, nil, fmt.Errorf("auth: read proof: %w", err)
+	}
+	clientPub, masked, proof := msg[:authPubSize], msg[authPubSize:size-authProofSize], msg[size-authProofSize:]
+	serverPub := hello.priv.PublicKey().Bytes()
+
+	var match *authCredential
+	var mode byte
+	var stamp []byte
+	for i := range creds {
+		var st []byte
+		if skew > 0 {
+			st = maskTime(masked, []byte(creds[i].psk), hello.nonce)
+		}
+		for _, m := range modes {
+			if hmac.Equal(proof, authProof(creds[i].psk, hello.nonce, serverPub, clientPub, m, st)) && match == nil {
+				match, mode, stamp = &creds[i], m, st
+			}
+		}
+	}
+	if match == nil {
+		return nil, 0, nil, errAuthFailed
+	}
+
+	peer, err := ecdh.X25519().NewPublicKey(clientPub)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("auth: client key: %w", err)
+	}
+	shared, err := hello.priv.ECDH(peer)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("auth: ecdh: %w", err)
+	}
+	key := sessionKey(match.psk, shared, hello.nonce)
+	if skew > 0 {
+		// Answered even when refusing, so the client can say why.
+		if _, err := conn.Write(maskTime(timeStamp(time.Now()), key, nil)); err != nil {
+			return nil, 0, nil, fmt.Errorf("auth: write time: %w", err)
+		}
+		if err := checkSkew("client", stampTime(stamp), skew); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+	return match, mode, key, nil
+}
+
+// clientAuthenticate answers the server hello. It returns the nonce
+// (for EncryptedConn.BindSession) and the derived session key. skew
+// is auth_time_skew, 0 = send no time.
+func clientAuthenticate(conn net.Conn, psk string, mode byte, skew time.Duration) (nonce, key []byte, err error) {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := make([]byte, authNonceSize+authPubSize)
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		return nil, nil, fmt.Errorf("auth: read nonce: %w", err)
+	}
+	nonce, serverPub := hello[:authNonceSize], hello[authNonceSize:]
+
+	peer, err := ecdh.X25519().NewPublicKey(serverPub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("auth: server key: %w", err)
+	}
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("auth key: %w", err)
+	}
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("auth: ecdh: %w", err)
+	}
+	clientPub := priv.PublicKey().Bytes()
+
+	msg := append([]byte(nil), clientPub...)
+	var stamp []byte
+	if skew > 0 {
+		stamp = timeStamp(time.Now())
+		msg = append(msg, maskTime(stamp, []byte(psk), nonce)...)
+	}
+	msg = append(msg, authProof(psk, nonce, serverPub, clientPub, mode, stamp)...)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, nil, fmt.Errorf("auth: write proof: %w", err)
+	}
+	key = sessionKey(psk, shared, nonce)
+	if skew > 0 {
+		reply := make([]byte, authTimeSize)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, nil, fmt.Errorf("auth: no time from the server (wrong psk, or auth_time_skew not set on both ends): %w", err)
+		}
+		if err := checkSkew("server", stampTime(maskTime(reply, key, nil)), skew); err != nil {
+			return nil, nil, err
+		}
+	}
+	return nonce, key, nil
+}
@@ -0,0 +1,326 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Tunnel benchmark
+//
+//   picotun bench -c client.yaml [-t 10s]
+//
+// Where speedtest moves a fixed amount one way, bench loads both
+// directions at once for a fixed time and pings through the tunnel
+// meanwhile, so smux/fragment settings can be compared on throughput
+// and on latency under load — the number that decides whether a
+// tunnel "feels slow".
+//
+//   "bench://down/<ms>"  server sends frames for <ms>, then a 0 frame
+//   "bench://up/<ms>"    client sends frames until a 0 frame; the
+//                        server answers with the 8-byte count it got
+//
+// Frames are [2B len][data]. Pings use the echo responder on their own
+// stream: idle first, then every benchPingEvery during the load.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	benchTarget    = "bench://"
+	benchMax       = 5 * time.Minute
+	benchFrame     = 16 * 1024
+	benchPingEvery = 100 * time.Millisecond
+	benchIdlePings = 10
+)
+
+func isBenchTarget(target string) bool {
+	return strings.HasPrefix(target, benchTarget)
+}
+
+func parseBench(target string) (up bool, d time.Duration, ok bool) {
+	dir, ms, found := strings.Cut(strings.TrimPrefix(target, benchTarget), "/")
+	if !found || (dir != "up" && dir != "down") {
+		return false, 0, false
+	}
+	n, err := strconv.Atoi(ms)
+	d = time.Duration(n) * time.Millisecond
+	if err != nil || d <= 0 || d > benchMax {
+		return false, 0, false
+	}
+	return dir == "up", d, true
+}
+
+// serveBench is the server side of one bench stream.
+func serveBench(rw io.ReadWriteCloser, target string) {
+	defer rw.Close()
+	up, d, ok := parseBench(target)
+	if !ok {
+		return
+	}
+	if up {
+		n, err := readBenchFrames(rw, nil)
+		if err != nil {
+			return
+		}
+		var ack [8]byte
+		binary.BigEndian.PutUint64(ack[:], uint64(n))
+		rw.Write(ack[:])
+		return
+	}
+	writeBenchFrames(rw, d, nil)
+}
+
+// writeBenchFrames sends random frames for d, then the end frame.
+func writeBenchFrames(w io.Writer, d time.Duration, sent func(int64)) error {
+	payload := speedtestPayload()[:benchFrame]
+	frame := make([]byte, 2+benchFrame)
+	binary.BigEndian.PutUint16(frame, benchFrame)
+	copy(frame[2:], payload)
+	var n int64
+	for end := time.Now().Add(d); time.Now().Before(end); {
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+		n += benchFrame
+		if sent != nil {
+			sent(n)
+		}
+	}
+	_, err := w.Write([]byte{0, 0})
+	return err
+}
+
+// readBenchFrames counts payload bytes up to the end frame.
+func readBenchFrames(r io.Reader, got func(int64)) (int64, error) {
+	var hdr [2]byte
+	buf := make([]byte, benchFrame)
+	var n int64
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return n, err
+		}
+		l := int(binary.BigEndian.Uint16(hdr[:]))
+		if l == 0 {
+			return n, nil
+		}
+		if l > len(buf) {
+			return n, fmt.Errorf("bench frame of %d bytes", l)
+		}
+		if _, err := io.ReadFull(r, buf[:l]); err != nil {
+			return n, err
+		}
+		n += int64(l)
+		if got != nil {
+			got(n)
+		}
+	}
+}
+
+// BenchResult is the outcome of Client.Bench.
+type BenchResult struct {
+	DownBytes int64
+	DownTime  time.Duration
+	UpBytes   int64
+	UpTime    time.Duration // until the server confirmed the count
+	IdleRTT   RTTStats
+	LoadedRTT RTTStats
+}
+
+// RTTStats summarises a series of pings.
+type RTTStats struct {
+	Count  int
+	Lost   int
+	Min    time.Duration
+	Avg    time.Duration
+	P95    time.Duration
+	Max    time.Duration
+	Jitter time.Duration // mean difference between consecutive pings
+}
+
+func (r BenchResult) DownMbps() float64 { return mbps(r.DownBytes, r.DownTime) }
+func (r BenchResult) UpMbps() float64   { return mbps(r.UpBytes, r.UpTime) }
+
+func mbps(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) * 8 / d.Seconds() / 1e6
+}
+
+func rttStats(samples []time.Duration, lost int) RTTStats {
+	st := RTTStats{Count: len(samples), Lost: lost}
+	if len(samples) == 0 {
+		return st
+	}
+	var sum, jit time.Duration
+	for i, s := range samples {
+		sum += s
+		if i > 0 {
+			jit += time.Duration(math.Abs(float64(s - samples[i-1])))
+		}
+	}
+	st.Avg = sum / time.Duration(len(samples))
+	if len(samples) > 1 {
+		st.Jitter = jit / time.Duration(len(samples)-1)
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	st.Min, st.Max = sorted[0], sorted[len(sorted)-1]
+	st.P95 = sorted[len(sorted)*95/100]
+	return st
+}
+
+// pinger measures RTT over one echo stream.
+type pinger struct {
+	rw io.ReadWriteCloser
+}
+
+func (p *pinger) ping() (time.Duration, error) {
+	var b [1]byte
+	start := time.Now()
+	if _, err := p.rw.Write(b[:]); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(p.rw, b[:]); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// Bench loads the tunnel in both directions for d and pings through
+// it. progress, if set, gets the running byte counts and the time
+// since the load started.
+func (c *Client) Bench(d time.Duration, progress func(down, up int64, elapsed time.Duration)) (BenchResult, error) {
+	if d <= 0 || d > benchMax {
+		return BenchResult{}, fmt.Errorf("duration must be within %v", benchMax)
+	}
+	echo, err := c.OpenStream(echoTarget)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	p := &pinger{rw: echo}
+	defer func() { p.rw.Close() }()
+
+	var res BenchResult
+	var idle []time.Duration
+	for i := 0; i < benchIdlePings; i++ {
+		echo.SetDeadline(time.Now().Add(2 * time.Second))
+		rtt, err := p.ping()
+		if err != nil {
+			return res, fmt.Errorf("ping: %w", err)
+		}
+		idle = append(idle, rtt)
+		time.Sleep(benchPingEvery / 2)
+	}
+	res.IdleRTT = rttStats(idle, 0)
+
+	ms := strconv.FormatInt(d.Milliseconds(), 10)
+	down, err := c.OpenStream(benchTarget + "down/" + ms)
+	if err != nil {
+		return res, err
+	}
+	defer down.Close()
+	up, err := c.OpenStream(benchTarget + "up/" + ms)
+	if err != nil {
+		return res, err
+	}
+	defer up.Close()
+
+	var mu sync.Mutex
+	var downN, upN int64
+	start := time.Now()
+	report := func() {
+		if progress != nil {
+			mu.Lock()
+			dn, un := downN, upN
+			mu.Unlock()
+			progress(dn, un, time.Since(start))
+		}
+	}
+
+	var wg sync.WaitGroup
+	var downErr, upErr error
+	var downTime, upTime time.Duration
+	stop := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, downErr = readBenchFrames(down, func(n int64) {
+			mu.Lock()
+			downN = n
+			mu.Unlock()
+		})
+		downTime = time.Since(start)
+	}()
+	go func() {
+		defer wg.Done()
+		if upErr = writeBenchFrames(up, d, func(n int64) {
+			mu.Lock()
+			upN = n
+			mu.Unlock()
+		}); upErr != nil {
+			return
+		}
+		// Only what reached the server counts.
+		var ack [8]byte
+		up.SetReadDeadline(time.Now().Add(30 * time.Second))
+		if _, err := io.ReadFull(up, ack[:]); err != nil {
+			upErr = fmt.Errorf("waiting for server: %w", err)
+			return
+		}
+		upTime = time.Since(start)
+		mu.Lock()
+		upN = int64(binary.BigEndian.Uint64(ack[:]))
+		mu.Unlock()
+	}()
+	go func() {
+		wg.Wait()
+		close(stop)
+	}()
+
+	var loaded []time.Duration
+	lost := 0
+	ticker := time.NewTicker(benchPingEvery)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-stop:
+			break loop
+		case <-ticker.C:
+			report()
+			if time.Since(start) > d {
+				continue
+			}
+			echo.SetDeadline(time.Now().Add(time.Second))
+			if rtt, err := p.ping(); err == nil {
+				loaded = append(loaded, rtt)
+			} else {
+				// A lost echo leaves the stream out of step; replace it.
+				lost++
+				echo.Close()
+				if echo, err = c.OpenStream(echoTarget); err != nil {
+					break loop
+				}
+				p.rw = echo
+			}
+		}
+	}
+	<-stop
+	res.DownBytes, res.DownTime = downN, downTime
+	res.UpBytes, res.UpTime = upN, upTime
+	res.LoadedRTT = rttStats(loaded, lost)
+	if downErr != nil {
+		return res, fmt.Errorf("download: %w", downErr)
+	}
+	if upErr != nil {
+		return res, fmt.Errorf("upload: %w", upErr)
+	}
+	return res, nil
+}
@@ -0,0 +1,288 @@
+package httpmux
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Blackout rotation (client)
+//
+//   blackout:
+//     window: 10      # seconds: every session lost within it is a blackout
+//     sessions: 2     # ... when at least this many were up (default 2)
+//     every: 60       # at most one rotation per this many seconds
+//     step: 5         # seconds each alternate gets at least
+//     alternates:     # what to try instead; unset fields keep the path's
+//       - { transport: httpsmux, port: 443, tls_fingerprint: chrome }
+//       - { port: 8080 }
+//       - { tls_fingerprint: firefox }
+//
+// A DPI reset event kills every session at once, and reconnecting the
+// way that was just cut off usually fails the same way. When the last
+// session goes within `window` of the first loss, the client treats it
+// as a blackout and rotates: every path is dialed with one alternate
+// at a time — transport, port and/or TLS fingerprint swapped in — in a
+// random order, moving on after a failed connect but not sooner than
+// `step` after the previous move. The configuration as written comes
+// last in each round; a round that gets nowhere is reshuffled.
+//
+// The first alternate that connects stays in use, and a structured
+// event is logged:
+//
+//   [BLACKOUT] {"start":"…","lost":4,"tried":["port=8080", …],
+//               "worked":"transport=httpsmux port=443 fp=chrome","took_ms":5210}
+//
+// A blackout within `every` of the last rotation is logged but does not
+// start a new one, so a flapping link can't keep the client spinning.
+// Alternates must be something the server serves (another listen port,
+// a second server on the same host). With port_hopping the hop
+// schedule picks the port and port: is ignored.
+// ═══════════════════════════════════════════════════════════════
+
+type BlackoutConfig struct {
+	Window     int                 `yaml:"window"`   // seconds, default 10
+	Sessions   int                 `yaml:"sessions"` // default 2
+	Every      int                 `yaml:"every"`    // seconds, default 60
+	Step       int                 `yaml:"step"`     // seconds, default 5
+	Alternates []BlackoutAlternate `yaml:"alternates"`
+}
+
+type BlackoutAlternate struct {
+	Transport      string `yaml:"transport"`
+	Port           int    `yaml:"port"`
+	TLSFingerprint string `yaml:"tls_fingerprint"`
+}
+
+const (
+	asConfigured     = -1 // variant index of the paths as written
+	maxBlackoutTried = 32 // variants listed in one event
+)
+
+// blackoutEvent is the structured log line of one rotation.
+type blackoutEvent struct {
+	Start  time.Time `json:"start"`
+	Lost   int       `json:"lost"`
+	Tried  []string  `json:"tried,omitempty"`
+	Worked string    `json:"worked"`
+	TookMS int64     `json:"took_ms"`
+}
+
+type blackout struct {
+	alts     []BlackoutAlternate
+	window   time.Duration
+	sessions int
+	every    time.Duration
+	step     time.Duration
+
+	mu    sync.Mutex
+	peak  int       // sessions before the current losses began
+	since time.Time // first loss since the count last grew
+	cur   int       // variant in use, asConfigured or an alts index
+
+	rotating bool
+	last     time.Time // the last rotation started
+	order    []int     // variants left to try, next first
+	moved    time.Time
+	event    blackoutEvent
+}
+
+// newBlackout returns the rotation for cfg, nil when no alternates are
+// configured.
+func newBlackout(cfg *BlackoutConfig) (*blackout, error) {
+	if cfg.Window < 0 || cfg.Sessions < 0 || cfg.Every < 0 || cfg.Step < 0 {
+		return nil, fmt.Errorf("window, sessions, every and step can't be negative")
+	}
+	if len(cfg.Alternates) == 0 {
+		return nil, nil
+	}
+	b := &blackout{
+		window:   10 * time.Second,
+		sessions: 2,
+		every:    60 * time.Second,
+		step:     5 * time.Second,
+		cur:      asConfigured,
+	}
+	if cfg.Window > 0 {
+		b.window = time.Duration(cfg.Window) * time.Second
+	}
+	if cfg.Sessions > 0 {
+		b.sessions = cfg.Sessions
+	}
+	if cfg.Every > 0 {
+		b.every = time.Duration(cfg.Every) * time.Second
+	}
+	if cfg.Step > 0 {
+		b.step = time.Duration(cfg.Step) * time.Second
+	}
+	for i, a := range cfg.Alternates {
+		a.Transport = strings.ToLower(strings.TrimSpace(a.Transport))
+		if a.Transport != "" && !knownTransport(a.Transport) {
+			return nil, fmt.Errorf("alternates[%d]: unknown transport %q", i, a.Transport)
+		}
+		if a.Port < 0 || a.Port > 65535 {
+			return nil, fmt.Errorf("alternates[%d]: port %d out of range", i, a.Port)
+		}
+		fp, err := normalizeFingerprint(a.TLSFingerprint)
+		if err != nil {
+			return nil, fmt.Errorf("alternates[%d]: tls_fingerprint: %w", i, err)
+		}
+		a.TLSFingerprint = fp
+		if a == (BlackoutAlternate{}) {
+			return nil, fmt.Errorf("alternates[%d]: set transport, port or tls_fingerprint", i)
+		}
+		b.alts = append(b.alts, a)
+	}
+	return b, nil
+}
+
+// variantName describes variant v for the logs.
+func (b *blackout) variantName(v int) string {
+	if v == asConfigured {
+		return "as configured"
+	}
+	a := b.alts[v]
+	var parts []string
+	if a.Transport != "" {
+		parts = append(parts, "transport="+a.Transport)
+	}
+	if a.Port > 0 {
+		parts = append(parts, "port="+strconv.Itoa(a.Port))
+	}
+	if a.TLSFingerprint != "" {
+		parts = append(parts, "fp="+a.TLSFingerprint)
+	}
+	return strings.Join(parts, " ")
+}
+
+// apply returns path as the variant in use dials it, and that variant.
+// A nil b returns path unchanged.
+func (b *blackout) apply(path PathConfig) (PathConfig, int) {
+	if b == nil {
+		return path, asConfigured
+	}
+	b.mu.Lock()
+	v := b.cur
+	b.mu.Unlock()
+	if v == asConfigured {
+		return path, v
+	}
+	a := b.alts[v]
+	if a.Transport != "" {
+		path.Transport = a.Transport
+	}
+	if a.Port > 0 {
+		host, _ := parseAddr(strings.TrimSpace(path.Addr), path.Transport)
+		path.Addr = net.JoinHostPort(host, strconv.Itoa(a.Port))
+	}
+	if a.TLSFingerprint != "" {
+		path.TLSFingerprint = a.TLSFingerprint
+	}
+	return path, v
+}
+
+// sessionUp notes the pool growing to n sessions after variant v
+// connected, which ends a rotation.
+func (b *blackout) sessionUp(n, v int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.peak, b.since = n, time.Time{}
+	if !b.rotating {
+		return
+	}
+	b.rotating, b.cur = false, v // a dial started before a move may win
+	ev := b.event
+	ev.Worked = b.variantName(v)
+	ev.TookMS = time.Since(ev.Start).Milliseconds()
+	data, _ := json.Marshal(ev)
+	log.Printf("[BLACKOUT] %s", data)
+}
+
+// sessionLost notes the pool shrinking to n sessions and starts a
+// rotation when that is a blackout.
+func (b *blackout) sessionLost(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.since.IsZero() {
+		b.since = now
+	}
+	if n > 0 || b.peak < b.sessions || now.Sub(b.since) > b.window {
+		return
+	}
+	lost := b.peak
+	b.peak, b.since = 0, time.Time{}
+	switch {
+	case b.rotating:
+		return
+	case !b.last.IsZero() && now.Sub(b.last) < b.every:
+		log.Printf("[BLACKOUT] lost all %d sessions within %v; rotated %v ago, not rotating again yet",
+			lost, b.window, now.Sub(b.last).Round(time.Second))
+		return
+	}
+	log.Printf("[BLACKOUT] lost all %d sessions within %v, rotating through %d alternate(s)",
+		lost, b.window, len(b.alts))
+	b.rotating, b.last, b.moved = true, now, time.Time{}
+	b.event = blackoutEvent{Start: now, Lost: lost}
+	b.order = b.shuffled()
+	b.next()
+}
+
+// failed notes a connect with variant v failing and moves the rotation
+// on when v is the one being tried and it has had its step.
+func (b *blackout) failed(v int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.rotating || v != b.cur || time.Since(b.moved) < b.step {
+		return
+	}
+	b.next()
+}
+
+// next switches to the next variant of the round. Caller holds mu.
+func (b *blackout) next() {
+	if len(b.order) == 0 {
+		b.order = b.shuffled()
+	}
+	b.cur, b.order = b.order[0], b.order[1:]
+	b.moved = time.Now()
+	if len(b.event.Tried) < maxBlackoutTried {
+		b.event.Tried = append(b.event.Tried, b.variantName(b.cur))
+	}
+	log.Printf("[BLACKOUT] trying %s", b.variantName(b.cur))
+}
+
+// shuffled returns a round: every variant but the one in use in random
+// order, then the paths as configured unless that is the one in use.
+// Caller holds mu.
+func (b *blackout) shuffled() []int {
+	var order []int
+	for i := range b.alts {
+		if i != b.cur {
+			order = append(order, i)
+		}
+	}
+	for i := len(order) - 1; i > 0; i-- {
+		j := secureRandInt(i + 1)
+		order[i], order[j] = order[j], order[i]
+	}
+	if b.cur != asConfigured {
+		order = append(order, asConfigured)
+	}
+	return order
+}
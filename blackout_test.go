@@ -0,0 +1,97 @@
+package httpmux
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlackoutRotation(t *testing.T) {
+	b, err := newBlackout(&BlackoutConfig{Alternates: []BlackoutAlternate{
+		{Transport: "HTTPSMUX", Port: 443, TLSFingerprint: "chrome"},
+		{Port: 8080},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := PathConfig{Transport: "httpmux", Addr: "1.2.3.4:2020"}
+
+	b.sessionUp(3, asConfigured)
+	b.sessionLost(2)
+	b.sessionLost(1)
+	if b.rotating {
+		t.Fatal("rotating with a session left")
+	}
+	b.sessionLost(0)
+	if !b.rotating || b.cur == asConfigured {
+		t.Fatalf("rotating=%v cur=%d after losing every session at once", b.rotating, b.cur)
+	}
+	first := b.cur
+	got, v := b.apply(path)
+	want := map[int]PathConfig{
+		0: {Transport: "httpsmux", Addr: "1.2.3.4:443", TLSFingerprint: "chrome"},
+		1: {Transport: "httpmux", Addr: "1.2.3.4:8080"},
+	}[first]
+	if v != first || got != want {
+		t.Fatalf("apply = %+v (variant %d), want %+v", got, v, want)
+	}
+
+	b.failed(first)
+	if b.cur != first {
+		t.Fatal("moved on before the step was up")
+	}
+	b.moved = time.Now().Add(-b.step)
+	b.failed(asConfigured) // a dial that started before the move
+	if b.cur != first {
+		t.Fatal("moved on after a stale failure")
+	}
+	b.failed(first)
+	if b.cur == first || b.cur == asConfigured {
+		t.Fatalf("after %d failed went to %d, want the other alternate", first, b.cur)
+	}
+	worked := b.cur
+
+	b.sessionUp(1, worked)
+	if b.rotating || b.cur != worked {
+		t.Fatalf("rotating=%v cur=%d after %d connected", b.rotating, b.cur, worked)
+	}
+	if len(b.event.Tried) != 2 {
+		t.Errorf("tried %v", b.event.Tried)
+	}
+
+	// A second blackout inside every: logged, no new rotation.
+	b.sessionUp(2, worked)
+	b.sessionLost(1)
+	b.sessionLost(0)
+	if b.rotating || b.cur != worked {
+		t.Fatalf("rotating=%v cur=%d on a blackout within every", b.rotating, b.cur)
+	}
+}
+
+func TestBlackoutSlowLoss(t *testing.T) {
+	b, _ := newBlackout(&BlackoutConfig{Alternates: []BlackoutAlternate{{Port: 8080}}})
+	b.sessionUp(2, asConfigured)
+	b.sessionLost(1)
+	b.since = time.Now().Add(-b.window - time.Second)
+	b.sessionLost(0)
+	if b.rotating {
+		t.Fatal("losses spread over more than window counted as a blackout")
+	}
+	b.sessionUp(1, asConfigured)
+	b.sessionLost(0)
+	if b.rotating {
+		t.Fatal("losing a single session counted as a blackout")
+	}
+}
+
+func TestBlackoutConfig(t *testing.T) {
+	for _, alt := range []BlackoutAlternate{
+		{},
+		{Transport: "carrier-pigeon"},
+		{Port: 70000},
+		{TLSFingerprint: "netscape"},
+	} {
+		if _, err := newBlackout(&BlackoutConfig{Alternates: []BlackoutAlternate{alt}}); err == nil {
+			t.Errorf("alternate %+v accepted", alt)
+		}
+	}
+}
@@ -0,0 +1,689 @@
+package httpmux
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Multipath bonding of a single stream
+//
+//   maps:
+//     - { type: tcp, bind: "8443", target: "127.0.0.1:443", bond: 2 }
+//
+// One visitor connection is carried over `bond` sub-streams on
+// different sessions (ideally different paths/uplinks). Each sub-stream
+// opens like a normal reverse stream with the target
+//
+//   bond://<id>/<index>/<width>/<inner target>
+//
+// and then carries frames  [8B seq][1B flags][2B len][data]  in both
+// directions. Every sub-stream's writer pulls from one shared queue, so
+// a fast path naturally sends more chunks than a throttled one; the
+// receiver reorders by seq and returns cumulative ACK frames. A
+// sub-stream whose write stalls for bondStall is dropped and every
+// unacknowledged chunk is re-sent on the others; duplicates are
+// discarded by seq. The bond survives as long as one sub-stream does,
+// or with resume: (resume.go) until a new one joins.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	bondScheme   = "bond://"
+	bondChunk    = 16 << 10
+	bondStall    = 5 * time.Second
+	bondJoinWait = 10 * time.Second
+	bondMaxWidth = 8
+	bondMaxBuf   = 4 << 20 // unacked / reorder+unread bytes before blocking
+	bondAckEvery = 16      // frames between cumulative ACKs
+
+	bondFlagFIN = 1
+	bondFlagACK = 2
+)
+
+var errBondClosed = errors.New("bond closed")
+
+func bondHeader(id string, idx, width int, target string) string {
+	return fmt.Sprintf("%s%s/%d/%d/%s", bondScheme, id, idx, width, target)
+}
+
+func parseBondHeader(h string) (id string, idx, width int, target string, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(h, bondScheme), "/", 4)
+	if len(parts) != 4 || !strings.HasPrefix(h, bondScheme) {
+		return "", 0, 0, "", false
+	}
+	width, err := strconv.Atoi(parts[2])
+	if err != nil || width < 1 || width > bondMaxWidth {
+		return "", 0, 0, "", false
+	}
+	idx, err = strconv.Atoi(parts[1])
+	if err != nil || idx < 0 {
+		return "", 0, 0, "", false
+	}
+	return parts[0], idx, width, parts[3], true
+}
+
+func newBondID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type bondFrame struct {
+	seq  uint64 // data: position; ACK: next seq expected
+	fin  bool
+	ack  bool
+	data []byte
+}
+
+func writeBondFrame(w io.Writer, f *bondFrame) error {
+	buf := make([]byte, 11+len(f.data))
+	binary.BigEndian.PutUint64(buf, f.seq)
+	if f.fin {
+		buf[8] |= bondFlagFIN
+	}
+	if f.ack {
+		buf[8] |= bondFlagACK
+	}
+	binary.BigEndian.PutUint16(buf[9:], uint16(len(f.data)))
+	copy(buf[11:], f.data)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readBondFrame(r io.Reader) (*bondFrame, error) {
+	var hdr [11]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	f := &bondFrame{
+		seq:  binary.BigEndian.Uint64(hdr[:8]),
+		fin:  hdr[8]&bondFlagFIN != 0,
+		ack:  hdr[8]&bondFlagACK != 0,
+		data: make([]byte, binary.BigEndian.Uint16(hdr[9:])),
+	}
+	if _, err := io.ReadFull(r, f.data); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// bondConn is one logical stream over several sub-streams.
+//
+// Every data frame stays in unacked until the peer's cumulative ACK
+// covers it. When a sub-stream dies, whatever it may have swallowed is
+// re-sent on the survivors from unacked.
+type bondConn struct {
+	sendq chan *bondFrame
+	kick  chan struct{} // retry queue non-empty
+	ackq  chan struct{} // an ACK is due
+	wmu   sync.Mutex
+	wseq  uint64
+
+	umu     sync.Mutex
+	ucond   *sync.Cond
+	unacked map[uint64]*bondFrame
+	ubytes  int
+	retryq  []*bondFrame
+
+	rmu     sync.Mutex
+	rcond   *sync.Cond
+	pending map[uint64]*bondFrame
+	rseq    uint64
+	acked   uint64 // rseq last reported to the peer
+	rbuf    []byte
+	held    int // bytes in pending + rbuf
+	rfin    bool
+	rerr    error
+
+	mu     sync.Mutex
+	subs   []io.ReadWriteCloser
+	alive  int
+	joined int
+	width  int
+
+	resume  time.Duration // wait this long for a new sub-stream when all are gone
+	lost    chan struct{} // all sub-streams gone while resume is set
+	lostGen int
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newBondConn(width int) *bondConn {
+	b := &bondConn{
+		sendq:   make(chan *bondFrame, 2*bondMaxWidth),
+		kick:    make(chan struct{}, 1),
+		ackq:    make(chan struct{}, 1),
+		unacked: map[uint64]*bondFrame{},
+		pending: map[uint64]*bondFrame{},
+		width:   width,
+		lost:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	b.ucond = sync.NewCond(&b.umu)
+	b.rcond = sync.NewCond(&b.rmu)
+	return b
+}
+
+// add attaches a sub-stream and starts its reader and writer.
+func (b *bondConn) add(sub io.ReadWriteCloser) {
+	b.mu.Lock()
+	select {
+	case <-b.done:
+		b.mu.Unlock()
+		sub.Close()
+		return
+	default:
+	}
+	b.subs = append(b.subs, sub)
+	b.alive++
+	b.joined++
+	b.mu.Unlock()
+	go b.readLoop(sub)
+	go b.writeLoop(sub)
+}
+
+func (b *bondConn) popRetry() *bondFrame {
+	b.umu.Lock()
+	defer b.umu.Unlock()
+	for len(b.retryq) > 0 {
+		f := b.retryq[0]
+		b.retryq = b.retryq[1:]
+		if _, ok := b.unacked[f.seq]; ok {
+			return f
+		}
+	}
+	return nil
+}
+
+func (b *bondConn) writeLoop(sub io.ReadWriteCloser) {
+	type deadliner interface{ SetWriteDeadline(time.Time) error }
+	for {
+		f := b.popRetry()
+		if f == nil {
+			select {
+			case f = <-b.sendq:
+			case <-b.kick:
+				continue
+			case <-b.ackq:
+				b.rmu.Lock()
+				f = &bondFrame{seq: b.rseq, ack: true}
+				b.rmu.Unlock()
+			case <-b.done:
+				return
+			}
+		}
+		if d, ok := sub.(deadliner); ok {
+			d.SetWriteDeadline(time.Now().Add(bondStall))
+		}
+		if err := writeBondFrame(sub, f); err != nil {
+			b.subFailed(sub)
+			b.requeue(f)
+			return
+		}
+	}
+}
+
+// requeue hands a frame a dead sub-stream failed to write to the others.
+func (b *bondConn) requeue(f *bondFrame) {
+	if f.ack {
+		b.requestAck()
+		return
+	}
+	b.umu.Lock()
+	_, ok := b.unacked[f.seq]
+	if ok {
+		b.retryq = append(b.retryq, f)
+	}
+	b.umu.Unlock()
+	if ok {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *bondConn) readLoop(sub io.ReadWriteCloser) {
+	for {
+		f, err := readBondFrame(sub)
+		if err != nil {
+			b.subFailed(sub)
+			return
+		}
+		if f.ack {
+			b.ackReceived(f.seq)
+			continue
+		}
+		b.deliver(f)
+	}
+}
+
+// ackReceived drops every frame below seq from unacked.
+func (b *bondConn) ackReceived(seq uint64) {
+	b.umu.Lock()
+	for s, f := range b.unacked {
+		if s < seq {
+			b.ubytes -= len(f.data)
+			delete(b.unacked, s)
+		}
+	}
+	b.ucond.Broadcast()
+	b.umu.Unlock()
+}
+
+func (b *bondConn) deliver(f *bondFrame) {
+	b.rmu.Lock()
+	defer b.rmu.Unlock()
+	// Back-pressure: only the frame the reader is waiting for may
+	// exceed the buffer limit, so a stalled path can't deadlock us.
+	for b.held > bondMaxBuf && f.seq != b.rseq && b.rerr == nil {
+		b.rcond.Wait()
+	}
+	if f.seq < b.rseq || b.pending[f.seq] != nil {
+		b.requestAck() // our ACK may have been lost with a sub-stream
+		return
+	}
+	b.pending[f.seq] = f
+	b.held += len(f.data)
+	for {
+		next, ok := b.pending[b.rseq]
+		if !ok {
+			break
+		}
+		delete(b.pending, b.rseq)
+		b.rseq++
+		b.rbuf = append(b.rbuf, next.data...)
+		if next.fin {
+			b.rfin = true
+		}
+	}
+	if b.rfin || b.rseq-b.acked >= bondAckEvery {
+		b.acked = b.rseq
+		b.requestAck()
+	}
+	b.rcond.Broadcast()
+}
+
+func (b *bondConn) requestAck() {
+	select {
+	case b.ackq <- struct{}{}:
+	default:
+	}
+}
+
+func (b *bondConn) subFailed(sub io.ReadWriteCloser) {
+	sub.Close()
+	b.mu.Lock()
+	found := false
+	for i, s := range b.subs {
+		if s == sub {
+			b.subs = append(b.subs[:i], b.subs[i+1:]...)
+			b.alive--
+			found = true
+			break
+		}
+	}
+	dead := b.alive == 0 && b.joined >= b.width
+	if dead && b.resume > 0 {
+		b.startResume()
+		dead = false
+	}
+	b.mu.Unlock()
+	if !found {
+		return
+	}
+	if dead {
+		b.fail(io.ErrUnexpectedEOF)
+		return
+	}
+	// Anything not yet acknowledged may have died with sub.
+	b.umu.Lock()
+	b.retryq = b.retryq[:0]
+	for _, f := range b.unacked {
+		b.retryq = append(b.retryq, f)
+	}
+	sort.Slice(b.retryq, func(i, j int) bool { return b.retryq[i].seq < b.retryq[j].seq })
+	b.umu.Unlock()
+	select {
+	case b.kick <- struct{}{}:
+	default:
+	}
+	b.requestAck()
+}
+
+// joinTimeout gives up waiting for missing sub-streams.
+func (b *bondConn) joinTimeout() {
+	b.mu.Lock()
+	b.width = b.joined
+	dead := b.alive == 0
+	if dead && b.resume > 0 && b.joined > 0 {
+		b.startResume()
+		dead = false
+	}
+	b.mu.Unlock()
+	if dead {
+		b.fail(io.ErrUnexpectedEOF)
+	}
+}
+
+func (b *bondConn) fail(err error) {
+	b.setReadErr(err)
+	b.shutdown()
+}
+
+func (b *bondConn) setReadErr(err error) {
+	b.rmu.Lock()
+	if b.rerr == nil {
+		b.rerr = err
+	}
+	b.rcond.Broadcast()
+	b.rmu.Unlock()
+}
+
+// shutdown stops the writers and closes every sub-stream.
+func (b *bondConn) shutdown() {
+	b.closeOnce.Do(func() {
+		b.mu.Lock()
+		close(b.done)
+		subs := append([]io.ReadWriteCloser(nil), b.subs...)
+		b.mu.Unlock()
+		for _, s := range subs {
+			s.Close()
+		}
+		b.umu.Lock()
+		b.ucond.Broadcast()
+		b.umu.Unlock()
+	})
+}
+
+func (b *bondConn) closed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *bondConn) Read(p []byte) (int, error) {
+	b.rmu.Lock()
+	defer b.rmu.Unlock()
+	for len(b.rbuf) == 0 {
+		if b.rfin {
+			return 0, io.EOF
+		}
+		if b.rerr != nil {
+			return 0, b.rerr
+		}
+		b.rcond.Wait()
+	}
+	n := copy(p, b.rbuf)
+	b.rbuf = b.rbuf[n:]
+	b.held -= n
+	b.rcond.Broadcast()
+	return n, nil
+}
+
+func (b *bondConn) Write(p []byte) (int, error) {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	total := 0
+	for len(p) > 0 {
+		n := min(len(p), bondChunk)
+		if err := b.send(&bondFrame{seq: b.wseq, data: append([]byte(nil), p[:n]...)}); err != nil {
+			return total, err
+		}
+		total += n
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// send registers f as unacked and queues it. Called with wmu held.
+func (b *bondConn) send(f *bondFrame) error {
+	b.umu.Lock()
+	for b.ubytes > bondMaxBuf && !b.closed() {
+		b.ucond.Wait()
+	}
+	b.unacked[f.seq] = f
+	b.ubytes += len(f.data)
+	b.umu.Unlock()
+	select {
+	case b.sendq <- f:
+	case <-b.done:
+		return errBondClosed
+	}
+	b.wseq++
+	return nil
+}
+
+// Close sends FIN, waits up to bondStall for the peer to acknowledge
+// everything, and closes every sub-stream.
+func (b *bondConn) Close() error {
+	b.wmu.Lock()
+	sent := !b.closed() && b.send(&bondFrame{seq: b.wseq, fin: true}) == nil
+	b.wmu.Unlock()
+
+	if sent {
+		timer := time.AfterFunc(bondStall, func() {
+			b.umu.Lock()
+			b.ucond.Broadcast()
+			b.umu.Unlock()
+		})
+		deadline := time.Now().Add(bondStall)
+		b.umu.Lock()
+		for len(b.unacked) > 0 && !b.closed() && time.Now().Before(deadline) {
+			b.ucond.Wait()
+		}
+		b.umu.Unlock()
+		timer.Stop()
+	}
+	b.setReadErr(errBondClosed)
+	b.shutdown()
+	return nil
+}
+
+// ──────────── Joining ────────────
+
+// bondRegistry collects the sub-streams of bonds opened by the peer.
+type bondRegistry struct {
+	mu    sync.Mutex
+	bonds map[string]*bondConn
+}
+
+func newBondRegistry() *bondRegistry {
+	return &bondRegistry{bonds: map[string]*bondConn{}}
+}
+
+// join attaches sub-stream idx to bond id, creating it on first
+// arrival. The first sub-stream's handler owns the bond; the others
+// just wait on done. A resumed sub-stream (idx >= width) only joins a
+// bond that still exists; b is nil otherwise.
+func (r *bondRegistry) join(id string, idx, width int, resume time.Duration, sub io.ReadWriteCloser) (b *bondConn, first bool) {
+	r.mu.Lock()
+	b, ok := r.bonds[id]
+	if !ok && idx >= width {
+		r.mu.Unlock()
+		sub.Close()
+		return nil, false
+	}
+	if !ok {
+		b = newBondConn(width)
+		b.resume = resume
+		r.bonds[id] = b
+		time.AfterFunc(bondJoinWait, func() {
+			if resume == 0 {
+				r.remove(id, b)
+			}
+			b.joinTimeout()
+		})
+		if resume > 0 {
+			go func() {
+				<-b.done
+				r.remove(id, b)
+			}()
+		}
+	}
+	r.mu.Unlock()
+	b.add(sub)
+	return b, !ok
+}
+
+func (r *bondRegistry) remove(id string, b *bondConn) {
+	r.mu.Lock()
+	if r.bonds[id] == b {
+		delete(r.bonds, id)
+	}
+	r.mu.Unlock()
+}
+
+// ──────────── Server: bonded reverse streams ────────────
+
+// openBondedReverse opens width sub-streams for target on distinct
+// sessions, preferring sessions from different remote hosts.
+func (s *Server) openBondedReverse(target, tag string, width int, resume time.Duration) (*bondConn, error) {
+	width = min(width, bondMaxWidth)
+	s.poolMu.RLock()
+	var picks, rest []*serverSession
+	seen := map[string]bool{}
+	for _, ss := range s.sessions {
+		if ss.sess.IsClosed() || !ss.serves(tag) {
+			continue
+		}
+		if h := hostOnly(ss.remote); !seen[h] {
+			seen[h] = true
+			picks = append(picks, ss)
+		} else {
+			rest = append(rest, ss)
+		}
+	}
+	s.poolMu.RUnlock()
+	picks = append(picks, rest...)
+	if len(picks) == 0 {
+		return nil, fmt.Errorf("no sessions")
+	}
+	if len(picks) > width {
+		picks = picks[:width]
+	}
+
+	id := newBondID()
+	b := newBondConn(len(picks))
+	b.resume = resume
+	if resume > 0 {
+		target = resumeTarget(target, resume)
+	}
+	for i, ss := range picks {
+		stream, err := s.openReverseStreamOn(ss, bondHeader(id, i, len(picks), target))
+		if err != nil {
+			b.joinTimeout()
+			continue
+		}
+		ss := ss
+		b.add(&closeHook{ReadWriteCloser: stream, fn: func() { atomic.AddInt64(&ss.streams, -1) }})
+	}
+	b.joinTimeout() // every sub-stream we will get has been added
+	b.mu.Lock()
+	alive := b.alive
+	b.mu.Unlock()
+	if alive == 0 {
+		b.fail(io.ErrUnexpectedEOF)
+		return nil, fmt.Errorf("no bond sub-stream opened")
+	}
+	if resume > 0 {
+		go s.resumeBond(b, id, target, tag, len(picks))
+	}
+	return b, nil
+}
+
+// relayBonded serves a visitor of a bond or resume map. It reports
+// false when no bond could be opened, leaving the visitor to the
+// single-stream path.
+func (s *Server) relayBonded(conn net.Conn, bind, target, tag string, width int, resume time.Duration) bool {
+	b, err := s.openBondedReverse(target, tag, width, resume)
+	if err != nil {
+		return false
+	}
+	m, done := s.stats.connOpened("tcp:" + bind)
+	defer done()
+	relay(&countedConn{ReadWriteCloser: conn, st: s.stats, m: m}, b, streamIdle(s.Config))
+	return true
+}
+
+// closeHook runs fn once when the wrapped stream is closed.
+type closeHook struct {
+	io.ReadWriteCloser
+	once sync.Once
+	fn   func()
+}
+
+func (c *closeHook) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(c.fn)
+	return err
+}
+
+// ──────────── Client: joining bonded reverse streams ────────────
+
+func (c *Client) proxyBondStream(sub io.ReadWriteCloser, header string) {
+	id, idx, width, target, ok := parseBondHeader(header)
+	if !ok {
+		return
+	}
+	target, resume := splitResumeTarget(target)
+	if resume > 0 {
+		resume += bondJoinWait // the server may notice the loss later than we do
+	}
+	b, first := c.bonds.join(id, idx, width, resume, sub)
+	if b == nil {
+		c.stats.incError("resume_unknown")
+		return
+	}
+	if !first {
+		<-b.done
+		return
+	}
+	target, src, dst, proxy := splitFromTarget(target)
+	resolved, ok := c.resolveTarget(target)
+	if !ok {
+		c.refuseTarget(target)
+		b.Close()
+		return
+	}
+	network, addr := splitTarget(resolved)
+	remote, err := c.life.dial(network, addr, 10*time.Second)
+	if err != nil {
+		c.stats.incError("dial")
+		if c.verbose {
+			logDedupf(network+addr, "[BOND] dial %s://%s: %v", network, addr, err)
+		}
+		b.Close()
+		return
+	}
+	defer remote.Close()
+	if c.verbose {
+		log.Printf("[BOND] %s via %d sub-stream(s)", target, width)
+	}
+	logVisitor(src, addr)
+	if proxy > 0 && network == "tcp" {
+		if err := writeProxyHeader(remote, proxy, src, dst); err != nil {
+			b.Close()
+			return
+		}
+	}
+	m, done := c.stats.connOpened(network + ":" + addr)
+	defer done()
+	relay(b, &countedConn{ReadWriteCloser: remote, st: c.stats, m: m}, streamIdle(c.cfg))
+}
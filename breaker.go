@@ -0,0 +1,236 @@
+package httpmux
+
+import (
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Circuit breaker for reverse maps
+//
+//   maps:
+//     - { type: tcp, bind: "443", target: "127.0.0.1:443",
+//         circuit_breaker: true, down_banner: "503 maintenance\r\n" }
+//
+// When the client fails to dial a map's target breakerTrips times in a
+// row it tells the server over a "breaker://" forward stream. While
+// the breaker is open the server answers visitors of circuit_breaker
+// maps straight away — a TCP reset, or down_banner then close —
+// instead of accepting, tunnelling and silently dropping them.
+//
+// The client keeps redialling the target every breakerProbe and sends
+// "up" as soon as it answers. It repeats "down" every breakerRefresh
+// while the target stays dead, and the server forgets a breaker after
+// breakerHold without a refresh, so a client that disappears can't
+// leave a map paused.
+//
+// A report only speaks for the client that sent it: the server pauses
+// a map while every session that could carry it (same tag, see
+// sessinfo.go) belongs to a client reporting the target down. One
+// client losing its target leaves the map to the others, and a user
+// can't pause a map served by someone else's client.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	breakerTarget  = "breaker://"
+	breakerTrips   = 3
+	breakerProbe   = 5 * time.Second
+	breakerRefresh = 20 * time.Second
+	breakerHold    = 60 * time.Second
+)
+
+// ──────────── Server ────────────
+
+type breakerBoard struct {
+	mu   sync.Mutex
+	open map[string]map[*serverSession]time.Time // stream target → reporter → last "down"
+}
+
+func newBreakerBoard() *breakerBoard {
+	return &breakerBoard{open: map[string]map[*serverSession]time.Time{}}
+}
+
+// handle applies "down <target>" / "up <target>" reported by ss.
+func (b *breakerBoard) handle(ss *serverSession, msg string) {
+	state, target, ok := strings.Cut(msg, " ")
+	if !ok || target == "" {
+		return
+	}
+	b.mu.Lock()
+	reports := b.open[target]
+	_, wasDown := reports[ss]
+	switch state {
+	case "down":
+		if reports == nil {
+			reports = map[*serverSession]time.Time{}
+			b.open[target] = reports
+		}
+		reports[ss] = time.Now()
+	case "up":
+		for r := range reports {
+			if sameClient(r, ss) {
+				delete(reports, r)
+			}
+		}
+		if len(reports) == 0 {
+			delete(b.open, target)
+		}
+	}
+	b.mu.Unlock()
+	if state == "down" && !wasDown {
+		log.Printf("[BREAKER] %s down at client %s", target, ss.remote)
+	} else if state == "up" && wasDown {
+		log.Printf("[BREAKER] %s back up at client %s", target, ss.remote)
+	}
+}
+
+// isOpen reports whether every session in serving has a client that
+// reported target down.
+func (b *breakerBoard) isOpen(target string, serving []*serverSession) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	reports := b.open[target]
+	for r, t := range reports {
+		if time.Since(t) > breakerHold {
+			delete(reports, r)
+		}
+	}
+	if len(reports) == 0 {
+		delete(b.open, target)
+		return false
+	}
+	for _, ss := range serving {
+		down := false
+		for r := range reports {
+			down = down || sameClient(r, ss)
+		}
+		if !down {
+			return false
+		}
+	}
+	return len(serving) > 0
+}
+
+// drop forgets what a closed session reported.
+func (b *breakerBoard) drop(ss *serverSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for target, reports := range b.open {
+		delete(reports, ss)
+		if len(reports) == 0 {
+			delete(b.open, target)
+		}
+	}
+}
+
+// sameClient reports whether a and b are sessions of one client: the
+// same session, or the same announced client id under the same user.
+func sameClient(a, b *serverSession) bool {
+	return a == b || a.user == b.user && a.clientID() != "" && a.clientID() == b.clientID()
+}
+
+// breakerOpen reports whether a circuit_breaker map pinned to tag
+// should turn visitors of target away.
+func (s *Server) breakerOpen(target, tag string) bool {
+	return s.breakers.isOpen(target, s.sessionsServing(tag))
+}
+
+// refuseVisitor answers a visitor of a paused map: banner + close, or
+// an immediate reset.
+func refuseVisitor(conn net.Conn, banner string) {
+	if banner != "" {
+		conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+		conn.Write([]byte(banner))
+		return
+	}
+	if tc, ok := conn.(*net.TCPConn); ok {
+		tc.SetLinger(0) // close sends RST
+	}
+}
+
+// ──────────── Client ────────────
+
+type breakerState struct {
+	fails   int
+	tripped bool
+}
+
+type clientBreakers struct {
+	mu      sync.Mutex
+	targets map[string]*breakerState
+}
+
+func newClientBreakers() *clientBreakers {
+	return &clientBreakers{targets: map[string]*breakerState{}}
+}
+
+// dialFailed counts a failed dial of target as the server sent it
+// ("tcp://host:port" or "tcp://@service").
+func (c *Client) dialFailed(target string) {
+	b := c.breakers
+	b.mu.Lock()
+	st, ok := b.targets[target]
+	if !ok {
+		st = &breakerState{}
+		b.targets[target] = st
+	}
+	st.fails++
+	trip := st.fails >= breakerTrips && !st.tripped
+	if trip {
+		st.tripped = true
+	}
+	b.mu.Unlock()
+	if trip {
+		log.Printf("[BREAKER] %s failed %d dials — telling server", target, breakerTrips)
+		c.sendBreaker("down", target)
+		go c.watchTarget(target)
+	}
+}
+
+// dialOK resets target's failure count.
+func (c *Client) dialOK(target string) {
+	b := c.breakers
+	b.mu.Lock()
+	st, ok := b.targets[target]
+	if ok && !st.tripped {
+		delete(b.targets, target)
+	}
+	b.mu.Unlock()
+}
+
+// watchTarget redials a tripped target until it answers.
+func (c *Client) watchTarget(target string) {
+	resolved, ok := c.resolveTarget(target)
+	if !ok {
+		return
+	}
+	network, addr := splitTarget(resolved)
+	last := time.Now()
+	for c.life.sleep(breakerProbe) {
+		conn, err := c.life.dial(network, addr, 5*time.Second)
+		if err == nil {
+			conn.Close()
+			c.breakers.mu.Lock()
+			delete(c.breakers.targets, target)
+			c.breakers.mu.Unlock()
+			log.Printf("[BREAKER] %s reachable again", target)
+			c.sendBreaker("up", target)
+			return
+		}
+		if time.Since(last) >= breakerRefresh {
+			c.sendBreaker("down", target)
+			last = time.Now()
+		}
+	}
+}
+
+func (c *Client) sendBreaker(state, target string) {
+	stream, err := c.OpenStream(breakerTarget + state + " " + target)
+	if err != nil {
+		return
+	}
+	stream.Close()
+}
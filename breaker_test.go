@@ -0,0 +1,49 @@
+package httpmux
+
+import "testing"
+
+func TestBreakerBoardPerClient(t *testing.T) {
+	session := func(user, id string) *serverSession {
+		ss := &serverSession{remote: user + "/" + id, user: user}
+		ss.info.Store(&sessionInfo{ID: id})
+		return ss
+	}
+	a1, a2 := session("alice", "c1"), session("alice", "c1") // one client, two sessions
+	b := session("bob", "c2")
+	spoof := session("bob", "c1") // bob announcing alice's client id
+	const target = "tcp://127.0.0.1:25"
+
+	board := newBreakerBoard()
+	board.handle(b, "down "+target)
+	if board.isOpen(target, []*serverSession{a1, a2}) {
+		t.Fatal("bob's report paused a map only alice serves")
+	}
+	board.handle(spoof, "down "+target)
+	if board.isOpen(target, []*serverSession{a1, a2}) {
+		t.Fatal("a report under another user's client id paused the map")
+	}
+
+	board.handle(a1, "down "+target)
+	if !board.isOpen(target, []*serverSession{a1, a2}) {
+		t.Fatal("not paused with alice's only client down")
+	}
+	if board.isOpen(target, []*serverSession{a1, a2, session("carol", "c3")}) {
+		t.Fatal("paused although another client still serves the map")
+	}
+	if !board.isOpen(target, []*serverSession{a1, a2, b}) {
+		t.Fatal("not paused with every serving client down")
+	}
+	if board.isOpen(target, nil) {
+		t.Fatal("paused with no serving session")
+	}
+
+	board.handle(a2, "up "+target)
+	if board.isOpen(target, []*serverSession{a1}) {
+		t.Fatal("still paused after the client's up")
+	}
+	board.handle(a1, "down "+target)
+	board.drop(a1)
+	if board.isOpen(target, []*serverSession{a2}) {
+		t.Fatal("a closed session's report outlived it")
+	}
+}
@@ -0,0 +1,283 @@
+package httpmux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Config check (picotun check -c config.yaml)
+//
+// LoadConfig is lenient: unknown keys are ignored and most options
+// have defaults, so a typo like `conection_pool:` silently does
+// nothing. CheckConfig reads a config the way LoadConfig does and
+// additionally reports
+//
+//   • unknown keys (with their line)
+//   • missing required fields (mode, psk, a client's paths, ...)
+//   • options that fight each other (obfuscation padding on top of
+//     stealth padding, acme with cert_file, ...)
+//
+// Errors would stop or break the tunnel; warnings are likely mistakes
+// that still run. `picotun check` exits 1 on any error (and with
+// -strict on any warning); `picotun -strict` refuses to start on
+// errors.
+// ═══════════════════════════════════════════════════════════════
+
+// CheckResult lists what CheckConfig found.
+type CheckResult struct {
+	Errors   []string
+	Warnings []string
+}
+
+func (r *CheckResult) errorf(format string, a ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, a...))
+}
+
+func (r *CheckResult) warnf(format string, a ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, a...))
+}
+
+// OK reports whether nothing was found; strict counts warnings too.
+func (r *CheckResult) OK(strict bool) bool {
+	return len(r.Errors) == 0 && (!strict || len(r.Warnings) == 0)
+}
+
+var unknownFieldRe = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// CheckConfig validates a YAML config with set overrides applied. The
+// returned Config is nil when LoadConfig would refuse it.
+func CheckConfig(b []byte, set []string) (*Config, *CheckResult) {
+	r := &CheckResult{}
+	b, _ = jsonToYAML(b)
+	b, _, err := expandConfig(b, set)
+	if err != nil {
+		r.errorf("%v", err)
+		return nil, r
+	}
+
+	// What the file says, before defaults and profiles.
+	var raw Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(&raw)
+	var te *yaml.TypeError
+	switch {
+	case errors.As(err, &te):
+		for _, e := range te.Errors {
+			r.Errors = append(r.Errors, unknownFieldRe.ReplaceAllString(e, "unknown key $1"))
+		}
+	case err != nil && !errors.Is(err, io.EOF):
+		r.errorf("%v", err)
+		return nil, r
+	}
+
+	c, err := ParseConfig(b)
+	if err != nil {
+		if !errors.As(err, new(*yaml.TypeError)) { // already listed
+			r.errorf("%v", err)
+		}
+		return nil, r
+	}
+	checkRequired(&raw, c, r)
+	checkConflicts(&raw, c, r)
+	return c, r
+}
+
+func checkRequired(raw, c *Config, r *CheckResult) {
+	if c.Mode != "server" && c.Mode != "client" {
+		r.errorf("mode: want server or client, got %q", raw.Mode)
+	}
+	if c.PSK == "" && !hasUsers(c) {
+		r.errorf("psk: required (or users: on the server)")
+	}
+	if c.Transport != "" && !knownTransport(c.Transport) {
+		r.warnf("transport: unknown %q, dials plain TCP", c.Transport)
+	}
+	if c.Mode == "client" {
+		if len(c.Paths) == 0 && c.ServerURL == "" {
+			r.errorf("paths: a client needs at least one path (or server_url)")
+		}
+		for i, p := range c.Paths {
+			if strings.TrimSpace(p.Addr) == "" {
+				r.errorf("paths[%d]: addr is required", i)
+			}
+			if p.Transport != "" && !knownTransport(strings.ToLower(p.Transport)) {
+				r.warnf("paths[%d]: unknown transport %q, dials plain TCP", i, p.Transport)
+			}
+		}
+	}
+	if c.Mode == "server" {
+		for i, m := range c.Maps {
+			if strings.TrimSpace(m.Bind) == "" {
+				r.errorf("maps[%d]: bind is required", i)
+			}
+			if strings.TrimSpace(m.Target) == "" && !strings.EqualFold(m.Type, "echo") {
+				r.errorf("map %s: target is required", m.Bind)
+			}
+		}
+	}
+}
+
+func hasUsers(c *Config) bool {
+	for _, u := range c.Users {
+		if !u.Disabled && u.PSK != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func knownTransport(t string) bool {
+	switch t {
+	case "httpmux", "httpsmux", "wsmux", "wssmux", "tcpmux", "h2mux", "xhttpmux", "xhttpsmux":
+		return true
+	}
+	return false
+}
+
+// checkConflicts looks at what the file sets, not at what profiles and
+// transport defaults switched on.
+func checkConflicts(raw, c *Config, r *CheckResult) {
+	obfs := raw.Obfuscation.Enabled || raw.Obfs.Enabled
+	if obfs && raw.Stealth.RandomPadding {
+		r.warnf("obfuscation and stealth.random_padding both pad every frame; enable one")
+	}
+	if obfs && raw.Stealth.TrafficModel != "" {
+		r.warnf("stealth.traffic_model: obfuscation pads every frame its own way and wins; enable one")
+	}
+	if raw.ACME.Enabled && (raw.CertFile != "" || raw.KeyFile != "") {
+		r.errorf("acme and cert_file/key_file are exclusive")
+	}
+	if (raw.CertFile == "") != (raw.KeyFile == "") {
+		r.errorf("cert_file and key_file go together")
+	}
+	tls := TransportUsesTLS(c.Transport)
+	if c.Mode == "client" {
+		for _, p := range c.Paths {
+			tls = tls || TransportUsesTLS(p.Transport)
+		}
+		if raw.TLSVerify && !tls {
+			r.warnf("tls_verify: no path uses a TLS transport")
+		}
+		if len(raw.Maps) > 0 {
+			r.warnf("maps: only read on the server")
+		}
+		if raw.Accounting.File != "" {
+			r.warnf("accounting: only read on the server")
+		}
+		if raw.State.Path != "" {
+			r.warnf("state: only read on the server")
+		}
+		if raw.ClientMaps.Ports != "" {
+			r.warnf("client_maps: only read on the server")
+		}
+		if len(raw.DecoyResponses) > 0 {
+			r.warnf("decoy_responses: only read on the server")
+		}
+		if raw.TProxy.Listen != "" && !tproxySupported {
+			r.warnf("tproxy: needs Linux, not started")
+		}
+	}
+	if c.Mode == "server" {
+		if len(raw.Paths) > 0 {
+			r.warnf("paths: only read on the client")
+		}
+		if raw.TProxy.Listen != "" {
+			r.warnf("tproxy: only read on the client")
+		}
+		if len(raw.Expose) > 0 {
+			r.warnf("expose: only read on the client")
+		}
+		if raw.PathProbe.Interval > 0 {
+			r.warnf("path_probe: only read on the client")
+		}
+		if len(raw.Blackout.Alternates) > 0 {
+			r.warnf("blackout: only read on the client")
+		}
+		if raw.ACME.Enabled && !tls {
+			r.warnf("acme: transport %s doesn't use TLS", c.Transport)
+		}
+	}
+	for _, m := range raw.Maps {
+		if m.MSS > 0 && !mssSupported {
+			r.warnf("map %s: mss is only applied on Linux", m.Bind)
+		}
+	}
+	if raw.Socket != (SocketConfig{}) && !socketSupported {
+		r.warnf("socket: only applied on Linux")
+	}
+	if raw.KCP != (KCPConfig{}) {
+		r.warnf("kcp: no transport of this build runs over UDP/KCP; ignored")
+	}
+	for _, u := range raw.Users {
+		if u.Quota != "" && raw.State.Path == "" {
+			r.warnf("user %s: quota needs state.path, not enforced", u.Name)
+		}
+	}
+	if sc := raw.SOCKS5; sc.RemoteDNS != "" {
+		if sc.Listen == "" {
+			r.warnf("socks5.remote_dns: only served with socks5.listen")
+		}
+		if _, err := newRemoteDNS(sc.RemoteDNSRange); err != nil {
+			r.warnf("socks5.%v, remote_dns is off", err)
+		}
+	}
+	for _, srv := range raw.Resolver.Servers {
+		u, err := parseUpstream(strings.TrimSpace(srv))
+		if err != nil {
+			r.warnf("resolver.servers: %v, skipped", err)
+			continue
+		}
+		if u.scheme == "https" {
+			if pu, err := url.Parse(u.addr); err == nil {
+				u.name = pu.Hostname()
+			}
+		}
+		if (u.scheme == "tls" || u.scheme == "https") && net.ParseIP(u.name) == nil {
+			r.warnf("resolver.servers: %s is looked up by the system resolver; an IP address avoids that", srv)
+		}
+	}
+	if t := raw.Tracing; t.Sample < 0 || t.Sample > 1 {
+		r.warnf("tracing.sample: %v is outside 0-1, every stream is traced", t.Sample)
+	}
+	if len(raw.Forward.TCP)+len(raw.Forward.UDP) > 0 {
+		if len(raw.Maps) > 0 {
+			r.warnf("forward: and maps: are both set; maps: is ignored")
+		} else {
+			r.warnf("forward: is the old map syntax; maps: takes per-map options")
+		}
+	}
+	for _, m := range raw.Maps {
+		if (m.UDPQueue != 0 || m.UDPDrop != "") && !strings.EqualFold(m.Type, "udp") {
+			r.warnf("map %s: udp_queue and udp_drop only apply to udp maps", m.Bind)
+		}
+		if m.Sticky && (m.Bond > 1 || m.Resume > 0) {
+			r.warnf("map %s: sticky is ignored with bond and resume", m.Bind)
+		}
+	}
+	if raw.Mux == "yamux" && raw.Smux != (SmuxConfig{}) {
+		r.warnf("smux: settings are ignored with mux: yamux")
+	}
+	for i, p := range raw.Paths {
+		if p.Smux == nil || c.Mode != "client" {
+			continue
+		}
+		if raw.Mux == "yamux" && (p.Smux.MaxRecv > 0 || p.Smux.FrameSize > 0) {
+			r.warnf("paths[%d].smux: only keepalive and max_stream apply with mux: yamux", i)
+		}
+		// The server times a session out after 15 of its own
+		// keepalives (at least 30s) and doesn't see path settings.
+		if p.Smux.KeepAlive >= 30 {
+			r.warnf("paths[%d].smux.keepalive: %ds may outlast the server's session timeout; keep it under 30", i, p.Smux.KeepAlive)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package httpmux
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,9 +12,6 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
-
-	utls "github.com/refraction-networking/utls"
-	"github.com/xtaci/smux"
 )
 
 // ═══════════════════════════════════════════════════════════════
@@ -36,8 +35,30 @@ type Client struct {
 	verbose bool
 
 	sessMu   sync.RWMutex
-	sessions []*smux.Session
+	sessions []*clientSession
 	rrIndex  uint64
+
+	stats    *Stats
+	life     *lifecycle
+	breakers *clientBreakers
+	bonds    *bondRegistry
+	warm     *warmPools
+	fpPins   fingerprintPins
+	certs    *certVerifier // nil = server certificate not checked
+	hop      *hopSchedule  // nil = dial the path's port
+	sd       *systemd      // nil = not started by systemd (Type=notify)
+	tracer   *tracer       // nil = no tracing.endpoint
+	sockets  *socketOpts   // nil = default tunnel sockets
+	probe    *pathProber   // nil = no path_probe
+	blackout *blackout     // nil = no blackout alternates
+
+	remoteDNS *remoteDNS  // nil = no socks5.remote_dns
+	router    *router     // nil = all SOCKS5 traffic through the tunnel
+	exposed   []pushedMap // expose: maps asked of the server
+
+	instanceID string      // per process, lets the server group our sessions
+	isReady    atomic.Bool // min_sessions reached (logging only)
+	pushed     atomic.Pointer[[]pushedMap]
 }
 
 func NewClient(cfg *Config) *Client {
@@ -51,37 +72,87 @@ func NewClient(cfg *Config) *Client {
 			DialTimeout:    10,
 		}}
 	}
-	return &Client{
-		cfg:     cfg,
-		mimic:   &cfg.Mimic,
-		obfs:    &cfg.Obfs,
-		psk:     cfg.PSK,
-		paths:   paths,
-		verbose: cfg.Verbose,
+	c := &Client{
+		cfg:      cfg,
+		mimic:    &cfg.Mimic,
+		obfs:     &cfg.Obfs,
+		psk:      cfg.PSK,
+		paths:    paths,
+		verbose:  cfg.Verbose,
+		stats:    NewStats(),
+		life:     newLifecycle(),
+		breakers: newClientBreakers(),
+		bonds:    newBondRegistry(),
+		warm:     newWarmPools(),
+		sd:       newSystemd(),
+
+		instanceID: newInstanceID(),
+	}
+	hop, err := newHopSchedule(cfg)
+	if err != nil {
+		log.Printf("[HOP] port_hopping: %v — using the path ports", err)
+	} else if hop != nil {
+		log.Printf("[HOP] dialing ports %s, changing every %v", cfg.PortHopping.Range, hop.every)
+	}
+	c.hop = hop
+	if c.sockets, err = newSocketOpts(&cfg.Socket); err != nil {
+		log.Printf("[SOCK] socket: %v — using default options", err)
+	}
+	if c.probe, err = newPathProber(&cfg.PathProbe, paths); err != nil {
+		log.Printf("[PROBE] path_probe: %v — not probing", err)
+	}
+	if c.blackout, err = newBlackout(&cfg.Blackout); err != nil {
+		log.Printf("[BLACKOUT] blackout: %v — not rotating", err)
+	}
+	if c.exposed, err = exposeMaps(cfg.Expose); err != nil {
+		log.Printf("[EXPOSE] %v — asking for no maps", err)
+	}
+	c.tracer = newTracer(cfg, c.stats)
+	c.life.resolver = newResolver(cfg)
+	if c.router, err = newRouter(&cfg.Routing, c.life.resolver); err != nil {
+		log.Printf("[ROUTE] routing: %v — everything goes through the tunnel", err)
 	}
+	return c
 }
 
-func (c *Client) Start() error {
+// Stats returns the client's traffic counters.
+func (c *Client) Stats() *Stats { return c.stats }
+
+// Start connects the session pool and serves until Shutdown is called
+// or ctx is cancelled, which shuts it down the same way.
+func (c *Client) Start(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { c.Shutdown() })
+	defer stop()
 	if len(c.paths) == 0 {
 		return fmt.Errorf("no paths configured")
 	}
 
-	poolSize := c.paths[0].ConnectionPool
-	if poolSize <= 0 {
-		poolSize = c.cfg.NumConnections
-	}
-	if poolSize <= 0 {
-		poolSize = 4
+	certs, err := newCertVerifier(c.cfg)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
 	}
+	c.certs = certs
+
+	poolSize := c.poolSize(c.paths[0])
 
-	sc := buildSmuxConfig(c.cfg)
-	log.Printf("[CLIENT] pool=%d paths=%d profile=%s", poolSize, len(c.paths), c.cfg.Profile)
+	sc := buildSmuxConfig(c.cfg, nil)
+	log.Printf("[CLIENT] pool=%d paths=%d profile=%s balance=%s", poolSize, len(c.paths), c.cfg.Profile, c.cfg.LoadBalance)
 	for i, p := range c.paths {
 		log.Printf("[CLIENT]   path[%d]: %s (%s)", i, p.Addr, p.Transport)
+		if p.Smux != nil {
+			ps := buildSmuxConfig(c.cfg, &p)
+			log.Printf("[CLIENT]   path[%d]: smux keepalive=%v frame=%d maxrecv=%d maxstream=%d",
+				i, ps.KeepAliveInterval, ps.MaxFrameSize, ps.MaxReceiveBuffer, ps.MaxStreamBuffer)
+		}
 	}
 	log.Printf("[CLIENT] smux: keepalive=%v timeout=%v frame=%d",
 		sc.KeepAliveInterval, sc.KeepAliveTimeout, sc.MaxFrameSize)
-	if c.cfg.Stealth.RandomPadding {
+	if certs != nil {
+		log.Printf("[CLIENT] tls: verify=%v pins=%d", certs.verify, len(certs.pins))
+	}
+	if c.cfg.Stealth.TrafficModel != "" {
+		log.Printf("[CLIENT] stealth: traffic_model=%s jitter=%dms", c.cfg.Stealth.TrafficModel, c.cfg.Stealth.ConnJitterMS)
+	} else if c.cfg.Stealth.RandomPadding {
 		log.Printf("[CLIENT] stealth: padding=%d-%dB jitter=%dms",
 			c.cfg.Stealth.MinPadding, c.cfg.Stealth.MaxPadding, c.cfg.Stealth.ConnJitterMS)
 	}
@@ -92,30 +163,88 @@ func (c *Client) Start() error {
 	}
 
 	go c.sessionHealthCheck()
+	go c.runPathProbe()
+	go c.runDecoyBrowsing()
+	go c.sd.watchdog(c.life)
+	logResolver(c.life.resolver)
+	logRouter(c.router)
+	go c.tracer.run(c.life)
+	c.startEchoMaps()
+	startMapDNS(c.cfg, c.life)
+	c.startSOCKS5()
+	c.startTProxy()
+	if d := newDiscoveryRelay(&c.cfg.Discovery, c.life); d != nil {
+		go c.runDiscovery(d)
+	}
+
+	// Failover: every worker starts on path 0. Multipath: each path
+	// gets its own pinned workers.
+	type slot struct{ path int }
+	var slots []slot
+	if c.multipath() {
+		for p := range c.paths {
+			for i := 0; i < c.poolSize(c.paths[p]); i++ {
+				slots = append(slots, slot{p})
+			}
+		}
+	} else {
+		for i := 0; i < poolSize; i++ {
+			slots = append(slots, slot{0})
+		}
+	}
 
 	var wg sync.WaitGroup
-	for i := 0; i < poolSize; i++ {
+	for i, sl := range slots {
 		wg.Add(1)
-		go func(id int) {
+		go func(id, path int) {
 			defer wg.Done()
-			c.poolWorker(id)
-		}(i)
+			c.poolWorker(c.life.ctx, id, path)
+		}(i, sl.path)
 		// v2.5: Randomized stagger to avoid DPI pattern detection
 		base := 500
 		jitter := secureRandInt(c.cfg.Stealth.ConnJitterMS + 1)
-		time.Sleep(time.Duration(base+jitter) * time.Millisecond)
+		if !c.life.sleep(time.Duration(base+jitter) * time.Millisecond) {
+			break
+		}
 	}
 
+	go c.spareMonitor(c.life.ctx, len(slots))
+
 	wg.Wait()
+	<-c.life.stopped
 	return nil
 }
 
-func (c *Client) poolWorker(id int) {
-	pathIdx := 0
+func (c *Client) poolSize(p PathConfig) int {
+	n := 4
+	if p.ConnectionPool > 0 {
+		n = p.ConnectionPool
+	} else if c.cfg.NumConnections > 0 {
+		n = c.cfg.NumConnections
+	}
+	return max(n, p.MinSessions)
+}
+
+// poolWorker keeps one session alive, starting on pathIdx. In multipath
+// mode the worker stays on its path; otherwise it fails over. It returns
+// once shutdown begins; ctx is the lifecycle context.
+func (c *Client) poolWorker(ctx context.Context, id, pathIdx int) {
+	defer guardPanic("pool worker")
+	pinned := c.multipath()
 	failCount := 0
 	consecutiveSuccess := 0
+	var aged *clientSession // retired once its replacement is up
 
-	for {
+	for !c.life.isClosing() {
+		if pinned && c.probe.down(pathIdx) {
+			c.life.sleep(c.probe.every) // measured down: wait for the prober
+			continue
+		}
+		if p := c.probedPath(pathIdx); !pinned && p != pathIdx {
+			log.Printf("[POOL#%d] path[%d] measured down → path[%d] %s",
+				id, pathIdx, p, c.paths[p].Addr)
+			pathIdx, failCount = p, 0
+		}
 		path := c.paths[pathIdx]
 		retryInterval := time.Duration(path.RetryInterval) * time.Second
 		if retryInterval <= 0 {
@@ -123,11 +252,21 @@ func (c *Client) poolWorker(id int) {
 		}
 
 		connStart := time.Now()
-		err := c.connectAndServe(id, path)
+		err := c.connectAndServe(ctx, id, pathIdx, path, aged, false)
 		connDuration := time.Since(connStart)
 
+		var ae agedError
+		if errors.As(err, &ae) {
+			aged = ae.cs
+			failCount = 0
+			continue // connect the replacement now
+		}
+
 		if err != nil {
 			alive := c.sessionCount()
+			if c.verbose && !c.life.isClosing() {
+				logDedupf("", "[POOL] %s: %v", path.Addr, err)
+			}
 
 			if connDuration < 30*time.Second {
 				failCount++
@@ -139,17 +278,24 @@ func (c *Client) poolWorker(id int) {
 				consecutiveSuccess++
 			}
 
+			if aggressiveRetry(path, failCount) {
+				c.life.sleep(time.Duration(secureRandInt(200)) * time.Millisecond)
+				continue
+			}
+
 			// Switch path after repeated quick failures
-			if failCount >= maxFailsBeforeSwitch && len(c.paths) > 1 {
+			if failCount >= maxFailsBeforeSwitch && len(c.paths) > 1 && !pinned {
 				oldIdx := pathIdx
-				pathIdx = (pathIdx + 1) % len(c.paths)
+				pathIdx = c.nextPath(pathIdx) // skips paths measured down
 				failCount = 0
-				log.Printf("[POOL#%d] path[%d] blocked → path[%d] %s",
-					id, oldIdx, pathIdx, c.paths[pathIdx].Addr)
+				if pathIdx != oldIdx {
+					log.Printf("[POOL#%d] path[%d] blocked → path[%d] %s",
+						id, oldIdx, pathIdx, c.paths[pathIdx].Addr)
+				}
 
-				if pathIdx == 0 {
+				if pathIdx <= oldIdx {
 					log.Printf("[POOL#%d] all paths tried, backing off 10s", id)
-					time.Sleep(10 * time.Second)
+					c.life.sleep(10 * time.Second)
 					continue
 				}
 			} else if failCount > 0 {
@@ -160,23 +306,38 @@ func (c *Client) poolWorker(id int) {
 				}
 				log.Printf("[POOL#%d] retry in %v (fails=%d alive=%d)",
 					id, backoff.Round(time.Millisecond), failCount, alive)
-				time.Sleep(backoff)
+				c.life.sleep(backoff)
 				continue
 			}
 
 			// v2.5: Add random jitter to prevent all workers reconnecting simultaneously
 			jitter := time.Duration(secureRandInt(500)) * time.Millisecond
-			time.Sleep(retryInterval + jitter)
+			if c.belowMin(pathIdx) {
+				c.life.sleep(jitter) // min_sessions: replace it now
+				continue
+			}
+			c.life.sleep(retryInterval + jitter)
 		} else {
 			failCount = 0
 			consecutiveSuccess++
 			jitter := time.Duration(secureRandInt(1000)) * time.Millisecond
-			time.Sleep(retryInterval + jitter)
+			c.life.sleep(retryInterval + jitter)
 		}
 	}
 }
 
-func (c *Client) connectAndServe(id int, path PathConfig) error {
+// connectAndServe runs one session on path. aged, when set, is retired
+// once the new session is up; spare marks an aggressive_pool spare.
+// Cancelling ctx aborts the dial and the handshake; a session that is
+// up ends with the drain.
+func (c *Client) connectAndServe(ctx context.Context, id, pathIdx int, path PathConfig, aged *clientSession, spare bool) error {
+	path, variant := c.blackout.apply(path)
+	up := false
+	defer func() {
+		if !up {
+			c.blackout.failed(variant)
+		}
+	}()
 	transport := strings.ToLower(strings.TrimSpace(path.Transport))
 	if transport == "" {
 		transport = c.cfg.Transport
@@ -191,8 +352,7 @@ func (c *Client) connectAndServe(id int, path PathConfig) error {
 		dialTimeout = 10 * time.Second
 	}
 
-	host, port := parseAddr(addr, transport)
-	dialAddr := net.JoinHostPort(host, port)
+	dialAddr := c.pathDialAddr(path)
 
 	if c.verbose {
 		log.Printf("[POOL#%d] connecting to %s (%s)", id, dialAddr, transport)
@@ -201,69 +361,152 @@ func (c *Client) connectAndServe(id int, path PathConfig) error {
 	// v2.5: Random pre-connect delay for DPI stealth
 	if c.cfg.Stealth.ConnJitterMS > 0 {
 		jitter := secureRandInt(c.cfg.Stealth.ConnJitterMS)
-		time.Sleep(time.Duration(jitter) * time.Millisecond)
+		t := time.NewTimer(time.Duration(jitter) * time.Millisecond)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
 	}
 
 	// ① Dial TCP/TLS connection
+	dial := func() (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		switch {
+		case TransportUsesTLS(transport):
+			conn, err = c.dialFragmentedTLS(ctx, dialAddr, dialTimeout, c.tlsFingerprint(pathIdx, path), transport == "h2mux")
+		case httpTransport(transport):
+			conn, err = dialFragmented(ctx, dialAddr, c.fragmentCfg(), dialTimeout, c.cfg.IPPreference, c.sockets)
+		default: // tcpmux: plain TCP, no fragmentation
+			conn, err = happyDial(ctx, dialAddr, dialTimeout, c.cfg.IPPreference, c.sockets.dialTCP)
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.setTCPOptions(conn)
+		return conn, nil
+	}
 	var conn net.Conn
 	var err error
-
-	switch transport {
-	case "httpsmux", "wssmux":
-		conn, err = c.dialFragmentedTLS(dialAddr, dialTimeout)
-	case "httpmux", "wsmux":
-		conn, err = DialFragmented(dialAddr, c.fragmentCfg(), dialTimeout)
-	default:
-		conn, err = net.DialTimeout("tcp", dialAddr, dialTimeout)
+	if path.AggressivePool {
+		conn, err = raceConnect(ctx, dialTimeout, dial)
+	} else {
+		conn, err = dial()
+	}
+	if c.hop != nil {
+		c.hop.dialed(err)
 	}
 	if err != nil {
 		return fmt.Errorf("dial: %w", err)
 	}
-
-	c.setTCPOptions(conn)
+	// Until the session is up a shutdown closes the raw connection,
+	// which fails whatever handshake step is blocked on it.
+	raw := conn
+	stop := context.AfterFunc(ctx, func() { raw.Close() })
+	defer stop()
 
 	// ② Mimicry handshake — v2.5.1: stealth rotation per connection
-	conn, err = ClientHandshakeWithStealth(conn, c.mimic, &c.cfg.Stealth)
+	var tunnel net.Conn
+	switch transport {
+	case "h2mux":
+		tunnel, err = clientH2Tunnel(conn, c.mimic, &c.cfg.Stealth)
+	case "xhttpmux", "xhttpsmux":
+		tunnel, err = clientXHTTPTunnel(conn, dial, c.mimic, &c.cfg.Stealth)
+	default:
+		tunnel, err = ClientHandshakeWithStealth(conn, c.mimic, &c.cfg.Stealth)
+	}
 	if err != nil {
 		conn.Close()
 		return fmt.Errorf("handshake: %w", err)
 	}
+	conn = tunnel
 
-	// ③ Encrypted connection (AES-256-GCM)
-	ec, err := NewEncryptedConn(conn, c.psk, c.obfs, &c.cfg.Stealth)
+	// ②½ PSK challenge-response — server drops us here on a wrong PSK
+	mode, merr := pathEncryptionMode(path.Encryption, transport)
+	if merr != nil {
+		logDedupf(path.Addr, "[POOL] %s: %v — using aes", path.Addr, merr)
+	}
+	nonce, key, err := clientAuthenticate(conn, c.psk, mode, authSkew(c.cfg))
 	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	// ③ Encrypted connection (AES-256-GCM, per-connection key)
+	var ec *EncryptedConn
+	if mode == encModeNone {
+		ec = NewIntegrityConn(conn, key, c.obfs, &c.cfg.Stealth)
+	} else if ec, err = NewEncryptedConnKey(conn, key, c.obfs, &c.cfg.Stealth); err != nil {
 		conn.Close()
 		return fmt.Errorf("encrypt: %w", err)
 	}
+	ec.BindSession(nonce, false)
 
-	// ④ smux session
-	sc := buildSmuxConfig(c.cfg)
-	sess, err := smux.Client(ec, sc)
+	// ④ mux session (smux or yamux)
+	sess, err := newMuxClient(c.cfg, &path, ec)
 	if err != nil {
 		ec.Close()
-		return fmt.Errorf("smux: %w", err)
+		return fmt.Errorf("mux: %w", err)
 	}
 
-	c.addSession(sess)
+	if !stop() || c.life.isClosing() {
+		sess.Close()
+		return fmt.Errorf("shutting down")
+	}
+	cs := &clientSession{sess: sess, path: pathIdx, nonce: nonce, created: time.Now(), spare: spare}
+	c.addSession(cs)
 	count := c.sessionCount()
+	up = true
+	c.blackout.sessionUp(count, variant)
 	log.Printf("[POOL#%d] connected to %s (pool: %d)", id, dialAddr, count)
-
-	// ⑤ Accept reverse streams — blocks until session dies
-	for {
-		stream, err := sess.AcceptStream()
-		if err != nil {
-			c.removeSession(sess)
-			sess.Close()
-			return fmt.Errorf("session closed: %w", err)
+	go func() {
+		c.sendSessionHello(cs, false)
+		c.retireSession(aged) // after the server has heard of cs
+	}()
+	go c.probeRTT(cs)
+	go c.heartbeat(cs)
+
+	// ⑤ Accept reverse streams until the session dies; the accept loop
+	// outlives this call when the session is renewed.
+	var renew <-chan time.Time
+	if age := sessionAge(path); age > 0 {
+		t := time.NewTimer(age)
+		defer t.Stop()
+		renew = t.C
+	}
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			stream, err := sess.AcceptStream()
+			if err != nil {
+				c.removeSession(sess)
+				sess.Close()
+				closed <- err
+				return
+			}
+			go c.handleReverseStream(cs, stream)
 		}
-		go c.handleReverseStream(stream)
+	}()
+	select {
+	case err := <-closed:
+		return fmt.Errorf("session closed: %w", err)
+	case <-renew:
+		return agedError{cs}
 	}
 }
 
 // handleReverseStream reads the stream type tag and target, then proxies.
 // v2.5: Supports stream type tags for proper routing.
-func (c *Client) handleReverseStream(stream *smux.Stream) {
+func (c *Client) handleReverseStream(cs *clientSession, stream net.Conn) {
+	defer guardPanic("client stream")
 	defer stream.Close()
+	if !c.life.acquire() {
+		refuseStream(stream)
+		return
+	}
+	defer c.life.release()
 
 	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
 
@@ -276,20 +519,35 @@ func (c *Client) handleReverseStream(stream *smux.Stream) {
 	switch typeBuf[0] {
 	case StreamTypeReverse:
 		// Normal reverse proxy stream — read target and dial
-		c.proxyReverseStream(stream)
+		c.proxyReverseStream(cs, stream)
+
+	case StreamTypePing:
+		servePing(stream)
+
+	case StreamTypeMaps:
+		c.servePushedMaps(stream)
 
 	case 0xFF:
 		// Fake traffic (DPI stealth) — just drain and discard
 		io.Copy(io.Discard, stream)
 
 	default:
-		// Unknown or old-format — try to handle as target header
-		// for backward compatibility with v2.4 servers
-		c.handleLegacyStream(stream, typeBuf)
+		// A server that negotiated never sends an untagged stream: the
+		// type is one this client doesn't know.
+		if p := cs.peer.Load(); p != nil && p.Proto > 0 {
+			if c.verbose {
+				logDedupf("stream-type", "[STREAM] unknown type 0x%02x from a %s server", typeBuf[0], p.protoString())
+			}
+			return
+		}
+		// No type tag: a v2.4 server (legacy.go)
+		c.handleLegacyStream(cs, stream, typeBuf)
 	}
 }
 
-func (c *Client) proxyReverseStream(stream *smux.Stream) {
+func (c *Client) proxyReverseStream(cs *clientSession, stream net.Conn) {
+	sp := c.tracer.startStream("reverse", spanKindClient)
+	sp.link(cs.nonce, stream, false)
 	// Read target: [2B len][target string]
 	hdr := make([]byte, 2)
 	if _, err := io.ReadFull(stream, hdr); err != nil {
@@ -305,47 +563,81 @@ func (c *Client) proxyReverseStream(stream *smux.Stream) {
 	}
 
 	stream.SetReadDeadline(time.Time{})
+	sp.event("header_read")
 
-	network, addr := splitTarget(string(tBuf))
-
-	remote, err := net.DialTimeout(network, addr, 10*time.Second)
-	if err != nil {
-		if c.verbose {
-			log.Printf("[REVERSE] dial %s://%s: %v", network, addr, err)
-		}
+	if strings.HasPrefix(string(tBuf), bondScheme) {
+		c.proxyBondStream(stream, string(tBuf))
 		return
 	}
-	defer remote.Close()
-	relay(stream, remote)
-}
-
-// handleLegacyStream — backward compat with v2.4 servers that don't send type tags.
-// The first byte was already read as typeBuf; prepend it to the header read.
-func (c *Client) handleLegacyStream(stream *smux.Stream, firstByte []byte) {
-	// The firstByte is actually the first byte of the 2-byte length header
-	hdr2 := make([]byte, 1)
-	if _, err := io.ReadFull(stream, hdr2); err != nil {
-		return
+	target, keep := splitKeepTarget(string(tBuf))
+	target, warm := splitWarmTarget(target)
+	target, compress := splitCompressTarget(target)
+	target, src, dst, proxy := splitFromTarget(target)
+	target, prio := splitPrioTarget(target)
+	if prio != "" {
+		stream = cs.sched.wrap(stream, prio)
 	}
-	tLen := binary.BigEndian.Uint16([]byte{firstByte[0], hdr2[0]})
-	if tLen == 0 || tLen > 4096 {
-		return
+	var tunnel io.ReadWriteCloser = stream
+	if keep {
+		tunnel = newKeepConn(stream)
+		defer tunnel.Close()
 	}
-	tBuf := make([]byte, tLen)
-	if _, err := io.ReadFull(stream, tBuf); err != nil {
-		return
+	if compress != "" {
+		tunnel = newCompressConn(tunnel, compress)
 	}
 
-	stream.SetReadDeadline(time.Time{})
+	if isEchoTarget(target) {
+		serveEcho(tunnel)
+		return
+	}
+	defer sp.end()
+	sp.attr("picotun.target", target)
 
-	network, addr := splitTarget(string(tBuf))
+	resolved, ok := c.resolveTarget(target)
+	if !ok {
+		sp.fail(fmt.Errorf("not in services"))
+		c.refuseTarget(target)
+		return
+	}
+	network, addr := splitTarget(resolved)
 
-	remote, err := net.DialTimeout(network, addr, 10*time.Second)
+	var remote net.Conn
+	var err error
+	if warm > 0 && network == "tcp" {
+		remote, err = c.dialWarm(addr, warm)
+	} else {
+		remote, err = c.life.dial(network, addr, 10*time.Second)
+	}
 	if err != nil {
+		sp.fail(err)
+		c.stats.incError("dial")
+		if c.verbose {
+			logDedupf(network+addr, "[REVERSE] dial %s://%s: %v", network, addr, err)
+		}
+		c.dialFailed(target)
 		return
 	}
+	c.dialOK(target)
+	sp.event("dialed")
 	defer remote.Close()
-	relay(stream, remote)
+	logVisitor(src, addr)
+	if proxy > 0 && network == "tcp" {
+		if err := writeProxyHeader(remote, proxy, src, dst); err != nil {
+			return
+		}
+	}
+	m, done := c.stats.connOpened(network + ":" + addr)
+	defer done()
+	if network == "udp" {
+		relayDatagrams(newDatagramConn(tunnel), &countedConn{ReadWriteCloser: remote, st: c.stats, m: m, span: sp},
+			time.Duration(c.cfg.Advanced.UDPFlowTimeout)*time.Second)
+		return
+	}
+	idle := streamIdle(c.cfg)
+	if keep {
+		idle = 0
+	}
+	relay(tunnel, &countedConn{ReadWriteCloser: remote, st: c.stats, m: m, span: sp}, idle)
 }
 
 func (c *Client) setTCPOptions(conn net.Conn) {
@@ -367,21 +659,28 @@ func (c *Client) setTCPOptions(conn net.Conn) {
 
 // ──────────── Session Pool ────────────
 
-func (c *Client) addSession(sess *smux.Session) {
+func (c *Client) addSession(cs *clientSession) {
 	c.sessMu.Lock()
-	c.sessions = append(c.sessions, sess)
+	c.sessions = append(c.sessions, cs)
 	c.sessMu.Unlock()
+	c.stats.sessionAdded()
+	c.noteReady()
 }
 
-func (c *Client) removeSession(sess *smux.Session) {
+func (c *Client) removeSession(sess muxSession) {
 	c.sessMu.Lock()
 	for i, s := range c.sessions {
-		if s == sess {
+		if s.sess == sess {
 			c.sessions = append(c.sessions[:i], c.sessions[i+1:]...)
+			c.stats.sessionRemoved()
+			if !c.life.isClosing() {
+				c.blackout.sessionLost(len(c.sessions))
+			}
 			break
 		}
 	}
 	c.sessMu.Unlock()
+	c.noteReady()
 }
 
 func (c *Client) sessionCount() int {
@@ -392,52 +691,89 @@ func (c *Client) sessionCount() int {
 
 // OpenStream — used by client-side forward proxy
 // v2.5: Writes stream type tag before target header
-func (c *Client) OpenStream(target string) (*smux.Stream, error) {
-	c.sessMu.RLock()
-	n := len(c.sessions)
+func (c *Client) OpenStream(target string) (net.Conn, error) {
+	return c.openStream(target, nil)
+}
+
+// openStream is OpenStream, linking the stream to sp (tracing.go).
+func (c *Client) openStream(target string, sp *streamSpan) (net.Conn, error) {
+	if c.warmupConfigured() && !c.ready() {
+		c.WaitReady(readyWait)
+	}
+	sessions := c.orderSessions()
+	n := len(sessions)
 	if n == 0 {
-		c.sessMu.RUnlock()
 		return nil, fmt.Errorf("no active session")
 	}
-	sessions := make([]*smux.Session, n)
-	copy(sessions, c.sessions)
-	c.sessMu.RUnlock()
-
-	idx := atomic.AddUint64(&c.rrIndex, 1)
-	for i := 0; i < n; i++ {
-		pick := sessions[(int(idx)+i)%n]
-		if pick.IsClosed() {
+	var lacked string
+	for _, pick := range sessions {
+		if pick.sess.IsClosed() {
+			continue
+		}
+		peer := pick.peer.Load()
+		if f := peer.lacks(target); f != "" {
+			lacked = f
 			continue
 		}
-		stream, err := pick.OpenStream()
+		fit := peer.fitTarget(statusTarget(target))
+		stream, err := openTargetStream(pick.sess, fit)
 		if err == nil {
-			// v2.5: Write stream type tag
-			stream.Write([]byte{StreamTypeForward})
-			sendTarget(stream, target)
+			sp.link(pick.nonce, stream, true)
+			if _, _, ok := takeTargetFlag(fit, featStatus); ok {
+				stream = &statusConn{Conn: stream, target: target}
+			}
+			if _, prio := splitPrioTarget(target); prio != "" {
+				stream = pick.sched.wrap(stream, prio)
+			}
 			return stream, nil
 		}
-		c.removeSession(pick)
+		c.removeSession(pick.sess)
+	}
+	if lacked != "" {
+		return nil, fmt.Errorf("server doesn't support %s streams", lacked)
 	}
 	return nil, fmt.Errorf("all %d sessions dead", n)
 }
 
+// openTargetStream opens a forward stream on sess and sends its header.
+func openTargetStream(sess muxSession, target string) (net.Conn, error) {
+	stream, err := sess.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	// v2.5: Write stream type tag
+	stream.Write([]byte{StreamTypeForward})
+	sendTarget(stream, target)
+	return stream, nil
+}
+
 func (c *Client) sessionHealthCheck() {
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(c.sd.healthEvery(5 * time.Second))
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.life.done:
+			return
+		}
 		c.sessMu.Lock()
 		alive := c.sessions[:0]
 		removed := 0
-		for _, sess := range c.sessions {
-			if sess.IsClosed() {
-				sess.Close()
+		for _, cs := range c.sessions {
+			if cs.sess.IsClosed() {
+				cs.sess.Close()
 				removed++
+				c.stats.sessionRemoved()
 			} else {
-				alive = append(alive, sess)
+				alive = append(alive, cs)
 			}
 		}
 		c.sessions = alive
+		if removed > 0 && !c.life.isClosing() {
+			c.blackout.sessionLost(len(alive))
+		}
 		c.sessMu.Unlock()
+		c.sd.alive()
 		if removed > 0 {
 			log.Printf("[POOL] cleaned %d dead sessions (alive: %d)", removed, len(alive))
 		}
@@ -446,9 +782,17 @@ func (c *Client) sessionHealthCheck() {
 
 // ──────────── TLS ────────────
 
-func (c *Client) dialFragmentedTLS(addr string, timeout time.Duration) (net.Conn, error) {
+// tlsClientOpts configures clientTLSHandshake.
+type tlsClientOpts struct {
+	sni         string
+	fingerprint string               // a namedFingerprints entry or a JA3 string
+	verify      func([][]byte) error // nil = any certificate
+	h2          bool                 // offer h2 in ALPN (h2mux)
+}
+
+func (c *Client) dialFragmentedTLS(ctx context.Context, addr string, timeout time.Duration, fingerprint string, h2 bool) (net.Conn, error) {
 	fragCfg := c.fragmentCfg()
-	rawConn, err := DialFragmented(addr, fragCfg, timeout)
+	rawConn, err := dialFragmented(ctx, addr, fragCfg, timeout, c.cfg.IPPreference, c.sockets)
 	if err != nil {
 		return nil, err
 	}
@@ -456,35 +800,18 @@ func (c *Client) dialFragmentedTLS(addr string, timeout time.Duration) (net.Conn
 	if sni == "" {
 		sni, _, _ = net.SplitHostPort(addr)
 	}
+	verify := c.certs.peerCheck(sni) // the server's name, not a rotated one
 
 	// v2.5.1: Rotate SNI to match rotated Host header for DPI consistency
 	if c.cfg.Stealth.RotateDomain && len(c.cfg.Stealth.DomainPool) > 0 {
 		sni = c.cfg.Stealth.DomainPool[secureRandInt(len(c.cfg.Stealth.DomainPool))]
 	}
 
-	// v2.5: Use different TLS fingerprints randomly
-	helloID := randomTLSHello()
-
-	uConn := utls.UClient(rawConn, &utls.Config{
-		ServerName:         sni,
-		InsecureSkipVerify: true,
-	}, helloID)
-	if err := uConn.Handshake(); err != nil {
-		uConn.Close()
+	conn, err := clientTLSHandshake(rawConn, tlsClientOpts{sni: sni, fingerprint: fingerprint, verify: verify, h2: h2})
+	if err != nil {
 		return nil, fmt.Errorf("tls: %w", err)
 	}
-	return uConn, nil
-}
-
-// randomTLSHello picks a random TLS fingerprint to avoid DPI fingerprinting
-func randomTLSHello() utls.ClientHelloID {
-	hellos := []utls.ClientHelloID{
-		utls.HelloChrome_120,
-		utls.HelloFirefox_120,
-		utls.HelloEdge_Auto,
-		utls.HelloSafari_Auto,
-	}
-	return hellos[secureRandInt(len(hellos))]
+	return conn, nil
 }
 
 func (c *Client) fragmentCfg() *FragmentConfig {
@@ -493,7 +820,7 @@ func (c *Client) fragmentCfg() *FragmentConfig {
 	}
 	transport := strings.ToLower(c.cfg.Transport)
 	// v2.5.1: Enable fragment for httpmux too (helps DPI evasion on plain HTTP)
-	if transport == "httpsmux" || transport == "wssmux" || transport == "httpmux" || transport == "wsmux" {
+	if httpTransport(transport) {
 		cfg := DefaultFragmentConfig()
 		return &cfg
 	}
@@ -512,12 +839,10 @@ func parseAddr(addr, transport string) (host, port string) {
 	}
 	h, p, err := net.SplitHostPort(addr)
 	if err != nil {
-		h = addr
-		switch transport {
-		case "httpsmux", "wssmux":
+		h = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]") // "[2001:db8::1]" or a bare literal
+		p = "80"
+		if TransportUsesTLS(transport) {
 			p = "443"
-		default:
-			p = "80"
 		}
 	}
 	return h, p
@@ -0,0 +1,265 @@
+package httpmux
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Maps requested by the client (client → server)
+//
+//   # client
+//   expose:
+//     - { type: tcp, bind: "0.0.0.0:8443", target: "localhost:443" }
+//     - { type: udp, bind: "27015",        target: "127.0.0.1:27015" }
+//
+//   # server
+//   client_maps:
+//     ports: "8000-8999,27015"  # ports clients may claim ("" = none)
+//     hosts: ["0.0.0.0"]        # bind addresses they may use (empty = any)
+//     udp: true                 # allow udp maps
+//     max: 4                    # maps per client
+//
+// Like frp's client-side proxies: the client lists the maps it wants
+// in its session hello ("expose=tcp 0.0.0.0:8443 localhost:443") and
+// the server opens those its client_maps: policy allows, pinned to that
+// client's sessions (the owner tag, "#" + the client's id, which no
+// configured tag can match). Maps the policy refuses are sent back in
+// the hello answer ("refused=tcp 0.0.0.0:8443 port not allowed") and
+// logged on both ends.
+//
+// A client map lives as long as its client: when the last session with
+// the client's id has been gone for clientMapGrace, its maps close.
+// Reconnecting within that time finds them still open, and a hello
+// that no longer lists a map closes it. Exposed targets pass the
+// client's services: whitelist — the client asked for them.
+//
+// The server's own maps and admin API maps win: a bind already in use
+// is refused. Only configured server-side, so no client can open ports
+// on a server that hasn't opted in.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	ownerTag       = "#" // map tag prefix: pinned to one client id
+	clientMapGrace = 30 * time.Second
+)
+
+type ExposeMap struct {
+	Type   string `yaml:"type"` // tcp (default) | udp
+	Bind   string `yaml:"bind"`
+	Target string `yaml:"target"`
+}
+
+type ClientMapsConfig struct {
+	Ports string   `yaml:"ports"`
+	Hosts []string `yaml:"hosts"`
+	UDP   bool     `yaml:"udp"`
+	Max   int      `yaml:"max"`
+}
+
+// exposeMaps normalizes the client's expose: list.
+func exposeMaps(list []ExposeMap) ([]pushedMap, error) {
+	var out []pushedMap
+	for i, e := range list {
+		network := strings.ToLower(strings.TrimSpace(e.Type))
+		if network == "" {
+			network = "tcp"
+		}
+		if network != "tcp" && network != "udp" {
+			return nil, fmt.Errorf("expose[%d]: type %q: want tcp or udp", i, e.Type)
+		}
+		bind, target, ok := SplitMap(e.Bind + "->" + e.Target)
+		if !ok || strings.ContainsAny(bind+target, " \n") {
+			return nil, fmt.Errorf("expose[%d]: bind and target are required", i)
+		}
+		if err := checkBracketed(bind); err != nil {
+			return nil, fmt.Errorf("expose[%d]: %w", i, err)
+		}
+		out = append(out, pushedMap{Network: network, Bind: bind, Target: target})
+	}
+	return out, nil
+}
+
+// exposesTarget reports whether addr is the target of one of our
+// expose: maps.
+func (c *Client) exposesTarget(addr string) bool {
+	for _, m := range c.exposed {
+		if m.Target == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// logRefusedMaps logs the maps the server wouldn't open for us.
+func logRefusedMaps(peer *sessionInfo, asked int) {
+	if asked == 0 {
+		return
+	}
+	if !peer.supports(featExpose) {
+		logDedupf("expose", "[EXPOSE] server doesn't take client maps; expose: ignored")
+		return
+	}
+	for _, r := range peer.Refused {
+		logDedupf("expose"+r, "[EXPOSE] server refused %s", r)
+	}
+}
+
+// ──────────── Server ────────────
+
+type clientMapPolicy struct {
+	ports []portRange
+	hosts []string
+	udp   bool
+	max   int
+}
+
+func newClientMapPolicy(cfg *ClientMapsConfig) (*clientMapPolicy, error) {
+	if strings.TrimSpace(cfg.Ports) == "" {
+		return nil, nil
+	}
+	pr, err := parsePortRanges(cfg.Ports)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Max < 0 {
+		return nil, fmt.Errorf("max %d: want 0 (no limit) or more", cfg.Max)
+	}
+	return &clientMapPolicy{ports: pr, hosts: cfg.Hosts, udp: cfg.UDP, max: cfg.Max}, nil
+}
+
+// allows returns why m may not be opened, or "".
+func (p *clientMapPolicy) allows(m pushedMap) string {
+	if p == nil {
+		return "client maps are off"
+	}
+	if m.Network == "udp" && !p.udp {
+		return "udp not allowed"
+	}
+	host, ps, err := net.SplitHostPort(m.Bind)
+	if err != nil {
+		return "bad bind"
+	}
+	port, _ := strconv.Atoi(ps)
+	if !slices.ContainsFunc(p.ports, func(r portRange) bool { return port >= r.lo && port <= r.hi }) {
+		return "port not allowed"
+	}
+	if len(p.hosts) > 0 && !slices.Contains(p.hosts, host) {
+		return "bind address not allowed"
+	}
+	return ""
+}
+
+// mapOwner returns the client id a map tag pins to, or "".
+func mapOwner(tag string) string {
+	id, _ := strings.CutPrefix(tag, ownerTag)
+	if id == tag {
+		return ""
+	}
+	return id
+}
+
+// openClientMaps opens the maps a client's hello asks for and closes
+// those of its maps it no longer lists. It returns the refusals.
+func (s *Server) openClientMaps(si *sessionInfo) []string {
+	if len(si.Expose) == 0 && si.ID == "" {
+		return nil
+	}
+	s.clientMapsMu.Lock() // a client's sessions say hello at once
+	defer s.clientMapsMu.Unlock()
+	want := map[string]pushedMap{}
+	for _, m := range si.Expose {
+		want[m.Network+":"+m.Bind] = m
+	}
+	s.mapsMu.Lock()
+	var stale []*activeMap
+	owned := 0
+	for key, am := range s.maps {
+		if si.ID == "" || am.owner != si.ID {
+			continue
+		}
+		if m, ok := want[key]; ok && m.Target == am.target {
+			delete(want, key) // open from an earlier session
+			owned++
+			continue
+		}
+		stale = append(stale, am)
+	}
+	s.mapsMu.Unlock()
+	for _, am := range stale {
+		s.closeMap(am.network, am.bind)
+	}
+
+	var refused []string
+	refuse := func(m pushedMap, why string) {
+		refused = append(refused, m.Network+" "+m.Bind+" "+why)
+		logDedupf("cmap"+si.ID+m.Bind+why, "[CMAP] refused %s %s → %s for client %s: %s",
+			m.Network, m.Bind, m.Target, clientLabel(si), why)
+	}
+	keys := make([]string, 0, len(want))
+	for key := range want {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		m := want[key]
+		switch {
+		case si.ID == "":
+			refuse(m, "no client id")
+			continue
+		case s.clientMaps.allows(m) != "":
+			refuse(m, s.clientMaps.allows(m))
+			continue
+		case s.clientMaps.max > 0 && owned >= s.clientMaps.max:
+			refuse(m, fmt.Sprintf("over %d maps", s.clientMaps.max))
+			continue
+		}
+		pm := &PortMap{Type: m.Network, Bind: m.Bind, Target: m.Target, Tag: ownerTag + si.ID}
+		if err := s.openMap(m.Network, m.Bind, m.Target, pm); err != nil {
+			refuse(m, err.Error())
+			continue
+		}
+		owned++
+		log.Printf("[CMAP] %s %s → %s for client %s", m.Network, m.Bind, m.Target, clientLabel(si))
+	}
+	return refused
+}
+
+func clientLabel(si *sessionInfo) string {
+	if si.Name != "" {
+		return si.Name
+	}
+	return si.ID
+}
+
+// closeClientMapsLater closes id's maps once it has had no session for
+// clientMapGrace.
+func (s *Server) closeClientMapsLater(id string) {
+	if id == "" || s.clientMaps == nil {
+		return
+	}
+	time.AfterFunc(clientMapGrace, func() {
+		s.poolMu.RLock()
+		back := slices.ContainsFunc(s.sessions, func(ss *serverSession) bool { return ss.clientID() == id })
+		s.poolMu.RUnlock()
+		if back {
+			return
+		}
+		s.mapsMu.Lock()
+		var owned []*activeMap
+		for _, am := range s.maps {
+			if am.owner == id {
+				owned = append(owned, am)
+			}
+		}
+		s.mapsMu.Unlock()
+		for _, am := range owned {
+			s.closeMap(am.network, am.bind)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package httpmux
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientMapPolicy(t *testing.T) {
+	p, err := newClientMapPolicy(&ClientMapsConfig{Ports: "8000-8999", Hosts: []string{"0.0.0.0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for m, want := range map[pushedMap]string{
+		{Network: "tcp", Bind: "0.0.0.0:8443"}:   "",
+		{Network: "tcp", Bind: "0.0.0.0:22"}:     "port not allowed",
+		{Network: "tcp", Bind: "127.0.0.1:8443"}: "bind address not allowed",
+		{Network: "udp", Bind: "0.0.0.0:8443"}:   "udp not allowed",
+	} {
+		if got := p.allows(m); got != want {
+			t.Errorf("%s %s: %q, want %q", m.Network, m.Bind, got, want)
+		}
+	}
+	if p, _ := newClientMapPolicy(&ClientMapsConfig{}); p.allows(pushedMap{Network: "tcp", Bind: "0.0.0.0:8443"}) == "" {
+		t.Error("no client_maps.ports allows maps")
+	}
+}
+
+// TestExpose has the client ask for two maps, one of which the
+// server's policy refuses.
+func TestExpose(t *testing.T) {
+	echo := tcpEcho(t)
+	allowed, denied := freeAddr(t), freeAddr(t)
+	_, port, _ := net.SplitHostPort(allowed)
+	tun := startTunnel(t, tunnelOpts{
+		server: fmt.Sprintf("client_maps: {ports: %q}\n", port),
+		client: fmt.Sprintf("services: {other: \"127.0.0.1:1\"}\nexpose:\n"+
+			"  - {bind: %q, target: %q}\n  - {bind: %q, target: %q}\n", allowed, echo, denied, echo),
+	})
+	waitListening(t, allowed)
+	// The target passes services: though it isn't listed there.
+	checkEcho(t, dialMap(t, "tcp", allowed), []byte("exposed by the client"))
+
+	if c, err := net.DialTimeout("tcp", denied, 200*time.Millisecond); err == nil {
+		c.Close()
+		t.Fatal("map outside client_maps.ports was opened")
+	}
+	cs := tun.client.orderSessions()[0]
+	deadline := time.Now().Add(5 * time.Second)
+	for cs.peer.Load() == nil && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	peer := cs.peer.Load()
+	if peer == nil {
+		t.Fatal("no hello answer")
+	}
+	if len(peer.Refused) != 1 || !strings.Contains(peer.Refused[0], "port not allowed") {
+		t.Fatalf("refused %q, want the denied map", peer.Refused)
+	}
+}
@@ -0,0 +1,103 @@
+// picotun-wire records the exact bytes PicoTun puts on the wire during
+// the handshake and first seconds of a session, and compares recordings
+// across versions so mimicry/padding changes can be reviewed as
+// wire-level diffs.
+//
+//	picotun-wire record  -c config.yaml -o v2.5.1.wire [-runs 5] [-duration 3s]
+//	picotun-wire inspect v2.5.1.wire
+//	picotun-wire compare old.wire new.wire
+//	picotun-wire replay  -to 127.0.0.1:2020 old.wire
+//
+// record runs an in-process server and client on loopback with the
+// config's transport/mimic/stealth settings and a recording proxy in
+// between. Every run is a fresh handshake, so inspect can tell which
+// fields vary between connections and which are constant — constant
+// values in places that should be random are fingerprints.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage:
+  picotun-wire record  -c config.yaml -o out.wire [-runs N] [-duration D] [-label L]
+  picotun-wire inspect file.wire
+  picotun-wire compare old.wire new.wire
+  picotun-wire replay  -to host:port [-run N] file.wire
+`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+
+	switch cmd {
+	case "record":
+		fs := flag.NewFlagSet("record", flag.ExitOnError)
+		cfgPath := fs.String("c", "/etc/picotun/config.yaml", "config providing transport/mimic/stealth settings")
+		out := fs.String("o", "out.wire", "output recording")
+		runs := fs.Int("runs", 5, "number of separate connections to record")
+		duration := fs.Duration("duration", 3e9, "how long to record each connection")
+		label := fs.String("label", "", "free-form label stored in the recording (e.g. version)")
+		fs.Parse(args)
+		rec, err := record(*cfgPath, *runs, *duration, *label)
+		if err != nil {
+			log.Fatalf("record: %v", err)
+		}
+		if err := rec.save(*out); err != nil {
+			log.Fatalf("save: %v", err)
+		}
+		log.Printf("recorded %d run(s) to %s", len(rec.Runs), *out)
+
+	case "inspect":
+		if len(args) != 1 {
+			usage()
+		}
+		rec := mustLoad(args[0])
+		for _, l := range summarize(rec) {
+			fmt.Println(l)
+		}
+
+	case "compare":
+		if len(args) != 2 {
+			usage()
+		}
+		a, b := mustLoad(args[0]), mustLoad(args[1])
+		if !printDiff(summarize(a), summarize(b)) {
+			fmt.Println("no wire-level differences")
+			return
+		}
+		os.Exit(1)
+
+	case "replay":
+		fs := flag.NewFlagSet("replay", flag.ExitOnError)
+		to := fs.String("to", "", "server address to replay the client side against")
+		run := fs.Int("run", 0, "which recorded run to replay")
+		fs.Parse(args)
+		if *to == "" || fs.NArg() != 1 {
+			usage()
+		}
+		if err := replay(mustLoad(fs.Arg(0)), *run, *to); err != nil {
+			log.Fatalf("replay: %v", err)
+		}
+
+	default:
+		usage()
+	}
+}
+
+func mustLoad(path string) *Recording {
+	rec, err := loadRecording(path)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	return rec
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	httpmux "github.com/amir6dev/PicoTun"
+)
+
+// ──────────── Recording format ────────────
+
+type Recording struct {
+	Label     string    `json:"label,omitempty"`
+	Created   time.Time `json:"created"`
+	Transport string    `json:"transport"`
+	Runs      []Run     `json:"runs"`
+}
+
+type Run struct {
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Chunk is one read off the socket in direction Dir ("c2s"/"s2c"),
+// AtMS milliseconds after the TCP connection was accepted.
+type Chunk struct {
+	Dir  string `json:"dir"`
+	AtMS int64  `json:"at_ms"`
+	Data []byte `json:"data"`
+}
+
+func (r *Recording) save(path string) error {
+	data, err := json.MarshalIndent(r, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func loadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r Recording
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// stream concatenates all chunks of one direction.
+func (run *Run) stream(dir string) []byte {
+	var b []byte
+	for _, c := range run.Chunks {
+		if c.Dir == dir {
+			b = append(b, c.Data...)
+		}
+	}
+	return b
+}
+
+// ──────────── Record ────────────
+
+func record(cfgPath string, runs int, duration time.Duration, label string) (*Recording, error) {
+	cfg, err := loadConfigCopy(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "picotun-wire")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	serverAddr, err := freeAddr()
+	if err != nil {
+		return nil, err
+	}
+	scfg := isolate(cfg)
+	scfg.Mode = "server"
+	scfg.Listen = serverAddr
+	scfg.ListenPorts = nil
+	if httpmux.TransportUsesTLS(scfg.Transport) && scfg.CertFile == "" {
+		if scfg.CertFile, scfg.KeyFile, err = selfSignedCert(dir); err != nil {
+			return nil, err
+		}
+	}
+	srv := httpmux.NewServer(scfg)
+	go srv.Start(context.Background())
+	defer srv.Shutdown()
+	time.Sleep(300 * time.Millisecond)
+
+	proxy, err := newRecordingProxy(serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer proxy.ln.Close()
+
+	rec := &Recording{Label: label, Created: time.Now().UTC(), Transport: cfg.Transport}
+	for i := 0; i < runs; i++ {
+		ccfg := isolate(cfg)
+		ccfg.Mode = "client"
+		path := httpmux.PathConfig{Transport: cfg.Transport, ConnectionPool: 1, RetryInterval: 1, DialTimeout: 5}
+		if len(cfg.Paths) > 0 {
+			path.Encryption = cfg.Paths[0].Encryption
+		}
+		path.Addr = proxy.ln.Addr().String()
+		ccfg.Paths = []httpmux.PathConfig{path}
+
+		runCh := proxy.expect(duration)
+		cl := httpmux.NewClient(ccfg)
+		go cl.Start(context.Background())
+		select {
+		case run := <-runCh:
+			rec.Runs = append(rec.Runs, run)
+			log.Printf("run %d: %d chunk(s)", i+1, len(run.Chunks))
+		case <-time.After(duration + 15*time.Second):
+			cl.Shutdown()
+			return nil, fmt.Errorf("run %d: client never connected", i+1)
+		}
+		cl.Shutdown()
+	}
+	return rec, nil
+}
+
+// loadConfigCopy loads the config from a temp copy so LoadConfig's
+// migration never rewrites the operator's file.
+func loadConfigCopy(path string) (*httpmux.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "picotun-wire-*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Write(data)
+	tmp.Close()
+	return httpmux.LoadConfig(tmp.Name())
+}
+
+// isolate copies cfg without anything that binds public ports or
+// touches state outside the recording.
+func isolate(cfg *httpmux.Config) *httpmux.Config {
+	c := *cfg
+	c.Maps = nil
+	c.Forward.TCP, c.Forward.UDP = nil, nil
+	c.ACME = httpmux.ACMEConfig{}
+	c.DNS = httpmux.DNSConfig{}
+	c.SOCKS5 = httpmux.SOCKS5Config{}
+	c.Discovery = httpmux.DiscoveryConfig{}
+	c.StatsFile = ""
+	c.Verbose = false
+	c.Advanced.DrainTimeout = 1
+	return &c
+}
+
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+func selfSignedCert(dir string) (certFile, keyFile string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "picotun-wire"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// ──────────── Recording proxy ────────────
+
+// recordingProxy forwards to the server and records the first
+// connection after each expect(); later reconnects are refused.
+type recordingProxy struct {
+	ln     net.Listener
+	target string
+
+	mu       sync.Mutex
+	pending  chan Run
+	duration time.Duration
+}
+
+func newRecordingProxy(target string) (*recordingProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &recordingProxy{ln: ln, target: target}
+	go p.acceptLoop()
+	return p, nil
+}
+
+func (p *recordingProxy) expect(d time.Duration) <-chan Run {
+	ch := make(chan Run, 1)
+	p.mu.Lock()
+	p.pending, p.duration = ch, d
+	p.mu.Unlock()
+	return ch
+}
+
+func (p *recordingProxy) acceptLoop() {
+	for {
+		conn, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		p.mu.Lock()
+		ch, d := p.pending, p.duration
+		p.pending = nil
+		p.mu.Unlock()
+		if ch == nil {
+			conn.Close()
+			continue
+		}
+		go func() { ch <- p.recordConn(conn, d) }()
+	}
+}
+
+func (p *recordingProxy) recordConn(conn net.Conn, d time.Duration) Run {
+	defer conn.Close()
+	up, err := net.Dial("tcp", p.target)
+	if err != nil {
+		return Run{}
+	}
+	defer up.Close()
+
+	start := time.Now()
+	deadline := start.Add(d)
+	conn.SetReadDeadline(deadline)
+	up.SetReadDeadline(deadline)
+
+	var (
+		mu  sync.Mutex
+		run Run
+		wg  sync.WaitGroup
+	)
+	pipe := func(dir string, dst, src net.Conn) {
+		defer wg.Done()
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				mu.Lock()
+				run.Chunks = append(run.Chunks, Chunk{
+					Dir:  dir,
+					AtMS: time.Since(start).Milliseconds(),
+					Data: append([]byte(nil), buf[:n]...),
+				})
+				mu.Unlock()
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+	wg.Add(2)
+	go pipe("c2s", up, conn)
+	go pipe("s2c", conn, up)
+	wg.Wait()
+	return run
+}
+
+// ──────────── Replay ────────────
+
+// replay sends the recorded client bytes of one run to addr with the
+// original timing and reports what the server sends back.
+func replay(rec *Recording, idx int, addr string) error {
+	if idx < 0 || idx >= len(rec.Runs) {
+		return fmt.Errorf("run %d out of range (have %d)", idx, len(rec.Runs))
+	}
+	run := rec.Runs[idx]
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var last int64
+	if n := len(run.Chunks); n > 0 {
+		last = run.Chunks[n-1].AtMS
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Duration(last)*time.Millisecond + 3*time.Second))
+
+	got := Run{}
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				got.Chunks = append(got.Chunks, Chunk{Dir: "s2c", AtMS: time.Since(start).Milliseconds(), Data: append([]byte(nil), buf[:n]...)})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for _, c := range run.Chunks {
+		if c.Dir != "c2s" {
+			continue
+		}
+		if wait := time.Duration(c.AtMS)*time.Millisecond - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := conn.Write(c.Data); err != nil {
+			log.Printf("write at %dms: %v", c.AtMS, err)
+			break
+		}
+	}
+	<-done
+
+	resp := got.stream("s2c")
+	fmt.Printf("server sent %d byte(s) in %d chunk(s)\n", len(resp), len(got.Chunks))
+	if head, _ := splitHead(resp); head != nil {
+		fmt.Printf("  %s\n", firstLine(head))
+	}
+	recorded := run.stream("s2c")
+	if len(recorded) > 0 && len(resp) > 0 {
+		fmt.Printf("recorded server sent %d byte(s); first line: %s\n", len(recorded), firstLine(recorded))
+	}
+	if _, err := io.Copy(io.Discard, conn); err != nil {
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ──────────── Summaries ────────────
+//
+// A recording is reduced to stable text lines describing the shape of
+// the traffic — HTTP heads, header order, which values are constant
+// across runs, TLS records, and early chunk sizes — so two versions
+// can be diffed line by line. Lines starting with "WARN" are values
+// that should differ per connection but didn't.
+
+// Headers whose values must not repeat across connections.
+var mustVary = map[string]bool{
+	"sec-websocket-key":    true,
+	"sec-websocket-accept": true,
+	"cookie":               true,
+	"set-cookie":           true,
+}
+
+const sizeSamples = 8
+
+func summarize(rec *Recording) []string {
+	lines := []string{
+		fmt.Sprintf("transport %s, %d run(s)", rec.Transport, len(rec.Runs)),
+	}
+	for _, dir := range []string{"c2s", "s2c"} {
+		lines = append(lines, summarizeDir(rec, dir)...)
+	}
+	return lines
+}
+
+func summarizeDir(rec *Recording, dir string) []string {
+	var lines []string
+	heads := make([][]byte, len(rec.Runs))
+	bodies := make([][]byte, len(rec.Runs))
+	for i := range rec.Runs {
+		heads[i], bodies[i] = splitHead(rec.Runs[i].stream(dir))
+	}
+
+	switch {
+	case len(rec.Runs) == 0:
+		return nil
+	case heads[0] != nil:
+		lines = append(lines, summarizeHeads(dir, heads)...)
+	case len(bodies[0]) > 5 && bodies[0][0] == 0x16:
+		lines = append(lines, fmt.Sprintf("%s tls: handshake record, first record %s bytes", dir, rangeOf(bodies, tlsRecordLen)))
+	}
+
+	// Constant leading bytes after the head are a static fingerprint.
+	if len(rec.Runs) > 1 && (len(bodies[0]) == 0 || bodies[0][0] != 0x16) {
+		if n := commonPrefix(bodies); n >= 4 {
+			lines = append(lines, fmt.Sprintf("WARN %s body: first %d byte(s) identical in every run", dir, n))
+		}
+	}
+
+	// Chunk sizes of the first reads, min-max across runs.
+	for i := 0; i < sizeSamples; i++ {
+		var sizes []int
+		for _, run := range rec.Runs {
+			k := 0
+			for _, c := range run.Chunks {
+				if c.Dir != dir {
+					continue
+				}
+				if k == i {
+					sizes = append(sizes, len(c.Data))
+					break
+				}
+				k++
+			}
+		}
+		if len(sizes) == 0 {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s chunk[%d] size %s", dir, i, minMax(sizes)))
+	}
+	return lines
+}
+
+func summarizeHeads(dir string, heads [][]byte) []string {
+	var lines []string
+	parsed := make([][]string, len(heads))
+	for i, h := range heads {
+		parsed[i] = strings.Split(strings.TrimRight(string(h), "\r\n"), "\r\n")
+	}
+
+	// Start line: method/status are shape, the rest may vary.
+	first := make([]string, len(parsed))
+	for i, p := range parsed {
+		first[i] = p[0]
+	}
+	fields := strings.Fields(first[0])
+	if strings.HasPrefix(first[0], "HTTP/") {
+		lines = append(lines, fmt.Sprintf("%s status: %s %s", dir, strings.Join(fields[:min(2, len(fields))], " "), variance(first)))
+	} else if len(fields) > 0 {
+		lines = append(lines, fmt.Sprintf("%s request: %s %s", dir, fields[0], variance(first)))
+	}
+
+	// Header order and per-header variance.
+	order := make([]string, len(parsed))
+	values := map[string][]string{}
+	var names []string
+	for i, p := range parsed {
+		var ns []string
+		for _, h := range p[1:] {
+			name, val, _ := strings.Cut(h, ":")
+			key := strings.ToLower(strings.TrimSpace(name))
+			ns = append(ns, name)
+			if _, seen := values[key]; !seen {
+				names = append(names, name)
+			}
+			values[key] = append(values[key], strings.TrimSpace(val))
+		}
+		order[i] = strings.Join(ns, ",")
+	}
+	lines = append(lines, fmt.Sprintf("%s header order %s", dir, variance(order)))
+	for _, name := range names {
+		key := strings.ToLower(name)
+		vals := values[key]
+		line := fmt.Sprintf("%s header %s: present %d/%d, %s", dir, name, len(vals), len(heads), variance(vals))
+		if len(heads) > 1 && len(vals) > 1 && distinct(vals) == 1 && mustVary[key] {
+			line = "WARN " + line
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// variance describes whether vals are all equal.
+func variance(vals []string) string {
+	if distinct(vals) == 1 {
+		return fmt.Sprintf("CONST %q", vals[0])
+	}
+	return fmt.Sprintf("varies (%d distinct)", distinct(vals))
+}
+
+func distinct(vals []string) int {
+	set := map[string]bool{}
+	for _, v := range vals {
+		set[v] = true
+	}
+	return len(set)
+}
+
+// splitHead separates an HTTP head (incl. the blank line) from the rest.
+func splitHead(b []byte) (head, rest []byte) {
+	if !looksHTTP(b) {
+		return nil, b
+	}
+	i := bytes.Index(b, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, b
+	}
+	return b[:i+4], b[i+4:]
+}
+
+func looksHTTP(b []byte) bool {
+	for _, p := range []string{"GET ", "POST ", "PUT ", "HEAD ", "OPTIONS ", "PATCH ", "DELETE ", "HTTP/1."} {
+		if bytes.HasPrefix(b, []byte(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstLine(b []byte) string {
+	if i := bytes.Index(b, []byte("\r\n")); i >= 0 {
+		return string(b[:i])
+	}
+	if len(b) > 64 {
+		b = b[:64]
+	}
+	return fmt.Sprintf("%q", b)
+}
+
+func tlsRecordLen(b []byte) int {
+	if len(b) < 5 {
+		return 0
+	}
+	return int(b[3])<<8 | int(b[4])
+}
+
+func rangeOf(bodies [][]byte, f func([]byte) int) string {
+	var v []int
+	for _, b := range bodies {
+		v = append(v, f(b))
+	}
+	return minMax(v)
+}
+
+func minMax(v []int) string {
+	lo, hi := v[0], v[0]
+	for _, x := range v {
+		lo, hi = min(lo, x), max(hi, x)
+	}
+	if lo == hi {
+		return fmt.Sprintf("%d", lo)
+	}
+	return fmt.Sprintf("%d-%d", lo, hi)
+}
+
+func commonPrefix(bs [][]byte) int {
+	n := len(bs[0])
+	for _, b := range bs[1:] {
+		i := 0
+		for i < n && i < len(b) && b[i] == bs[0][i] {
+			i++
+		}
+		n = i
+	}
+	return n
+}
+
+// ──────────── Diff ────────────
+
+// printDiff prints a line diff of a → b and reports whether they differ.
+func printDiff(a, b []string) bool {
+	// LCS table; summaries are small.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	changed := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Println("  " + a[i])
+			i, j = i+1, j+1
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			fmt.Println("+ " + b[j])
+			j++
+			changed = true
+		default:
+			fmt.Println("- " + a[i])
+			i++
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	httpmux "github.com/amir6dev/PicoTun"
+)
+
+// runBench: picotun bench -c client.yaml [-t 10s]
+//
+// Loads the tunnel both ways for -t and reports throughput plus idle
+// and loaded latency, for comparing smux/fragment settings.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	cfgPath := fs.String("c", "/etc/picotun/config.yaml", "client config")
+	d := fs.Duration("t", 10*time.Second, "how long to load the tunnel")
+	fs.Parse(args)
+
+	cl := startTestClient(*cfgPath, "bench")
+	defer stopTestClient(cl)
+
+	res, err := cl.Bench(*d, func(down, up int64, elapsed time.Duration) {
+		el := elapsed.Seconds()
+		fmt.Fprintf(os.Stderr, "\r%4.1fs  ↓ %7.2f Mbit/s  ↑ %7.2f Mbit/s", el,
+			float64(down)*8/el/1e6, float64(up)*8/el/1e6)
+	})
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	if err != nil {
+		log.Fatalf("bench: %v", err)
+	}
+	ms := func(d time.Duration) string { return fmt.Sprintf("%.1fms", float64(d)/1e6) }
+	fmt.Printf("download   %8.2f Mbit/s  (%d MB)\n", res.DownMbps(), res.DownBytes>>20)
+	fmt.Printf("upload     %8.2f Mbit/s  (%d MB)\n", res.UpMbps(), res.UpBytes>>20)
+	for _, row := range []struct {
+		name string
+		st   httpmux.RTTStats
+	}{{"idle rtt", res.IdleRTT}, {"loaded rtt", res.LoadedRTT}} {
+		fmt.Printf("%-10s min %s  avg %s  p95 %s  max %s  jitter %s", row.name,
+			ms(row.st.Min), ms(row.st.Avg), ms(row.st.P95), ms(row.st.Max), ms(row.st.Jitter))
+		if row.st.Lost > 0 {
+			fmt.Printf("  lost %d/%d", row.st.Lost, row.st.Lost+row.st.Count)
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	httpmux "github.com/amir6dev/PicoTun"
+)
+
+// runCheck: picotun check -c config.yaml [-strict] [--set key=value ...]
+//
+// Prints every problem CheckConfig finds and exits 1 on errors (with
+// -strict also on warnings), for CI and config management.
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := fs.String("config", "/etc/picotun/config.yaml", "path to config file")
+	fs.StringVar(configPath, "c", "/etc/picotun/config.yaml", "alias for -config")
+	strict := fs.Bool("strict", false, "fail on warnings too")
+	var set listFlag
+	fs.Var(&set, "set", "override a config value, key=value (repeatable)")
+	fs.Parse(args)
+
+	b, err := os.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	cfg, r := httpmux.CheckConfig(b, set)
+	for _, e := range r.Errors {
+		fmt.Printf("%s: error: %s\n", *configPath, e)
+	}
+	for _, w := range r.Warnings {
+		fmt.Printf("%s: warning: %s\n", *configPath, w)
+	}
+	if !r.OK(*strict) {
+		os.Exit(1)
+	}
+	fmt.Printf("%s: ok (mode %s, transport %s, %d map(s), %d path(s))\n",
+		*configPath, cfg.Mode, cfg.Transport, len(cfg.Maps), len(cfg.Paths))
+}
+
+// strictCheck stops startup on anything CheckConfig calls an error.
+func strictCheck(path string, set []string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	_, r := httpmux.CheckConfig(b, set)
+	for _, w := range r.Warnings {
+		log.Printf("[CONFIG] warning: %s", w)
+	}
+	for _, e := range r.Errors {
+		log.Printf("[CONFIG] error: %s", e)
+	}
+	if len(r.Errors) > 0 {
+		log.Fatalf("config: %d error(s), not starting (-strict)", len(r.Errors))
+	}
+}
+
+// runSchema: picotun schema > picotun.schema.json
+func runSchema() {
+	b, err := httpmux.ConfigSchema()
+	if err != nil {
+		log.Fatalf("schema: %v", err)
+	}
+	fmt.Printf("%s\n", b)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	httpmux "github.com/amir6dev/PicoTun"
+)
+
+// runInit: picotun init -mode server|client|pair [-server host[:port]] [-map 443->127.0.0.1:443 ...]
+//
+// Writes a ready-to-run config with a random PSK. "pair" writes a
+// server and a matching client config at once. Run without -mode on a
+// terminal it asks for everything instead. Every file is parsed with
+// the same loader the tunnel uses before it is written.
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	o := initOptions{}
+	fs.StringVar(&o.mode, "mode", "", "server, client or pair (both, matching)")
+	fs.StringVar(&o.transport, "transport", "httpmux", "tunnel transport: "+strings.Join(initTransports, ", "))
+	fs.StringVar(&o.server, "server", "", "the server's public address, host or host:port (client, pair)")
+	fs.StringVar(&o.port, "port", "", "tunnel port (default 2020, 443 for TLS transports)")
+	fs.StringVar(&o.psk, "psk", "", "pre-shared key (default: random)")
+	fs.StringVar(&o.domain, "domain", "", "TLS transports: the server's domain, certificate from Let's Encrypt")
+	fs.StringVar(&o.certFile, "cert", "", "TLS transports: certificate file instead of -domain")
+	fs.StringVar(&o.keyFile, "key", "", "TLS transports: key file for -cert")
+	fs.StringVar(&o.socks, "socks", "", "client SOCKS5 listen address, e.g. 127.0.0.1:1080")
+	fs.Var((*listFlag)(&o.maps), "map", "server map bind->target, \"udp:\" prefix for UDP (repeatable)")
+	out := fs.String("o", "", "output file (default stdout; pair: server.yaml)")
+	clientOut := fs.String("client-o", "client.yaml", "pair: client output file")
+	force := fs.Bool("force", false, "overwrite existing files")
+	fs.Parse(args)
+
+	if o.mode == "" {
+		if !isTerminal(os.Stdin) {
+			log.Fatal("init: -mode is required (server, client or pair)")
+		}
+		o.ask(bufio.NewReader(os.Stdin))
+		if *out == "" && o.mode == "pair" {
+			*out = "server.yaml"
+		}
+	}
+	if err := o.check(); err != nil {
+		log.Fatalf("init: %v", err)
+	}
+	if o.psk == "" {
+		o.psk = randomPSK()
+	}
+
+	switch o.mode {
+	case "server":
+		writeInit(*out, o.serverYAML(), *force)
+	case "client":
+		writeInit(*out, o.clientYAML(), *force)
+	case "pair":
+		if *out == "" || *out == "-" {
+			*out = "server.yaml"
+		}
+		writeInit(*out, o.serverYAML(), *force)
+		writeInit(*clientOut, o.clientYAML(), *force)
+		fmt.Fprintf(os.Stderr, "copy %s to the client machine; both files share the PSK\n", *clientOut)
+	}
+}
+
+var initTransports = []string{"httpmux", "httpsmux", "wsmux", "wssmux", "tcpmux", "h2mux", "xhttpmux", "xhttpsmux"}
+
+type initOptions struct {
+	mode, transport, server, port, psk string
+	domain, certFile, keyFile, socks   string
+	maps                               []string
+}
+
+type listFlag []string
+
+func (l *listFlag) String() string     { return strings.Join(*l, ",") }
+func (l *listFlag) Set(v string) error { *l = append(*l, v); return nil }
+
+func (o *initOptions) tls() bool {
+	return httpmux.TransportUsesTLS(o.transport)
+}
+
+// check validates the options and fills in derived defaults.
+func (o *initOptions) check() error {
+	o.mode = strings.ToLower(strings.TrimSpace(o.mode))
+	o.transport = strings.ToLower(strings.TrimSpace(o.transport))
+	if o.mode != "server" && o.mode != "client" && o.mode != "pair" {
+		return fmt.Errorf("unknown mode %q (server, client or pair)", o.mode)
+	}
+	if !slices.Contains(initTransports, o.transport) {
+		return fmt.Errorf("unknown transport %q (%s)", o.transport, strings.Join(initTransports, ", "))
+	}
+	if host, port, err := net.SplitHostPort(o.server); err == nil {
+		o.server = host
+		if o.port == "" {
+			o.port = port
+		}
+	}
+	o.server = strings.Trim(o.server, "[]")
+	if o.server == "" && o.domain != "" {
+		o.server = o.domain
+	}
+	if o.port == "" {
+		o.port = "2020"
+		if o.tls() {
+			o.port = "443"
+		}
+	}
+	if o.mode != "server" && o.server == "" {
+		return fmt.Errorf("-server is required for a client config")
+	}
+	if o.tls() && o.mode != "client" && o.domain == "" && o.certFile == "" {
+		return fmt.Errorf("%s needs -domain (Let's Encrypt) or -cert/-key on the server", o.transport)
+	}
+	if (o.certFile == "") != (o.keyFile == "") {
+		return fmt.Errorf("-cert and -key go together")
+	}
+	if o.mode != "client" {
+		for _, m := range o.maps {
+			if _, _, ok := httpmux.SplitMap(strings.TrimPrefix(m, "udp:")); !ok {
+				return fmt.Errorf("map %q: want bind->target, e.g. 443->127.0.0.1:443", m)
+			}
+		}
+	}
+	return nil
+}
+
+// ask fills the options in from a terminal.
+func (o *initOptions) ask(in *bufio.Reader) {
+	q := func(prompt, def string) string {
+		if def != "" {
+			fmt.Fprintf(os.Stderr, "%s [%s]: ", prompt, def)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: ", prompt)
+		}
+		line, _ := in.ReadString('\n')
+		if line = strings.TrimSpace(line); line == "" {
+			return def
+		}
+		return line
+	}
+	o.mode = q("Generate configs for server, client or pair", "pair")
+	o.transport = q("Transport ("+strings.Join(initTransports, ", ")+")", o.transport)
+	if o.mode != "server" {
+		o.server = q("Server public IP or host name", o.server)
+	}
+	if o.tls() && o.mode != "client" {
+		o.domain = q("Server domain for a Let's Encrypt certificate (empty: use -cert/-key files)", o.domain)
+		if o.domain == "" {
+			o.certFile = q("Certificate file", "/etc/picotun/cert.pem")
+			o.keyFile = q("Key file", "/etc/picotun/key.pem")
+		}
+	}
+	def := "2020"
+	if o.tls() {
+		def = "443"
+	}
+	o.port = q("Tunnel port", def)
+	if o.mode != "client" {
+		for _, m := range strings.Split(q("Maps on the server, comma-separated bind->target (udp: prefix for UDP)", "443->127.0.0.1:443"), ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				o.maps = append(o.maps, m)
+			}
+		}
+	}
+	if o.mode != "server" {
+		o.socks = q("SOCKS5 proxy on the client (e.g. 127.0.0.1:1080, empty: off)", "")
+	}
+}
+
+func randomPSK() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("init: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (o *initOptions) header(mode string) *strings.Builder {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# PicoTun %s config, written by `picotun init`.\n", mode)
+	b.WriteString("# Keep the psk secret; server and client must use the same one.\n")
+	fmt.Fprintf(&b, "config_version: %d\n", httpmux.CurrentConfigVersion)
+	fmt.Fprintf(&b, "mode: %q\n", mode)
+	fmt.Fprintf(&b, "transport: %q\n", o.transport)
+	fmt.Fprintf(&b, "psk: %q\n", o.psk)
+	b.WriteString("profile: \"balanced\"\n")
+	return &b
+}
+
+// mimic sets Host and SNI to the real domain; the server only accepts
+// requests for its own fake_domain.
+func (o *initOptions) mimic(b *strings.Builder) {
+	if o.domain != "" {
+		fmt.Fprintf(b, "\nhttp_mimic:\n  fake_domain: %q\n", o.domain)
+	}
+}
+
+func (o *initOptions) serverYAML() string {
+	b := o.header("server")
+	fmt.Fprintf(b, "listen: %q\n", net.JoinHostPort("0.0.0.0", o.port))
+	switch {
+	case o.certFile != "":
+		fmt.Fprintf(b, "cert_file: %q\nkey_file: %q\n", o.certFile, o.keyFile)
+	case o.tls():
+		fmt.Fprintf(b, "\nacme:\n  enabled: true\n  domains: [%q]\n", o.domain)
+	}
+	o.mimic(b)
+	b.WriteString("\nmaps:\n")
+	if len(o.maps) == 0 {
+		b.WriteString("  []\n  # - { type: tcp, bind: \"443\", target: \"127.0.0.1:443\" }\n")
+	}
+	for _, m := range o.maps {
+		kind := "tcp"
+		if rest, ok := strings.CutPrefix(m, "udp:"); ok {
+			kind, m = "udp", rest
+		}
+		bind, target, _ := httpmux.SplitMap(m)
+		if strings.HasPrefix(bind, "0.0.0.0:") {
+			bind = strings.TrimPrefix(bind, "0.0.0.0:")
+		}
+		fmt.Fprintf(b, "  - { type: %s, bind: %q, target: %q }\n", kind, bind, target)
+	}
+	b.WriteString("\nstealth:\n  random_padding: true\n  fake_traffic: true\n")
+	b.WriteString("\nadvanced:\n  max_connections: 500\n")
+	return b.String()
+}
+
+func (o *initOptions) clientYAML() string {
+	b := o.header("client")
+	host := o.server
+	if o.domain != "" {
+		host = o.domain // the certificate's name
+	}
+	fmt.Fprintf(b, "\npaths:\n  - transport: %q\n    addr: %q\n    connection_pool: 4\n",
+		o.transport, net.JoinHostPort(host, o.port))
+	o.mimic(b)
+	if o.domain != "" {
+		b.WriteString("\n# The server has a Let's Encrypt certificate: check it.\ntls_verify: true\n")
+	}
+	if o.socks != "" {
+		fmt.Fprintf(b, "\nsocks5:\n  listen: %q\n", o.socks)
+	}
+	b.WriteString("\nstealth:\n  random_padding: true\n  burst_split: true\n")
+	return b.String()
+}
+
+// writeInit checks data with the config loader, then writes it to path
+// ("" or "-" = stdout).
+func writeInit(path, data string, force bool) {
+	if _, err := httpmux.ParseConfig([]byte(data)); err != nil {
+		log.Fatalf("init: generated config does not load (%v) — please report this", err)
+	}
+	if path == "" || path == "-" {
+		fmt.Print(data)
+		return
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			log.Fatalf("init: %s exists (use -force to overwrite)", path)
+		}
+		log.Fatalf("init: %v", err)
+	}
+	if _, err := f.WriteString(data); err != nil {
+		log.Fatalf("init: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("init: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s\n", path)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
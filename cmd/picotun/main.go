@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	httpmux "github.com/amir6dev/PicoTun"
 )
@@ -11,9 +15,38 @@ import (
 var version = "2.5.1"
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "speedtest":
+			runSpeedtest(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "init":
+			runInit(os.Args[2:])
+			return
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "probe":
+			runProbe(os.Args[2:])
+			return
+		case "schema":
+			runSchema()
+			return
+		case "service":
+			runService(os.Args[2:])
+			return
+		}
+	}
+
 	showVersion := flag.Bool("version", false, "print version and exit")
 	configPath := flag.String("config", "/etc/picotun/config.yaml", "path to config file")
 	configShort := flag.String("c", "", "alias for -config")
+	strict := flag.Bool("strict", false, "refuse to start when `picotun check` finds errors (unknown keys, ...)")
+	var set listFlag
+	flag.Var(&set, "set", "override a config value, e.g. --set psk=... or --set paths.0.addr=host:port (repeatable)")
 	flag.Parse()
 
 	if *showVersion {
@@ -25,24 +58,66 @@ func main() {
 	if *configShort != "" {
 		cfgPath = *configShort
 	}
+	setupServiceLog(cfgPath)
 
-	cfg, err := httpmux.LoadConfig(cfgPath)
+	if *strict {
+		strictCheck(cfgPath, set)
+	}
+	cfg, err := httpmux.LoadConfigOverrides(cfgPath, set)
 	if err != nil {
 		log.Fatalf("config: %v", err)
 	}
 
 	log.Printf("PicoTun %s — mode=%s profile=%s", version, cfg.Mode, cfg.Profile)
+	httpmux.EnableCrashReports(cfg, version)
 
+	var start, shutdown func() error
+	var stats *httpmux.Stats
 	switch strings.ToLower(strings.TrimSpace(cfg.Mode)) {
 	case "server":
 		srv := httpmux.NewServer(cfg)
-		log.Fatal(srv.Start())
-
+		start = func() error { return srv.Start(context.Background()) }
+		shutdown, stats = srv.Shutdown, srv.Stats()
 	case "client":
 		cl := httpmux.NewClient(cfg)
-		log.Fatal(cl.Start())
-
+		start = func() error { return cl.Start(context.Background()) }
+		shutdown, stats = cl.Shutdown, cl.Stats()
 	default:
 		log.Fatalf("unknown mode: %q (expected server/client)", cfg.Mode)
 	}
+
+	// Under the Windows service manager its stop request replaces
+	// the signals.
+	if ok, err := runAsService(start, shutdown); ok {
+		stats.SaveOnExit(cfg.StatsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	shutdownOnSignal(shutdown)
+	err = start()
+	stats.SaveOnExit(cfg.StatsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// shutdownOnSignal drains gracefully on the first SIGINT/SIGTERM;
+// a second signal exits immediately.
+func shutdownOnSignal(shutdown func() error) {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		log.Printf("received %v, shutting down (send again to force)", sig)
+		go func() {
+			<-sigCh
+			log.Printf("forced exit")
+			os.Exit(1)
+		}()
+		if err := shutdown(); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
 }
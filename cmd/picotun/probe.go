@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	httpmux "github.com/amir6dev/PicoTun"
+)
+
+// runProbe: picotun probe [-host name] [-path /search] [-n 5] [-json] https://myserver/
+//
+// Probes a server like a censor's active prober and prints what could
+// fingerprint it. Exits 1 when anything "high" is found.
+func runProbe(args []string) {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	host := fs.String("host", "", "Host header and SNI (default: the URL's host)")
+	path := fs.String("path", "", "tunnel path (default: the URL's path, or /search)")
+	n := fs.Int("n", 5, "GETs of / to compare")
+	timeout := fs.Duration("timeout", 10*time.Second, "per connection")
+	asJSON := fs.Bool("json", false, "print the full report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: picotun probe [flags] https://myserver[:port]/[path]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	r, err := httpmux.Probe(fs.Arg(0), httpmux.ProbeOptions{Host: *host, Path: *path, Requests: *n, Timeout: *timeout})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "probe: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		b, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Printf("%s\n", b)
+	} else {
+		if r.TLS != nil {
+			fmt.Printf("tls      %s %s, ALPN %q, expires %s\n", r.TLS.Version, r.TLS.Cipher, r.TLS.ALPN, r.TLS.Expires)
+			for _, c := range r.TLS.Chain {
+				fmt.Printf("         %s\n", c)
+			}
+		}
+		for _, resp := range r.Responses {
+			fmt.Printf("%-7d  %-40s  %-14s %6d B  ttfb %v\n", resp.Status, resp.Request, resp.Server, resp.Size, resp.TTFB.Round(time.Millisecond/10))
+		}
+		fmt.Println()
+		for _, f := range r.Findings {
+			fmt.Printf("%-6s  %-6s  %s\n", f.Severity, f.Check, f.Detail)
+		}
+	}
+	if r.Worst() == "high" {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	httpmux "github.com/amir6dev/PicoTun"
+)
+
+// runService: picotun service install|uninstall|start|stop [-c config] [-name picotun]
+//
+// Registers this binary with the system's service manager — a systemd
+// unit on Linux, a launchd daemon on macOS, a Windows service — so it
+// starts at boot and is restarted when it dies. Needs root /
+// Administrator.
+func runService(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		log.Fatal("usage: picotun service install|uninstall|start|stop [-c config.yaml] [-name picotun]")
+	}
+	action := args[0]
+	fs := flag.NewFlagSet("service "+action, flag.ExitOnError)
+	configPath := fs.String("config", "/etc/picotun/config.yaml", "config file the service runs with")
+	fs.StringVar(configPath, "c", "/etc/picotun/config.yaml", "alias for -config")
+	name := fs.String("name", "picotun", "service name (several tunnels need different names)")
+	fs.Parse(args[1:])
+
+	sv := serviceSpec{name: *name}
+	var err error
+	switch action {
+	case "install":
+		err = sv.prepare(*configPath)
+		if err == nil {
+			err = sv.install()
+		}
+		if err == nil {
+			fmt.Printf("installed service %s (%s mode, %s); start it with: picotun service start -name %s\n",
+				sv.name, sv.mode, sv.config, sv.name)
+		}
+	case "uninstall":
+		err = sv.uninstall()
+	case "start":
+		err = sv.start()
+	case "stop":
+		err = sv.stop()
+	default:
+		err = fmt.Errorf("unknown action %q (install, uninstall, start or stop)", action)
+	}
+	if err != nil {
+		log.Fatalf("service %s: %v", action, err)
+	}
+}
+
+// serviceSpec is what the platform installers need to know.
+type serviceSpec struct {
+	name   string
+	exe    string // absolute path of this binary
+	config string // absolute config path
+	mode   string // server or client
+}
+
+// prepare resolves the binary and config paths and checks the config
+// loads, so a broken service isn't registered.
+func (sv *serviceSpec) prepare(configPath string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if sv.exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if sv.config, err = filepath.Abs(configPath); err != nil {
+		return err
+	}
+	cfg, err := httpmux.LoadConfig(sv.config)
+	if err != nil {
+		return fmt.Errorf("config %s: %w", sv.config, err)
+	}
+	sv.mode = cfg.Mode
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+)
+
+// ──────────── launchd ────────────
+
+func (sv *serviceSpec) label() string { return "com.amir6dev." + sv.name }
+
+func (sv *serviceSpec) plistPath() string {
+	return "/Library/LaunchDaemons/" + sv.label() + ".plist"
+}
+
+func (sv *serviceSpec) install() error {
+	logFile := "/var/log/" + sv.name + ".log"
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key><string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+		<string>-c</string>
+		<string>%s</string>
+	</array>
+	<key>RunAtLoad</key><true/>
+	<key>KeepAlive</key><true/>
+	<key>StandardOutPath</key><string>%s</string>
+	<key>StandardErrorPath</key><string>%s</string>
+</dict>
+</plist>
+`, sv.label(), html.EscapeString(sv.exe), html.EscapeString(sv.config), logFile, logFile)
+	return writeServiceFile(sv.plistPath(), plist)
+}
+
+func (sv *serviceSpec) uninstall() error {
+	run("launchctl", "unload", "-w", sv.plistPath())
+	return os.Remove(sv.plistPath())
+}
+
+// start loads the daemon; RunAtLoad starts it now and at every boot.
+func (sv *serviceSpec) start() error { return run("launchctl", "load", "-w", sv.plistPath()) }
+
+// stop unloads it; it is loaded again at the next boot.
+func (sv *serviceSpec) stop() error { return run("launchctl", "unload", sv.plistPath()) }
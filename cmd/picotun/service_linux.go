@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ──────────── systemd ────────────
+
+func (sv *serviceSpec) unitPath() string {
+	return "/etc/systemd/system/" + sv.name + ".service"
+}
+
+func (sv *serviceSpec) install() error {
+	// Same unit as setup.sh: a client stays Type=simple since it may
+	// not become ready while its server is down (see systemd.go).
+	typ := "notify"
+	if sv.mode == "client" {
+		typ = "simple"
+	}
+	unit := fmt.Sprintf(`[Unit]
+Description=PicoTun %s (%s)
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=%s
+NotifyAccess=main
+WatchdogSec=60
+ExecStart=%q -c %q
+Restart=always
+RestartSec=3
+LimitNOFILE=1048576
+
+[Install]
+WantedBy=multi-user.target
+`, sv.mode, sv.name, typ, sv.exe, sv.config)
+	if err := writeServiceFile(sv.unitPath(), unit); err != nil {
+		return err
+	}
+	if err := run("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	return run("systemctl", "enable", sv.name)
+}
+
+func (sv *serviceSpec) uninstall() error {
+	run("systemctl", "disable", "--now", sv.name)
+	if err := os.Remove(sv.unitPath()); err != nil {
+		return err
+	}
+	return run("systemctl", "daemon-reload")
+}
+
+func (sv *serviceSpec) start() error { return run("systemctl", "start", sv.name) }
+
+func (sv *serviceSpec) stop() error { return run("systemctl", "stop", sv.name) }
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+var errNoServiceManager = errors.New("not supported on this system; run picotun from your init system directly")
+
+func (sv *serviceSpec) install() error   { return errNoServiceManager }
+func (sv *serviceSpec) uninstall() error { return errNoServiceManager }
+func (sv *serviceSpec) start() error     { return errNoServiceManager }
+func (sv *serviceSpec) stop() error      { return errNoServiceManager }
@@ -0,0 +1,35 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// runAsService reports false: outside Windows the service manager
+// talks to the process through signals.
+func runAsService(start, shutdown func() error) (bool, error) {
+	return false, nil
+}
+
+func setupServiceLog(cfgPath string) {}
+
+// run executes a service manager command, folding its output into the
+// error.
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %v %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func writeServiceFile(path, data string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s exists (uninstall first, or pick another -name)", path)
+	}
+	return os.WriteFile(path, []byte(data), 0644)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// ──────────── Windows service ────────────
+
+func (sv *serviceSpec) install() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(sv.name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s exists (uninstall first, or pick another -name)", sv.name)
+	}
+	s, err := m.CreateService(sv.name, sv.exe, mgr.Config{
+		DisplayName: "PicoTun " + sv.mode + " (" + sv.name + ")",
+		Description: "PicoTun tunnel running " + sv.config,
+		StartType:   mgr.StartAutomatic,
+	}, "-c", sv.config)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// Restart=always: after a crash and after the tunnel gives up.
+	err = s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 3 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 3 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+	}, uint32((24 * time.Hour).Seconds()))
+	if err != nil {
+		return err
+	}
+	return s.SetRecoveryActionsOnNonCrashFailures(true)
+}
+
+// open runs fn on the installed service.
+func (sv *serviceSpec) open(fn func(*mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(sv.name)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", sv.name, err)
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+func (sv *serviceSpec) uninstall() error {
+	return sv.open(func(s *mgr.Service) error {
+		s.Control(svc.Stop)
+		return s.Delete()
+	})
+}
+
+func (sv *serviceSpec) start() error {
+	return sv.open(func(s *mgr.Service) error { return s.Start() })
+}
+
+func (sv *serviceSpec) stop() error {
+	return sv.open(func(s *mgr.Service) error {
+		_, err := s.Control(svc.Stop)
+		return err
+	})
+}
+
+// runAsService runs the tunnel under the service manager when it
+// started this process; false otherwise.
+func runAsService(start, shutdown func() error) (bool, error) {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return false, nil
+	}
+	w := &winService{start: start, shutdown: shutdown}
+	if err := svc.Run("picotun", w); err != nil {
+		return true, err
+	}
+	return true, w.err
+}
+
+type winService struct {
+	start, shutdown func() error
+	err             error
+}
+
+func (w *winService) Execute(args []string, req <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan error, 1)
+	go func() { done <- w.start() }()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-req:
+			switch c.Cmd {
+			case svc.Interrogate:
+				status <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				if err := w.shutdown(); err != nil {
+					log.Printf("shutdown: %v", err)
+				}
+				w.err = <-done
+				return false, 0
+			}
+		case w.err = <-done:
+			// Stopped on its own: a failure, so recovery restarts it.
+			return true, 1
+		}
+	}
+}
+
+// setupServiceLog sends the log next to the config when running as a
+// service, which has no console: config.yaml → config.log.
+func setupServiceLog(cfgPath string) {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return
+	}
+	path := strings.TrimSuffix(cfgPath, ".yaml") + ".log"
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err == nil {
+		log.SetOutput(f)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	httpmux "github.com/amir6dev/PicoTun"
+)
+
+// runSpeedtest: picotun speedtest -c client.yaml [-mb 25] [-up] [-both]
+//
+// Starts a short-lived client from the config, runs one transfer
+// through the tunnel and prints the throughput. A client already
+// running with the same config is not disturbed; this one just adds
+// its own session for the duration of the test.
+func runSpeedtest(args []string) {
+	fs := flag.NewFlagSet("speedtest", flag.ExitOnError)
+	cfgPath := fs.String("c", "/etc/picotun/config.yaml", "client config")
+	mb := fs.Int("mb", 25, "megabytes to transfer")
+	up := fs.Bool("up", false, "measure upload instead of download")
+	both := fs.Bool("both", false, "measure download, then upload")
+	fs.Parse(args)
+
+	cl := startTestClient(*cfgPath, "speedtest")
+	defer stopTestClient(cl)
+
+	runs := []bool{*up}
+	if *both {
+		runs = []bool{false, true}
+	}
+	size := int64(*mb) << 20
+	for _, upload := range runs {
+		dir := "download"
+		if upload {
+			dir = "upload"
+		}
+		start := time.Now()
+		last := start
+		res, err := cl.SpeedTest(upload, size, func(done int64) {
+			if time.Since(last) < 500*time.Millisecond {
+				return
+			}
+			last = time.Now()
+			mbps := float64(done) * 8 / time.Since(start).Seconds() / 1e6
+			fmt.Fprintf(os.Stderr, "\r%s  %5.1f / %d MB  %7.2f Mbit/s", dir, float64(done)/(1<<20), *mb, mbps)
+		})
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		if err != nil {
+			log.Fatalf("%s: %v", dir, err)
+		}
+		fmt.Printf("%-8s %d MB in %v — %.2f Mbit/s\n", dir, res.Bytes>>20, res.Duration.Round(time.Millisecond), res.Mbps())
+	}
+}
+
+// startTestClient starts a short-lived client from cfgPath with a
+// single session and no local listeners, and waits for it to connect.
+func startTestClient(cfgPath, cmd string) *httpmux.Client {
+	cfg, err := httpmux.LoadConfig(cfgPath)
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	if cfg.Mode != "client" {
+		log.Fatalf("%s needs a client config (mode=%q)", cmd, cfg.Mode)
+	}
+	// Only the test streams are wanted: no local listeners, one session.
+	cfg.Maps, cfg.Forward.TCP, cfg.Forward.UDP = nil, nil, nil
+	cfg.SOCKS5.Listen = ""
+	cfg.DNS.Enabled = false
+	cfg.Discovery.Enabled = false
+	for i := range cfg.Paths {
+		cfg.Paths[i].ConnectionPool = 1
+	}
+
+	// The client's connection logs would interleave with the progress line.
+	log.SetOutput(io.Discard)
+	cl := httpmux.NewClient(cfg)
+	go cl.Start(context.Background())
+	err = cl.WaitReady(20 * time.Second)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		log.Fatalf("%s: %v", cmd, err)
+	}
+	return cl
+}
+
+func stopTestClient(cl *httpmux.Client) {
+	log.SetOutput(io.Discard)
+	cl.Shutdown()
+}
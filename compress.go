@@ -0,0 +1,197 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Stream compression (per map)
+//
+//   maps:
+//     - { type: tcp, bind: "5432", target: "127.0.0.1:5432", compress: zstd }
+//
+// For text-heavy protocols over slow international links. The server
+// names the algorithm in the stream header ("tcp+compress-zstd://…")
+// and both ends wrap the stream in [1B kind][2B len][payload] frames,
+// each compressed on its own (snappy or zstd) or sent raw. A chunk is
+// sent raw when it is small, when a byte-entropy estimate says it is
+// already compressed or encrypted (TLS, images, archives), or when
+// compressing didn't shrink it — so a compress map costs little even
+// when the traffic turns out not to compress. Both ends must run a
+// version that knows the "+compress-" target scheme.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	compressSnappy = "snappy"
+	compressZstd   = "zstd"
+
+	compressChunk    = 16 * 1024 // max input per frame
+	compressMinSize  = 128       // smaller chunks go raw
+	compressMaxBits  = 7.2       // bits/byte above which a chunk goes raw
+	compressSampleSz = 1024
+
+	frameRaw  byte = 0
+	frameComp byte = 1
+)
+
+func validCompress(algo string) bool {
+	return algo == "" || algo == compressSnappy || algo == compressZstd
+}
+
+// compressTarget marks a stream target as compressed with algo.
+func compressTarget(target, algo string) string {
+	return addTargetFlag(target, "compress-"+algo)
+}
+
+// splitCompressTarget strips the compression marker, returning the
+// algorithm ("" = none or unknown).
+func splitCompressTarget(target string) (string, string) {
+	rest, algo, ok := takeTargetFlag(target, "compress-")
+	if !ok || !validCompress(algo) {
+		return rest, ""
+	}
+	return rest, algo
+}
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+)
+
+// zstdCodec returns the shared encoder/decoder; EncodeAll and
+// DecodeAll are safe for concurrent use.
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEnc, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(compressChunk*4))
+		zstdDec, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0),
+			zstd.WithDecoderMaxMemory(compressChunk*4))
+	})
+	return zstdEnc, zstdDec
+}
+
+// looksCompressed estimates the Shannon entropy of a sample of p.
+func looksCompressed(p []byte) bool {
+	if len(p) > compressSampleSz {
+		p = p[:compressSampleSz]
+	}
+	var counts [256]int
+	for _, b := range p {
+		counts[b]++
+	}
+	n := float64(len(p))
+	bits := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			f := float64(c) / n
+			bits -= f * math.Log2(f)
+		}
+	}
+	return bits > compressMaxBits
+}
+
+type compressConn struct {
+	io.ReadWriteCloser
+	algo string
+
+	wbuf []byte // frame being written
+	rbuf []byte // decoded data not yet read
+	in   []byte // payload of the frame being read
+}
+
+func newCompressConn(rw io.ReadWriteCloser, algo string) *compressConn {
+	return &compressConn{ReadWriteCloser: rw, algo: algo}
+}
+
+func (c *compressConn) encode(dst, p []byte) []byte {
+	if c.algo == compressZstd {
+		enc, _ := zstdCodec()
+		return enc.EncodeAll(p, dst)
+	}
+	return snappy.Encode(dst[:cap(dst)], p)
+}
+
+func (c *compressConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), compressChunk)]
+		frame := c.wbuf[:0]
+		frame = append(frame, frameRaw, 0, 0)
+		if len(chunk) >= compressMinSize && !looksCompressed(chunk) {
+			if out := c.encode(frame[3:], chunk); len(out) < len(chunk) {
+				frame = append(frame[:3], out...)
+				frame[0] = frameComp
+			}
+		}
+		if frame[0] == frameRaw {
+			frame = append(frame, chunk...)
+		}
+		binary.BigEndian.PutUint16(frame[1:3], uint16(len(frame)-3))
+		c.wbuf = frame
+		if _, err := c.ReadWriteCloser.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (c *compressConn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		var hdr [3]byte
+		if _, err := io.ReadFull(c.ReadWriteCloser, hdr[:]); err != nil {
+			return 0, err
+		}
+		n := int(binary.BigEndian.Uint16(hdr[1:]))
+		if cap(c.in) < n {
+			c.in = make([]byte, n)
+		}
+		payload := c.in[:n]
+		if _, err := io.ReadFull(c.ReadWriteCloser, payload); err != nil {
+			return 0, err
+		}
+		switch hdr[0] {
+		case frameRaw:
+			c.rbuf = payload
+		case frameComp:
+			out, err := c.decode(payload)
+			if err != nil {
+				return 0, err
+			}
+			c.rbuf = out
+		default:
+			return 0, fmt.Errorf("compress: bad frame kind %d", hdr[0])
+		}
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *compressConn) decode(p []byte) ([]byte, error) {
+	if c.algo == compressZstd {
+		_, dec := zstdCodec()
+		out, err := dec.DecodeAll(p, nil)
+		if err == nil && len(out) > compressChunk {
+			err = fmt.Errorf("compress: frame decodes to %d bytes", len(out))
+		}
+		return out, err
+	}
+	n, err := snappy.DecodedLen(p)
+	if err != nil {
+		return nil, err
+	}
+	if n > compressChunk {
+		return nil, fmt.Errorf("compress: frame decodes to %d bytes", n)
+	}
+	return snappy.Decode(nil, p)
+}
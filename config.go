@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/xtaci/smux"
 	"gopkg.in/yaml.v3"
 )
 
@@ -20,7 +21,7 @@ import (
 //    • Improved connection stability
 // ═══════════════════════════════════════════════════════════════
 
-const CurrentConfigVersion = 3
+const CurrentConfigVersion = 4
 
 type Config struct {
 	ConfigVersion int    `yaml:"config_version"`
@@ -33,7 +34,13 @@ type Config struct {
 	CertFile      string `yaml:"cert_file"`
 	KeyFile       string `yaml:"key_file"`
 	MaxSessions   int    `yaml:"max_sessions"`
-	Heartbeat     int    `yaml:"heartbeat"`
+	Heartbeat     int    `yaml:"heartbeat"` // seconds, -1 = off (heartbeat.go)
+
+	// AuthTimeSkew binds the time into the PSK handshake and refuses
+	// peers whose clock is further off, in seconds; 0 = off (auth.go).
+	AuthTimeSkew int `yaml:"auth_time_skew"`
+
+	HeartbeatMisses int `yaml:"heartbeat_misses"`
 
 	NumConnections   int  `yaml:"num_connections"`
 	EnableDecoy      bool `yaml:"enable_decoy"`
@@ -43,9 +50,125 @@ type Config struct {
 	// ─── Multi-Port Load Balancer (v2.5) ───
 	ListenPorts []string `yaml:"listen_ports"`
 
+	// ─── Port hopping (both ends) ───
+	PortHopping PortHopConfig `yaml:"port_hopping"`
+
+	// ─── Automatic certificates (httpsmux server) ───
+	ACME ACMEConfig `yaml:"acme"`
+
+	// ─── SNI routing (httpsmux server) ───
+	SNIRouting SNIRoutingConfig `yaml:"sni_routing"`
+
+	// ─── Map discovery DNS ───
+	DNS DNSConfig `yaml:"dns"`
+
+	// ─── Resolver for target host names ───
+	Resolver ResolverConfig `yaml:"resolver"`
+
+	// ─── SOCKS5 frontend (client) ───
+	SOCKS5 SOCKS5Config `yaml:"socks5"`
+
+	// ─── Tunnel or direct, per SOCKS5 connection (client) ───
+	Routing RoutingConfig `yaml:"routing"`
+
+	// ─── Transparent proxy inbound (client, Linux) ───
+	TProxy TProxyConfig `yaml:"tproxy"`
+
+	// ─── LAN discovery relay (mDNS/SSDP) ───
+	Discovery DiscoveryConfig `yaml:"discovery"`
+
+	// ─── Decoy site + login honeypot (server) ───
+	DecoySite DecoySiteConfig `yaml:"decoy_site"`
+
+	// DecoyUpstream answers non-tunnel requests instead: a website URL
+	// to reverse-proxy or a directory of static files (server).
+	DecoyUpstream string `yaml:"decoy_upstream"`
+
+	// DecoyResponses replace the built-in error pages (server).
+	DecoyResponses []DecoyResponse `yaml:"decoy_responses"`
+
+	// ─── Forward stream destinations (server) ───
+	ACL ACLConfig `yaml:"acl"`
+
+	// ─── Admin API (server) ───
+	Admin AdminConfig `yaml:"admin"`
+
+	// ─── Usage file (server) ───
+	Accounting AccountingConfig `yaml:"accounting"`
+
+	// ─── Persistent totals, session log and quotas (server) ───
+	State StateConfig `yaml:"state"`
+
+	// ─── Stream spans to an OTLP collector ───
+	Tracing TracingConfig `yaml:"tracing"`
+
+	// ─── Opt-in crash reports ───
+	CrashReport CrashReportConfig `yaml:"crash_report"`
+
+	// ─── Multi-User (server) ───
+	// When set, only these credentials are accepted and the top-level
+	// psk is ignored on the server.
+	Users []UserConfig `yaml:"users"`
+
 	Maps  []PortMap    `yaml:"maps"`
 	Paths []PathConfig `yaml:"paths"`
 
+	// Services resolves "@name" map targets on the client and, when
+	// set, is the whitelist of addresses reverse streams may reach.
+	Services map[string]string `yaml:"services"`
+
+	// AcceptServerMaps lets the targets of the maps the server pushes
+	// through the services: whitelist (client).
+	AcceptServerMaps bool `yaml:"accept_server_maps"`
+
+	// Expose asks the server for reverse maps (client); ClientMaps is
+	// the server's policy on what clients may ask for (clientmaps.go).
+	Expose     []ExposeMap      `yaml:"expose"`
+	ClientMaps ClientMapsConfig `yaml:"client_maps"`
+
+	// ClientName is shown for this client's sessions in the server's
+	// logs, stats and admin API (client).
+	ClientName string `yaml:"client_name"`
+
+	// Tag identifies this client to the server, which pins maps with
+	// the same tag to its sessions (client).
+	Tag string `yaml:"tag"`
+
+	// LoadBalance picks how streams spread over paths (client):
+	// "failover" (default), "rtt" or "least_load".
+	LoadBalance string `yaml:"load_balance"`
+
+	// PathProbe measures every path in the background so failover
+	// acts on measurements (client, pathprobe.go).
+	PathProbe PathProbeConfig `yaml:"path_probe"`
+
+	// Blackout rotates through alternate transports, ports and
+	// fingerprints when every session dies at once (client, blackout.go).
+	Blackout BlackoutConfig `yaml:"blackout"`
+
+	// TLSVerify checks the server certificate against CAFile (or the
+	// system roots); PinSHA256 pins a certificate or key (client).
+	TLSVerify bool     `yaml:"tls_verify"`
+	CAFile    string   `yaml:"ca_file"`
+	PinSHA256 []string `yaml:"pin_sha256"`
+
+	// TLSFingerprint is the ClientHello the client sends on TLS paths:
+	// a browser name, "random" (default) or a JA3 string.
+	TLSFingerprint string `yaml:"tls_fingerprint"`
+
+	// IPPreference orders the address families a path host name is
+	// dialed on (client): "auto" (IPv6 first), "ipv4", "ipv6",
+	// "ipv4_only" or "ipv6_only".
+	IPPreference string `yaml:"ip_preference"`
+
+	// Mux is the stream multiplexer: "smux" (default) or "yamux".
+	// Servers detect it per session unless it is set there.
+	Mux string `yaml:"mux"`
+
+	// Socket sets options on tunnel sockets: TCP fast open,
+	// SO_REUSEPORT and DSCP marking (see socket.go).
+	Socket SocketConfig `yaml:"socket"`
+
 	Smux        SmuxConfig      `yaml:"smux"`
 	KCP         KCPConfig       `yaml:"kcp"`
 	Advanced    AdvancedConfig  `yaml:"advanced"`
@@ -68,6 +191,9 @@ type Config struct {
 	Obfs  ObfsConfig  `yaml:"obfs"`
 
 	SessionTimeout int `yaml:"session_timeout"`
+
+	// StatsFile receives one JSON snapshot line per run on exit ("" = log only)
+	StatsFile string `yaml:"stats_file"`
 }
 
 type StealthConfig struct {
@@ -81,6 +207,16 @@ type StealthConfig struct {
 	FakeTraffic         bool `yaml:"fake_traffic"`
 	FakeTrafficInterval int  `yaml:"fake_traffic_interval"`
 
+	// FakeBrowsing visits the fake domain's real site now and then
+	// while the client is idle (decoybrowse.go).
+	FakeBrowsing         bool     `yaml:"fake_browsing"`
+	FakeBrowsingInterval int      `yaml:"fake_browsing_interval"`
+	FakeBrowsingSites    []string `yaml:"fake_browsing_sites"`
+
+	// TrafficModel shapes record sizes and timing like web, video or
+	// call traffic instead of uniform padding (trafficmodel.go).
+	TrafficModel string `yaml:"traffic_model"`
+
 	// v2.5.1: Anti-DPI rotation — each connection uses different fingerprint
 	RotateDomain bool     `yaml:"rotate_domain"`
 	RotateUA     bool     `yaml:"rotate_ua"`
@@ -88,6 +224,16 @@ type StealthConfig struct {
 	UAPool       []string `yaml:"ua_pool"`
 }
 
+type UserConfig struct {
+	Name        string   `yaml:"name"`
+	PSK         string   `yaml:"psk"`
+	Disabled    bool     `yaml:"disabled"`
+	MaxSessions int      `yaml:"max_sessions"` // 0 = unlimited
+	Quota       string   `yaml:"quota"`        // e.g. "50GB"; needs state.path
+	QuotaPeriod string   `yaml:"quota_period"` // monthly (default), daily or total
+	Tags        []string `yaml:"tags"`         // tags this user's clients may announce (sessinfo.go)
+}
+
 type PathConfig struct {
 	Transport      string `yaml:"transport"`
 	Addr           string `yaml:"addr"`
@@ -95,12 +241,115 @@ type PathConfig struct {
 	AggressivePool bool   `yaml:"aggressive_pool"`
 	RetryInterval  int    `yaml:"retry_interval"`
 	DialTimeout    int    `yaml:"dial_timeout"`
+	Encryption     string `yaml:"encryption"` // "aes" (default) or "none" (TLS transports only)
+	Weight         int    `yaml:"weight"`     // share under load_balance rtt/least_load (default 1)
+
+	// TLSFingerprint overrides tls_fingerprint for this path;
+	// PinFingerprint keeps one random pick for the path's lifetime.
+	TLSFingerprint string `yaml:"tls_fingerprint"`
+	PinFingerprint bool   `yaml:"pin_fingerprint"`
+
+	// MinSessions is how many sessions the path needs before the
+	// tunnel counts as up (see minsessions.go).
+	MinSessions int `yaml:"min_sessions"`
+
+	// SessionMaxAge (seconds) renews sessions before DPI throttles
+	// long-lived connections (see maxage.go).
+	SessionMaxAge int `yaml:"session_max_age"`
+
+	// Smux overrides the global smux settings for this path's sessions;
+	// unset fields keep the global value.
+	Smux *SmuxConfig `yaml:"smux"`
 }
 
 type PortMap struct {
 	Type   string `yaml:"type"`
 	Bind   string `yaml:"bind"`
 	Target string `yaml:"target"`
+
+	// FallbackTarget is dialed directly by the server when no client
+	// session exists (e.g. the backend's second public address).
+	FallbackTarget string `yaml:"fallback_target"`
+
+	// IdleKeep sends keep frames on idle streams (IMAP IDLE, IRC, ...)
+	IdleKeep bool `yaml:"idle_keep"`
+
+	// BindHealth serves 200/503 for this map's availability (":8081")
+	BindHealth string `yaml:"bind_health"`
+
+	// Name publishes the map as <name>.<dns.zone> when dns: is enabled.
+	Name string `yaml:"name"`
+
+	// CircuitBreaker refuses visitors while the client reports the
+	// target down: RST, or DownBanner then close.
+	CircuitBreaker bool   `yaml:"circuit_breaker"`
+	DownBanner     string `yaml:"down_banner"`
+
+	// Bond spreads each visitor connection over this many sessions
+	// (different paths) and reassembles it on the client.
+	Bond int `yaml:"bond"`
+
+	// WarmPool keeps this many pre-dialed target connections on the
+	// client so visitors skip the dial (one visitor per connection).
+	WarmPool int `yaml:"warm_pool"`
+
+	// Tag pins the map to sessions from clients configured with the
+	// same tag ("" = any session).
+	Tag string `yaml:"tag"`
+
+	// MaxConnections caps this map's concurrent visitors (0 = only the
+	// server-wide advanced.max_connections applies).
+	MaxConnections int `yaml:"max_connections"`
+
+	// Compress compresses the stream between server and client:
+	// "snappy" or "zstd" (TCP maps).
+	Compress string `yaml:"compress"`
+
+	// HoldTimeout keeps visitors open this many seconds while no
+	// client session is up, instead of dropping them.
+	HoldTimeout int `yaml:"hold_timeout"`
+
+	// RealIP passes the visitor's address to the client: "log", or
+	// "proxy"/"proxy_v2" to also send a PROXY header to the target.
+	RealIP string `yaml:"real_ip"`
+
+	// TLS terminates visitor TLS on the server with CertFile/KeyFile
+	// (default: the server's) and relays plaintext.
+	TLS      bool   `yaml:"tls"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// TLSPassthrough picks the target by the visitor's SNI without
+	// terminating TLS: server name or "*.suffix" → target.
+	TLSPassthrough map[string]string `yaml:"tls_passthrough"`
+
+	// VHosts picks the target by the visitor's HTTP Host header:
+	// host name or "*.suffix" → target (see vhost.go).
+	VHosts map[string]string `yaml:"vhosts"`
+
+	// Resume keeps a visitor connected this many seconds while its
+	// stream moves to a new session (see resume.go).
+	Resume int `yaml:"resume"`
+
+	// Sticky routes all visitors from one source IP over the same
+	// session (see sticky.go).
+	Sticky bool `yaml:"sticky"`
+
+	// UDPQueue is how many packets each visitor flow of a UDP map may
+	// have waiting for the tunnel; UDPDrop picks which packet goes when
+	// it is full: "tail" (the new one) or "head" (see udpqueue.go).
+	UDPQueue int    `yaml:"udp_queue"`
+	UDPDrop  string `yaml:"udp_drop"`
+
+	// Rate caps the map's traffic per direction ("20mbit", "2MB");
+	// Priority orders its stream writes against other maps sharing a
+	// session: "high", "normal" or "bulk" (see qos.go).
+	Rate     string `yaml:"rate"`
+	Priority string `yaml:"priority"`
+
+	// MSS clamps the segment size visitors of a TCP map may send
+	// (see mss.go).
+	MSS int `yaml:"mss"`
 }
 
 type SmuxConfig struct {
@@ -111,6 +360,27 @@ type SmuxConfig struct {
 	Version   int `yaml:"version"`
 }
 
+// over returns base with the fields set in o replaced. Version is not
+// overridable: both ends of a session must agree on it.
+func (o *SmuxConfig) over(base SmuxConfig) SmuxConfig {
+	if o == nil {
+		return base
+	}
+	if o.KeepAlive > 0 {
+		base.KeepAlive = o.KeepAlive
+	}
+	if o.MaxRecv > 0 {
+		base.MaxRecv = o.MaxRecv
+	}
+	if o.MaxStream > 0 {
+		base.MaxStream = o.MaxStream
+	}
+	if o.FrameSize > 0 {
+		base.FrameSize = o.FrameSize
+	}
+	return base
+}
+
 type KCPConfig struct {
 	NoDelay  int `yaml:"nodelay"`
 	Interval int `yaml:"interval"`
@@ -134,10 +404,21 @@ type AdvancedConfig struct {
 	ConnectionTimeout    int  `yaml:"connection_timeout"`
 	StreamTimeout        int  `yaml:"stream_timeout"`
 	MaxConnections       int  `yaml:"max_connections"`
+	MaxConnectionsWait   int  `yaml:"max_connections_wait"` // seconds to queue for a slot, 0 = reject at once
 	MaxUDPFlows          int  `yaml:"max_udp_flows"`
 	UDPFlowTimeout       int  `yaml:"udp_flow_timeout"`
 	UDPBufferSize        int  `yaml:"udp_buffer_size"`
 	MaxStreamsPerSession  int  `yaml:"max_streams_per_session"`
+	DrainTimeout         int  `yaml:"drain_timeout"` // seconds to wait for relays on shutdown
+	AllowIntegrityOnly   bool `yaml:"allow_integrity_only"` // accept paths with encryption: none
+	HandshakeWorkers     int  `yaml:"handshake_workers"`    // concurrent TLS handshakes (httpsmux server)
+	HandshakeQueue       int  `yaml:"handshake_queue"`      // accepted conns waiting for a worker
+	HandshakeTimeout     int  `yaml:"handshake_timeout"`    // seconds, queue wait + handshake
+	PingInterval         int  `yaml:"ping_interval"`        // seconds between tunnel RTT probes, -1 = off
+	HoldQueue            int  `yaml:"hold_queue"`           // visitors held for a session at once (maps with hold_timeout)
+	StreamRate           int  `yaml:"stream_rate"`          // forward streams per second per session, -1 = unlimited
+	StreamBurst          int  `yaml:"stream_burst"`         // default 2 × stream_rate
+	FDBudget             int  `yaml:"fd_budget"`            // sockets for relays, 0 = from ulimit -n, -1 = off
 }
 
 type HTTPMimicCompat struct {
@@ -169,13 +450,30 @@ func normalizePath(p string) string {
 	return p
 }
 
+// TransportUsesTLS reports whether transport runs inside TLS.
+func TransportUsesTLS(transport string) bool {
+	switch strings.ToLower(transport) {
+	case "httpsmux", "wssmux", "h2mux", "xhttpsmux":
+		return true
+	}
+	return false
+}
+
+// httpTransport reports whether transport opens with an HTTP handshake:
+// every transport but tcpmux.
+func httpTransport(transport string) bool {
+	switch strings.ToLower(transport) {
+	case "httpmux", "wsmux", "xhttpmux":
+		return true
+	}
+	return TransportUsesTLS(transport)
+}
+
 func applyBaseDefaults(c *Config) {
 	if c.Profile == "" {
 		c.Profile = "balanced"
 	}
-	if c.Heartbeat <= 0 {
-		c.Heartbeat = 2
-	}
+	applyHeartbeatDefaults(c)
 	if c.SessionTimeout <= 0 {
 		c.SessionTimeout = 30
 	}
@@ -214,8 +512,8 @@ func applyBaseDefaults(c *Config) {
 	if c.Advanced.ConnectionTimeout <= 0 {
 		c.Advanced.ConnectionTimeout = 30
 	}
-	if c.Advanced.StreamTimeout <= 0 {
-		c.Advanced.StreamTimeout = 60
+	if c.Advanced.StreamTimeout == 0 {
+		c.Advanced.StreamTimeout = 300 // idle seconds before a relay is closed, -1 = never
 	}
 	if c.Advanced.MaxConnections <= 0 {
 		c.Advanced.MaxConnections = 500
@@ -232,6 +530,13 @@ func applyBaseDefaults(c *Config) {
 	if c.Advanced.MaxStreamsPerSession <= 0 {
 		c.Advanced.MaxStreamsPerSession = 512
 	}
+	if c.Advanced.DrainTimeout <= 0 {
+		c.Advanced.DrainTimeout = 15
+	}
+	applyHandshakeDefaults(&c.Advanced)
+	applyPingDefaults(&c.Advanced)
+	applyHoldDefaults(&c.Advanced)
+	applyStreamLimitDefaults(&c.Advanced)
 	c.Advanced.TCPNoDelay = true
 
 	if c.HTTPMimic.FakeDomain == "" {
@@ -276,7 +581,7 @@ func applyBaseDefaults(c *Config) {
 	}
 	transport := strings.ToLower(c.Transport)
 	// v2.5.1: Enable fragment for all HTTP transports (helps DPI evasion)
-	if !c.Fragment.Enabled && (transport == "httpsmux" || transport == "wssmux" || transport == "httpmux" || transport == "wsmux") {
+	if !c.Fragment.Enabled && httpTransport(transport) {
 		c.Fragment.Enabled = true
 	}
 
@@ -299,6 +604,9 @@ func applyBaseDefaults(c *Config) {
 	if c.Stealth.FakeTrafficInterval <= 0 {
 		c.Stealth.FakeTrafficInterval = 30
 	}
+	if c.Stealth.FakeBrowsingInterval <= 0 {
+		c.Stealth.FakeBrowsingInterval = 120
+	}
 
 	// v2.5.1: Domain/UA rotation pools for DPI evasion
 	if len(c.Stealth.DomainPool) == 0 {
@@ -491,6 +799,8 @@ func convertMapsToForward(c *Config) {
 			switch strings.ToLower(strings.TrimSpace(m.Type)) {
 			case "udp":
 				c.Forward.UDP = append(c.Forward.UDP, entry)
+			case "echo":
+				c.Forward.TCP = append(c.Forward.TCP, strings.TrimSpace(m.Bind)+"->"+echoTarget)
 			case "both":
 				c.Forward.TCP = append(c.Forward.TCP, entry)
 				c.Forward.UDP = append(c.Forward.UDP, entry)
@@ -501,6 +811,22 @@ func convertMapsToForward(c *Config) {
 	}
 }
 
+// mapFor returns the maps: entry behind a forward rule bound on bind,
+// so per-map options survive the "bind->target" conversion. Rules that
+// only exist under forward: get an empty PortMap.
+func (c *Config) mapFor(bind string) *PortMap {
+	for i := range c.Maps {
+		b := strings.TrimSpace(c.Maps[i].Bind)
+		if !strings.Contains(b, ":") {
+			b = "0.0.0.0:" + b
+		}
+		if b == bind {
+			return &c.Maps[i]
+		}
+	}
+	return &PortMap{Bind: bind}
+}
+
 // ═══════════════════════════════════════════════════════════════
 // Config Migration — auto-update old configs to new format
 // ═══════════════════════════════════════════════════════════════
@@ -574,6 +900,15 @@ func migrateConfig(c *Config, path string) {
 		}
 	}
 
+	// stream_timeout is now enforced as a relay idle limit; the old
+	// default would cut idle SSH sessions after a minute.
+	if c.ConfigVersion < 4 {
+		if c.Advanced.StreamTimeout == 60 {
+			c.Advanced.StreamTimeout = 300
+			log.Printf("[CONFIG]   stream_timeout: 60s → 300s")
+		}
+	}
+
 	c.ConfigVersion = CurrentConfigVersion
 	if path != "" {
 		if err := SaveConfig(c, path); err != nil {
@@ -593,18 +928,62 @@ func SaveConfig(c *Config, path string) error {
 }
 
 func LoadConfig(path string) (*Config, error) {
+	return LoadConfigOverrides(path, nil)
+}
+
+// LoadConfigOverrides is LoadConfig with --set key=value overrides
+// applied on top of the file (see configenv.go).
+func LoadConfigOverrides(path string, set []string) (*Config, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return parseConfig(b, path, set)
+}
+
+// ParseConfig is LoadConfig for YAML already in memory. An old config
+// is migrated in memory only.
+func ParseConfig(b []byte) (*Config, error) {
+	return parseConfig(b, "", nil)
+}
+
+func parseConfig(b []byte, path string, set []string) (*Config, error) {
+	b, isJSON := jsonToYAML(b)
+	b, expanded, err := expandConfig(b, set)
+	if err != nil {
+		return nil, err
+	}
+	if expanded || isJSON {
+		path = "" // a migration must not write the secrets into the file, or YAML into config.json
+	}
 	var c Config
-	if err := yaml.Unmarshal(b, &c); err != nil {
+	err = yaml.Unmarshal(b, &c)
+	if err != nil {
 		return nil, err
 	}
+	if err := prepareConfig(&c, path); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// PrepareConfig fills in defaults and validates a Config built in code,
+// as LoadConfig does for a file.
+func PrepareConfig(c *Config) error {
+	if c.ConfigVersion == 0 {
+		c.ConfigVersion = CurrentConfigVersion // written for this version, nothing to migrate
+	}
+	return prepareConfig(c, "")
+}
 
+// prepareConfig normalizes c after unmarshalling; an old config is
+// migrated and, with a path, saved.
+func prepareConfig(c *Config, path string) error {
+	var err error
 	c.Mode = strings.ToLower(strings.TrimSpace(c.Mode))
 	c.Transport = strings.ToLower(strings.TrimSpace(c.Transport))
 	c.Profile = strings.ToLower(strings.TrimSpace(c.Profile))
+	c.Mux = strings.ToLower(strings.TrimSpace(c.Mux))
 	c.Listen = strings.TrimSpace(c.Listen)
 	c.ServerURL = strings.TrimSpace(c.ServerURL)
 
@@ -612,11 +991,163 @@ func LoadConfig(path string) (*Config, error) {
 		c.Listen = "0.0.0.0:2020"
 	}
 
-	applyBaseDefaults(&c)
-	applyProfile(&c)
-	convertMapsToForward(&c)
-	syncAliases(&c)
-	migrateConfig(&c, path)
-
-	return &c, nil
+	applyBaseDefaults(c)
+	applyProfile(c)
+	convertMapsToForward(c)
+	syncAliases(c)
+	applyACMEDefaults(c)
+	applyDNSDefaults(c)
+	applyDecoySiteDefaults(c)
+	normalizeLoadBalance(c)
+	if _, err := newACL(&c.ACL); err != nil {
+		return fmt.Errorf("acl: %w", err)
+	}
+	if _, err := compileRouting(&c.Routing); err != nil {
+		return fmt.Errorf("routing: %w", err)
+	}
+	if _, err := newSocketOpts(&c.Socket); err != nil {
+		return fmt.Errorf("socket: %w", err)
+	}
+	if _, err := normalizeTProxyMode(c.TProxy.Mode); err != nil {
+		return fmt.Errorf("tproxy: %w", err)
+	}
+	if _, err := newPathProber(&c.PathProbe, c.Paths); err != nil {
+		return fmt.Errorf("path_probe: %w", err)
+	}
+	if c.Stealth.TrafficModel, err = normalizeTrafficModel(c.Stealth.TrafficModel); err != nil {
+		return fmt.Errorf("stealth.traffic_model: %w", err)
+	}
+	if _, err := decoySites(c); err != nil {
+		return fmt.Errorf("stealth.fake_browsing_sites: %w", err)
+	}
+	if _, err := newBlackout(&c.Blackout); err != nil {
+		return fmt.Errorf("blackout: %w", err)
+	}
+	if _, err := exposeMaps(c.Expose); err != nil {
+		return err
+	}
+	if _, err := newClientMapPolicy(&c.ClientMaps); err != nil {
+		return fmt.Errorf("client_maps: %w", err)
+	}
+	if _, err := newHopSchedule(c); err != nil {
+		return fmt.Errorf("port_hopping: %w", err)
+	}
+	for i := range c.Maps {
+		m := &c.Maps[i]
+		m.Compress = strings.ToLower(strings.TrimSpace(m.Compress))
+		if !validCompress(m.Compress) {
+			return fmt.Errorf("map %s: unknown compress %q (snappy or zstd)", m.Bind, m.Compress)
+		}
+		if _, err := parseRate(m.Rate); err != nil {
+			return fmt.Errorf("map %s: rate: %w", m.Bind, err)
+		}
+		m.Priority = strings.ToLower(strings.TrimSpace(m.Priority))
+		if !validPriority(m.Priority) {
+			return fmt.Errorf("map %s: unknown priority %q (high, normal or bulk)", m.Bind, m.Priority)
+		}
+		if !validMSS(m.MSS) {
+			return fmt.Errorf("map %s: mss %d: want %d-%d", m.Bind, m.MSS, mssMin, mssMax)
+		}
+		m.UDPDrop = strings.ToLower(strings.TrimSpace(m.UDPDrop))
+		if !validUDPDrop(m.UDPDrop) {
+			return fmt.Errorf("map %s: unknown udp_drop %q (tail or head)", m.Bind, m.UDPDrop)
+		}
+		if m.UDPQueue < 0 || m.UDPQueue > udpQueueMax {
+			return fmt.Errorf("map %s: udp_queue: want 0-%d packets", m.Bind, udpQueueMax)
+		}
+		m.RealIP = strings.ToLower(strings.TrimSpace(m.RealIP))
+		if !validRealIP(m.RealIP) {
+			return fmt.Errorf("map %s: unknown real_ip %q (log, proxy or proxy_v2)", m.Bind, m.RealIP)
+		}
+		for _, a := range []string{m.Bind, m.Target, m.FallbackTarget} {
+			if err := checkBracketed(strings.TrimSpace(a)); err != nil {
+				return fmt.Errorf("map %s: %w", m.Bind, err)
+			}
+		}
+		if m.Resume < 0 || m.Resume > maxResume {
+			return fmt.Errorf("map %s: resume: want 0-%d seconds", m.Bind, maxResume)
+		}
+		if m.TLS && len(m.TLSPassthrough) > 0 {
+			return fmt.Errorf("map %s: tls and tls_passthrough are exclusive", m.Bind)
+		}
+		if m.TLS && (m.CertFile == "" || m.KeyFile == "") && (c.CertFile == "" || c.KeyFile == "") {
+			return fmt.Errorf("map %s: tls needs cert_file and key_file on the map or the server", m.Bind)
+		}
+		if len(m.VHosts) > 0 && len(m.TLSPassthrough) > 0 {
+			return fmt.Errorf("map %s: vhosts and tls_passthrough are exclusive", m.Bind)
+		}
+		if m.TLSPassthrough, err = normalizeRoutes(m.TLSPassthrough); err != nil {
+			return fmt.Errorf("map %s: tls_passthrough: %w", m.Bind, err)
+		}
+		if m.VHosts, err = normalizeRoutes(m.VHosts); err != nil {
+			return fmt.Errorf("map %s: vhosts: %w", m.Bind, err)
+		}
+	}
+	for i := range c.Users {
+		u := &c.Users[i]
+		u.QuotaPeriod = strings.ToLower(strings.TrimSpace(u.QuotaPeriod))
+		if _, err := parseByteSize(u.Quota); err != nil {
+			return fmt.Errorf("user %s: quota: %w", u.Name, err)
+		}
+		if !validQuotaPeriod(u.QuotaPeriod) {
+			return fmt.Errorf("user %s: unknown quota_period %q (monthly, daily or total)", u.Name, u.QuotaPeriod)
+		}
+		for _, tag := range u.Tags {
+			if tag == "" {
+				return fmt.Errorf("user %s: empty tag", u.Name)
+			}
+			if err := validTag(tag); err != nil {
+				return fmt.Errorf("user %s: tag %w", u.Name, err)
+			}
+		}
+	}
+	if c.AuthTimeSkew < 0 {
+		return fmt.Errorf("auth_time_skew: want seconds >= 0, 0 = off")
+	}
+	if c.IPPreference, err = normalizeIPPreference(c.IPPreference); err != nil {
+		return fmt.Errorf("ip_preference: %w", err)
+	}
+	if _, err := newDecoyUpstream(c.DecoyUpstream, nil); err != nil {
+		return fmt.Errorf("decoy_upstream: %w", err)
+	}
+	if _, err := newDecoyTemplates(c.DecoyResponses); err != nil {
+		return err
+	}
+	if _, err := newCertVerifier(c); err != nil {
+		return err
+	}
+	if c.TLSFingerprint, err = normalizeFingerprint(c.TLSFingerprint); err != nil {
+		return fmt.Errorf("tls_fingerprint: %w", err)
+	}
+	for i := range c.Paths {
+		p := &c.Paths[i]
+		if p.TLSFingerprint, err = normalizeFingerprint(p.TLSFingerprint); err != nil {
+			return fmt.Errorf("path %s: tls_fingerprint: %w", p.Addr, err)
+		}
+		if p.MinSessions < 0 || p.MinSessions > maxMinSessions {
+			return fmt.Errorf("path %s: min_sessions: want 0-%d", p.Addr, maxMinSessions)
+		}
+		if p.SessionMaxAge < 0 || p.SessionMaxAge > 0 && p.SessionMaxAge < minSessionMaxAge {
+			return fmt.Errorf("path %s: session_max_age: want 0 (off) or at least %d seconds", p.Addr, minSessionMaxAge)
+		}
+		if p.Smux != nil {
+			if p.Smux.Version != 0 {
+				return fmt.Errorf("path %s: smux.version: set it globally, the server must speak the same one", p.Addr)
+			}
+			if err := smux.VerifyConfig(buildSmuxConfig(c, p)); err != nil {
+				return fmt.Errorf("path %s: smux: %w", p.Addr, err)
+			}
+		}
+	}
+	if err := normalizeMux(c); err != nil {
+		return err
+	}
+	if err := validTag(c.Tag); err != nil {
+		return fmt.Errorf("tag: %w", err)
+	}
+	if err := validClientName(c.ClientName); err != nil {
+		return fmt.Errorf("client_name: %w", err)
+	}
+	migrateConfig(c, path)
+	return nil
 }
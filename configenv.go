@@ -0,0 +1,175 @@
+package httpmux
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Environment variables and --set overrides
+//
+//   psk: "${PICOTUN_PSK}"
+//   cert_file: "${CERT_DIR:-/etc/picotun}/cert.pem"
+//
+//   picotun -c config.yaml --set psk=$PSK --set paths.0.addr=1.2.3.4:443
+//
+// ${NAME} in any YAML value is replaced by the environment variable,
+// ${NAME:-default} falls back to default; an unset variable without a
+// default is an error. --set key=value sets one value after expansion:
+// the key is a dotted path, list items by index, missing maps are
+// created. Values are plain scalars ("true" and numbers work) unless
+// they start with [ or {, which is parsed as YAML.
+//
+// A config with either is never rewritten by migration, so the
+// secrets stay out of the file.
+// ═══════════════════════════════════════════════════════════════
+
+var envRefRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandConfig applies ${VAR} expansion and set to the YAML in b. It
+// returns b itself when there is nothing to do.
+func expandConfig(b []byte, set []string) ([]byte, bool, error) {
+	if !bytes.Contains(b, []byte("${")) && len(set) == 0 {
+		return b, false, nil
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, false, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	changed, err := expandEnv(&doc)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, kv := range set {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, false, fmt.Errorf("--set %q: want key=value", kv)
+		}
+		if err := setConfigValue(doc.Content[0], strings.TrimSpace(key), value); err != nil {
+			return nil, false, fmt.Errorf("--set %s: %w", key, err)
+		}
+		changed = true
+	}
+	if !changed {
+		return b, false, nil
+	}
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// expandEnv replaces ${VAR} in every value below n (keys stay as they
+// are).
+func expandEnv(n *yaml.Node) (bool, error) {
+	changed := false
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if !strings.Contains(n.Value, "${") {
+			return false, nil
+		}
+		var missing string
+		v := envRefRe.ReplaceAllStringFunc(n.Value, func(ref string) string {
+			m := envRefRe.FindStringSubmatch(ref)
+			if val, ok := os.LookupEnv(m[1]); ok {
+				return val
+			}
+			if strings.Contains(ref, ":-") {
+				return m[2]
+			}
+			missing = m[1]
+			return ref
+		})
+		if missing != "" {
+			return false, fmt.Errorf("line %d: environment variable %s is not set", n.Line, missing)
+		}
+		if v == n.Value {
+			return false, nil
+		}
+		n.Value = v
+		if n.Style == 0 {
+			n.Tag = "" // unquoted: port: ${PORT} is still a number
+		}
+		return true, nil
+	case yaml.MappingNode:
+		for i := 1; i < len(n.Content); i += 2 {
+			c, err := expandEnv(n.Content[i])
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+	default:
+		for _, c := range n.Content {
+			ch, err := expandEnv(c)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || ch
+		}
+	}
+	return changed, nil
+}
+
+// setConfigValue sets the dotted key path below the mapping root.
+func setConfigValue(root *yaml.Node, path, value string) error {
+	val := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if v := strings.TrimSpace(value); strings.HasPrefix(v, "[") || strings.HasPrefix(v, "{") {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(v), &doc); err != nil {
+			return err
+		}
+		val = doc.Content[0]
+	}
+	parts := strings.Split(path, ".")
+	n := root
+	for i, part := range parts {
+		last := i == len(parts)-1
+		if n.Kind == yaml.ScalarNode && n.ShortTag() == "!!null" {
+			*n = yaml.Node{Kind: yaml.MappingNode} // "stealth:" with nothing under it
+		}
+		switch n.Kind {
+		case yaml.MappingNode:
+			var next *yaml.Node
+			for j := 0; j+1 < len(n.Content); j += 2 {
+				if n.Content[j].Value == part {
+					next = n.Content[j+1]
+					if last {
+						n.Content[j+1] = val
+					}
+					break
+				}
+			}
+			if next == nil {
+				next = &yaml.Node{Kind: yaml.MappingNode}
+				if last {
+					next = val
+				}
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: part}, next)
+			}
+			n = next
+		case yaml.SequenceNode:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(n.Content) {
+				return fmt.Errorf("%s: no list item %q", strings.Join(parts[:i], "."), part)
+			}
+			if last {
+				n.Content[idx] = val
+			}
+			n = n.Content[idx]
+		default:
+			return fmt.Errorf("%s is not a map or list", strings.Join(parts[:i], "."))
+		}
+	}
+	return nil
+}
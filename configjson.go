@@ -0,0 +1,100 @@
+package httpmux
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// JSON configs and the config schema
+//
+// A config file whose content is a JSON object is read as JSON, with
+// the same keys as the YAML (config.json works wherever config.yaml
+// does, ${VAR} and --set included). It is converted to YAML first, so
+// everything after that is shared; migration never rewrites it.
+//
+// `picotun schema` prints a JSON Schema (draft 2020-12) generated from
+// the Config struct, for validating configs — YAML or JSON — before
+// they are deployed. Objects reject unknown keys, like picotun check.
+// ═══════════════════════════════════════════════════════════════
+
+// jsonToYAML converts b to YAML when it is a JSON object.
+func jsonToYAML(b []byte) ([]byte, bool) {
+	t := bytes.TrimSpace(b)
+	if len(t) == 0 || t[0] != '{' || !json.Valid(t) {
+		return b, false
+	}
+	var v map[string]any
+	if err := json.Unmarshal(t, &v); err != nil {
+		return b, false
+	}
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return b, false
+	}
+	return out, true
+}
+
+// schemaEnums lists the fixed choices of string fields, by yaml key.
+var schemaEnums = map[string][]string{
+	"mode":          {"server", "client"},
+	"transport":     {"", "httpmux", "httpsmux", "wsmux", "wssmux", "tcpmux", "h2mux", "xhttpmux", "xhttpsmux"},
+	"mux":           {"", "smux", "yamux"},
+	"load_balance":  {"", "failover", "rtt", "least_load"},
+	"ip_preference": {"", "auto", "ipv4", "ipv6", "ipv4_only", "ipv6_only"},
+	"compress":      {"", "snappy", "zstd"},
+	"real_ip":       {"", "log", "proxy", "proxy_v2"},
+}
+
+// ConfigSchema returns a JSON Schema describing Config.
+func ConfigSchema() ([]byte, error) {
+	s := schemaFor(reflect.TypeOf(Config{}), "")
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "PicoTun config"
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func schemaFor(t reflect.Type, key string) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaFor(t.Elem(), key)
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		if enum, ok := schemaEnums[key]; ok {
+			return map[string]any{"type": "string", "enum": enum}
+		}
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), key)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), "")}
+	case reflect.Struct:
+		props := map[string]any{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			props[name] = schemaFor(f.Type, name)
+		}
+		return map[string]any{"type": "object", "properties": props, "additionalProperties": false}
+	}
+	return map[string]any{}
+}
@@ -0,0 +1,166 @@
+package httpmux
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Crash reports (opt-in)
+//
+//   crash_report:
+//     enabled: true
+//     dir: "/var/lib/picotun/crash"    # default: <tmp>/picotun-crash
+//     url: "https://ops.example/crash" # optional: POST each report
+//
+// A panic in a tunnel goroutine takes the whole process down, and the
+// operator usually only has "it stopped" to report. With crash_report
+// the top-level goroutines write the panic value, stack and build info
+// to a file (and optionally POST it) before the process exits as it
+// would have anyway. No core dump is involved.
+//
+// Reports are redacted first: PSKs, the admin token, map targets,
+// services and path addresses from the config, and any remaining IP
+// addresses are replaced, so a report can be passed on as-is.
+// ═══════════════════════════════════════════════════════════════
+
+type CrashReportConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`
+	URL     string `yaml:"url"`
+}
+
+type crashReporter struct {
+	dir     string
+	url     string
+	version string
+	started time.Time
+	secrets []string // replaced with <secret>
+	targets []string // replaced with <target>
+}
+
+var (
+	crashMu     sync.Mutex
+	activeCrash *crashReporter
+)
+
+// EnableCrashReports installs the reporter described by cfg.CrashReport.
+// version is recorded in every report.
+func EnableCrashReports(cfg *Config, version string) {
+	cr := &cfg.CrashReport
+	if !cr.Enabled {
+		return
+	}
+	dir := cr.Dir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "picotun-crash")
+	}
+	r := &crashReporter{dir: dir, url: cr.URL, version: version, started: time.Now()}
+	add := func(list *[]string, v string) {
+		if v = strings.TrimSpace(v); len(v) >= 4 {
+			*list = append(*list, v)
+		}
+	}
+	add(&r.secrets, cfg.PSK)
+	add(&r.secrets, cfg.Admin.Token)
+	for _, u := range cfg.Users {
+		add(&r.secrets, u.PSK)
+	}
+	for _, m := range cfg.Maps {
+		add(&r.targets, m.Target)
+		add(&r.targets, m.FallbackTarget)
+	}
+	for _, p := range cfg.Paths {
+		add(&r.targets, p.Addr)
+	}
+	for _, a := range cfg.Services {
+		add(&r.targets, a)
+	}
+	add(&r.targets, cfg.ServerURL)
+	// Longest first so "1.2.3.4:80" isn't half-replaced by "1.2.3.4".
+	for _, list := range [][]string{r.secrets, r.targets} {
+		sort.Slice(list, func(i, j int) bool { return len(list[i]) > len(list[j]) })
+	}
+
+	crashMu.Lock()
+	activeCrash = r
+	crashMu.Unlock()
+	log.Printf("[CRASH] reports enabled → %s", dir)
+}
+
+// guardPanic is deferred at the top of long-lived and per-connection
+// goroutines. Without a reporter it does nothing; with one it records
+// the panic and re-raises it, so the process still exits.
+func guardPanic(where string) {
+	crashMu.Lock()
+	r := activeCrash
+	crashMu.Unlock()
+	if r == nil {
+		return
+	}
+	v := recover()
+	if v == nil {
+		return
+	}
+	r.report(where, v, debug.Stack())
+	panic(v)
+}
+
+var (
+	ipv4Re = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	ipv6Re = regexp.MustCompile(`\[[0-9a-fA-F:]*:[0-9a-fA-F:]*\]`)
+)
+
+func (r *crashReporter) redact(s string) string {
+	for _, v := range r.secrets {
+		s = strings.ReplaceAll(s, v, "<secret>")
+	}
+	for _, v := range r.targets {
+		s = strings.ReplaceAll(s, v, "<target>")
+	}
+	s = ipv4Re.ReplaceAllString(s, "<ip>")
+	return ipv6Re.ReplaceAllString(s, "[<ip>]")
+}
+
+func (r *crashReporter) report(where string, v any, stack []byte) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "PicoTun %s crash\n", r.version)
+	fmt.Fprintf(&b, "time:    %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "uptime:  %s\n", time.Since(r.started).Round(time.Second))
+	fmt.Fprintf(&b, "go:      %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "in:      %s\n", where)
+	fmt.Fprintf(&b, "panic:   %v\n\n", v)
+	b.Write(stack)
+	text := r.redact(b.String())
+
+	name := fmt.Sprintf("crash-%s.txt", time.Now().UTC().Format("20060102-150405.000"))
+	path := filepath.Join(r.dir, name)
+	if err := os.MkdirAll(r.dir, 0o700); err == nil {
+		if err := os.WriteFile(path, []byte(text), 0o600); err == nil {
+			log.Printf("[CRASH] report written to %s", path)
+		} else {
+			log.Printf("[CRASH] write %s: %v", path, err)
+		}
+	}
+	if r.url == "" {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(r.url, "text/plain; charset=utf-8", strings.NewReader(text))
+	if err != nil {
+		log.Printf("[CRASH] upload: %v", err)
+		return
+	}
+	resp.Body.Close()
+	log.Printf("[CRASH] report sent (%s)", resp.Status)
+}
@@ -0,0 +1,29 @@
+//go:build !no_admin
+
+package httpmux
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Dashboard (server, on the admin listener)
+//
+// A single self-contained page — no external scripts, fonts or CDNs,
+// since the box it runs on may not reach them. It asks for the admin
+// token once (kept in sessionStorage) and polls the admin API:
+// sessions, throughput graph, per-map traffic, errors and the
+// session up/down history.
+// ═══════════════════════════════════════════════════════════════
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Write(dashboardHTML)
+}
@@ -0,0 +1,135 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Datagram framing over streams
+//
+// smux streams are byte streams: two UDP payloads written back to
+// back can arrive as one read, or one split in two. udp:// streams
+// therefore carry length-prefixed datagrams:
+//
+//   [2B len][payload]   (len ≤ 65535)
+//
+// datagramConn turns a stream into a message-oriented conn — each
+// Write is one datagram, each Read returns exactly one.
+// ═══════════════════════════════════════════════════════════════
+
+const maxDatagram = 0xFFFF
+
+var errDatagramTooLarge = errors.New("datagram too large")
+
+func writeDatagram(w io.Writer, p []byte) error {
+	if len(p) > maxDatagram {
+		return fmt.Errorf("%w: %d", errDatagramTooLarge, len(p))
+	}
+	buf := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(buf, uint16(len(p)))
+	copy(buf[2:], p)
+	_, err := w.Write(buf)
+	return err
+}
+
+// readDatagram reads one frame; the payload is truncated to len(buf)
+// like a UDP read into a short buffer.
+func readDatagram(r io.Reader, buf []byte) (int, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[:]))
+	if n <= len(buf) {
+		_, err := io.ReadFull(r, buf[:n])
+		return n, err
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+	_, err := io.CopyN(io.Discard, r, int64(n-len(buf)))
+	return len(buf), err
+}
+
+type datagramConn struct {
+	io.ReadWriteCloser
+	wmu sync.Mutex
+}
+
+func newDatagramConn(rw io.ReadWriteCloser) *datagramConn {
+	return &datagramConn{ReadWriteCloser: rw}
+}
+
+func (d *datagramConn) Read(p []byte) (int, error) {
+	return readDatagram(d.ReadWriteCloser, p)
+}
+
+func (d *datagramConn) Write(p []byte) (int, error) {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	if err := writeDatagram(d.ReadWriteCloser, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// relayDatagrams copies datagrams both ways until either side fails or
+// nothing has moved for idle.
+func relayDatagrams(a, b io.ReadWriteCloser, idle time.Duration) {
+	if idle <= 0 {
+		idle = 2 * time.Minute
+	}
+	var last int64 = time.Now().UnixNano()
+	done := make(chan struct{}, 2)
+	cp := func(dst io.Writer, src io.Reader) {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				break
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				break
+			}
+			atomic.StoreInt64(&last, time.Now().UnixNano())
+		}
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+
+	ticker := time.NewTicker(idle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			a.Close()
+			b.Close()
+			<-done
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, atomic.LoadInt64(&last))) > idle {
+				a.Close()
+				b.Close()
+			}
+		}
+	}
+}
+
+// ──────────── Client ────────────
+
+// DialUDP opens a forward UDP flow to target ("host:port") through the
+// tunnel. Each Write sends one datagram and each Read returns one.
+func (c *Client) DialUDP(target string) (io.ReadWriteCloser, error) {
+	stream, err := c.OpenStream("udp://" + target)
+	if err != nil {
+		return nil, err
+	}
+	return newDatagramConn(stream), nil
+}
@@ -0,0 +1,392 @@
+package httpmux
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"html/template"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Decoy site + login honeypot
+//
+//   decoy_site:
+//     enabled: true
+//     title: "Example Portal"   # default: derived from mimic.fake_domain
+//     honeypot: true            # record /login submissions as probes
+//
+// Without a site, anything that isn't a tunnel upgrade gets one of a
+// few random error pages. A human investigating the server learns
+// from that in seconds. With decoy_site the server instead looks like
+// a small private portal: an index page, a login form, robots.txt and
+// a favicon, all consistent across requests. Failed logins go one
+// stage further (a lockout message after a few tries), and every
+// submission is scored as a probe along with the submitted username and
+// a masked password. GET /api/probes on the admin API lists the scored
+// hosts.
+// ═══════════════════════════════════════════════════════════════
+
+type DecoySiteConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Title    string `yaml:"title"`
+	Honeypot bool   `yaml:"honeypot"`
+}
+
+func applyDecoySiteDefaults(c *Config) {
+	d := &c.DecoySite
+	if d.Title == "" {
+		domain := strings.TrimPrefix(c.Mimic.FakeDomain, "www.")
+		if domain == "" {
+			domain = "Portal"
+		}
+		d.Title = domain
+	}
+}
+
+const (
+	decoyLockoutAfter = 3 // failed logins before the "locked" stage
+	decoyLoginDelay   = 600 * time.Millisecond
+	decoyMaxDelayed   = 64 // logins held for decoyLoginDelay at once
+)
+
+type decoySite struct {
+	cfg     DecoySiteConfig
+	server  string // Server header, fixed per process like a real host
+	favicon []byte
+	probes  *probeTracker
+	delays  *connLimiter // logins sleeping decoyLoginDelay
+
+	mu       sync.Mutex
+	failures map[string]int // per host: failed login count
+}
+
+func newDecoySite(cfg *Config, probes *probeTracker) *decoySite {
+	servers := []string{"nginx/1.24.0", "nginx/1.25.4", "Apache/2.4.58"}
+	return &decoySite{
+		cfg:      cfg.DecoySite,
+		server:   servers[secureRandInt(len(servers))],
+		favicon:  decoyFavicon(cfg.DecoySite.Title),
+		probes:   probes,
+		delays:   newConnLimiter(decoyMaxDelayed),
+		failures: map[string]int{},
+	}
+}
+
+var decoyPages = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<link rel="icon" href="/favicon.ico">
+<style>body{font-family:sans-serif;background:#f4f5f7;margin:0}main{max-width:420px;margin:12vh auto;background:#fff;padding:2em;border-radius:6px;box-shadow:0 1px 4px #0002}a{color:#2a62c9}</style>
+</head><body><main><h1>{{.Title}}</h1>
+<p>This is a private service. Authorized users only.</p>
+<p><a href="/login">Sign in</a></p></main></body></html>
+`))
+
+func init() {
+	template.Must(decoyPages.New("login").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Sign in · {{.Title}}</title>
+<link rel="icon" href="/favicon.ico">
+<style>body{font-family:sans-serif;background:#f4f5f7;margin:0}main{max-width:340px;margin:12vh auto;background:#fff;padding:2em;border-radius:6px;box-shadow:0 1px 4px #0002}input{display:block;width:100%;box-sizing:border-box;margin:.4em 0 1em;padding:.5em}.err{color:#b3261e}</style>
+</head><body><main><h2>Sign in to {{.Title}}</h2>
+{{if .Error}}<p class="err">{{.Error}}</p>{{end}}
+{{if not .Locked}}<form method="post" action="/login">
+<label>Username<input name="username" autocomplete="username"></label>
+<label>Password<input name="password" type="password" autocomplete="current-password"></label>
+<button type="submit">Sign in</button></form>{{end}}
+</main></body></html>
+`))
+	template.Must(decoyPages.New("404").Parse(`<html>
+<head><title>404 Not Found</title></head>
+<body>
+<center><h1>404 Not Found</h1></center>
+<hr><center>{{.Server}}</center>
+</body>
+</html>
+`))
+}
+
+type decoyPage struct {
+	Title  string
+	Server string
+	Error  string
+	Locked bool
+}
+
+func (d *decoySite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Server", d.server)
+	page := decoyPage{Title: d.cfg.Title, Server: d.server}
+
+	switch r.URL.Path {
+	case "/", "/index.html":
+		d.render(w, http.StatusOK, "index", page)
+	case "/login":
+		d.serveLogin(w, r, page)
+	case "/robots.txt":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("User-agent: *\nDisallow: /login\nDisallow: /admin/\n"))
+	case "/favicon.ico":
+		w.Header().Set("Content-Type", "image/x-icon")
+		w.Header().Set("Cache-Control", "max-age=86400")
+		w.Write(d.favicon)
+	default:
+		d.render(w, http.StatusNotFound, "404", page)
+	}
+}
+
+func (d *decoySite) serveLogin(w http.ResponseWriter, r *http.Request, page decoyPage) {
+	host := hostOnly(r.RemoteAddr)
+	d.mu.Lock()
+	fails := d.failures[host]
+	d.mu.Unlock()
+	page.Locked = fails >= decoyLockoutAfter
+	if page.Locked {
+		page.Error = "Too many failed attempts. Your account has been temporarily locked."
+	}
+
+	if r.Method != http.MethodPost {
+		d.render(w, http.StatusOK, "login", page)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 8<<10)
+	user, pass := r.PostFormValue("username"), r.PostFormValue("password")
+	if d.cfg.Honeypot {
+		d.probes.login(r.RemoteAddr, user, pass)
+	}
+	// A real backend would check a hash; a locked account answers at
+	// once, and a flood of logins isn't held open.
+	if !page.Locked && d.delays.acquire(0, nil) {
+		time.Sleep(decoyLoginDelay)
+		d.delays.release()
+	}
+	if !page.Locked {
+		d.mu.Lock()
+		if len(d.failures) >= probeMaxHosts {
+			d.failures = map[string]int{}
+		}
+		d.failures[host]++
+		fails = d.failures[host]
+		d.mu.Unlock()
+		page.Error = "Invalid username or password."
+		if fails >= decoyLockoutAfter {
+			page.Locked = true
+			page.Error = "Too many failed attempts. Your account has been temporarily locked."
+		}
+	}
+	d.render(w, http.StatusUnauthorized, "login", page)
+}
+
+func (d *decoySite) render(w http.ResponseWriter, status int, name string, page decoyPage) {
+	var buf bytes.Buffer
+	if err := decoyPages.ExecuteTemplate(&buf, name, page); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// decoyFavicon builds a 16×16 ICO whose colour is derived from the
+// site title, so every deployment doesn't ship the same icon bytes.
+func decoyFavicon(title string) []byte {
+	h := sha256.Sum256([]byte(title))
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	fg := color.NRGBA{h[0], h[1], h[2], 255}
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			if x > 2 && x < 13 && y > 2 && y < 13 {
+				img.Set(x, y, fg)
+			}
+		}
+	}
+	var pngBuf bytes.Buffer
+	png.Encode(&pngBuf, img)
+
+	// ICONDIR + one ICONDIRENTRY pointing at the embedded PNG.
+	ico := make([]byte, 22, 22+pngBuf.Len())
+	binary.LittleEndian.PutUint16(ico[2:], 1) // type: icon
+	binary.LittleEndian.PutUint16(ico[4:], 1) // one image
+	ico[6], ico[7] = 16, 16
+	binary.LittleEndian.PutUint16(ico[10:], 1)  // planes
+	binary.LittleEndian.PutUint16(ico[12:], 32) // bpp
+	binary.LittleEndian.PutUint32(ico[14:], uint32(pngBuf.Len()))
+	binary.LittleEndian.PutUint32(ico[18:], 22)
+	return append(ico, pngBuf.Bytes()...)
+}
+
+// ──────────── Probe scoring ────────────
+//
+// Every request that reaches the server but isn't a valid tunnel
+// upgrade is a possible probe. Scores accumulate per source host and
+// decay after probeForget of silence; crossing probeAlertScore is
+// logged once. Honeypot logins weigh heavily — no client ever posts
+// to /login.
+
+const (
+	probeAlertScore = 20
+	probeForget     = time.Hour
+	probeMaxLogins  = 10
+	probeMaxHosts   = 4096
+)
+
+type probeTracker struct {
+	mu    sync.Mutex
+	hosts map[string]*probeRecord
+}
+
+type probeRecord struct {
+	Score   int
+	First   time.Time
+	Last    time.Time
+	Reasons map[string]int
+	Logins  []honeypotLogin
+	alerted bool
+}
+
+type honeypotLogin struct {
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	Password string    `json:"password"` // masked: first character and length only
+}
+
+// probeHost is one scored host, as GET /api/probes lists it.
+type probeHost struct {
+	Host    string          `json:"host"`
+	Score   int             `json:"score"`
+	First   time.Time       `json:"first"`
+	Last    time.Time       `json:"last"`
+	Reasons map[string]int  `json:"reasons"`
+	Logins  []honeypotLogin `json:"logins,omitempty"`
+	Alerted bool            `json:"alerted"`
+}
+
+func newProbeTracker() *probeTracker {
+	return &probeTracker{hosts: map[string]*probeRecord{}}
+}
+
+// record adds points for reason to the host of remote.
+func (p *probeTracker) record(remote, reason string, points int) {
+	p.update(remote, reason, points, nil)
+}
+
+// login records a honeypot submission.
+func (p *probeTracker) login(remote, user, pass string) {
+	l := honeypotLogin{Time: time.Now(), Username: truncate(user, 64), Password: maskPassword(truncate(pass, 64))}
+	p.update(remote, "honeypot_login", 10, &l)
+	log.Printf("[DECOY] honeypot login from %s user=%q", hostOnly(remote), l.Username)
+}
+
+func (p *probeTracker) update(remote, reason string, points int, l *honeypotLogin) {
+	host := hostOnly(remote)
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rec, ok := p.hosts[host]
+	if ok && now.Sub(rec.Last) > probeForget {
+		ok = false
+	}
+	if !ok {
+		if len(p.hosts) >= probeMaxHosts {
+			p.pruneLocked(now)
+		}
+		rec = &probeRecord{First: now, Reasons: map[string]int{}}
+		p.hosts[host] = rec
+	}
+	rec.Last = now
+	rec.Score += points
+	rec.Reasons[reason]++
+	if l != nil {
+		if len(rec.Logins) >= probeMaxLogins {
+			rec.Logins = rec.Logins[1:]
+		}
+		rec.Logins = append(rec.Logins, *l)
+	}
+	if rec.Score >= probeAlertScore && !rec.alerted {
+		rec.alerted = true
+		log.Printf("[PROBE] %s scored %d (%s)", host, rec.Score, formatReasons(rec.Reasons))
+	}
+}
+
+// pruneLocked drops expired hosts, or the stalest one if none expired.
+func (p *probeTracker) pruneLocked(now time.Time) {
+	var oldest string
+	for h, r := range p.hosts {
+		if now.Sub(r.Last) > probeForget {
+			delete(p.hosts, h)
+			continue
+		}
+		if oldest == "" || r.Last.Before(p.hosts[oldest].Last) {
+			oldest = h
+		}
+	}
+	if len(p.hosts) >= probeMaxHosts && oldest != "" {
+		delete(p.hosts, oldest)
+	}
+}
+
+// snapshot returns the hosts seen within probeForget, highest score
+// first.
+func (p *probeTracker) snapshot() []probeHost {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := []probeHost{}
+	for h, r := range p.hosts {
+		if time.Since(r.Last) > probeForget {
+			continue
+		}
+		reasons := make(map[string]int, len(r.Reasons))
+		for k, v := range r.Reasons {
+			reasons[k] = v
+		}
+		out = append(out, probeHost{Host: h, Score: r.Score, First: r.First, Last: r.Last,
+			Reasons: reasons, Logins: append([]honeypotLogin(nil), r.Logins...), Alerted: r.alerted})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Host < out[j].Host
+	})
+	return out
+}
+
+// maskPassword keeps a honeypot password's first character and length,
+// enough to tell a default-credential scan from a targeted guess.
+func maskPassword(pass string) string {
+	if pass == "" {
+		return ""
+	}
+	r := []rune(pass)
+	return string(r[0]) + strings.Repeat("*", len(r)-1)
+}
+
+func formatReasons(m map[string]int) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + strconv.Itoa(m[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
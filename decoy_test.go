@@ -0,0 +1,45 @@
+package httpmux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHoneypotLogins(t *testing.T) {
+	probes := newProbeTracker()
+	site := newDecoySite(testConfig(t, "mode: server\npsk: "+testPSK+"\ndecoy_site: {enabled: true, honeypot: true}\n"), probes)
+	login := func(pass string) (int, time.Duration) {
+		form := url.Values{"username": {"admin"}, "password": {pass}}
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "203.0.113.7:40000"
+		w := httptest.NewRecorder()
+		start := time.Now()
+		site.ServeHTTP(w, r)
+		return w.Code, time.Since(start)
+	}
+	for range decoyLockoutAfter {
+		if code, _ := login("hunter2"); code != http.StatusUnauthorized {
+			t.Fatalf("login: %d", code)
+		}
+	}
+	// Locked out: answered without the hash-check delay.
+	if _, took := login("letmein"); took >= decoyLoginDelay {
+		t.Errorf("locked login took %v", took)
+	}
+
+	hosts := probes.snapshot()
+	if len(hosts) != 1 || hosts[0].Host != "203.0.113.7" || len(hosts[0].Logins) != decoyLockoutAfter+1 {
+		t.Fatalf("snapshot %+v", hosts)
+	}
+	if l := hosts[0].Logins[0]; l.Username != "admin" || l.Password != "h******" {
+		t.Errorf("login %+v", l)
+	}
+	if !hosts[0].Alerted || hosts[0].Reasons["honeypot_login"] != decoyLockoutAfter+1 {
+		t.Errorf("host %+v", hosts[0])
+	}
+}
@@ -0,0 +1,188 @@
+package httpmux
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Decoy upstream (server)
+//
+//   decoy_upstream: "https://www.example.com"   # a real website
+//   decoy_upstream: "/var/www/html"             # or local static files
+//
+// Every request that isn't a tunnel upgrade is answered by a real
+// site instead of the built-in error pages or decoy_site, so a probe
+// sees whatever that site serves — its headers, pages and errors. A
+// URL is reverse-proxied with its own Host header and without any
+// X-Forwarded-* headers; a directory is served like a plain static
+// web root (index.html, no directory listings). If the upstream is
+// unreachable the built-in error pages are used.
+//
+// A directory is served by net/http's FileServer, whose own 404 and
+// redirect bodies are well-known Go fingerprints. Its 4xx answers are
+// replaced by the decoy pages for the same status (decoy_responses,
+// or nginx's error page), and its redirects get nginx's body.
+// ═══════════════════════════════════════════════════════════════
+
+// newDecoyUpstream returns the decoy_upstream handler, nil when unset.
+// fallback writes a decoy page for status, or any page for status 0.
+func newDecoyUpstream(raw string, fallback func(w http.ResponseWriter, status int)) (http.Handler, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://") {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("bad URL %q", raw)
+		}
+		return &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(u)
+				r.Out.Header.Del("Forwarded")
+			},
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost:   8,
+				IdleConnTimeout:       90 * time.Second,
+				ResponseHeaderTimeout: 15 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+			},
+			ErrorLog: log.New(io.Discard, "", 0),
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logDedupf("decoy_upstream", "[DECOY] upstream %s: %v", u.Host, err)
+				fallback(w, 0)
+			},
+		}, nil
+	}
+	fi, err := os.Stat(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", raw)
+	}
+	// nginx only: the error and redirect pages are nginx's.
+	servers := []string{"nginx/1.24.0", "nginx/1.25.4", "nginx/1.26.1"}
+	server := servers[secureRandInt(len(servers))]
+	if fallback == nil {
+		fallback = writeNginxPage
+	}
+	root := staticRoot{http.Dir(raw)}
+	files := http.FileServer(root)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", server)
+		// FileServer answers If-None-Match against a preset ETag.
+		if etag := staticETag(root, r.URL.Path); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		files.ServeHTTP(&staticWriter{ResponseWriter: w, page: fallback}, r)
+	}), nil
+}
+
+// staticWriter replaces FileServer's error and redirect bodies.
+type staticWriter struct {
+	http.ResponseWriter
+	page     func(http.ResponseWriter, int)
+	replaced bool // status written here; FileServer's body is dropped
+}
+
+func (w *staticWriter) WriteHeader(code int) {
+	if w.replaced {
+		return
+	}
+	h := w.Header()
+	switch {
+	case code >= 400 && code < 500:
+		w.replaced = true
+		server := h.Get("Server")
+		clear(h) // text/plain, nosniff, the preset ETag
+		h.Set("Server", server)
+		w.page(w.ResponseWriter, code)
+	case code == http.StatusMovedPermanently || code == http.StatusFound ||
+		code == http.StatusTemporaryRedirect || code == http.StatusPermanentRedirect:
+		w.replaced = true
+		h.Del("ETag")
+		writeNginxPage(w.ResponseWriter, code)
+	default:
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *staticWriter) Write(p []byte) (int, error) {
+	if w.replaced {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// writeNginxPage answers with nginx's built-in page for status.
+func writeNginxPage(w http.ResponseWriter, status int) {
+	server := w.Header().Get("Server")
+	if !strings.HasPrefix(server, "nginx") {
+		server = "nginx"
+	}
+	title := fmt.Sprintf("%d %s", status, http.StatusText(status))
+	body := "<html>\r\n<head><title>" + title + "</title></head>\r\n<body>\r\n" +
+		"<center><h1>" + title + "</h1></center>\r\n<hr><center>" + server + "</center>\r\n</body>\r\n</html>\r\n"
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+// staticETag is the nginx-style ETag of the file FileServer serves
+// for name, "" when there is none.
+func staticETag(fs http.FileSystem, name string) string {
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	name = path.Clean(name)
+	f, err := fs.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		idx, err := fs.Open(path.Join(name, "index.html"))
+		if err != nil {
+			return ""
+		}
+		defer idx.Close()
+		fi, err = idx.Stat()
+	}
+	if err != nil || !fi.Mode().IsRegular() {
+		return ""
+	}
+	return nginxETag(fi.ModTime(), fi.Size())
+}
+
+// staticRoot hides directories without an index.html, as a web server
+// with autoindex off would.
+type staticRoot struct{ http.FileSystem }
+
+func (s staticRoot) Open(name string) (http.File, error) {
+	f, err := s.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err == nil && fi.IsDir() {
+		idx, err := s.FileSystem.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+		idx.Close()
+	}
+	return f, nil
+}
@@ -0,0 +1,261 @@
+package httpmux
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Decoy browsing (client)
+//
+//   stealth:
+//     fake_browsing: true
+//     fake_browsing_interval: 120            # mean seconds between visits
+//     fake_browsing_sites:                   # allowlist (default: the fake domain(s))
+//       - "www.google.com"
+//       - "www.bing.com/search?q=weather"
+//
+// fake_traffic keeps idle sessions from going silent, but only inside
+// the tunnel: to the ISP the client still talks to one address and
+// never to the site its tunnel requests claim to be for. With
+// fake_browsing the client also visits that site for real, now and
+// then while it is idle (fewer than 3 relayed connections): a direct
+// HTTPS connection with the tunnel's TLS fingerprint, a page GET with
+// browser headers, then up to decoyAssets of the stylesheets, scripts
+// and images the page references, a short pause apart.
+//
+// Only hosts on the allowlist are ever contacted, and assets only on
+// the page's own host. Without fake_browsing_sites the allowlist is
+// mimic.fake_domain, plus stealth.domain_pool under rotate_domain.
+// Certificates are not checked and bodies are discarded (at most
+// decoyMaxBody each); nothing a visit returns is used. Failures are
+// logged with verbose: true only.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	decoyAssets  = 4
+	decoyMaxBody = 4 << 20
+	decoyTimeout = 30 * time.Second
+)
+
+var decoyAssetRE = regexp.MustCompile(`(?i)(?:src|href)\s*=\s*["']([^"'#\s]+\.(?:css|js|png|jpe?g|gif|svg|webp|ico|woff2?)(?:\?[^"'#\s]*)?)["']`)
+
+// decoySites returns the allowlist of fake_browsing.
+func decoySites(cfg *Config) ([]*url.URL, error) {
+	sites := cfg.Stealth.FakeBrowsingSites
+	if len(sites) == 0 {
+		if cfg.Mimic.FakeDomain != "" {
+			sites = append(sites, cfg.Mimic.FakeDomain)
+		}
+		if cfg.Stealth.RotateDomain {
+			sites = append(sites, cfg.Stealth.DomainPool...)
+		}
+	}
+	var out []*url.URL
+	for _, s := range sites {
+		s = strings.TrimSpace(s)
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+		u, err := url.Parse("https://" + s)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("site %q: want host[:port][/path]", s)
+		}
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+func (c *Client) runDecoyBrowsing() {
+	if !c.cfg.Stealth.FakeBrowsing {
+		return
+	}
+	defer guardPanic("decoy browsing")
+	sites, err := decoySites(c.cfg)
+	if err != nil || len(sites) == 0 {
+		log.Printf("[DECOY] fake_browsing: no sites to visit (%v)", err)
+		return
+	}
+	every := time.Duration(c.cfg.Stealth.FakeBrowsingInterval) * time.Second
+	log.Printf("[DECOY] browsing %d site(s) about every %v while idle", len(sites), every)
+	for {
+		// Half to one and a half times the interval, like a person.
+		wait := every/2 + time.Duration(secureRandInt(int(every/time.Millisecond)+1))*time.Millisecond
+		if !c.life.sleep(wait) {
+			return
+		}
+		if conns, _ := c.stats.Active(); conns >= 3 {
+			continue
+		}
+		site := sites[secureRandInt(len(sites))]
+		if err := c.decoyVisit(site); err != nil && c.verbose && !c.life.isClosing() {
+			logDedupf("decoy"+site.Host, "[DECOY] %s: %v", site.Host, err)
+		}
+	}
+}
+
+// decoyVisit loads page and some of the assets it references on the
+// same host.
+func (c *Client) decoyVisit(page *url.URL) error {
+	host := page.Host
+	if page.Port() == "" {
+		host = net.JoinHostPort(page.Hostname(), "443")
+	}
+	raw, err := c.life.dial("tcp", host, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(decoyTimeout))
+	fp := c.cfg.TLSFingerprint
+	if fp == "" || fp == fingerprintRandom {
+		fp = randomFingerprints[secureRandInt(len(randomFingerprints))]
+	}
+	conn, err := clientTLSHandshake(raw, tlsClientOpts{sni: page.Hostname(), fingerprint: fp, h2: true})
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	defer conn.Close()
+
+	var rt http.RoundTripper = &h1RoundTripper{conn: conn, br: bufio.NewReader(conn)}
+	if negotiatedProtocol(conn) == "h2" {
+		cc, err := (&http2.Transport{}).NewClientConn(conn)
+		if err != nil {
+			return err
+		}
+		defer cc.Close()
+		rt = cc
+	}
+	_, ua, _ := mimicRequest(c.mimic, &c.cfg.Stealth)
+
+	body, status, err := decoyGet(rt, page, ua, "document", "")
+	if err != nil {
+		return err
+	}
+	got := 0
+	for _, a := range decoyAssetLinks(page, body) {
+		time.Sleep(time.Duration(50+secureRandInt(250)) * time.Millisecond)
+		if _, _, err := decoyGet(rt, a, ua, assetDest(a.Path), page.String()); err != nil {
+			break
+		}
+		got++
+	}
+	if c.verbose {
+		log.Printf("[DECOY] visited %s (%d, %d asset(s))", page, status, got)
+	}
+	return nil
+}
+
+// decoyGet fetches u like a browser would and returns the page body
+// when it is HTML, nil otherwise.
+func decoyGet(rt http.RoundTripper, u *url.URL, ua, dest, referer string) ([]byte, int, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	accept := "*/*"
+	switch dest {
+	case "document":
+		accept = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	case "image":
+		accept = "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+	case "style":
+		accept = "text/css,*/*;q=0.1"
+	}
+	req.Header.Set("User-Agent", ua)
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Accept-Language", randomAcceptLang())
+	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
+	req.Header.Set("Sec-Fetch-Dest", dest)
+	if dest == "document" {
+		req.Header.Set("Sec-Fetch-Mode", "navigate")
+		req.Header.Set("Sec-Fetch-Site", "none")
+		req.Header.Set("Upgrade-Insecure-Requests", "1")
+	} else {
+		req.Header.Set("Sec-Fetch-Mode", "no-cors")
+		req.Header.Set("Sec-Fetch-Site", "same-origin")
+		req.Header.Set("Referer", referer)
+	}
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body := io.LimitReader(resp.Body, decoyMaxBody)
+	if dest != "document" || !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		_, err = io.Copy(io.Discard, body)
+		return nil, resp.StatusCode, err
+	}
+	var html []byte
+	switch resp.Header.Get("Content-Encoding") {
+	case "":
+		html, err = io.ReadAll(body)
+	case "gzip":
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(body); err == nil {
+			html, err = io.ReadAll(io.LimitReader(zr, decoyMaxBody))
+			io.Copy(io.Discard, body)
+		}
+	default:
+		_, err = io.Copy(io.Discard, body) // br: drained, not parsed
+	}
+	return html, resp.StatusCode, err
+}
+
+// decoyAssetLinks returns up to decoyAssets assets html references on
+// page's host.
+func decoyAssetLinks(page *url.URL, html []byte) []*url.URL {
+	var out []*url.URL
+	for _, m := range decoyAssetRE.FindAllSubmatch(html, -1) {
+		u, err := page.Parse(string(m[1]))
+		if err != nil || u.Scheme != "https" || u.Host != page.Host {
+			continue
+		}
+		if slices.ContainsFunc(out, func(o *url.URL) bool { return o.String() == u.String() }) {
+			continue
+		}
+		if out = append(out, u); len(out) == decoyAssets {
+			break
+		}
+	}
+	return out
+}
+
+func assetDest(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".css"):
+		return "style"
+	case strings.HasSuffix(path, ".js"):
+		return "script"
+	case strings.Contains(path, ".woff"):
+		return "font"
+	}
+	return "image"
+}
+
+// h1RoundTripper sends requests one after another on one HTTP/1.1
+// connection.
+type h1RoundTripper struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func (h *h1RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Write(h.conn); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(h.br, req)
+}
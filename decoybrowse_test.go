@@ -0,0 +1,77 @@
+package httpmux
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestDecoyVisit browses a local HTTPS site over HTTP/1.1 and h2 and
+// checks which requests it saw.
+func TestDecoyVisit(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		var mu sync.Mutex
+		var seen []string
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			seen = append(seen, fmt.Sprintf("%s %s %s", r.Proto[:6], r.URL.RequestURI(), r.Header.Get("Sec-Fetch-Dest")))
+			mu.Unlock()
+			if r.URL.Path == "/" {
+				w.Header().Set("Content-Type", "text/html")
+				fmt.Fprint(w, `<html><link rel="stylesheet" href="/style.css">`+
+					`<img src="https://elsewhere.example/logo.png"><script src="app.js?v=2"></script>`+
+					`<link href="/style.css"></html>`)
+				return
+			}
+			fmt.Fprint(w, "asset")
+		}))
+		srv.EnableHTTP2 = h2
+		srv.StartTLS()
+		defer srv.Close()
+
+		addr := strings.TrimPrefix(srv.URL, "https://")
+		c := NewClient(testConfig(t, fmt.Sprintf("mode: client\npsk: %s\n"+
+			"stealth: {fake_browsing: true, fake_browsing_sites: [%q]}\n"+
+			"paths:\n  - {addr: %q}\n", testPSK, addr, addr)))
+		sites, err := decoySites(c.cfg)
+		if err != nil || len(sites) != 1 {
+			t.Fatalf("sites %v, %v", sites, err)
+		}
+		if err := c.decoyVisit(sites[0]); err != nil {
+			t.Fatalf("h2=%v: %v", h2, err)
+		}
+		proto := "HTTP/1"
+		if h2 {
+			proto = "HTTP/2"
+		}
+		want := []string{proto + " / document", proto + " /style.css style", proto + " /app.js?v=2 script"}
+		mu.Lock()
+		if !slices.Equal(seen, want) {
+			t.Errorf("h2=%v: requests %q, want %q", h2, seen, want)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestDecoySites(t *testing.T) {
+	cfg := testConfig(t, "mode: client\npsk: x\nmimic: {fake_domain: www.example.com}\n"+
+		"stealth: {rotate_domain: true, domain_pool: [cdn.example.net]}\npaths:\n  - {addr: \"127.0.0.1:1\"}\n")
+	sites, err := decoySites(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range sites {
+		got = append(got, u.String())
+	}
+	if want := []string{"https://www.example.com/", "https://cdn.example.net/"}; !slices.Equal(got, want) {
+		t.Errorf("default sites %q, want %q", got, want)
+	}
+	if _, err := ParseConfig([]byte("mode: client\npsk: x\nstealth: {fake_browsing_sites: [\"%zz\"]}\n")); err == nil {
+		t.Error("bad site accepted")
+	}
+}
@@ -0,0 +1,111 @@
+package httpmux
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Decoy response templates (server)
+//
+//   decoy_responses:
+//     - status: 404
+//       headers: { Server: "nginx/1.18.0 (Ubuntu)" }
+//       body_file: /etc/picotun/404.html
+//     - status: 200
+//       headers: { Content-Type: "text/html; charset=utf-8", Cache-Control: "max-age=600" }
+//       body: "<html><body>It works!</body></html>"
+//
+// Replaces the built-in error pages — what a request that isn't a
+// tunnel upgrade gets without decoy_site, and what decoy_upstream
+// falls back to — with the operator's own. Each request is answered
+// with one of the list at random, as the built-ins are.
+//
+// Unset headers are filled in like a web server would: a Server picked
+// per request from the built-in list, a Content-Type sniffed from the
+// body, and on a 200 a Last-Modified and an nginx-style ETag
+// ("<mtime hex>-<length hex>"). The Last-Modified time is drawn at
+// start, somewhere in the past year, so the ETag is stable for a run
+// like a real file's but differs between servers. Date is always the
+// current time.
+// ═══════════════════════════════════════════════════════════════
+
+type DecoyResponse struct {
+	Status   int               `yaml:"status"` // default 404
+	Headers  map[string]string `yaml:"headers"`
+	Body     string            `yaml:"body"`
+	BodyFile string            `yaml:"body_file"`
+}
+
+type decoyTemplate struct {
+	status   int
+	headers  http.Header
+	body     []byte
+	modified time.Time
+}
+
+// newDecoyTemplates loads decoy_responses, nil when there are none.
+func newDecoyTemplates(list []DecoyResponse) ([]*decoyTemplate, error) {
+	var out []*decoyTemplate
+	for i, r := range list {
+		t := &decoyTemplate{status: r.Status, headers: http.Header{}, body: []byte(r.Body)}
+		if t.status == 0 {
+			t.status = http.StatusNotFound
+		}
+		if t.status < 200 || t.status > 599 {
+			return nil, fmt.Errorf("decoy_responses[%d]: status %d: want 200-599", i, r.Status)
+		}
+		if r.BodyFile != "" {
+			if r.Body != "" {
+				return nil, fmt.Errorf("decoy_responses[%d]: body and body_file are exclusive", i)
+			}
+			b, err := os.ReadFile(r.BodyFile)
+			if err != nil {
+				return nil, fmt.Errorf("decoy_responses[%d]: %w", i, err)
+			}
+			t.body = b
+		}
+		for k, v := range r.Headers {
+			t.headers.Set(k, v)
+		}
+		if t.headers.Get("Content-Type") == "" {
+			t.headers.Set("Content-Type", http.DetectContentType(t.body))
+		}
+		// Somewhere in the past year, on a whole second like a file's.
+		t.modified = time.Now().Add(-time.Duration(secureRandInt(365*24*3600)) * time.Second).Truncate(time.Second)
+		out = append(out, t)
+	}
+	return out, nil
+}
+
+func (t *decoyTemplate) write(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range t.headers {
+		h[k] = v
+	}
+	if h.Get("Server") == "" {
+		h.Set("Server", decoyServers[secureRandInt(len(decoyServers))])
+	}
+	if t.status == http.StatusOK {
+		if h.Get("Last-Modified") == "" {
+			h.Set("Last-Modified", t.modified.UTC().Format(http.TimeFormat))
+		}
+		if h.Get("ETag") == "" {
+			h.Set("ETag", nginxETag(t.modified, int64(len(t.body))))
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(t.body)))
+	w.WriteHeader(t.status)
+	w.Write(t.body)
+}
+
+// nginxETag is the ETag nginx sends for a static file.
+func nginxETag(modified time.Time, size int64) string {
+	return `"` + strconv.FormatInt(modified.Unix(), 16) + "-" + strconv.FormatInt(size, 16) + `"`
+}
+
+// decoyServers are the Server headers the built-in pages pick from.
+var decoyServers = []string{"nginx/1.24.0", "nginx/1.25.4", "Apache/2.4.58", "cloudflare"}
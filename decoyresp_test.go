@@ -0,0 +1,135 @@
+package httpmux
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecoyTemplates(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "404.html")
+	os.WriteFile(page, []byte("<html><body>gone</body></html>"), 0o644)
+
+	list, err := newDecoyTemplates([]DecoyResponse{
+		{BodyFile: page, Headers: map[string]string{"Server": "nginx/1.18.0 (Ubuntu)"}},
+		{Status: 200, Body: "hello"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	list[0].write(w)
+	if w.Code != 404 || w.Body.String() != "<html><body>gone</body></html>" {
+		t.Fatalf("404 template: %d %q", w.Code, w.Body)
+	}
+	h := w.Header()
+	if h.Get("Server") != "nginx/1.18.0 (Ubuntu)" || h.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("404 headers %v", h)
+	}
+	if h.Get("ETag") != "" || h.Get("Last-Modified") != "" {
+		t.Errorf("validators on an error page: %v", h)
+	}
+
+	w = httptest.NewRecorder()
+	list[1].write(w)
+	h = w.Header()
+	if w.Code != 200 || h.Get("Content-Length") != "5" || h.Get("Server") == "" {
+		t.Fatalf("200 template: %d %v", w.Code, h)
+	}
+	mod, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil || mod.After(time.Now()) || time.Since(mod) > 366*24*time.Hour {
+		t.Errorf("Last-Modified %q", h.Get("Last-Modified"))
+	}
+	if h.Get("ETag") != nginxETag(mod, 5) {
+		t.Errorf("ETag %q, want %q", h.Get("ETag"), nginxETag(mod, 5))
+	}
+
+	for _, bad := range []DecoyResponse{
+		{Status: 101},
+		{Body: "x", BodyFile: page},
+		{BodyFile: filepath.Join(dir, "missing.html")},
+	} {
+		if _, err := newDecoyTemplates([]DecoyResponse{bad}); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
+
+func TestDecoyUpstreamETag(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>home</html>"), 0o644)
+	h, err := newDecoyUpstream(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	etag := w.Header().Get("ETag")
+	if w.Code != 200 || !regexp.MustCompile(`^"[0-9a-f]+-11"$`).MatchString(etag) {
+		t.Fatalf("GET /: %d ETag %q", w.Code, etag)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: %d, want 304", w.Code)
+	}
+}
+
+func TestDecoyUpstreamPages(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>home</html>"), 0o644)
+	os.Mkdir(filepath.Join(dir, "docs"), 0o755)
+	os.WriteFile(filepath.Join(dir, "docs", "index.html"), []byte("<html>docs</html>"), 0o644)
+	h, err := newDecoyUpstream(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/missing.php")
+	if w.Code != 404 || w.Header().Get("Content-Type") != "text/html" || w.Header().Get("X-Content-Type-Options") != "" {
+		t.Fatalf("404: %d %v", w.Code, w.Header())
+	}
+	if body := w.Body.String(); !strings.Contains(body, "<center><h1>404 Not Found</h1></center>") ||
+		!strings.Contains(body, "<hr><center>"+w.Header().Get("Server")+"</center>") || strings.Contains(body, "page not found") {
+		t.Fatalf("404 body %q", body)
+	}
+	if n := w.Header().Get("Content-Length"); n != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Content-Length %s for %d bytes", n, w.Body.Len())
+	}
+
+	w = get("/docs")
+	if w.Code != 301 || w.Header().Get("Location") != "docs/" || !strings.Contains(w.Body.String(), "<h1>301 Moved Permanently</h1>") {
+		t.Fatalf("redirect: %d %v %q", w.Code, w.Header(), w.Body)
+	}
+	if w := get("/docs/"); w.Code != 200 || w.Body.String() != "<html>docs</html>" {
+		t.Fatalf("GET /docs/: %d %q", w.Code, w.Body)
+	}
+
+	// decoy_responses replace the page, by status where one matches.
+	s := &Server{}
+	s.decoyTemplates, _ = newDecoyTemplates([]DecoyResponse{
+		{Status: 503, Body: "busy"},
+		{Status: 404, Body: "<html>no such page</html>", Headers: map[string]string{"Content-Type": "text/html"}},
+	})
+	h, _ = newDecoyUpstream(dir, s.writeDecoyStatus)
+	if w := get("/missing.php"); w.Code != 404 || w.Body.String() != "<html>no such page</html>" {
+		t.Fatalf("template 404: %d %q", w.Code, w.Body)
+	}
+}
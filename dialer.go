@@ -0,0 +1,234 @@
+package httpmux
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Dual-stack dialing (client → server)
+//
+//   ip_preference: auto   # auto (default) | ipv4 | ipv6 | ipv4_only | ipv6_only
+//
+// A path addr that is a host name is resolved for both A and AAAA
+// and dialed the RFC 8305 ("happy eyeballs v2") way: both lookups run
+// at once, the addresses are interleaved by family starting with the
+// preferred one, and a new attempt starts every 250ms — or as soon as
+// the previous one fails — until one connects. auto prefers IPv6 as
+// the RFC does; ipv4/ipv6 put that family first; the _only values
+// never dial the other family.
+//
+// IPv6 literals go in brackets wherever a port follows:
+// "[2001:db8::1]:443" in paths, "[::]:8080" and "[::1]:22" in maps.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	ipAuto     = "auto"
+	ipPrefer4  = "ipv4"
+	ipPrefer6  = "ipv6"
+	ipOnly4    = "ipv4_only"
+	ipOnly6    = "ipv6_only"
+	heResolve  = 50 * time.Millisecond  // wait for the preferred family's answer
+	heStagger  = 250 * time.Millisecond // between connection attempts
+	heMaxAddrs = 8
+)
+
+func normalizeIPPreference(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch s {
+	case "":
+		return ipAuto, nil
+	case ipAuto, ipPrefer4, ipPrefer6, ipOnly4, ipOnly6:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown %q (auto, ipv4, ipv6, ipv4_only or ipv6_only)", s)
+}
+
+// checkBracketed rejects an IPv6 literal with a port but no brackets,
+// which net.Listen and net.Dial would misread.
+func checkBracketed(addr string) error {
+	if strings.Count(addr, ":") < 2 || strings.HasPrefix(addr, "[") {
+		return nil
+	}
+	return fmt.Errorf("%q: put IPv6 literals in brackets, e.g. [::1]:80", addr)
+}
+
+type dialFunc func(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error)
+
+func dialPlainTCP(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	d := net.Dialer{Timeout: timeout}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// happyDial connects to host:port with dial, racing the host's
+// addresses when it has several. Cancelling ctx abandons the dial.
+func happyDial(ctx context.Context, addr string, timeout time.Duration, pref string, dial dialFunc) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return dial(ctx, addr, timeout)
+	}
+	deadline := time.Now().Add(timeout)
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	ips, err := resolveHappy(ctx, host, pref)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return raceDial(ctx, addrs, deadline, dial)
+}
+
+// resolveHappy looks up both families and returns the addresses in
+// dialing order.
+func resolveHappy(ctx context.Context, host, pref string) ([]netip.Addr, error) {
+	type answer struct {
+		v6  bool
+		ips []netip.Addr
+		err error
+	}
+	prefer6 := pref != ipPrefer4 && pref != ipOnly4
+	lookup := func(v6 bool, ch chan<- answer) {
+		network := "ip4"
+		if v6 {
+			network = "ip6"
+		}
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, network, host)
+		ch <- answer{v6, ips, err}
+	}
+	ch := make(chan answer, 2)
+	pending := 0
+	if pref != ipOnly4 {
+		go lookup(true, ch)
+		pending++
+	}
+	if pref != ipOnly6 {
+		go lookup(false, ch)
+		pending++
+	}
+
+	var v4, v6 []netip.Addr
+	var firstErr error
+	var wait <-chan time.Time
+	for pending > 0 {
+		select {
+		case a := <-ch:
+			pending--
+			if a.err != nil {
+				if firstErr == nil {
+					firstErr = a.err
+				}
+				continue
+			}
+			if a.v6 {
+				v6 = a.ips
+			} else {
+				v4 = a.ips
+			}
+			if a.v6 == prefer6 {
+				pending = 0 // the preferred family is in; don't wait for the other
+			} else if wait == nil {
+				wait = time.After(heResolve)
+			}
+		case <-wait:
+			pending = 0
+		}
+	}
+
+	first, second := v4, v6
+	if prefer6 {
+		first, second = v6, v4
+	}
+	var out []netip.Addr
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i].Unmap())
+		}
+		if i < len(second) {
+			out = append(out, second[i].Unmap())
+		}
+	}
+	if len(out) == 0 {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("%s: no addresses for ip_preference %s", host, pref)
+		}
+		return nil, firstErr
+	}
+	if len(out) > heMaxAddrs {
+		out = out[:heMaxAddrs]
+	}
+	return out, nil
+}
+
+// raceDial starts an attempt on addrs[0], then on the next address
+// every heStagger or as soon as one fails. The first connection wins;
+// late ones are closed.
+func raceDial(ctx context.Context, addrs []string, deadline time.Time, dial dialFunc) (net.Conn, error) {
+	if len(addrs) == 1 {
+		return dial(ctx, addrs[0], time.Until(deadline))
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	next, pending := 0, 0
+	var stagger <-chan time.Time
+	start := func() {
+		a := addrs[next]
+		next++
+		pending++
+		go func() {
+			c, err := dial(ctx, a, time.Until(deadline))
+			results <- result{c, err}
+		}()
+		stagger = nil
+		if next < len(addrs) {
+			stagger = time.After(heStagger)
+		}
+	}
+
+	closeLate := func() {
+		go func(n int) {
+			for ; n > 0; n-- {
+				if late := <-results; late.conn != nil {
+					late.conn.Close()
+				}
+			}
+		}(pending)
+	}
+
+	start()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				closeLate()
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+			}
+		case <-stagger:
+			start()
+		case <-ctx.Done():
+			closeLate()
+			return nil, ctx.Err()
+		}
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,252 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// LAN discovery relay (mDNS / SSDP)
+//
+// Opt-in on BOTH ends:
+//
+//   discovery:
+//     enabled: true
+//     interface: "br0"          # "" = system default
+//     protocols: [mdns, ssdp]   # default: both
+//
+// The client keeps one dedicated stream (target "discovery://") open
+// to the server, which announces "discovery" only when it's enabled.
+// Each side joins the multicast groups on its LAN and forwards every
+// announcement/query it hears over that stream, where the other side
+// re-multicasts it. Packets we injected ourselves are recognised by
+// hash for a few seconds so they don't bounce back.
+//
+// Stream frames: [1B group][2B length][datagram]
+//
+// Announced addresses are the origin LAN's; they must be routable
+// from the other LAN (routed subnets, or maps on the same ports).
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	discoveryTarget = "discovery://"
+
+	discoveryMDNS byte = 1
+	discoverySSDP byte = 2
+
+	discoveryEchoWindow = 3 * time.Second
+	discoveryMaxPacket  = 9000
+	discoveryMinUp      = 5 * time.Second // a shorter-lived stream counts as refused
+	discoveryMaxBackoff = 5 * time.Minute
+)
+
+type DiscoveryConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	Interface string   `yaml:"interface"`
+	Protocols []string `yaml:"protocols"`
+}
+
+type discoveryGroup struct {
+	id   byte
+	name string
+	addr *net.UDPAddr
+	conn *net.UDPConn
+}
+
+type discoveryRelay struct {
+	life   *lifecycle
+	groups map[byte]*discoveryGroup
+
+	mu       sync.Mutex
+	streams  map[*discoveryPeer]struct{}
+	injected map[uint64]time.Time // payload hash → when we multicast it
+}
+
+type discoveryPeer struct {
+	mu sync.Mutex // serializes frame writes
+	rw io.ReadWriteCloser
+}
+
+// newDiscoveryRelay joins the configured groups; nil if disabled or
+// no group could be joined.
+func newDiscoveryRelay(cfg *DiscoveryConfig, life *lifecycle) *discoveryRelay {
+	if !cfg.Enabled {
+		return nil
+	}
+	var ifi *net.Interface
+	if cfg.Interface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(cfg.Interface); err != nil {
+			log.Printf("[DISCOVERY] interface %s: %v", cfg.Interface, err)
+			return nil
+		}
+	}
+
+	protos := cfg.Protocols
+	if len(protos) == 0 {
+		protos = []string{"mdns", "ssdp"}
+	}
+	d := &discoveryRelay{
+		life:     life,
+		groups:   make(map[byte]*discoveryGroup),
+		streams:  make(map[*discoveryPeer]struct{}),
+		injected: make(map[uint64]time.Time),
+	}
+	for _, p := range protos {
+		g := &discoveryGroup{name: strings.ToLower(strings.TrimSpace(p))}
+		switch g.name {
+		case "mdns":
+			g.id, g.addr = discoveryMDNS, &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+		case "ssdp":
+			g.id, g.addr = discoverySSDP, &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+		default:
+			log.Printf("[DISCOVERY] unknown protocol %q", p)
+			continue
+		}
+		conn, err := net.ListenMulticastUDP("udp4", ifi, g.addr)
+		if err != nil {
+			log.Printf("[DISCOVERY] join %s %v: %v", g.name, g.addr, err)
+			continue
+		}
+		g.conn = conn
+		life.track(conn)
+		d.groups[g.id] = g
+		go d.readGroup(g)
+		log.Printf("[DISCOVERY] relaying %s (%v)", g.name, g.addr)
+	}
+	if len(d.groups) == 0 {
+		return nil
+	}
+	return d
+}
+
+// readGroup forwards LAN datagrams to every connected peer.
+func (d *discoveryRelay) readGroup(g *discoveryGroup) {
+	buf := make([]byte, discoveryMaxPacket)
+	for {
+		n, _, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			if d.life.isClosing() {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if d.wasInjected(buf[:n]) {
+			continue
+		}
+		frame := make([]byte, 3+n)
+		frame[0] = g.id
+		binary.BigEndian.PutUint16(frame[1:3], uint16(n))
+		copy(frame[3:], buf[:n])
+
+		d.mu.Lock()
+		peers := make([]*discoveryPeer, 0, len(d.streams))
+		for p := range d.streams {
+			peers = append(peers, p)
+		}
+		d.mu.Unlock()
+		for _, p := range peers {
+			p.mu.Lock()
+			_, err := p.rw.Write(frame)
+			p.mu.Unlock()
+			if err != nil {
+				p.rw.Close()
+			}
+		}
+	}
+}
+
+// serve attaches one relay stream and re-multicasts what it carries
+// until the stream closes.
+func (d *discoveryRelay) serve(rw io.ReadWriteCloser) {
+	p := &discoveryPeer{rw: rw}
+	d.mu.Lock()
+	d.streams[p] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.streams, p)
+		d.mu.Unlock()
+		rw.Close()
+	}()
+
+	hdr := make([]byte, 3)
+	buf := make([]byte, discoveryMaxPacket)
+	for {
+		if _, err := io.ReadFull(rw, hdr); err != nil {
+			return
+		}
+		n := int(binary.BigEndian.Uint16(hdr[1:3]))
+		if n > len(buf) {
+			return
+		}
+		if _, err := io.ReadFull(rw, buf[:n]); err != nil {
+			return
+		}
+		g := d.groups[hdr[0]]
+		if g == nil {
+			continue // protocol not enabled on this side
+		}
+		d.markInjected(buf[:n])
+		g.conn.WriteToUDP(buf[:n], g.addr)
+	}
+}
+
+func discoveryHash(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+func (d *discoveryRelay) markInjected(b []byte) {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, t := range d.injected {
+		if now.Sub(t) > discoveryEchoWindow {
+			delete(d.injected, k)
+		}
+	}
+	d.injected[discoveryHash(b)] = now
+}
+
+func (d *discoveryRelay) wasInjected(b []byte) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.injected[discoveryHash(b)]
+	return ok && time.Since(t) <= discoveryEchoWindow
+}
+
+// ──────────── Client ────────────
+
+// runDiscovery keeps the client's relay stream open while running. A
+// server without discovery doesn't announce it, or (legacy) closes the
+// stream at once; either way the client retries less and less often.
+func (c *Client) runDiscovery(d *discoveryRelay) {
+	wait := time.Second
+	for !c.life.isClosing() {
+		start := time.Now()
+		stream, err := c.OpenStream(discoveryTarget)
+		if err == nil {
+			d.serve(stream)
+		}
+		if err == nil && time.Since(start) >= discoveryMinUp {
+			wait = time.Second
+		} else {
+			if err == nil {
+				err = fmt.Errorf("server closed the stream")
+			}
+			wait = min(wait*2, discoveryMaxBackoff)
+			logDedupf("discovery", "[DISCOVERY] relay unavailable (%v), retrying in %v", err, wait)
+		}
+		c.life.sleep(wait)
+	}
+}
@@ -0,0 +1,247 @@
+package httpmux
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Map discovery DNS
+//
+// Optional tiny authoritative responder so mapped services can be
+// reached by name instead of by port:
+//
+//   dns:
+//     enabled: true
+//     listen: "0.0.0.0:5353"
+//     zone: "tunnel.local"
+//   maps:
+//     - { type: tcp, bind: "8443", target: "...", name: "nas" }
+//
+//   nas.tunnel.local            A    <bind address>
+//   _nas._tcp.tunnel.local      SRV  0 0 8443 nas.tunnel.local
+//
+// Maps bound to 0.0.0.0 advertise dns.address (or the first
+// non-loopback interface address). Names outside the zone are
+// REFUSED so the responder can't be used as an open resolver.
+// ═══════════════════════════════════════════════════════════════
+
+type DNSConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"`
+	Zone    string `yaml:"zone"`
+	TTL     int    `yaml:"ttl"`
+	Address string `yaml:"address"` // advertised for wildcard binds
+}
+
+func applyDNSDefaults(c *Config) {
+	d := &c.DNS
+	if d.Listen == "" {
+		d.Listen = "127.0.0.1:5353"
+	}
+	if d.Zone == "" {
+		d.Zone = "tunnel.local"
+	}
+	if d.TTL <= 0 {
+		d.TTL = 60
+	}
+}
+
+type dnsRecord struct {
+	fqdn  string
+	ip    net.IP
+	port  uint16
+	proto []string // "tcp", "udp"
+}
+
+type mapDNS struct {
+	zone    string // canonical, with trailing dot
+	ttl     uint32
+	records map[string]*dnsRecord // "nas.tunnel.local."
+}
+
+func newMapDNS(cfg *Config) *mapDNS {
+	d := &mapDNS{
+		zone:    canonicalName(cfg.DNS.Zone),
+		ttl:     uint32(cfg.DNS.TTL),
+		records: make(map[string]*dnsRecord),
+	}
+	for _, m := range cfg.Maps {
+		name := strings.ToLower(strings.TrimSpace(m.Name))
+		if name == "" {
+			continue
+		}
+		bind := strings.TrimSpace(m.Bind)
+		if !strings.Contains(bind, ":") {
+			bind = "0.0.0.0:" + bind
+		}
+		host, portStr, err := net.SplitHostPort(bind)
+		port, perr := strconv.Atoi(portStr)
+		if err != nil || perr != nil {
+			log.Printf("[DNS] map %q: bad bind %q", name, m.Bind)
+			continue
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsUnspecified() {
+			ip = advertisedIP(cfg.DNS.Address)
+		}
+		var proto []string
+		switch strings.ToLower(strings.TrimSpace(m.Type)) {
+		case "udp":
+			proto = []string{"udp"}
+		case "both":
+			proto = []string{"tcp", "udp"}
+		default:
+			proto = []string{"tcp"}
+		}
+		fqdn := name + "." + d.zone
+		d.records[fqdn] = &dnsRecord{fqdn: fqdn, ip: ip, port: uint16(port), proto: proto}
+	}
+	return d
+}
+
+func canonicalName(s string) string {
+	s = strings.ToLower(strings.Trim(strings.TrimSpace(s), "."))
+	return s + "."
+}
+
+// advertisedIP picks the address wildcard binds are announced under.
+func advertisedIP(configured string) net.IP {
+	if ip := net.ParseIP(configured); ip != nil {
+		return ip
+	}
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() && ipn.IP.To4() != nil {
+			return ipn.IP
+		}
+	}
+	return net.IPv4(127, 0, 0, 1)
+}
+
+// startMapDNS serves the map names until life begins shutting down.
+func startMapDNS(cfg *Config, life *lifecycle) {
+	if !cfg.DNS.Enabled {
+		return
+	}
+	d := newMapDNS(cfg)
+	pc, err := net.ListenPacket("udp", cfg.DNS.Listen)
+	if err != nil {
+		log.Printf("[DNS] FAILED listen %s: %v", cfg.DNS.Listen, err)
+		return
+	}
+	life.track(pc)
+	log.Printf("[DNS] %s serving %d name(s) in %s", cfg.DNS.Listen, len(d.records), d.zone)
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				if life.isClosing() {
+					return
+				}
+				continue
+			}
+			if resp := d.answer(buf[:n]); resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+		}
+	}()
+}
+
+// answer builds the response for one query packet, or nil to drop it.
+func (d *mapDNS) answer(query []byte) []byte {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+
+	rh := dnsmessage.Header{ID: hdr.ID, Response: true, OpCode: hdr.OpCode, RecursionDesired: hdr.RecursionDesired}
+	name := strings.ToLower(q.Name.String())
+	rec, rcode, answers := d.lookup(name, q.Type)
+	rh.RCode = rcode
+	rh.Authoritative = rcode != dnsmessage.RCodeRefused
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), rh)
+	b.EnableCompression()
+	if b.StartQuestions() != nil || b.Question(q) != nil || b.StartAnswers() != nil {
+		return nil
+	}
+	rhdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: d.ttl}
+	for _, a := range answers {
+		switch a {
+		case dnsmessage.TypeA:
+			var ip4 [4]byte
+			copy(ip4[:], rec.ip.To4())
+			b.AResource(rhdr, dnsmessage.AResource{A: ip4})
+		case dnsmessage.TypeAAAA:
+			var ip6 [16]byte
+			copy(ip6[:], rec.ip.To16())
+			b.AAAAResource(rhdr, dnsmessage.AAAAResource{AAAA: ip6})
+		case dnsmessage.TypeSRV:
+			b.SRVResource(rhdr, dnsmessage.SRVResource{Port: rec.port, Target: dnsmessage.MustNewName(rec.fqdn)})
+		}
+	}
+	resp, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+// lookup resolves name/qtype to the record and the answer types to emit.
+func (d *mapDNS) lookup(name string, qtype dnsmessage.Type) (*dnsRecord, dnsmessage.RCode, []dnsmessage.Type) {
+	if name != d.zone && !strings.HasSuffix(name, "."+d.zone) {
+		return nil, dnsmessage.RCodeRefused, nil
+	}
+
+	// _nas._tcp.tunnel.local.
+	if strings.HasPrefix(name, "_") {
+		parts := strings.SplitN(name, ".", 3)
+		if len(parts) == 3 {
+			rec := d.records[strings.TrimPrefix(parts[0], "_")+"."+parts[2]]
+			if rec != nil && containsString(rec.proto, strings.TrimPrefix(parts[1], "_")) {
+				if qtype == dnsmessage.TypeSRV || qtype == dnsmessage.TypeALL {
+					return rec, dnsmessage.RCodeSuccess, []dnsmessage.Type{dnsmessage.TypeSRV}
+				}
+				return rec, dnsmessage.RCodeSuccess, nil
+			}
+		}
+		return nil, dnsmessage.RCodeNameError, nil
+	}
+
+	rec := d.records[name]
+	if rec == nil {
+		if name == d.zone {
+			return nil, dnsmessage.RCodeSuccess, nil
+		}
+		return nil, dnsmessage.RCodeNameError, nil
+	}
+	is4 := rec.ip.To4() != nil
+	switch {
+	case (qtype == dnsmessage.TypeA || qtype == dnsmessage.TypeALL) && is4:
+		return rec, dnsmessage.RCodeSuccess, []dnsmessage.Type{dnsmessage.TypeA}
+	case (qtype == dnsmessage.TypeAAAA || qtype == dnsmessage.TypeALL) && !is4:
+		return rec, dnsmessage.RCodeSuccess, []dnsmessage.Type{dnsmessage.TypeAAAA}
+	}
+	return rec, dnsmessage.RCodeSuccess, nil // NODATA
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
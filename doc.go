@@ -0,0 +1,17 @@
+// Package httpmux is PicoTun's tunnel: the server and client behind
+// the picotun command, usable from other Go programs. Import it under
+// the project's name:
+//
+//	import picotun "github.com/amir6dev/PicoTun"
+//
+//	cfg, err := picotun.LoadConfig("client.yaml") // or ParseConfig, or a Config and PrepareConfig
+//	cl := picotun.NewClient(cfg)
+//	go cl.Start(ctx)                              // until ctx is cancelled
+//	conn, err := cl.DialContext(ctx, "tcp", "10.0.0.5:22")
+//
+// The embedding API is Config and its loaders, NewServer/NewClient,
+// Start, Shutdown, Stats, and the client's Dial, DialContext, DialUDP,
+// WaitReady, SpeedTest and Bench. Other exported names (handshakes,
+// EncryptedConn, obfuscation helpers) are wire-level building blocks
+// shared with the tools under cmd/ and may change between releases.
+package httpmux
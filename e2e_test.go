@@ -0,0 +1,272 @@
+package httpmux
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// End-to-end: every test starts a real server/client pair (harness_test.go)
+// and pushes traffic through it in both directions.
+
+func TestForwardTCP(t *testing.T) {
+	echo := tcpEcho(t)
+	for _, tr := range []string{"httpmux", "tcpmux", "wsmux", "xhttpmux"} {
+		t.Run(tr, func(t *testing.T) {
+			tun := startTunnel(t, tunnelOpts{transport: tr})
+			c, err := tun.client.Dial("tcp", echo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			checkEcho(t, c, []byte("hello"))
+			checkEcho(t, c, randomBytes(t, 256<<10))
+		})
+	}
+}
+
+func TestForwardTCPOverTLS(t *testing.T) {
+	echo := tcpEcho(t)
+	for _, tr := range []string{"httpsmux", "wssmux", "h2mux", "xhttpsmux"} {
+		t.Run(tr, func(t *testing.T) {
+			tun := startTunnel(t, tunnelOpts{transport: tr, tls: true})
+			c, err := tun.client.Dial("tcp", echo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			checkEcho(t, c, randomBytes(t, 64<<10))
+		})
+	}
+}
+
+func TestForwardUDP(t *testing.T) {
+	tun := startTunnel(t, tunnelOpts{})
+	c, err := tun.client.Dial("udp", udpEcho(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	checkDatagrams(t, c)
+}
+
+func TestReverseTCPMap(t *testing.T) {
+	bind := freeAddr(t)
+	startTunnel(t, tunnelOpts{server: mapYAML("tcp", bind, tcpEcho(t))})
+	c := dialMap(t, "tcp", bind)
+	checkEcho(t, c, []byte("hello"))
+	checkEcho(t, c, randomBytes(t, 256<<10))
+
+	// A second visitor gets its own stream.
+	checkEcho(t, dialMap(t, "tcp", bind), []byte("second"))
+}
+
+func TestReverseUDPMap(t *testing.T) {
+	bind := freeUDPAddr(t)
+	startTunnel(t, tunnelOpts{server: mapYAML("udp", bind, udpEcho(t))})
+	checkDatagrams(t, dialMap(t, "udp", bind))
+}
+
+func TestLegacyForwardMaps(t *testing.T) {
+	tcpBind, udpBind := freeAddr(t), freeUDPAddr(t)
+	startTunnel(t, tunnelOpts{server: "forward:\n" +
+		"  tcp: [\"" + tcpBind + "->" + tcpEcho(t) + "\"]\n" +
+		"  udp: [\"" + udpBind + "->" + udpEcho(t) + "\"]\n"})
+	checkEcho(t, dialMap(t, "tcp", tcpBind), []byte("hello"))
+	checkDatagrams(t, dialMap(t, "udp", udpBind))
+}
+
+// TestFraming runs the same reverse map under each option that changes
+// how packets are framed on the tunnel connection.
+func TestFraming(t *testing.T) {
+	cases := []struct {
+		name string
+		opts tunnelOpts
+	}{
+		{"obfuscation", tunnelOpts{
+			server: "obfuscation: {enabled: true, min_padding: 8, max_padding: 64}\n",
+			client: "obfuscation: {enabled: true, min_padding: 8, max_padding: 64}\n",
+		}},
+		{"stealth padding", tunnelOpts{
+			server:  "stealth: {random_padding: true, min_padding: 4, max_padding: 32}\n",
+			stealth: ", random_padding: true, min_padding: 4, max_padding: 32, burst_split: true, max_burst_size: 4096",
+		}},
+		{"traffic model", tunnelOpts{
+			server:  "stealth: {traffic_model: video}\n",
+			stealth: ", traffic_model: web",
+		}},
+		{"fragment", tunnelOpts{
+			client: "fragment: {enabled: true, min_size: 16, max_size: 32}\n",
+		}},
+		{"yamux", tunnelOpts{client: "mux: yamux\n"}},
+		{"auth time", tunnelOpts{server: "auth_time_skew: 60\n", client: "auth_time_skew: 60\n"}},
+		{"integrity only", tunnelOpts{
+			transport: "httpsmux",
+			tls:       true,
+			advanced:  "allow_integrity_only: true",
+			path:      ", encryption: none",
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bind := freeAddr(t)
+			tc.opts.server += mapYAML("tcp", bind, tcpEcho(t))
+			startTunnel(t, tc.opts)
+			c := dialMap(t, "tcp", bind)
+			checkEcho(t, c, []byte("x"))
+			checkEcho(t, c, randomBytes(t, 128<<10))
+		})
+	}
+}
+
+func TestWrongPSKRefused(t *testing.T) {
+	tun := startTunnel(t, tunnelOpts{})
+	cfg := testConfig(t, "mode: client\ntransport: httpmux\npsk: not-the-psk\n"+
+		"paths:\n  - {transport: httpmux, addr: \""+tun.addr+"\", connection_pool: 1}\n")
+	cl := NewClient(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cl.Start(ctx)
+	if err := cl.WaitReady(2 * time.Second); err == nil {
+		t.Fatal("client with the wrong psk got a session")
+	}
+}
+
+// liveSession is a muxSession that is never closed.
+type liveSession struct{ muxSession }
+
+func (liveSession) IsClosed() bool { return false }
+
+// TestMaxSessionsRace adds sessions of one user from many handshakes at
+// once; max_sessions must hold.
+func TestMaxSessionsRace(t *testing.T) {
+	s := NewServer(testConfig(t, "mode: server\npsk: "+testPSK+"\n"))
+	var wg sync.WaitGroup
+	var added atomic.Int32
+	for range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.tryAddSession(&serverSession{sess: liveSession{}, user: "alice"}, 3) {
+				added.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := added.Load(); n != 3 || s.userSessions("alice") != 3 {
+		t.Fatalf("added %d, pool has %d, want 3", n, s.userSessions("alice"))
+	}
+}
+
+func TestProtoNegotiated(t *testing.T) {
+	tun := startTunnel(t, tunnelOpts{})
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var cp, sp *sessionInfo
+		if cs := tun.client.orderSessions(); len(cs) > 0 {
+			cp = cs[0].peer.Load()
+		}
+		tun.server.poolMu.RLock()
+		if len(tun.server.sessions) > 0 {
+			sp = tun.server.sessions[0].info.Load()
+		}
+		tun.server.poolMu.RUnlock()
+		if cp != nil && sp != nil {
+			if cp.Proto != protoVersion || sp.Proto != protoVersion {
+				t.Fatalf("negotiated client %s, server %s", sp.protoString(), cp.protoString())
+			}
+			if !cp.supports(featCompress) || !sp.supports(featDatagram) {
+				t.Fatalf("features missing: client %s, server %s", sp.protoString(), cp.protoString())
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no hello exchanged")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// TestLegacyUntaggedStream plays a v2.4 server: reverse streams without
+// a type byte are served on sessions that didn't negotiate, and refused
+// on those that did.
+func TestLegacyUntaggedStream(t *testing.T) {
+	echo := tcpEcho(t)
+	tun := startTunnel(t, tunnelOpts{})
+	cs := tun.client.orderSessions()[0]
+	deadline := time.Now().Add(5 * time.Second)
+	for cs.peer.Load() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no hello answer")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	tun.server.poolMu.RLock()
+	ss := tun.server.sessions[0]
+	tun.server.poolMu.RUnlock()
+
+	untagged := func() net.Conn {
+		st, err := ss.sess.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		sendTarget(st, "tcp://"+echo)
+		return st
+	}
+
+	st := untagged()
+	st.SetReadDeadline(time.Now().Add(time.Second))
+	st.Write([]byte("x"))
+	if _, err := st.Read(make([]byte, 1)); err == nil {
+		t.Fatal("negotiated client served an untagged stream")
+	}
+
+	cs.peer.Store(&sessionInfo{}) // as if the server never answered
+	checkEcho(t, untagged(), []byte("hello from v2.4"))
+}
+
+// TestSOCKS5Fallback runs a client whose server is down: tunnel traffic
+// is dialed directly after the grace period with fallback: direct, and
+// refused with a closed rule.
+func TestSOCKS5Fallback(t *testing.T) {
+	echo := tcpEcho(t)
+	socks := freeAddr(t)
+	cfg := testConfig(t, fmt.Sprintf("mode: client\ntransport: httpmux\npsk: %s\nadvanced:\n  drain_timeout: 1\n"+
+		"paths:\n  - {transport: httpmux, addr: %q, connection_pool: 1}\n"+
+		"socks5: {listen: %q}\n"+
+		"routing:\n  fallback: direct\n  fallback_grace: 1\n  rules:\n    - {via: tunnel, ports: \"1-1023\", fallback: closed}\n",
+		testPSK, freeAddr(t), socks))
+	cl := NewClient(cfg)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cl.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitListening(t, socks)
+
+	start := time.Now()
+	c, err := socksConnect(t, socks, echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("fell back after %v, before the grace period", waited)
+	}
+	checkEcho(t, c, []byte("around the tunnel"))
+
+	if c, err := socksConnect(t, socks, "127.0.0.1:1"); err == nil {
+		c.Close()
+		t.Fatal("fallback: closed rule was dialed directly")
+	}
+}
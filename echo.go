@@ -0,0 +1,83 @@
+package httpmux
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Built-in echo maps (reachability / latency test)
+//
+//   maps:
+//     - { type: echo, bind: "7777" }
+//
+// On the server the bind port is tunneled to the client, which
+// answers with a built-in echo responder instead of dialing a
+// backend. On the client the bind port is tunneled to the server's
+// responder. Either way `nc host 7777` proves the full path works
+// before the real backend is involved.
+// ═══════════════════════════════════════════════════════════════
+
+const echoTarget = "echo://"
+
+func isEchoTarget(target string) bool {
+	return strings.HasPrefix(target, echoTarget)
+}
+
+// serveEcho copies everything the peer sends straight back.
+func serveEcho(rw io.ReadWriteCloser) {
+	buf := make([]byte, 32*1024)
+	io.CopyBuffer(rw, rw, buf)
+	rw.Close()
+}
+
+// startEchoMaps starts a local listener for every echo map in the
+// client config; each connection is relayed to the server's responder.
+func (c *Client) startEchoMaps() {
+	for _, m := range c.cfg.Forward.TCP {
+		bind, target, ok := SplitMap(m)
+		if !ok || !isEchoTarget(target) {
+			continue
+		}
+		go c.serveEchoMap(bind)
+	}
+}
+
+func (c *Client) serveEchoMap(bind string) {
+	ln, err := net.Listen("tcp", bind)
+	if err != nil {
+		log.Printf("[ECHO] FAILED listen %s: %v", bind, err)
+		return
+	}
+	log.Printf("[ECHO] %s → server echo", bind)
+	c.life.track(ln)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if c.life.isClosing() {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if !c.life.acquire() {
+				return
+			}
+			defer c.life.release()
+			stream, err := c.OpenStream(echoTarget)
+			if err != nil {
+				c.stats.incError("no_session")
+				return
+			}
+			m, done := c.stats.connOpened("echo:" + bind)
+			defer done()
+			relay(&countedConn{ReadWriteCloser: conn, st: c.stats, m: m}, stream, streamIdle(c.cfg))
+		}(conn)
+	}
+}
@@ -0,0 +1,75 @@
+package httpmux
+
+import (
+	"context"
+	"net"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Embedding: the client as a dialer
+//
+//   cl := picotun.NewClient(cfg)
+//   go cl.Start(ctx)
+//   cl.WaitReady(10 * time.Second)
+//   conn, err := cl.DialContext(ctx, "tcp", "example.com:443")
+//
+// Dial and DialContext have net.Dialer's signature, so a Client plugs
+// into http.Transport.DialContext and the like. The server dials the
+// address (its acl: applies); "udp" returns a conn whose every Write
+// is one datagram and every Read returns one.
+// ═══════════════════════════════════════════════════════════════
+
+// Dial connects to addr through the tunnel.
+func (c *Client) Dial(network, addr string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to addr through the tunnel; ctx bounds the wait
+// for a session and the stream setup, not the connection's lifetime.
+func (c *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	target := addr
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	case "udp", "udp4", "udp6":
+		target = "udp://" + addr
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := c.OpenStream(target)
+		done <- result{conn, err}
+	}()
+	var r result
+	select {
+	case r = <-done:
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+	}
+	if r.err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: r.err}
+	}
+	if target != addr {
+		return &datagramNetConn{Conn: r.conn, dg: newDatagramConn(r.conn)}, nil
+	}
+	return r.conn, nil
+}
+
+// datagramNetConn is a datagramConn that keeps the stream's net.Conn
+// methods (addresses, deadlines).
+type datagramNetConn struct {
+	net.Conn
+	dg *datagramConn
+}
+
+func (d *datagramNetConn) Read(p []byte) (int, error)  { return d.dg.Read(p) }
+func (d *datagramNetConn) Write(p []byte) (int, error) { return d.dg.Write(p) }
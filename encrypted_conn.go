@@ -25,27 +25,44 @@ import (
 
 type EncryptedConn struct {
 	conn    net.Conn
-	gcm     cipher.AEAD
+	gcm     cipher.AEAD // AES-GCM, or hmacAEAD in integrity-only mode
 	obfs    *ObfsConfig
 	stealth *StealthConfig
 
 	readMu  sync.Mutex
 	writeMu sync.Mutex
 	readBuf []byte
+
+	// traffic_model record sizes and pacing (trafficmodel.go); guarded
+	// by writeMu
+	model *trafficModel
+	shape shapeState
+
+	// Replay protection (see BindSession); guarded by writeMu/readMu
+	session  []byte
+	writeDir byte
+	readDir  byte
+	writeSeq uint64
+	readSeq  uint64
 }
 
 func NewEncryptedConn(conn net.Conn, psk string, obfs *ObfsConfig, stealth ...*StealthConfig) (*EncryptedConn, error) {
-	ec := &EncryptedConn{conn: conn, obfs: obfs}
-	if len(stealth) > 0 && stealth[0] != nil {
-		ec.stealth = stealth[0]
+	if psk == "" {
+		return NewEncryptedConnKey(conn, nil, obfs, stealth...)
 	}
+	hash := sha256.Sum256([]byte(psk))
+	return NewEncryptedConnKey(conn, hash[:], obfs, stealth...)
+}
 
-	if psk == "" {
+// NewEncryptedConnKey is NewEncryptedConn with an explicit 32-byte AES
+// key, e.g. a per-connection key from the auth exchange. nil = no AEAD.
+func NewEncryptedConnKey(conn net.Conn, key []byte, obfs *ObfsConfig, stealth ...*StealthConfig) (*EncryptedConn, error) {
+	ec := newEncryptedConn(conn, obfs, stealth)
+	if key == nil {
 		return ec, nil
 	}
 
-	hash := sha256.Sum256([]byte(psk))
-	block, err := aes.NewCipher(hash[:])
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, fmt.Errorf("aes: %w", err)
 	}
@@ -57,9 +74,73 @@ func NewEncryptedConn(conn net.Conn, psk string, obfs *ObfsConfig, stealth ...*S
 	return ec, nil
 }
 
+// NewIntegrityConn frames and authenticates packets with key but does
+// not encrypt them (encryption: none — see integrity.go).
+func NewIntegrityConn(conn net.Conn, key []byte, obfs *ObfsConfig, stealth ...*StealthConfig) *EncryptedConn {
+	ec := newEncryptedConn(conn, obfs, stealth)
+	ec.gcm = &hmacAEAD{key: key}
+	return ec
+}
+
+func newEncryptedConn(conn net.Conn, obfs *ObfsConfig, stealth []*StealthConfig) *EncryptedConn {
+	ec := &EncryptedConn{conn: conn, obfs: obfs}
+	if len(stealth) > 0 {
+		ec.SetStealth(stealth[0])
+	}
+	return ec
+}
+
 // SetStealth enables v2.5 DPI stealth features
 func (c *EncryptedConn) SetStealth(s *StealthConfig) {
 	c.stealth = s
+	c.model = nil
+	if s != nil && (c.obfs == nil || !c.obfs.Enabled) {
+		c.model = trafficModels[s.TrafficModel]
+	}
+}
+
+// ──────────────────── Replay protection ────────────────────
+//
+// The AES key is static per PSK, so without extra binding an on-path
+// attacker could replay, reorder, drop, or reflect sealed packets —
+// within a connection or into a later one. BindSession ties every
+// packet to its position:
+//
+//   additional data = [1B direction][8B sequence][session nonce]
+//
+// The session nonce is the fresh per-connection auth nonce, the
+// direction differs per side, and each side counts its packets from 0.
+// The transport is an ordered byte stream, so the receive window is
+// exactly the next sequence number: anything replayed, reordered or
+// missing fails authentication and the connection is dropped.
+
+const (
+	dirClientToServer byte = 'C'
+	dirServerToClient byte = 'S'
+)
+
+// BindSession enables sequence/replay checking for this connection.
+// Both ends must call it with the same nonce before any traffic.
+func (c *EncryptedConn) BindSession(nonce []byte, server bool) {
+	c.session = append([]byte(nil), nonce...)
+	if server {
+		c.writeDir, c.readDir = dirServerToClient, dirClientToServer
+	} else {
+		c.writeDir, c.readDir = dirClientToServer, dirServerToClient
+	}
+}
+
+// packetAD returns the additional data for packet seq in direction dir,
+// or nil when the connection isn't bound.
+func (c *EncryptedConn) packetAD(dir byte, seq uint64) []byte {
+	if c.session == nil {
+		return nil
+	}
+	ad := make([]byte, 9+len(c.session))
+	ad[0] = dir
+	binary.BigEndian.PutUint64(ad[1:9], seq)
+	copy(ad[9:], c.session)
+	return ad
 }
 
 // ──────────────────── Write ────────────────────
@@ -68,6 +149,10 @@ func (c *EncryptedConn) Write(data []byte) (int, error) {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 
+	if c.model != nil {
+		return c.modelWrite(data)
+	}
+
 	// v2.5: Burst split — break large writes into random-sized chunks
 	// This prevents DPI from seeing consistent packet size patterns.
 	if c.stealth != nil && c.stealth.BurstSplit && len(data) > c.stealth.MaxBurstSize {
@@ -89,12 +174,27 @@ func (c *EncryptedConn) writePacket(data []byte) (int, error) {
 	}
 
 	// ② Encrypt
+	if err := c.seal(payload); err != nil {
+		return 0, err
+	}
+
+	// ③ Timing jitter only for large data (protect keepalives)
+	if c.obfs != nil && c.obfs.Enabled && c.obfs.MaxDelayMS > 0 && len(data) > 128 {
+		obfsDelay(c.obfs)
+	}
+
+	return len(data), nil
+}
+
+// seal encrypts payload and writes it as one length-prefixed record.
+func (c *EncryptedConn) seal(payload []byte) error {
 	if c.gcm != nil {
 		nonce := make([]byte, c.gcm.NonceSize())
 		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-			return 0, fmt.Errorf("nonce: %w", err)
+			return fmt.Errorf("nonce: %w", err)
 		}
-		ciphertext := c.gcm.Seal(nil, nonce, payload, nil)
+		ciphertext := c.gcm.Seal(nil, nonce, payload, c.packetAD(c.writeDir, c.writeSeq))
+		c.writeSeq++
 
 		pktLen := len(nonce) + len(ciphertext)
 		buf := make([]byte, 4+pktLen)
@@ -102,25 +202,14 @@ func (c *EncryptedConn) writePacket(data []byte) (int, error) {
 		copy(buf[4:], nonce)
 		copy(buf[4+len(nonce):], ciphertext)
 
-		if _, err := c.conn.Write(buf); err != nil {
-			return 0, err
-		}
-	} else {
-		buf := make([]byte, 4+len(payload))
-		binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
-		copy(buf[4:], payload)
-
-		if _, err := c.conn.Write(buf); err != nil {
-			return 0, err
-		}
+		_, err := c.conn.Write(buf)
+		return err
 	}
-
-	// ③ Timing jitter only for large data (protect keepalives)
-	if c.obfs != nil && c.obfs.Enabled && c.obfs.MaxDelayMS > 0 && len(data) > 128 {
-		obfsDelay(c.obfs)
-	}
-
-	return len(data), nil
+	buf := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(payload)))
+	copy(buf[4:], payload)
+	_, err := c.conn.Write(buf)
+	return err
 }
 
 // burstWrite splits a large write into random-sized chunks
@@ -187,10 +276,14 @@ func (c *EncryptedConn) Read(p []byte) (int, error) {
 			return 0, fmt.Errorf("packet too short")
 		}
 		var err error
-		plaintext, err = c.gcm.Open(nil, pkt[:ns], pkt[ns:], nil)
+		plaintext, err = c.gcm.Open(nil, pkt[:ns], pkt[ns:], c.packetAD(c.readDir, c.readSeq))
 		if err != nil {
+			if c.session != nil {
+				return 0, fmt.Errorf("decrypt packet #%d (wrong key, replayed or reordered): %w", c.readSeq, err)
+			}
 			return 0, fmt.Errorf("decrypt: %w", err)
 		}
+		c.readSeq++
 	} else {
 		plaintext = pkt
 	}
@@ -201,7 +294,7 @@ func (c *EncryptedConn) Read(p []byte) (int, error) {
 		if plaintext == nil {
 			return 0, fmt.Errorf("invalid padding")
 		}
-	} else if c.stealth != nil && c.stealth.RandomPadding {
+	} else if c.stealth != nil && (c.stealth.RandomPadding || c.model != nil) {
 		stripped := removeStealthPadding(plaintext)
 		if stripped != nil {
 			plaintext = stripped
@@ -247,7 +340,11 @@ func removePadding(data []byte) []byte {
 
 // v2.5: Stealth padding — same format as obfs padding but uses stealth config
 func addStealthPadding(data []byte, s *StealthConfig) []byte {
-	padLen := s.MinPadding + secureRandInt(s.MaxPadding-s.MinPadding+1)
+	return padFrame(data, s.MinPadding+secureRandInt(s.MaxPadding-s.MinPadding+1))
+}
+
+// padFrame frames data as [2B length][data][padLen random bytes].
+func padFrame(data []byte, padLen int) []byte {
 	out := make([]byte, 2+len(data)+padLen)
 	binary.BigEndian.PutUint16(out[:2], uint16(len(data)))
 	copy(out[2:], data)
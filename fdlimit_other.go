@@ -0,0 +1,6 @@
+//go:build !unix
+
+package httpmux
+
+// fdLimit: Windows has no per-process descriptor limit to budget.
+func fdLimit() int { return 0 }
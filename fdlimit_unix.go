@@ -0,0 +1,14 @@
+//go:build unix
+
+package httpmux
+
+import "syscall"
+
+// fdLimit is the soft RLIMIT_NOFILE, 0 if unknown or unlimited.
+func fdLimit() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil || rl.Cur > 1<<30 {
+		return 0
+	}
+	return int(rl.Cur)
+}
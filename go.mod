@@ -3,8 +3,21 @@ module github.com/amir6dev/PicoTun
 go 1.22
 
 require (
+	github.com/hashicorp/yamux v0.1.1
+	github.com/klauspost/compress v1.16.7
 	github.com/refraction-networking/utls v1.6.0
 	github.com/xtaci/smux v1.5.24
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/crypto v0.21.0
 	golang.org/x/net v0.23.0
+	golang.org/x/sys v0.18.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	github.com/andybalholm/brotli v1.0.5 // indirect
+	github.com/cloudflare/circl v1.3.6 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/quic-go/quic-go v0.37.4 // indirect
+	golang.org/x/text v0.14.0 // indirect
+)
@@ -0,0 +1,234 @@
+package httpmux
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// h2mux transport
+//
+//   transport: h2mux       # both ends
+//
+// The tunnel rides in one HTTP/2 stream instead of an upgraded
+// HTTP/1.1 connection: the client POSTs to the tunnel path with
+// content-type application/grpc and keeps the request body open, the
+// server answers 200 and keeps the response body open, and the two
+// bodies carry the usual auth → encryption → mux stack. On the wire
+// that is an ordinary long-lived gRPC call, which h2-terminating CDNs
+// and gRPC-aware proxies pass through when they forward h2 (or h2c)
+// to the origin.
+//
+// The client always uses TLS with h2 in ALPN. The server speaks h2
+// over TLS when it has a certificate and h2c (cleartext) otherwise,
+// e.g. behind a CDN or proxy. HTTP/1.1 tunnel clients still work on
+// an h2mux server as long as their ClientHello doesn't offer h2 —
+// uTLS browser fingerprints do, so run those on another server or port.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	h2ContentType      = "application/grpc"
+	h2HandshakeTimeout = 15 * time.Second
+)
+
+var grpcUserAgents = []string{"grpc-go/1.62.1", "grpc-go/1.63.2", "grpc-go/1.64.0"}
+
+// h2TLSConfig is base with h2 preferred in ALPN.
+func h2TLSConfig(base *tls.Config) *tls.Config {
+	cfg := base.Clone()
+	cfg.NextProtos = append([]string{"h2"}, base.NextProtos...)
+	return cfg
+}
+
+// h2cHandler serves h2c (prior knowledge) alongside HTTP/1.1 on a
+// plain listener.
+func h2cHandler(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, &http2.Server{})
+}
+
+// ──────────── Client ────────────
+
+// clientH2Tunnel opens the tunnel stream on a TLS conn that
+// negotiated h2. The returned conn reads the response body and
+// writes the request body.
+func clientH2Tunnel(conn net.Conn, cfg *MimicConfig, stealth *StealthConfig) (net.Conn, error) {
+	if p := negotiatedProtocol(conn); p != "h2" {
+		return nil, fmt.Errorf("server did not negotiate h2 (got %q)", p)
+	}
+	_, _, fullURL := mimicRequest(cfg, stealth)
+
+	tr := &http2.Transport{ReadIdleTimeout: 30 * time.Second, PingTimeout: 15 * time.Second}
+	cc, err := tr.NewClientConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, "https://"+strings.TrimPrefix(fullURL, "http://"), pr)
+	if err != nil {
+		cc.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", h2ContentType)
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("User-Agent", grpcUserAgents[secureRandInt(len(grpcUserAgents))])
+
+	conn.SetDeadline(time.Now().Add(h2HandshakeTimeout))
+	resp, err := cc.RoundTrip(req)
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		pw.Close()
+		cc.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), h2ContentType) {
+		resp.Body.Close()
+		pw.Close()
+		cc.Close()
+		return nil, fmt.Errorf("h2 tunnel: status %d", resp.StatusCode)
+	}
+	return &h2ClientConn{Conn: conn, body: resp.Body, pw: pw, cc: cc}, nil
+}
+
+// h2ClientConn is the client end of the tunnel stream. Addresses and
+// deadlines are the TLS conn's.
+type h2ClientConn struct {
+	net.Conn
+	body io.ReadCloser
+	pw   *io.PipeWriter
+	cc   *http2.ClientConn
+}
+
+func (c *h2ClientConn) Read(p []byte) (int, error)  { return c.body.Read(p) }
+func (c *h2ClientConn) Write(p []byte) (int, error) { return c.pw.Write(p) }
+
+func (c *h2ClientConn) Close() error {
+	c.pw.Close()
+	c.body.Close()
+	c.cc.Close()
+	return c.Conn.Close()
+}
+
+// ──────────── Server ────────────
+
+func (s *Server) handleH2Tunnel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), h2ContentType) {
+		s.rejectUpgrade(w, r, "bad_h2")
+		return
+	}
+	if !s.validHost(r.Host) {
+		s.rejectUpgrade(w, r, "bad_host")
+		return
+	}
+	hello, err := newServerHello()
+	if err != nil {
+		return
+	}
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", h2ContentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(hello.bytes()); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	conn := newHTTPStreamConn(r, rc, w, rc)
+	s.serveTunnel(conn, r.RemoteAddr, hello)
+	conn.Close() // no writes may follow the handler's return
+}
+
+// httpStreamConn is the server end of a tunnel carried in HTTP
+// bodies: reads come from a request body, writes go to a response
+// (the same exchange for h2mux, two for xhttp).
+type httpStreamConn struct {
+	body   io.ReadCloser
+	rrc    *http.ResponseController // read side
+	w      http.ResponseWriter
+	wrc    *http.ResponseController // write side
+	local  net.Addr
+	remote net.Addr
+
+	mu     sync.Mutex // serializes writes with Close
+	closed atomic.Bool
+}
+
+// newHTTPStreamConn reads r.Body (controlled by rrc) and writes to w.
+func newHTTPStreamConn(r *http.Request, rrc *http.ResponseController, w http.ResponseWriter, wrc *http.ResponseController) *httpStreamConn {
+	c := &httpStreamConn{body: r.Body, rrc: rrc, w: w, wrc: wrc, local: httpAddr(""), remote: httpAddr(r.RemoteAddr)}
+	if la, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		c.local = la
+	}
+	return c
+}
+
+var errStreamConnClosed = errors.New("http tunnel closed")
+
+func (c *httpStreamConn) Read(p []byte) (int, error) { return c.body.Read(p) }
+
+func (c *httpStreamConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed.Load() {
+		return 0, errStreamConnClosed
+	}
+	n, err := c.w.Write(p)
+	if err == nil {
+		err = c.wrc.Flush()
+	}
+	return n, err
+}
+
+// Close unblocks a pending write through the write deadline, then
+// waits for it so the handler can return safely.
+func (c *httpStreamConn) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	c.wrc.SetWriteDeadline(time.Now())
+	c.rrc.SetReadDeadline(time.Now())
+	c.body.Close()
+	c.mu.Lock()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *httpStreamConn) LocalAddr() net.Addr  { return c.local }
+func (c *httpStreamConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *httpStreamConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *httpStreamConn) SetReadDeadline(t time.Time) error {
+	if c.closed.Load() {
+		return errStreamConnClosed
+	}
+	return c.rrc.SetReadDeadline(t)
+}
+
+func (c *httpStreamConn) SetWriteDeadline(t time.Time) error {
+	if c.closed.Load() {
+		return errStreamConnClosed
+	}
+	return c.wrc.SetWriteDeadline(t)
+}
+
+// httpAddr is a peer address as net/http reports it.
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
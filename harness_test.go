@@ -0,0 +1,363 @@
+package httpmux
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Test harness: a server and a client in one process
+//
+//   tun := startTunnel(t, tunnelOpts{
+//       transport: "httpmux",
+//       server:    "maps:\n  - {type: tcp, bind: \"" + bind + "\", target: \"" + echo + "\"}\n",
+//   })
+//   conn, err := tun.client.Dial("tcp", echo)
+//
+// Both ends run on loopback listeners with the real transports, mux
+// and encryption, so a test exercises exactly what goes on the wire.
+// The pair is shut down when the test ends.
+// ═══════════════════════════════════════════════════════════════
+
+const testPSK = "picotun-test-psk"
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(io.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+type tunnelOpts struct {
+	transport string // default httpmux
+	tls       bool   // serve a self-signed certificate (TLS transports)
+	server    string // extra server YAML
+	advanced  string // extra server advanced: keys, one per line
+	client    string // extra client YAML (top level)
+	stealth   string // extra flow keys on the client's stealth:, ", burst_split: true"
+	path      string // extra flow keys on the client's path, ", encryption: none"
+}
+
+type testTunnel struct {
+	server *Server
+	client *Client
+	addr   string // the server's tunnel listener
+}
+
+// startTunnel runs a server and a client connected to it and waits
+// until the client has a session.
+func startTunnel(t *testing.T, o tunnelOpts) *testTunnel {
+	t.Helper()
+	if o.transport == "" {
+		o.transport = "httpmux"
+	}
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sy := fmt.Sprintf("mode: server\nlisten: %q\ntransport: %s\npsk: %s\nadvanced:\n  drain_timeout: 1\n",
+		addr, o.transport, testPSK)
+	if o.advanced != "" {
+		sy += indent(o.advanced, "  ")
+	}
+	if o.tls {
+		cert, key := selfSignedCert(t)
+		sy += fmt.Sprintf("cert_file: %q\nkey_file: %q\n", cert, key)
+	}
+	srv := NewServer(testConfig(t, sy+o.server))
+	srvDone := make(chan struct{})
+	go func() {
+		defer close(srvDone)
+		srv.Start(ctx)
+	}()
+	waitListening(t, addr)
+
+	cy := fmt.Sprintf("mode: client\ntransport: %s\npsk: %s\nadvanced:\n  drain_timeout: 1\n"+
+		"stealth: {conn_jitter_ms: 1%s}\npaths:\n  - {transport: %s, addr: %q, connection_pool: 1%s}\n",
+		o.transport, testPSK, o.stealth, o.transport, addr, o.path)
+	cl := NewClient(testConfig(t, cy+o.client))
+	clDone := make(chan struct{})
+	go func() {
+		defer close(clDone)
+		cl.Start(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		for _, done := range []chan struct{}{clDone, srvDone} {
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Errorf("tunnel did not shut down")
+			}
+		}
+	})
+	if err := cl.WaitReady(10 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return &testTunnel{server: srv, client: cl, addr: addr}
+}
+
+func testConfig(t *testing.T, y string) *Config {
+	t.Helper()
+	cfg, err := ParseConfig([]byte(y))
+	if err != nil {
+		t.Fatalf("config: %v\n%s", err, y)
+	}
+	return cfg
+}
+
+// freeAddr returns a loopback address nothing listens on right now.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// freeUDPAddr is freeAddr for UDP.
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
+
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("nothing listening on %s", addr)
+}
+
+// ──────────── Targets ────────────
+
+// tcpEcho serves an echo backend for the test's lifetime.
+func tcpEcho(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// udpEcho sends every datagram back to its sender.
+func udpEcho(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// ──────────── Checks ────────────
+
+// checkEcho writes payload on c and expects it back unchanged.
+func checkEcho(t *testing.T, c net.Conn, payload []byte) {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	defer c.SetDeadline(time.Time{})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Write(payload)
+		errc <- err
+	}()
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatalf("read %d bytes: %v", len(payload), err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Fatalf("echo mismatch after %d bytes", len(payload))
+	}
+}
+
+// checkDatagrams sends a few datagrams on c and expects each one back
+// whole. UDP may drop, so each is retried a few times.
+func checkDatagrams(t *testing.T, c net.Conn) {
+	t.Helper()
+	buf := make([]byte, 65535)
+	for _, size := range []int{1, 512, 1400} {
+		want := randomBytes(t, size)
+		ok := false
+		for try := 0; try < 5 && !ok; try++ {
+			if _, err := c.Write(want); err != nil {
+				t.Fatalf("write datagram: %v", err)
+			}
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, err := c.Read(buf)
+			if err != nil {
+				continue
+			}
+			if string(buf[:n]) != string(want) {
+				t.Fatalf("datagram of %d bytes came back as %d bytes", size, n)
+			}
+			ok = true
+		}
+		if !ok {
+			t.Fatalf("no reply to a %d-byte datagram", size)
+		}
+	}
+	c.SetReadDeadline(time.Time{})
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// mapYAML is one maps: entry.
+func mapYAML(typ, bind, target string) string {
+	return fmt.Sprintf("maps:\n  - {type: %s, bind: %q, target: %q}\n", typ, bind, target)
+}
+
+// dialMap connects to a server map, retrying while its listener opens.
+func dialMap(t *testing.T, network, addr string) net.Conn {
+	t.Helper()
+	var err error
+	for i := 0; i < 50; i++ {
+		var c net.Conn
+		if c, err = net.Dial(network, addr); err == nil {
+			t.Cleanup(func() { c.Close() })
+			return c
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("dial map %s://%s: %v", network, addr, err)
+	return nil
+}
+
+// selfSignedCert writes a certificate for 127.0.0.1 and returns the
+// cert and key file paths.
+func selfSignedCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "picotun-test"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	return certFile, keyFile
+}
+
+// indent prefixes every line of y, for nesting YAML snippets.
+func indent(y, prefix string) string {
+	lines := strings.Split(strings.TrimRight(y, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
+
+// socksConnect does a no-auth SOCKS5 CONNECT to target through proxy.
+func socksConnect(t *testing.T, proxy, target string) (net.Conn, error) {
+	t.Helper()
+	c, err := net.DialTimeout("tcp", proxy, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	req := appendSocksAddr([]byte{socksVersion, 1, 0, socksVersion, socksCmdConnect, 0}, target)
+	if _, err := c.Write(req); err != nil {
+		c.Close()
+		return nil, err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(c, resp[:]); err != nil || resp[1] != 0 {
+		c.Close()
+		return nil, fmt.Errorf("method selection: %v %v", resp, err)
+	}
+	var rep [3]byte
+	if _, err := io.ReadFull(c, rep[:]); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if rep[1] != socksRepSuccess {
+		c.Close()
+		return nil, fmt.Errorf("socks reply %d", rep[1])
+	}
+	if _, err := readSocksAddr(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
@@ -0,0 +1,107 @@
+package httpmux
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Heartbeat and dead-session cutover
+//
+//   heartbeat: 2           # seconds between heartbeats, -1 = off
+//   heartbeat_misses: 3    # missed in a row before the session is closed
+//
+// smux keepalive only notices a dead connection after its timeout
+// (15 keepalives, at least 30s); until then streams keep being opened
+// on it and hang. The heartbeat is a ping stream (ping.go) every
+// `heartbeat` seconds; a reply that doesn't come within twice that
+// (2s-10s) is a miss. After heartbeat_misses in a row the session is
+// closed: it leaves the pool, new streams go to the other sessions and
+// the pool worker reconnects. Replies feed the session's smoothed RTT.
+//
+// Both ends run it. The server only heartbeats a session once its
+// client has pinged it, like the RTT probe. A draining end refuses new
+// streams but still answers heartbeats.
+// ═══════════════════════════════════════════════════════════════
+
+func applyHeartbeatDefaults(c *Config) {
+	if c.Heartbeat == 0 {
+		c.Heartbeat = 2
+	}
+	if c.HeartbeatMisses <= 0 {
+		c.HeartbeatMisses = 3
+	}
+}
+
+// heartbeatTimeout is how long one heartbeat waits for its reply.
+func heartbeatTimeout(every time.Duration) time.Duration {
+	return min(max(2*every, 2*time.Second), 10*time.Second)
+}
+
+// runHeartbeat pings sess every cfg.Heartbeat seconds until it closes or
+// shutdown begins, closing it after cfg.HeartbeatMisses misses in a
+// row. skip, when it returns true, postpones a beat; dead is called
+// before the session is closed.
+func runHeartbeat(cfg *Config, life *lifecycle, sess muxSession, rtt *int64, skip func() bool, dead func(missed int)) {
+	if cfg.Heartbeat <= 0 {
+		return
+	}
+	every := time.Duration(cfg.Heartbeat) * time.Second
+	timeout := heartbeatTimeout(every)
+	missed := 0
+	for life.sleep(every) && !sess.IsClosed() {
+		if skip != nil && skip() {
+			continue
+		}
+		d, err := pingRTT(sess, timeout)
+		if err == nil {
+			missed = 0
+			smoothRTT(rtt, d)
+			continue
+		}
+		if sess.IsClosed() || life.isClosing() {
+			return
+		}
+		if missed++; missed >= cfg.HeartbeatMisses {
+			dead(missed)
+			sess.Close()
+			return
+		}
+	}
+}
+
+// refuseStream closes a stream that arrived while draining, answering
+// it first if it is a ping: the session is still alive and the peer's
+// heartbeat must not cut it while its relays finish.
+func refuseStream(stream net.Conn) {
+	defer stream.Close()
+	stream.SetReadDeadline(time.Now().Add(time.Second))
+	var t [1]byte
+	if _, err := io.ReadFull(stream, t[:]); err == nil && t[0] == StreamTypePing {
+		servePing(stream)
+	}
+}
+
+// ──────────── Client ────────────
+
+func (c *Client) heartbeat(cs *clientSession) {
+	runHeartbeat(c.cfg, c.life, cs.sess, &cs.rtt, nil, func(missed int) {
+		c.stats.incError("heartbeat")
+		log.Printf("[HEARTBEAT] path %d: %d heartbeats missed, closing session", cs.path, missed)
+		c.removeSession(cs.sess)
+	})
+}
+
+// ──────────── Server ────────────
+
+func (s *Server) heartbeat(ss *serverSession) {
+	skip := func() bool { return atomic.LoadInt32(&ss.pings) == 0 }
+	runHeartbeat(s.Config, s.life, ss.sess, &ss.rtt, skip, func(missed int) {
+		s.stats.incError("heartbeat")
+		log.Printf("[HEARTBEAT] %s%s: %d heartbeats missed, closing session", ss.remote, ss.userTag(), missed)
+		s.removeSession(ss)
+	})
+}
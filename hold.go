@@ -0,0 +1,98 @@
+package httpmux
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Holding visitors while no session is up (server, TCP maps)
+//
+//   maps:
+//     - { type: tcp, bind: "443", target: "127.0.0.1:443", hold_timeout: 15 }
+//   advanced:
+//     hold_queue: 256      # visitors held at once, all maps
+//
+// Without a client session a visitor is dropped at once. A client
+// reconnect (network blip, restart, server_url rotation) usually takes
+// a few seconds, so with hold_timeout the accepted connection is kept
+// open instead and served by the first session that comes up. Past the
+// timeout it goes to fallback_target, if any, or is dropped as before.
+// When hold_queue visitors are already waiting, new ones aren't held.
+// ═══════════════════════════════════════════════════════════════
+
+func applyHoldDefaults(a *AdvancedConfig) {
+	if a.HoldQueue <= 0 {
+		a.HoldQueue = 256
+	}
+}
+
+// sessionSignal returns a channel closed by the next signalSession.
+func (s *Server) sessionSignal() <-chan struct{} {
+	s.poolMu.RLock()
+	defer s.poolMu.RUnlock()
+	return s.sessionUp
+}
+
+// signalSession wakes held visitors: a session came up or announced
+// its tag.
+func (s *Server) signalSession() {
+	s.poolMu.Lock()
+	close(s.sessionUp)
+	s.sessionUp = make(chan struct{})
+	s.poolMu.Unlock()
+}
+
+// holdForSession retries openReverseStream each time a session comes
+// up, until one works or wait passes.
+func (s *Server) holdForSession(target, tag, bind string, wait time.Duration) (net.Conn, *serverSession, error) {
+	if atomic.AddInt64(&s.held, 1) > int64(s.Config.Advanced.HoldQueue) {
+		atomic.AddInt64(&s.held, -1)
+		s.stats.incError("hold_full")
+		return nil, nil, fmt.Errorf("hold queue full")
+	}
+	defer atomic.AddInt64(&s.held, -1)
+	if s.Verbose {
+		logDedupf("hold"+bind, "[HOLD] %s: no session, holding visitors up to %v", bind, wait)
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		// Take the signal before trying so a session added in between
+		// isn't missed.
+		up := s.sessionSignal()
+		stream, ss, err := s.openReverseStream(target, tag)
+		if err == nil {
+			return stream, ss, nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			s.stats.incError("hold_timeout")
+			return nil, nil, err
+		}
+		t := time.NewTimer(left)
+		select {
+		case <-up:
+		case <-t.C:
+		case <-s.life.done:
+			t.Stop()
+			return nil, nil, err
+		}
+		t.Stop()
+	}
+}
+
+func logHold(cfg *Config) {
+	n := 0
+	for _, m := range cfg.Maps {
+		if m.HoldTimeout > 0 {
+			n++
+		}
+	}
+	if n > 0 {
+		log.Printf("[HOLD] %d map(s) hold visitors while no session is up (queue %d)", n, cfg.Advanced.HoldQueue)
+	}
+}
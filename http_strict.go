@@ -0,0 +1,178 @@
+package httpmux
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Strict HTTP head parsing for the mimic handshake
+//
+// The upgrade handshake is the only plaintext HTTP the tunnel speaks,
+// and whatever the parser consumes must end exactly where the tunnel
+// payload begins. net/http is lenient (bare LF, folded headers,
+// unbounded response heads), so handshake reads go through
+// readHeadBlock first: it consumes exactly one header block under
+// hard limits and leaves everything after it in the bufio.Reader,
+// where bufferedConn hands it to EncryptedConn untouched.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	maxHandshakeHeadBytes = 16 << 10 // whole request/response head
+	maxHandshakeHeaders   = 64
+)
+
+var (
+	errHeadTooLarge     = errors.New("http head too large")
+	errTooManyHeaders   = errors.New("too many header lines")
+	errBareLF           = errors.New("header line not terminated by CRLF")
+	errBadHeaderByte    = errors.New("invalid byte in header line")
+	errFoldedHeader     = errors.New("obsolete header line folding")
+	errEmptyStartLine   = errors.New("empty start line")
+	errDuplicateLength  = errors.New("duplicate Content-Length")
+	errAmbiguousFraming = errors.New("both Content-Length and Transfer-Encoding present")
+	errUnexpectedBody   = errors.New("handshake message carries a body")
+	errPipelinedRequest = errors.New("pipelined HTTP request after upgrade")
+)
+
+// readHeadBlock reads one HTTP head (start line + headers + blank line)
+// from br and returns it verbatim. Bytes after the blank line are left
+// unread in br.
+func readHeadBlock(br *bufio.Reader) ([]byte, error) {
+	var head []byte
+	lineStart := 0
+	lines := 0
+	for {
+		frag, err := br.ReadSlice('\n')
+		head = append(head, frag...)
+		if len(head) > maxHandshakeHeadBytes {
+			return nil, errHeadTooLarge
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line := head[lineStart:]
+		if !bytes.HasSuffix(line, []byte("\r\n")) {
+			return nil, errBareLF
+		}
+		content := line[:len(line)-2]
+		if bytes.IndexByte(content, '\r') >= 0 || bytes.IndexByte(content, 0) >= 0 {
+			return nil, errBadHeaderByte
+		}
+		if len(content) == 0 {
+			if lines == 0 {
+				return nil, errEmptyStartLine
+			}
+			return head, nil
+		}
+		if lines > 0 && (content[0] == ' ' || content[0] == '\t') {
+			return nil, errFoldedHeader
+		}
+		lines++
+		if lines > maxHandshakeHeaders+1 {
+			return nil, errTooManyHeaders
+		}
+		lineStart = len(head)
+	}
+}
+
+// readStrictRequest parses a handshake request. The upgrade request must
+// not carry a body: any framing header is treated as a smuggling attempt.
+func readStrictRequest(br *bufio.Reader) (*http.Request, error) {
+	head, err := readHeadBlock(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkNoBody(rawHeader(head)); err != nil {
+		return nil, err
+	}
+	return http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+}
+
+// readStrictResponse parses a handshake response for req. Accepted
+// responses (101/200) must not declare a body, since every byte after
+// the head belongs to the tunnel.
+func readStrictResponse(br *bufio.Reader, req *http.Request) (*http.Response, error) {
+	head, err := readHeadBlock(br)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols || resp.StatusCode == http.StatusOK {
+		if err := checkNoBody(rawHeader(head)); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// rawHeader is head's header fields as sent. net/http moves
+// Transfer-Encoding out of the header and folds repeated identical
+// Content-Lengths into one, hiding exactly what checkFraming looks for.
+func rawHeader(head []byte) http.Header {
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(head)))
+	tp.ReadLine()
+	h, _ := tp.ReadMIMEHeader()
+	return http.Header(h)
+}
+
+// checkFraming rejects the classic CL/TE desync inputs.
+func checkFraming(h http.Header) error {
+	if len(h.Values("Content-Length")) > 1 {
+		return errDuplicateLength
+	}
+	if len(h.Values("Transfer-Encoding")) > 0 && len(h.Values("Content-Length")) > 0 {
+		return errAmbiguousFraming
+	}
+	return nil
+}
+
+func checkNoBody(h http.Header) error {
+	if err := checkFraming(h); err != nil {
+		return err
+	}
+	if len(h.Values("Transfer-Encoding")) > 0 {
+		return errUnexpectedBody
+	}
+	if cl := strings.TrimSpace(h.Get("Content-Length")); cl != "" && cl != "0" {
+		return errUnexpectedBody
+	}
+	return nil
+}
+
+// looksLikeHTTPRequest reports whether b starts with an HTTP request line.
+// Used to tell a pipelined second request apart from tunnel payload.
+func looksLikeHTTPRequest(b []byte) bool {
+	for _, m := range []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "} {
+		if bytes.HasPrefix(b, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// drainPipelined checks bytes the HTTP server buffered past the upgrade
+// request. Tunnel payload is kept; a second HTTP request is refused.
+func drainPipelined(br *bufio.Reader) error {
+	n := br.Buffered()
+	if n == 0 {
+		return nil
+	}
+	peek, _ := br.Peek(n)
+	if looksLikeHTTPRequest(peek) {
+		return fmt.Errorf("%w (%d bytes)", errPipelinedRequest, n)
+	}
+	return nil
+}
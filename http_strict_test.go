@@ -0,0 +1,117 @@
+package httpmux
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+const strictUpgrade = "GET /search HTTP/1.1\r\n" +
+	"Host: example.com\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Version: 13\r\n"
+
+// The request side of the handshake against smuggling-style heads.
+func TestStrictRequest(t *testing.T) {
+	manyHeaders := strings.Repeat("X-A: b\r\n", maxHandshakeHeaders+1)
+	bigHeader := "X-Big: " + strings.Repeat("a", maxHandshakeHeadBytes) + "\r\n"
+	cases := []struct {
+		name string
+		raw  string
+		want error // nil = accepted
+	}{
+		{"valid upgrade", strictUpgrade + "\r\n", nil},
+		{"valid upgrade, tunnel bytes follow", strictUpgrade + "\r\n\x00\x01tunnel", nil},
+		{"bare LF", "GET /search HTTP/1.1\nHost: example.com\n\n", errBareLF},
+		{"bare LF in a header", strictUpgrade + "X-A: b\n\r\n", errBareLF},
+		{"CR inside a line", strictUpgrade + "X-A: b\rc\r\n\r\n", errBadHeaderByte},
+		{"obs-fold", strictUpgrade + "X-A: b\r\n c\r\n\r\n", errFoldedHeader},
+		{"obs-fold, tab", strictUpgrade + "X-A: b\r\n\tc\r\n\r\n", errFoldedHeader},
+		{"empty start line", "\r\nGET / HTTP/1.1\r\n\r\n", errEmptyStartLine},
+		{"duplicate Content-Length", strictUpgrade + "Content-Length: 0\r\nContent-Length: 0\r\n\r\n", errDuplicateLength},
+		{"conflicting Content-Length", strictUpgrade + "Content-Length: 0\r\nContent-Length: 5\r\n\r\n", errDuplicateLength},
+		{"CL and TE", strictUpgrade + "Content-Length: 0\r\nTransfer-Encoding: chunked\r\n\r\n", errAmbiguousFraming},
+		{"TE on the upgrade", strictUpgrade + "Transfer-Encoding: chunked\r\n\r\n", errUnexpectedBody},
+		{"TE, odd case", strictUpgrade + "transfer-encoding: chunked\r\n\r\n", errUnexpectedBody},
+		{"Content-Length on the upgrade", strictUpgrade + "Content-Length: 5\r\n\r\nhello", errUnexpectedBody},
+		{"Content-Length: 0", strictUpgrade + "Content-Length: 0\r\n\r\n", nil},
+		{"pipelined GET", strictUpgrade + "\r\nGET /admin HTTP/1.1\r\nHost: x\r\n\r\n", errPipelinedRequest},
+		{"pipelined POST", strictUpgrade + "\r\nPOST / HTTP/1.1\r\n\r\n", errPipelinedRequest},
+		{"head over 16KB", strictUpgrade + bigHeader + "\r\n", errHeadTooLarge},
+		{"too many headers", strictUpgrade + manyHeaders + "\r\n", errTooManyHeaders},
+		{"no blank line", strictUpgrade, io.EOF},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			br := bufio.NewReaderSize(strings.NewReader(c.raw), 64<<10)
+			req, err := readStrictRequest(br)
+			if err == nil {
+				err = drainPipelined(br)
+			}
+			if !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+			if c.want == nil && req.URL.Path != "/search" {
+				t.Fatalf("path %q", req.URL.Path)
+			}
+		})
+	}
+}
+
+func TestStrictResponse(t *testing.T) {
+	const head = "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"
+	cases := []struct {
+		name string
+		raw  string
+		want error
+	}{
+		{"101", head + "\r\n", nil},
+		{"bare LF", "HTTP/1.1 101 Switching Protocols\nUpgrade: websocket\n\n", errBareLF},
+		{"obs-fold", head + "X-A: b\r\n c\r\n\r\n", errFoldedHeader},
+		{"101 with TE", head + "Transfer-Encoding: chunked\r\n\r\n", errUnexpectedBody},
+		{"101 with a body", head + "Content-Length: 3\r\n\r\nabc", errUnexpectedBody},
+		{"101 with CL and TE", head + "Content-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n", errAmbiguousFraming},
+		{"404 may carry a body", "HTTP/1.1 404 Not Found\r\nContent-Length: 3\r\n\r\nabc", nil},
+		{"head over 16KB", head + "X-Big: " + strings.Repeat("a", maxHandshakeHeadBytes) + "\r\n\r\n", errHeadTooLarge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(c.raw))
+			if _, err := readStrictResponse(br, nil); !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+// Bytes the client sends right behind its upgrade request are tunnel
+// payload and must come out of the handshake's conn unchanged.
+func TestStrictHandshakeKeepsTunnelBytes(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	payload := "\x17\x03\x03tunnel payload, not HTTP"
+	go func() {
+		a.Write([]byte(strictUpgrade + "\r\n" + payload))
+		io.Copy(io.Discard, a)
+	}()
+
+	conn, err := ServerHandshake(b, &MimicConfig{FakePath: "/search"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != payload {
+		t.Fatalf("tunnel bytes %q, want %q", got, payload)
+	}
+}
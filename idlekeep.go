@@ -0,0 +1,135 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// idle_keep — keep-open frames for long-lived idle streams
+//
+//   maps:
+//     - { type: tcp, bind: "993", target: "127.0.0.1:993", idle_keep: true }
+//
+// IMAP IDLE, IRC and similar protocols sit silent for many minutes.
+// For idle_keep maps the stream between server and client carries
+// [2B len][data] frames, and each end sends an empty frame when it
+// hasn't written for idleKeepInterval. The visitor and backend never
+// see these frames; they only keep middleboxes and idle reapers from
+// treating the stream as dead. Both ends must run a version that
+// knows the "+keep" target scheme.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	idleKeepInterval = 25 * time.Second
+	maxKeepFrame     = 0xFFFF
+)
+
+// keepTarget marks a stream target ("tcp://x") as idle_keep framed.
+func keepTarget(target string) string {
+	return addTargetFlag(target, "keep")
+}
+
+// splitKeepTarget strips the idle_keep marker from a stream target.
+func splitKeepTarget(target string) (string, bool) {
+	rest, v, ok := takeTargetFlag(target, "keep")
+	if !ok || v != "" {
+		return target, false
+	}
+	return rest, true
+}
+
+type keepConn struct {
+	io.ReadWriteCloser
+
+	wmu       sync.Mutex
+	lastWrite int64 // atomic: unix nanos
+	remaining int   // bytes left in the current inbound frame (reader only)
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newKeepConn(rw io.ReadWriteCloser) *keepConn {
+	k := &keepConn{
+		ReadWriteCloser: rw,
+		lastWrite:       time.Now().UnixNano(),
+		done:            make(chan struct{}),
+	}
+	go k.keepLoop()
+	return k
+}
+
+func (k *keepConn) keepLoop() {
+	ticker := time.NewTicker(idleKeepInterval / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-k.done:
+			return
+		}
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&k.lastWrite)))
+		if idle < idleKeepInterval {
+			continue
+		}
+		if _, err := k.writeFrame(nil); err != nil {
+			return
+		}
+	}
+}
+
+func (k *keepConn) writeFrame(p []byte) (int, error) {
+	buf := make([]byte, 2+len(p))
+	binary.BigEndian.PutUint16(buf, uint16(len(p)))
+	copy(buf[2:], p)
+
+	k.wmu.Lock()
+	defer k.wmu.Unlock()
+	atomic.StoreInt64(&k.lastWrite, time.Now().UnixNano())
+	if _, err := k.ReadWriteCloser.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (k *keepConn) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxKeepFrame {
+			chunk = chunk[:maxKeepFrame]
+		}
+		n, err := k.writeFrame(chunk)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		p = p[len(chunk):]
+	}
+	return total, nil
+}
+
+func (k *keepConn) Read(p []byte) (int, error) {
+	for k.remaining == 0 {
+		var hdr [2]byte
+		if _, err := io.ReadFull(k.ReadWriteCloser, hdr[:]); err != nil {
+			return 0, err
+		}
+		k.remaining = int(binary.BigEndian.Uint16(hdr[:])) // 0 = keep frame
+	}
+	if len(p) > k.remaining {
+		p = p[:k.remaining]
+	}
+	n, err := k.ReadWriteCloser.Read(p)
+	k.remaining -= n
+	return n, err
+}
+
+func (k *keepConn) Close() error {
+	k.closeOnce.Do(func() { close(k.done) })
+	return k.ReadWriteCloser.Close()
+}
@@ -0,0 +1,91 @@
+package httpmux
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"strings"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Integrity-only mode (encryption: none)
+//
+// With httpsmux the tunnel already runs inside TLS, so AES-GCM on top
+// only burns CPU. A path with `encryption: none` keeps EncryptedConn's
+// framing, padding and replay binding but seals packets with an
+// HMAC-SHA256 tag instead of encrypting them:
+//
+//   [4B len][payload][32B HMAC(key, AD || payload)]
+//
+// The mode is bound into the auth proof (no marker on the wire) and
+// the server only accepts it with advanced.allow_integrity_only.
+// Clients refuse it on transports without TLS.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	encryptionAES  = "aes"
+	encryptionNone = "none"
+)
+
+// Mode byte mixed into the auth proof.
+const (
+	encModeAES  byte = 0
+	encModeNone byte = 1
+)
+
+// serverEncModes lists the modes a server accepts.
+func serverEncModes(cfg *Config) []byte {
+	if cfg.Advanced.AllowIntegrityOnly {
+		return []byte{encModeAES, encModeNone}
+	}
+	return []byte{encModeAES}
+}
+
+// pathEncryptionMode resolves a path's encryption setting for transport.
+func pathEncryptionMode(encryption, transport string) (byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encryption)) {
+	case "", encryptionAES:
+		return encModeAES, nil
+	case encryptionNone:
+		if !TransportUsesTLS(transport) {
+			return encModeAES, errors.New("encryption: none needs a TLS transport (httpsmux/wssmux/h2mux/xhttpsmux)")
+		}
+		return encModeNone, nil
+	default:
+		return encModeAES, errors.New("unknown encryption " + encryption)
+	}
+}
+
+// hmacAEAD satisfies cipher.AEAD with authentication only: Seal appends
+// a tag and leaves the plaintext readable.
+type hmacAEAD struct {
+	key []byte
+}
+
+var errIntegrity = errors.New("integrity: tag mismatch")
+
+func (h *hmacAEAD) NonceSize() int { return 0 }
+func (h *hmacAEAD) Overhead() int  { return sha256.Size }
+
+func (h *hmacAEAD) tag(plaintext, ad []byte) []byte {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write(ad)
+	mac.Write(plaintext)
+	return mac.Sum(nil)
+}
+
+func (h *hmacAEAD) Seal(dst, nonce, plaintext, ad []byte) []byte {
+	dst = append(dst, plaintext...)
+	return append(dst, h.tag(plaintext, ad)...)
+}
+
+func (h *hmacAEAD) Open(dst, nonce, ciphertext, ad []byte) ([]byte, error) {
+	if len(ciphertext) < sha256.Size {
+		return nil, errIntegrity
+	}
+	body, tag := ciphertext[:len(ciphertext)-sha256.Size], ciphertext[len(ciphertext)-sha256.Size:]
+	if !hmac.Equal(tag, h.tag(body, ad)) {
+		return nil, errIntegrity
+	}
+	return append(dst, body...), nil
+}
@@ -0,0 +1,84 @@
+package httpmux
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Compatibility with older peers and configs
+//
+// There is one reverse path: the server opens a mux stream per visitor,
+// tags it StreamTypeReverse, sends the target and the client dials it
+// (proxyReverseStream). What older versions did differently is adapted
+// to that path here instead of being served by code of its own:
+//
+//   • v2.4 servers send no type byte; the stream starts straight with
+//     the [2B len][target] header. The client puts the byte it read as
+//     a type back in front and hands the stream to proxyReverseStream,
+//     so services:, real_ip and the stats apply to it like to any other.
+//     It is only done on sessions whose server didn't negotiate
+//     (proto.go), and logged once per server so it can be upgraded.
+//
+//   • forward: {tcp: ["bind->target"], udp: [...]} is the old map
+//     syntax. It still works — maps: are converted to it internally —
+//     but takes no per-map options, and when both are set maps: is
+//     ignored. The server prints the equivalent maps: at startup.
+// ═══════════════════════════════════════════════════════════════
+
+// legacyServers remembers which v2.4 servers we already warned about.
+var legacyServers sync.Map
+
+// handleLegacyStream serves a stream from a v2.4 server. firstByte was
+// read as a type tag but is the high byte of the target length.
+func (c *Client) handleLegacyStream(cs *clientSession, stream net.Conn, firstByte []byte) {
+	if _, seen := legacyServers.LoadOrStore(stream.RemoteAddr().String(), true); !seen {
+		log.Printf("[COMPAT] server %s sends untagged (v2.4) reverse streams; they are handled, but upgrade the server", stream.RemoteAddr())
+	}
+	shim := &prefixConn{Conn: stream, r: io.MultiReader(bytes.NewReader(firstByte), stream)}
+	c.proxyReverseStream(cs, shim)
+}
+
+// logLegacyForward prints maps: entries equivalent to a config's
+// forward: section.
+func logLegacyForward(cfg *Config) {
+	if len(cfg.Forward.TCP)+len(cfg.Forward.UDP) == 0 || convertedFromMaps(cfg) {
+		return
+	}
+	if len(cfg.Maps) > 0 {
+		log.Printf("[COMPAT] forward: is set, so maps: is ignored — keep one of them")
+		return
+	}
+	log.Printf("[COMPAT] forward: is the old map syntax and takes no per-map options; the same as maps:")
+	log.Printf("[COMPAT]   maps:")
+	for _, rules := range []struct {
+		typ     string
+		entries []string
+	}{{"tcp", cfg.Forward.TCP}, {"udp", cfg.Forward.UDP}} {
+		for _, e := range rules.entries {
+			if bind, target, ok := SplitMap(e); ok {
+				typ := rules.typ
+				if target == echoTarget {
+					typ, target = "echo", ""
+				}
+				log.Printf("[COMPAT]     - { type: %s, bind: %q, target: %q }", typ, bind, target)
+			}
+		}
+	}
+}
+
+// convertedFromMaps reports whether forward: holds exactly what
+// convertMapsToForward made of maps:.
+func convertedFromMaps(cfg *Config) bool {
+	if len(cfg.Maps) == 0 {
+		return false
+	}
+	probe := &Config{Maps: cfg.Maps}
+	convertMapsToForward(probe)
+	return strings.Join(probe.Forward.TCP, "\n") == strings.Join(cfg.Forward.TCP, "\n") &&
+		strings.Join(probe.Forward.UDP, "\n") == strings.Join(cfg.Forward.UDP, "\n")
+}
@@ -0,0 +1,108 @@
+package httpmux
+
+import "time"
+
+// ═══════════════════════════════════════════════════════════════
+// Connection limits (server)
+//
+//   advanced:
+//     max_connections: 500        # relayed at once, all maps + forward streams
+//     max_connections_wait: 0     # seconds to wait for a free slot, 0 = reject
+//   maps:
+//     - { type: tcp, bind: "80", target: "127.0.0.1:80", max_connections: 100 }
+//
+// Past a limit new visitors either get a TCP reset right away or wait
+// up to max_connections_wait for a slot, so a burst queues briefly
+// instead of failing. Rejections are counted as errors["conn_limit"]
+// (server-wide) and errors["map_conn_limit"] (per map).
+// ═══════════════════════════════════════════════════════════════
+
+// connLimiter is a counting semaphore; nil means unlimited.
+type connLimiter struct {
+	slots chan struct{}
+}
+
+func newConnLimiter(n int) *connLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &connLimiter{slots: make(chan struct{}, n)}
+}
+
+// acquire takes a slot, waiting up to wait; false if none came free
+// or done closed first.
+func (l *connLimiter) acquire(wait time.Duration, done <-chan struct{}) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if wait <= 0 {
+		return false
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-t.C:
+	case <-done:
+	}
+	return false
+}
+
+func (l *connLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+func (s *Server) connWait() time.Duration {
+	return time.Duration(s.Config.Advanced.MaxConnectionsWait) * time.Second
+}
+
+// admitConn takes a socket from fd_budget (streamlimit.go), the
+// server-wide slot and, for map visitors, the map's slot. The returned
+// func releases them; nil means rejected, for the reason in code.
+func (s *Server) admitConn(network, bind string) (func(), byte) {
+	if !s.fds.acquire(0, nil) {
+		s.stats.incError("fd_budget")
+		logDedupf("fd_budget", "[LIMIT] fd_budget=%d sockets in use, refusing", s.Config.Advanced.FDBudget)
+		return nil, streamNoSockets
+	}
+	if !s.conns.acquire(s.connWait(), s.life.done) {
+		s.fds.release()
+		s.stats.incError("conn_limit")
+		logDedupf("conn_limit", "[LIMIT] max_connections=%d reached, refusing", s.Config.Advanced.MaxConnections)
+		return nil, streamConnLimit
+	}
+	var ml *connLimiter
+	if bind != "" {
+		ml = s.mapLimiter(network, bind)
+	}
+	if !ml.acquire(s.connWait(), s.life.done) {
+		s.conns.release()
+		s.fds.release()
+		s.stats.incError("map_conn_limit")
+		logDedupf("conn_limit"+bind, "[LIMIT] %s: map max_connections=%d reached, refusing", bind, cap(ml.slots))
+		return nil, streamConnLimit
+	}
+	return func() {
+		ml.release()
+		s.conns.release()
+		s.fds.release()
+	}, streamOK
+}
+
+// mapLimiter returns the running map's limiter (nil = unlimited).
+func (s *Server) mapLimiter(network, bind string) *connLimiter {
+	s.mapsMu.Lock()
+	defer s.mapsMu.Unlock()
+	if am, ok := s.maps[network+":"+bind]; ok {
+		return am.limit
+	}
+	return nil
+}
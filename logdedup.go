@@ -0,0 +1,100 @@
+package httpmux
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Log deduplication
+//
+// During an outage the same dial/auth error repeats thousands of
+// times a minute. logDedupf prints the first occurrence of a
+// message+key, counts the repeats silently, and when the window
+// closes emits one "(repeated N times in last 1m0s)" summary.
+// ═══════════════════════════════════════════════════════════════
+
+const logDedupWindow = time.Minute
+
+type logDeduper struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	entries map[uint64]*dedupEntry
+	started bool
+}
+
+type dedupEntry struct {
+	msg        string // last suppressed message
+	first      time.Time
+	suppressed int
+}
+
+var dedupLog = &logDeduper{
+	window:  logDedupWindow,
+	entries: make(map[uint64]*dedupEntry),
+}
+
+// logDedupf logs format/args unless the same key (or, for key "",
+// the same formatted message) was already logged in the last window.
+func logDedupf(key, format string, args ...interface{}) {
+	dedupLog.printf(key, format, args...)
+}
+
+func (d *logDeduper) printf(key, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if key == "" {
+		key = msg
+	}
+	h := fnv.New64a()
+	h.Write([]byte(format))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	id := h.Sum64()
+	now := time.Now()
+
+	d.mu.Lock()
+	if !d.started {
+		d.started = true
+		go d.flushLoop()
+	}
+	if e, ok := d.entries[id]; ok {
+		if now.Sub(e.first) < d.window {
+			e.suppressed++
+			e.msg = msg
+			d.mu.Unlock()
+			return
+		}
+		d.summarize(e)
+	}
+	d.entries[id] = &dedupEntry{msg: msg, first: now}
+	d.mu.Unlock()
+
+	log.Print(msg)
+}
+
+// summarize prints the repeat count for e. Caller holds d.mu.
+func (d *logDeduper) summarize(e *dedupEntry) {
+	if e.suppressed > 0 {
+		log.Printf("%s (repeated %d times in last %v)", e.msg, e.suppressed, d.window)
+	}
+}
+
+func (d *logDeduper) flushLoop() {
+	ticker := time.NewTicker(d.window / 4)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		d.mu.Lock()
+		for id, e := range d.entries {
+			if now.Sub(e.first) >= d.window {
+				d.summarize(e)
+				delete(d.entries, id)
+			}
+		}
+		d.mu.Unlock()
+	}
+}
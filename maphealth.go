@@ -0,0 +1,87 @@
+package httpmux
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Per-map health endpoints
+//
+//   maps:
+//     - { type: tcp, bind: "443", target: "...", bind_health: ":8081" }
+//
+// The public port accepts TCP even while the tunnel is down, so a
+// plain port check can't tell whether the service is reachable.
+// bind_health answers any HTTP GET with 200 while at least one
+// session can serve the map and 503 otherwise.
+// ═══════════════════════════════════════════════════════════════
+
+func (s *Server) startMapHealth() {
+	for i := range s.Config.Maps {
+		m := &s.Config.Maps[i]
+		addr := strings.TrimSpace(m.BindHealth)
+		if addr == "" {
+			continue
+		}
+		if !strings.Contains(addr, ":") {
+			addr = ":" + addr
+		}
+		bind := strings.TrimSpace(m.Bind)
+		if !strings.Contains(bind, ":") {
+			bind = "0.0.0.0:" + bind
+		}
+		go s.serveMapHealth(addr, bind)
+	}
+}
+
+func (s *Server) serveMapHealth(addr, bind string) {
+	srv := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-store")
+			n := s.servingSessions(bind)
+			if pm := s.mapFor("tcp", bind); pm.CircuitBreaker && s.breakerOpen("tcp://"+pm.Target, pm.Tag) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "DOWN %s: target unreachable\n", bind)
+				return
+			}
+			if n == 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "DOWN %s: no session\n", bind)
+				return
+			}
+			fmt.Fprintf(w, "OK %s: %d session(s)\n", bind, n)
+		}),
+	}
+	s.life.track(srv)
+	log.Printf("[HEALTH] %s reports map %s", addr, bind)
+	if err := srv.ListenAndServe(); err != nil && !s.life.isClosing() {
+		log.Printf("[HEALTH] FAILED listen %s: %v", addr, err)
+	}
+}
+
+// servingSessions counts live sessions able to carry streams for the
+// map bound on bind.
+func (s *Server) servingSessions(bind string) int {
+	return len(s.sessionsServing(s.mapFor("tcp", bind).Tag))
+}
+
+// sessionsServing lists live sessions able to carry streams for a map
+// pinned to tag.
+func (s *Server) sessionsServing(tag string) []*serverSession {
+	s.poolMu.RLock()
+	defer s.poolMu.RUnlock()
+	var serving []*serverSession
+	for _, ss := range s.sessions {
+		if !ss.sess.IsClosed() && ss.serves(tag) {
+			serving = append(serving, ss)
+		}
+	}
+	return serving
+}
@@ -0,0 +1,114 @@
+package httpmux
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Per-map TLS (TCP maps, server)
+//
+//   maps:
+//     - { type: tcp, bind: "443", target: "127.0.0.1:80", tls: true,
+//         cert_file: "/etc/ssl/site.pem", key_file: "/etc/ssl/site.key" }
+//     - type: tcp
+//       bind: "8443"
+//       target: "127.0.0.1:443"            # no SNI or an unlisted one
+//       tls_passthrough:
+//         "git.example.com": "127.0.0.1:3443"
+//         "*.apps.example.com": "10.0.0.7:443"
+//
+// tls: true terminates TLS on the server with the map's certificate
+// (default: the server's cert_file/key_file) and sends the plaintext
+// through the tunnel, for backends that only speak plain TCP.
+//
+// tls_passthrough leaves TLS alone: the server reads the visitor's
+// ClientHello, picks the target by its server name, and replays the
+// hello to it, so several TLS services share one public port. Exact
+// names win over "*." suffixes, longer suffixes over shorter ones.
+// ═══════════════════════════════════════════════════════════════
+
+const mapHelloTimeout = 10 * time.Second
+
+// mapTLSConfig loads the certificate for a tls: true map.
+func mapTLSConfig(pm *PortMap, cfg *Config) (*tls.Config, error) {
+	certFile, keyFile := pm.CertFile, pm.KeyFile
+	if certFile == "" {
+		certFile, keyFile = cfg.CertFile, cfg.KeyFile
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls: needs cert_file and key_file on the map or the server")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// normalizeRoutes lower-cases the names of a tls_passthrough or vhosts
+// table and checks its targets.
+func normalizeRoutes(in map[string]string) (map[string]string, error) {
+	if len(in) == 0 {
+		return in, nil
+	}
+	routes := make(map[string]string, len(in))
+	for name, target := range in {
+		target = strings.TrimSpace(target)
+		if err := checkBracketed(target); err != nil {
+			return nil, err
+		}
+		routes[strings.ToLower(strings.TrimSpace(name))] = target
+	}
+	return routes, nil
+}
+
+// passthroughTarget picks the target for sni from routes, def when
+// nothing matches.
+func passthroughTarget(routes map[string]string, sni, def string) string {
+	sni = strings.ToLower(sni)
+	if t, ok := routes[sni]; ok && sni != "" {
+		return t
+	}
+	target, best := def, ""
+	for name, t := range routes {
+		if suf, ok := strings.CutPrefix(name, "*"); ok && strings.HasSuffix(sni, suf) && len(suf) > len(best) {
+			target, best = t, suf
+		}
+	}
+	return target
+}
+
+// mapTLS finishes a tls: true handshake or routes a tls_passthrough
+// visitor. It returns the conn to relay and its target; ok is false
+// when the visitor should be dropped.
+func (s *Server) mapTLS(conn net.Conn, pm *PortMap, target string) (net.Conn, string, bool) {
+	if tc, isTLS := conn.(*tls.Conn); isTLS {
+		tc.SetDeadline(time.Now().Add(mapHelloTimeout))
+		if err := tc.Handshake(); err != nil {
+			s.stats.incError("map_tls")
+			return nil, "", false
+		}
+		tc.SetDeadline(time.Time{})
+		return tc, target, true
+	}
+	if len(pm.TLSPassthrough) == 0 {
+		return conn, target, true
+	}
+	conn.SetReadDeadline(time.Now().Add(mapHelloTimeout))
+	hello, sni, err := readClientHello(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil && len(hello) == 0 {
+		return nil, "", false
+	}
+	if err != nil {
+		s.stats.incError("map_sni")
+	}
+	pc := &prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(hello), conn)}
+	return pc, passthroughTarget(pm.TLSPassthrough, sni, target), true
+}
@@ -0,0 +1,69 @@
+package httpmux
+
+import (
+	"log"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Session renewal (session_max_age)
+//
+//   paths:
+//     - { transport: httpmux, addr: "1.2.3.4:2020", session_max_age: 1800 }
+//
+// Some DPI throttles a connection once it has lived long enough. With
+// session_max_age (seconds, ±10% so a pool doesn't renew all at once)
+// the client replaces each session before that happens:
+//
+//   ① the pool worker connects a replacement on the same path
+//   ② once it is up, the old session leaves the pool — new client
+//      streams go elsewhere — and a hello with drain=1 tells the
+//      server to stop opening visitor streams on it
+//   ③ streams still open on the old session run to completion; the
+//      session is closed when the last one ends
+//
+// If the replacement can't connect, the old session keeps serving.
+// ═══════════════════════════════════════════════════════════════
+
+const minSessionMaxAge = 30 // seconds
+
+// agedError ends connectAndServe when its session reached
+// session_max_age; the worker retires cs once its replacement is up.
+type agedError struct{ cs *clientSession }
+
+func (agedError) Error() string { return "session_max_age reached" }
+
+// sessionAge is when a new session to path is due for renewal, 0 for
+// never.
+func sessionAge(path PathConfig) time.Duration {
+	if path.SessionMaxAge <= 0 {
+		return 0
+	}
+	age := time.Duration(path.SessionMaxAge) * time.Second
+	spread := int(age / 5 / time.Millisecond)
+	return age - age/10 + time.Duration(secureRandInt(spread))*time.Millisecond
+}
+
+// retireSession takes cs out of service and closes it once its
+// streams have finished. Safe to call more than once.
+func (c *Client) retireSession(cs *clientSession) {
+	if cs == nil || cs.sess.IsClosed() || !cs.retired.CompareAndSwap(false, true) {
+		return
+	}
+	c.removeSession(cs.sess)
+	c.sendSessionHello(cs, true)
+	if cs.spare {
+		log.Printf("[POOL] path %d: spare session retired after %v, draining %d stream(s)",
+			cs.path, time.Since(cs.created).Round(time.Second), cs.sess.NumStreams())
+	} else {
+		log.Printf("[POOL] path %d: session renewed after %v, draining %d stream(s)",
+			cs.path, time.Since(cs.created).Round(time.Second), cs.sess.NumStreams())
+	}
+	for cs.sess.NumStreams() > 0 && !cs.sess.IsClosed() {
+		if !c.life.sleep(time.Second) {
+			<-c.life.stopped // Shutdown drains relays first
+			break
+		}
+	}
+	cs.sess.Close()
+}
@@ -2,6 +2,8 @@ package httpmux
 
 import (
 	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
 	"fmt"
 	"math/rand"
 	"net"
@@ -57,45 +59,13 @@ func ClientHandshake(conn net.Conn, cfg *MimicConfig) (net.Conn, error) {
 // ClientHandshakeWithStealth is the v2.5.1 anti-DPI version that rotates
 // domain, User-Agent, headers, and path per connection.
 func ClientHandshakeWithStealth(conn net.Conn, cfg *MimicConfig, stealth *StealthConfig) (net.Conn, error) {
-	domain := "www.google.com"
-	path := "/"
-	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36"
-
-	if cfg != nil {
-		if cfg.FakeDomain != "" {
-			domain = cfg.FakeDomain
-		}
-		if cfg.FakePath != "" {
-			path = cfg.FakePath
-		}
-		if cfg.UserAgent != "" {
-			ua = cfg.UserAgent
-		}
-	}
-
-	// v2.5.1: Rotate domain & UA per connection to break DPI fingerprints
-	if stealth != nil {
-		if stealth.RotateDomain && len(stealth.DomainPool) > 0 {
-			domain = stealth.DomainPool[secureRandInt(len(stealth.DomainPool))]
-		}
-		if stealth.RotateUA && len(stealth.UAPool) > 0 {
-			ua = stealth.UAPool[secureRandInt(len(stealth.UAPool))]
-		}
-	}
-
-	// v2.5.1: Randomize path with realistic query strings
-	fullURL := "http://" + domain + path
-	if strings.Contains(path, "{rand}") {
-		fullURL, _ = BuildURLWithFakePath("http://"+domain, path)
-	} else {
-		// Add random query params to vary the URL fingerprint
-		fullURL += randomQueryString()
-	}
+	domain, ua, fullURL := mimicRequest(cfg, stealth)
 
 	req, err := http.NewRequest("GET", fullURL, nil)
 	if err != nil {
 		return nil, err
 	}
+	wsKey := generateWebSocketKeyBase64()
 
 	// v2.5.1: Build headers based on which "browser" UA we picked
 	// Each browser has slightly different header patterns
@@ -105,17 +75,19 @@ func ClientHandshakeWithStealth(conn net.Conn, cfg *MimicConfig, stealth *Stealt
 		{"User-Agent", ua},
 		{"Connection", "Upgrade"},
 		{"Upgrade", "websocket"},
-		{"Sec-WebSocket-Key", generateWebSocketKeyBase64()},
+		{"Sec-WebSocket-Key", wsKey},
 		{"Sec-WebSocket-Version", "13"},
 	}
 
-	// Browser-specific headers — makes each connection look like a real browser
+	// Browser-specific headers — makes each connection look like a real browser.
+	// Browsers send no document Accept header on a websocket upgrade.
 	var extraHeaders []hdr
 	if strings.Contains(ua, "Firefox") {
 		extraHeaders = []hdr{
-			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			{"Accept", "*/*"},
 			{"Accept-Language", randomAcceptLang()},
 			{"Accept-Encoding", "gzip, deflate, br"},
+			{"Sec-WebSocket-Extensions", "permessage-deflate"},
 			{"Sec-Fetch-Dest", "empty"},
 			{"Sec-Fetch-Mode", "websocket"},
 			{"Sec-Fetch-Site", "cross-site"},
@@ -125,18 +97,18 @@ func ClientHandshakeWithStealth(conn net.Conn, cfg *MimicConfig, stealth *Stealt
 		}
 	} else if strings.Contains(ua, "Safari") && !strings.Contains(ua, "Chrome") {
 		extraHeaders = []hdr{
-			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
 			{"Accept-Language", randomAcceptLang()},
 			{"Accept-Encoding", "gzip, deflate, br"},
+			{"Sec-WebSocket-Extensions", "permessage-deflate"},
 			{"Origin", "https://" + domain},
 		}
 	} else {
 		// Chrome / Edge
 		extraHeaders = []hdr{
-			{"Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8"},
 			{"Accept-Language", randomAcceptLang()},
 			{"Accept-Encoding", "gzip, deflate, br"},
-			{"Sec-Fetch-Dest", "empty"},
+			{"Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits"},
+			{"Sec-Fetch-Dest", "websocket"},
 			{"Sec-Fetch-Mode", "websocket"},
 			{"Sec-Fetch-Site", "same-origin"},
 			{"Origin", "https://" + domain},
@@ -190,19 +162,139 @@ func ClientHandshakeWithStealth(conn net.Conn, cfg *MimicConfig, stealth *Stealt
 	}
 
 	// CRITICAL: Keep the bufio.Reader — it may contain pre-read smux data!
+	// readStrictResponse consumes exactly the response head, nothing more.
 	br := bufio.NewReader(conn)
-	resp, err := http.ReadResponse(br, req)
+	resp, err := readStrictResponse(br, req)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != 101 && resp.StatusCode != 200 {
-		return nil, fmt.Errorf("handshake: expected 101, got %d", resp.StatusCode)
+	if err := checkUpgradeResponse(resp, wsKey); err != nil {
+		return nil, err
 	}
 
 	return &bufferedConn{Conn: conn, r: br}, nil
 }
 
+// mimicRequest picks the Host, User-Agent and URL ("http://host/path?q")
+// of one tunnel request, rotated per connection when stealth says so.
+func mimicRequest(cfg *MimicConfig, stealth *StealthConfig) (domain, ua, fullURL string) {
+	domain = "www.google.com"
+	path := "/"
+	ua = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/122.0.0.0 Safari/537.36"
+
+	if cfg != nil {
+		if cfg.FakeDomain != "" {
+			domain = cfg.FakeDomain
+		}
+		if cfg.FakePath != "" {
+			path = cfg.FakePath
+		}
+		if cfg.UserAgent != "" {
+			ua = cfg.UserAgent
+		}
+	}
+
+	// v2.5.1: Rotate domain & UA per connection to break DPI fingerprints
+	if stealth != nil {
+		if stealth.RotateDomain && len(stealth.DomainPool) > 0 {
+			domain = stealth.DomainPool[secureRandInt(len(stealth.DomainPool))]
+		}
+		if stealth.RotateUA && len(stealth.UAPool) > 0 {
+			ua = stealth.UAPool[secureRandInt(len(stealth.UAPool))]
+		}
+	}
+
+	// v2.5.1: Randomize path with realistic query strings
+	fullURL = "http://" + domain + path
+	if strings.Contains(path, "{rand}") {
+		fullURL, _ = BuildURLWithFakePath("http://"+domain, path)
+	} else {
+		// Add random query params to vary the URL fingerprint
+		fullURL += randomQueryString()
+	}
+	return domain, ua, fullURL
+}
+
+// ──────────── RFC 6455 handshake correctness ────────────
+//
+// A hard-coded Sec-WebSocket-Accept (or a 200 instead of a 101) is a
+// protocol violation any CDN or DPI box can check in one line, so both
+// ends now do the real thing: the server derives the accept value from
+// the client's key and the client verifies it. The 101's headers also
+// come in a fresh random order per connection, so no fixed byte layout
+// of the answer marks a PicoTun server.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketAccept computes Sec-WebSocket-Accept for a client key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// upgradeResponse is the 101 answering a websocket upgrade with key.
+// decorate adds the Server, Date and hardening headers a web server in
+// front of the endpoint would send.
+func upgradeResponse(key string, decorate bool) string {
+	headers := []string{
+		"Upgrade: websocket",
+		"Connection: Upgrade",
+		"Sec-WebSocket-Accept: " + websocketAccept(key),
+	}
+	if decorate {
+		serverNames := []string{"nginx/1.24.0", "nginx/1.25.4", "cloudflare", "gws", "Microsoft-IIS/10.0", "Apache/2.4.58"}
+		headers = append(headers,
+			"Server: "+serverNames[secureRandInt(len(serverNames))],
+			"Date: "+time.Now().UTC().Format(http.TimeFormat))
+		if secureRandInt(2) == 0 {
+			headers = append(headers, "X-Content-Type-Options: nosniff")
+		}
+		if secureRandInt(2) == 0 {
+			headers = append(headers, "X-Frame-Options: SAMEORIGIN")
+		}
+		if secureRandInt(3) == 0 {
+			headers = append(headers, fmt.Sprintf("Alt-Svc: h3=\":443\"; ma=%d", 86400+secureRandInt(86400)))
+		}
+	}
+	for i := len(headers) - 1; i > 0; i-- {
+		j := secureRandInt(i + 1)
+		headers[i], headers[j] = headers[j], headers[i]
+	}
+	return "HTTP/1.1 101 Switching Protocols\r\n" + strings.Join(headers, "\r\n") + "\r\n\r\n"
+}
+
+// validWebSocketRequest checks the key (base64 of 16 bytes) and version.
+func validWebSocketRequest(h http.Header) bool {
+	key, err := base64.StdEncoding.DecodeString(h.Get("Sec-WebSocket-Key"))
+	return err == nil && len(key) == 16 && h.Get("Sec-WebSocket-Version") == "13"
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkUpgradeResponse validates the server's answer to key.
+func checkUpgradeResponse(resp *http.Response, key string) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("handshake: expected 101, got %d", resp.StatusCode)
+	}
+	if !headerHasToken(resp.Header, "Upgrade", "websocket") || !headerHasToken(resp.Header, "Connection", "upgrade") {
+		return fmt.Errorf("handshake: 101 without websocket upgrade headers")
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		return fmt.Errorf("handshake: Sec-WebSocket-Accept mismatch")
+	}
+	return nil
+}
+
 // ──────────── v2.5.1 Anti-DPI Helpers ────────────
 
 // randomAcceptLang returns a realistic Accept-Language header
@@ -279,21 +371,28 @@ func base64Encode(data []byte) string {
 	return string(result)
 }
 
-// ServerHandshake — server-side validation (for tcpmux direct mode)
-func ServerHandshake(conn net.Conn, cfg *MimicConfig) error {
+// ServerHandshake — server-side validation (for tcpmux direct mode).
+// Returns a wrapped net.Conn so bytes the client sent right after its
+// request head are not lost with the bufio.Reader.
+func ServerHandshake(conn net.Conn, cfg *MimicConfig) (net.Conn, error) {
 	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
 	defer conn.SetReadDeadline(time.Time{})
 
 	reader := bufio.NewReader(conn)
-	req, err := http.ReadRequest(reader)
+	req, err := readStrictRequest(reader)
 	if err != nil {
-		return err
+		writeFakeResponse(conn, 400)
+		return nil, err
+	}
+	if err := drainPipelined(reader); err != nil {
+		writeFakeResponse(conn, 400)
+		return nil, err
 	}
 
 	if cfg != nil && cfg.FakeDomain != "" {
 		if req.Host != cfg.FakeDomain && !strings.HasSuffix(req.Host, "."+cfg.FakeDomain) {
 			writeFakeResponse(conn, 404)
-			return fmt.Errorf("invalid host: %s", req.Host)
+			return nil, fmt.Errorf("invalid host: %s", req.Host)
 		}
 	}
 
@@ -303,20 +402,22 @@ func ServerHandshake(conn net.Conn, cfg *MimicConfig) error {
 	}
 	if !strings.HasPrefix(req.URL.Path, expectedPath) {
 		writeFakeResponse(conn, 404)
-		return fmt.Errorf("invalid path: %s", req.URL.Path)
+		return nil, fmt.Errorf("invalid path: %s", req.URL.Path)
+	}
+	if !validWebSocketRequest(req.Header) {
+		writeFakeResponse(conn, 400)
+		return nil, fmt.Errorf("invalid websocket key/version")
 	}
 
-	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
-		"Upgrade: websocket\r\n" +
-		"Connection: Upgrade\r\n" +
-		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n" +
-		"\r\n"
-	_, err = conn.Write([]byte(resp))
-	return err
+	resp := upgradeResponse(req.Header.Get("Sec-WebSocket-Key"), false)
+	if _, err = conn.Write([]byte(resp)); err != nil {
+		return nil, err
+	}
+	return &bufferedConn{Conn: conn, r: reader}, nil
 }
 
 func writeFakeResponse(conn net.Conn, code int) {
-	resp := fmt.Sprintf("HTTP/1.1 %d Not Found\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", code)
+	resp := fmt.Sprintf("HTTP/1.1 %d %s\r\nContent-Length: 0\r\nConnection: close\r\n\r\n", code, http.StatusText(code))
 	conn.Write([]byte(resp))
 }
 
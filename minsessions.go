@@ -0,0 +1,171 @@
+package httpmux
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Minimum sessions (warm-up)
+//
+//   paths:
+//     - { transport: httpmux, addr: "1.2.3.4:2020", connection_pool: 4, min_sessions: 3 }
+//
+// Right after a restart the pool comes up one session at a time, and
+// the first visitors all land on whichever session connected first.
+// With min_sessions the tunnel only counts as up once that many
+// sessions to the path are established:
+//
+//   client  WaitReady and client-side streams (SOCKS5, forwards) wait
+//           for it; a session lost below the minimum is replaced
+//           without the usual retry pause.
+//   server  the client announces its minimum and a per-process id in
+//           the session hello; its sessions carry no visitors — held
+//           visitors wait, bind_health answers 503 — until that many
+//           have connected. After that the client stays in service
+//           even if sessions drop, so a reconnect never blacks it out.
+//
+// connection_pool is raised to min_sessions when smaller.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	maxMinSessions = 64
+	readyWait      = 10 * time.Second // how long client streams wait for warm-up
+)
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ──────────── Client ────────────
+
+// warmupConfigured reports whether any path sets min_sessions.
+func (c *Client) warmupConfigured() bool {
+	for _, p := range c.paths {
+		if p.MinSessions > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// ready reports whether the pool has reached min_sessions: on every
+// path in multipath mode, on the active path otherwise.
+func (c *Client) ready() bool {
+	c.sessMu.RLock()
+	defer c.sessMu.RUnlock()
+	if len(c.sessions) == 0 {
+		return false
+	}
+	if !c.multipath() {
+		return len(c.sessions) >= c.paths[0].MinSessions
+	}
+	counts := make([]int, len(c.paths))
+	for _, cs := range c.sessions {
+		counts[cs.path]++
+	}
+	for i, p := range c.paths {
+		if counts[i] < p.MinSessions {
+			return false
+		}
+	}
+	return true
+}
+
+// belowMin reports whether path pathIdx has fewer sessions than its
+// min_sessions.
+func (c *Client) belowMin(pathIdx int) bool {
+	min := c.paths[pathIdx].MinSessions
+	if min <= 1 {
+		return false
+	}
+	c.sessMu.RLock()
+	defer c.sessMu.RUnlock()
+	n := 0
+	for _, cs := range c.sessions {
+		if cs.path == pathIdx || !c.multipath() {
+			n++
+		}
+	}
+	return n < min
+}
+
+// noteReady logs warm-up transitions after the pool changed.
+func (c *Client) noteReady() {
+	if c.sd != nil && c.ready() {
+		c.sd.ready(fmt.Sprintf("%d session(s) up", c.sessionCount()))
+	}
+	if !c.warmupConfigured() {
+		return
+	}
+	now := c.ready()
+	if c.isReady.Swap(now) == now {
+		return
+	}
+	if now {
+		log.Printf("[POOL] ready: %d session(s), min_sessions reached", c.sessionCount())
+	} else {
+		log.Printf("[POOL] below min_sessions (%d session(s)), reconnecting", c.sessionCount())
+	}
+}
+
+// ──────────── Server ────────────
+
+// markWarm puts the sessions of ss's client into service once
+// enough of them are up; clients without a minimum are in service at
+// once.
+func (s *Server) markWarm(ss *serverSession, si *sessionInfo) {
+	if si.Min <= 1 || si.ID == "" {
+		ss.ready.Store(true)
+		return
+	}
+	s.poolMu.Lock()
+	var group []*serverSession
+	for _, e := range s.sessions {
+		if e.clientID() == si.ID {
+			group = append(group, e)
+		}
+	}
+	was := s.warmClients[si.ID]
+	ready := was || len(group) >= si.Min
+	if ready {
+		s.warmClients[si.ID] = true
+		for _, e := range group {
+			e.ready.Store(true)
+		}
+	}
+	s.poolMu.Unlock()
+	switch {
+	case ready && !was:
+		log.Printf("[SESSION] client %s ready: %d session(s)", si.ID, len(group))
+	case !ready:
+		log.Printf("[SESSION] client %s warming up: %d/%d session(s)", si.ID, len(group), si.Min)
+	}
+}
+
+// forgetWarm drops a client's warm-up state with its last session.
+// Caller holds poolMu.
+func (s *Server) forgetWarm(ss *serverSession) {
+	id := ss.clientID()
+	if id == "" {
+		return
+	}
+	for _, e := range s.sessions {
+		if e.clientID() == id {
+			return
+		}
+	}
+	delete(s.warmClients, id)
+}
+
+func (ss *serverSession) clientID() string {
+	if si := ss.info.Load(); si != nil {
+		return si.ID
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package httpmux
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// MSS clamping for reverse TCP maps (server)
+//
+//   maps:
+//     - { type: tcp, bind: "8443", target: "...", mss: 1360 }
+//
+// Visitors reaching the server through a VPN, PPPoE or another tunnel
+// have less than 1500 bytes of path MTU, and when ICMP "fragmentation
+// needed" is filtered on the way their big segments vanish: the
+// handshake works, then the connection hangs on the first full-size
+// write. mss sets TCP_MAXSEG on the map's listener, so the SYN-ACK
+// advertises the smaller segment size and the visitor never sends
+// anything larger. 1360 leaves room for IPv6 and a layer of
+// encapsulation; 0 keeps the kernel's choice.
+//
+// Linux only (mssSupported); elsewhere the option is ignored and
+// picotun check says so.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	mssMin = 88 // the kernel's TCP_MIN_MSS
+	mssMax = 65495
+)
+
+func validMSS(mss int) bool {
+	return mss == 0 || (mss >= mssMin && mss <= mssMax)
+}
+
+// listenTCP listens on bind with the listener's sockets clamped to mss
+// (0 = unclamped).
+func listenTCP(bind string, mss int) (net.Listener, error) {
+	if mss == 0 || !mssSupported {
+		return net.Listen("tcp", bind)
+	}
+	lc := net.ListenConfig{Control: func(_, _ string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) { serr = setMSS(fd, mss) })
+		if err != nil {
+			return err
+		}
+		if serr != nil {
+			return fmt.Errorf("mss %d: %w", mss, serr)
+		}
+		return nil
+	}}
+	return lc.Listen(context.Background(), "tcp", bind)
+}
@@ -0,0 +1,11 @@
+//go:build linux
+
+package httpmux
+
+import "syscall"
+
+const mssSupported = true
+
+func setMSS(fd uintptr, mss int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, mss)
+}
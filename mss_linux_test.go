@@ -0,0 +1,40 @@
+package httpmux
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestListenTCPClampsMSS(t *testing.T) {
+	ln, err := listenTCP("127.0.0.1:0", 536)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+			defer c.Close()
+			c.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mss int
+	raw.Control(func(fd uintptr) {
+		mss, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mss > 536 {
+		t.Fatalf("accepted connection has mss %d, want at most 536", mss)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package httpmux
+
+const mssSupported = false
+
+func setMSS(fd uintptr, mss int) error { return nil }
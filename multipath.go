@@ -0,0 +1,108 @@
+package httpmux
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Multipath load balancing (client)
+//
+//   load_balance: rtt          # failover (default) | rtt | least_load
+//   paths:
+//     - { addr: "1.2.3.4:80", connection_pool: 4, weight: 2 }
+//     - { addr: "5.6.7.8:80", connection_pool: 4 }
+//
+// failover keeps every pool worker on one path and moves on only after
+// repeated failures. With rtt or least_load each path gets its own
+// workers (connection_pool each), so sessions stay open on all paths at
+// once, and new streams go to the best session:
+//
+//   rtt         lowest smoothed round trip (ping.go, every
+//               advanced.ping_interval), divided by weight; ties
+//               go to the session with fewer streams
+//   least_load  fewest open streams per unit of weight
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	balanceFailover  = "failover"
+	balanceRTT       = "rtt"
+	balanceLeastLoad = "least_load"
+)
+
+func normalizeLoadBalance(c *Config) {
+	lb := strings.ToLower(strings.TrimSpace(c.LoadBalance))
+	switch lb {
+	case "":
+		lb = balanceFailover
+	case balanceFailover, balanceRTT, balanceLeastLoad:
+	default:
+		log.Printf("[CONFIG] unknown load_balance %q — using failover", c.LoadBalance)
+		lb = balanceFailover
+	}
+	c.LoadBalance = lb
+	for i := range c.Paths {
+		if c.Paths[i].Weight <= 0 {
+			c.Paths[i].Weight = 1
+		}
+	}
+}
+
+// clientSession is one established tunnel session and the path it
+// was dialed on.
+type clientSession struct {
+	sess    muxSession
+	path    int
+	nonce   []byte                      // auth nonce, ties stream spans to the server's (tracing.go)
+	peer    atomic.Pointer[sessionInfo] // the server's hello answer, nil until read (proto.go)
+	created time.Time
+	rtt     int64       // atomic: smoothed echo RTT in ns, 0 = not measured yet
+	retired atomic.Bool // past session_max_age and replaced
+	spare   bool        // opened under load (aggressive_pool)
+	sched   writeSched  // stream write order by map priority (qos.go)
+}
+
+// multipath reports whether paths are used concurrently.
+func (c *Client) multipath() bool {
+	lb := c.cfg.LoadBalance
+	return (lb == balanceRTT || lb == balanceLeastLoad) && len(c.paths) > 1
+}
+
+// orderSessions returns sessions in the order OpenStream should try
+// them. Failover rotates round-robin; the balanced modes sort by score.
+func (c *Client) orderSessions() []*clientSession {
+	c.sessMu.RLock()
+	n := len(c.sessions)
+	sessions := make([]*clientSession, 0, n)
+	idx := int(atomic.AddUint64(&c.rrIndex, 1))
+	for i := 0; i < n; i++ {
+		sessions = append(sessions, c.sessions[(idx+i)%n])
+	}
+	c.sessMu.RUnlock()
+
+	if !c.multipath() {
+		return sessions
+	}
+	score := func(cs *clientSession) float64 {
+		w := float64(max(c.paths[cs.path].Weight, 1))
+		if c.cfg.LoadBalance == balanceRTT {
+			rtt := atomic.LoadInt64(&cs.rtt)
+			if rtt == 0 {
+				rtt = int64(time.Second) // unmeasured: usable, not preferred
+			}
+			return float64(rtt) / w
+		}
+		return float64(cs.sess.NumStreams()+1) / w
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		si, sj := score(sessions[i]), score(sessions[j])
+		if si != sj {
+			return si < sj
+		}
+		return sessions[i].sess.NumStreams() < sessions[j].sess.NumStreams()
+	})
+	return sessions
+}
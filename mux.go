@@ -0,0 +1,164 @@
+package httpmux
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/xtaci/smux"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Stream multiplexer (smux or yamux)
+//
+//   mux: yamux        # client: smux (default) | yamux
+//
+// Some setups hit smux window/keepalive pathologies at high stream
+// counts; yamux is there to A/B test against without patching. Both
+// sit behind muxSession, which is all Server and Client use.
+//
+// The client picks. The server tells them apart from the first frame
+// the client sends — yamux frames start with version 0, smux frames
+// with 1 or 2 — and the client always opens its session hello first
+// so that frame comes right away. A server with `mux: smux` or
+// `mux: yamux` skips the detection and only speaks that one. yamux
+// reuses the smux keepalive and max_stream (window) settings, a path's
+// smux overrides included.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	muxSmux  = "smux"
+	muxYamux = "yamux"
+
+	// muxDetectTimeout bounds the wait for the client's first frame;
+	// clients that predate the hello may send nothing until their
+	// first keepalive, and those speak smux.
+	muxDetectTimeout = 3 * time.Second
+)
+
+// muxSession is one multiplexed tunnel connection.
+type muxSession interface {
+	OpenStream() (net.Conn, error)
+	AcceptStream() (net.Conn, error)
+	NumStreams() int
+	IsClosed() bool
+	Close() error
+}
+
+func normalizeMux(c *Config) error {
+	switch c.Mux {
+	case "", muxSmux, muxYamux:
+		return nil
+	}
+	return fmt.Errorf("mux: unknown %q (smux or yamux)", c.Mux)
+}
+
+// ──────────── smux ────────────
+
+type smuxSession struct{ *smux.Session }
+
+func (s smuxSession) OpenStream() (net.Conn, error) {
+	st, err := s.Session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s smuxSession) AcceptStream() (net.Conn, error) {
+	st, err := s.Session.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// ──────────── yamux ────────────
+
+type yamuxSession struct{ *yamux.Session }
+
+func (s yamuxSession) OpenStream() (net.Conn, error) {
+	st, err := s.Session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s yamuxSession) AcceptStream() (net.Conn, error) {
+	st, err := s.Session.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func buildYamuxConfig(cfg *Config, path *PathConfig) *yamux.Config {
+	sc := buildSmuxConfig(cfg, path)
+	yc := yamux.DefaultConfig()
+	yc.KeepAliveInterval = sc.KeepAliveInterval
+	yc.ConnectionWriteTimeout = sc.KeepAliveTimeout
+	if w := uint32(sc.MaxStreamBuffer); w > yc.MaxStreamWindowSize {
+		yc.MaxStreamWindowSize = w
+	}
+	yc.AcceptBacklog = cfg.Advanced.MaxStreamsPerSession
+	yc.LogOutput = io.Discard
+	if cfg.Verbose {
+		yc.LogOutput = log.Writer()
+	}
+	return yc
+}
+
+// ──────────── Session setup ────────────
+
+func newMuxClient(cfg *Config, path *PathConfig, conn net.Conn) (muxSession, error) {
+	if cfg.Mux == muxYamux {
+		sess, err := yamux.Client(conn, buildYamuxConfig(cfg, path))
+		if err != nil {
+			return nil, err
+		}
+		return yamuxSession{sess}, nil
+	}
+	sess, err := smux.Client(conn, buildSmuxConfig(cfg, path))
+	if err != nil {
+		return nil, err
+	}
+	return smuxSession{sess}, nil
+}
+
+func newMuxServer(cfg *Config, conn net.Conn) (muxSession, error) {
+	kind := cfg.Mux
+	if kind == "" {
+		kind, conn = detectMux(conn)
+	}
+	if kind == muxYamux {
+		sess, err := yamux.Server(conn, buildYamuxConfig(cfg, nil))
+		if err != nil {
+			return nil, err
+		}
+		return yamuxSession{sess}, nil
+	}
+	sess, err := smux.Server(conn, buildSmuxConfig(cfg, nil))
+	if err != nil {
+		return nil, err
+	}
+	return smuxSession{sess}, nil
+}
+
+// detectMux peeks at the client's first frame. The returned conn
+// replays it.
+func detectMux(conn net.Conn) (string, net.Conn) {
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(muxDetectTimeout))
+	first, err := br.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	kind := muxSmux
+	if err == nil && first[0] == 0 {
+		kind = muxYamux
+	}
+	return kind, &bufferedConn{Conn: conn, r: br}
+}
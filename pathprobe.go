@@ -0,0 +1,198 @@
+package httpmux
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Active path probing (client)
+//
+//   path_probe:
+//     interval: 10   # seconds between rounds, 0 = off (default)
+//     timeout: 3     # seconds per probe
+//     fails: 2       # failed probes in a row before a path is down
+//
+// Without probing a failover worker learns a path is dead only by
+// failing on it maxFailsBeforeSwitch times, backing off in between,
+// and learns nothing about the spare paths until it moves to one.
+// The prober TCP-connects to every path each interval — the address,
+// hopped port and socket options a session dial would use — whether
+// or not a worker is on it, records up/down and the connect time, and
+// closes the connection without sending anything.
+//
+// Pool workers act on the measurements:
+//
+//   failover        a worker about to connect on a path measured down
+//                   moves straight to the first path measured up, and
+//                   moving on after failures skips paths measured down
+//   rtt/least_load  a worker whose path is measured down waits for
+//                   the next round instead of redialing
+//
+// Paths not probed yet count as up. Every change is logged under
+// [PROBE] and the health of each path is in the stats ("paths").
+// Off by default: the probes are extra connections an observer sees.
+// ═══════════════════════════════════════════════════════════════
+
+type PathProbeConfig struct {
+	Interval int `yaml:"interval"` // seconds, 0 = off
+	Timeout  int `yaml:"timeout"`  // seconds, default 3
+	Fails    int `yaml:"fails"`    // default 2
+}
+
+// PathHealth is the prober's view of one path.
+type PathHealth struct {
+	Addr    string    `json:"addr"`
+	Up      bool      `json:"up"`
+	RTTms   float64   `json:"rtt_ms,omitempty"` // last successful connect
+	Fails   int       `json:"fails,omitempty"`  // failed probes in a row
+	Checked time.Time `json:"checked"`
+}
+
+type pathProber struct {
+	every   time.Duration
+	timeout time.Duration
+	fails   int
+
+	mu     sync.Mutex
+	health []PathHealth
+}
+
+// newPathProber returns the prober for paths, nil when path_probe is
+// off.
+func newPathProber(cfg *PathProbeConfig, paths []PathConfig) (*pathProber, error) {
+	if cfg.Interval < 0 || cfg.Timeout < 0 || cfg.Fails < 0 {
+		return nil, fmt.Errorf("interval, timeout and fails can't be negative")
+	}
+	if cfg.Interval == 0 {
+		return nil, nil
+	}
+	p := &pathProber{
+		every:   time.Duration(cfg.Interval) * time.Second,
+		timeout: 3 * time.Second,
+		fails:   2,
+		health:  make([]PathHealth, len(paths)),
+	}
+	if cfg.Timeout > 0 {
+		p.timeout = time.Duration(cfg.Timeout) * time.Second
+	}
+	if cfg.Fails > 0 {
+		p.fails = cfg.Fails
+	}
+	for i, path := range paths {
+		p.health[i] = PathHealth{Addr: strings.TrimSpace(path.Addr), Up: true}
+	}
+	return p, nil
+}
+
+// down reports whether path i is measured down; false on a nil p.
+func (p *pathProber) down(i int) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.health[i].Up
+}
+
+// record stores the outcome of one probe of path i and returns the
+// new health and whether Up changed.
+func (p *pathProber) record(i int, rtt time.Duration, err error) (PathHealth, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	h := &p.health[i]
+	was := h.Up
+	h.Checked = time.Now()
+	if err == nil {
+		h.Up, h.Fails, h.RTTms = true, 0, float64(rtt)/1e6
+	} else if h.Fails++; h.Fails >= p.fails {
+		h.Up = false
+	}
+	return *h, h.Up != was
+}
+
+func (c *Client) runPathProbe() {
+	if c.probe == nil {
+		return
+	}
+	defer guardPanic("path probe")
+	log.Printf("[PROBE] probing %d path(s) every %v", len(c.paths), c.probe.every)
+	for {
+		var wg sync.WaitGroup
+		for i, path := range c.paths {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.probePath(i, path)
+			}()
+		}
+		wg.Wait()
+		if !c.life.sleep(c.probe.every) {
+			return
+		}
+	}
+}
+
+// probePath connects to path i once and records the result.
+func (c *Client) probePath(i int, path PathConfig) {
+	start := time.Now()
+	conn, err := happyDial(c.life.ctx, c.pathDialAddr(path), c.probe.timeout, c.cfg.IPPreference, c.sockets.dialTCP)
+	rtt := time.Since(start)
+	if err == nil {
+		conn.Close()
+	}
+	if c.life.isClosing() {
+		return
+	}
+	h, changed := c.probe.record(i, rtt, err)
+	c.stats.setPathHealth(i, h)
+	switch {
+	case !changed:
+	case h.Up:
+		log.Printf("[PROBE] path[%d] %s up (%.1fms)", i, h.Addr, h.RTTms)
+	default:
+		log.Printf("[PROBE] path[%d] %s down after %d failed probes: %v", i, h.Addr, h.Fails, err)
+	}
+}
+
+// pathDialAddr is the address a session dial on path connects to.
+func (c *Client) pathDialAddr(path PathConfig) string {
+	transport := strings.ToLower(strings.TrimSpace(path.Transport))
+	if transport == "" {
+		transport = c.cfg.Transport
+	}
+	host, port := parseAddr(strings.TrimSpace(path.Addr), transport)
+	if c.hop != nil {
+		port = c.hop.dialPort()
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// probedPath returns the path a failover worker on cur should connect
+// on: cur unless it is measured down and another path is measured up.
+func (c *Client) probedPath(cur int) int {
+	if !c.probe.down(cur) {
+		return cur
+	}
+	for i := range c.paths {
+		if !c.probe.down(i) {
+			return i
+		}
+	}
+	return cur
+}
+
+// nextPath returns the path a failover worker moves to after giving
+// up on cur: the next one in order not measured down, cur if none.
+func (c *Client) nextPath(cur int) int {
+	for n := 1; n < len(c.paths); n++ {
+		if i := (cur + n) % len(c.paths); !c.probe.down(i) {
+			return i
+		}
+	}
+	return cur
+}
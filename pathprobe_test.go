@@ -0,0 +1,54 @@
+package httpmux
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+// TestPathProbe probes a dead and a live path and checks where a
+// failover worker goes.
+func TestPathProbe(t *testing.T) {
+	dead := freeAddr(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	c := NewClient(testConfig(t, fmt.Sprintf("mode: client\npsk: %s\n"+
+		"path_probe: {interval: 1, fails: 2}\n"+
+		"paths:\n  - {transport: tcpmux, addr: %q}\n  - {transport: tcpmux, addr: %q}\n", testPSK, dead, l.Addr())))
+	defer c.Shutdown()
+
+	if got := c.probedPath(0); got != 0 {
+		t.Fatalf("unprobed: worker goes to path[%d], want 0", got)
+	}
+	c.probePath(0, c.paths[0])
+	c.probePath(1, c.paths[1])
+	if c.probe.down(0) {
+		t.Fatal("path[0] down after one failed probe with fails: 2")
+	}
+	c.probePath(0, c.paths[0])
+	if !c.probe.down(0) || c.probe.down(1) {
+		t.Fatalf("down = %v, %v; want true, false", c.probe.down(0), c.probe.down(1))
+	}
+	if got := c.probedPath(0); got != 1 {
+		t.Errorf("worker on the dead path goes to path[%d], want 1", got)
+	}
+	if got := c.nextPath(1); got != 1 {
+		t.Errorf("giving up on path[1] moves to path[%d], want to stay", got)
+	}
+	snap := c.stats.Snapshot()
+	if len(snap.Paths) != 2 || snap.Paths[0].Up || !snap.Paths[1].Up || snap.Paths[1].RTTms <= 0 {
+		t.Errorf("stats paths = %+v", snap.Paths)
+	}
+}
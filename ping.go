@@ -0,0 +1,127 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Tunnel RTT (ping streams)
+//
+//   advanced:
+//     ping_interval: 10     # seconds, -1 = off
+//
+// Both ends measure the end-to-end round trip through the tunnel —
+// smux, encryption and whatever sits on the path — with a short
+// StreamTypePing stream: 8 bytes out, the same 8 bytes back. The
+// smoothed value is kept per session (multipath rtt balancing, admin
+// API) and in the stats snapshot as rtt_ms.
+//
+// A server only pings a session after that client has pinged it, so
+// older clients never see a stream type they don't know.
+// ═══════════════════════════════════════════════════════════════
+
+func applyPingDefaults(a *AdvancedConfig) {
+	if a.PingInterval == 0 {
+		a.PingInterval = 10
+	}
+}
+
+func pingInterval(cfg *Config) time.Duration {
+	return time.Duration(cfg.Advanced.PingInterval) * time.Second
+}
+
+// pingRTT times one round trip over a new ping stream on sess, giving
+// up after timeout.
+func pingRTT(sess muxSession, timeout time.Duration) (time.Duration, error) {
+	stream, err := sess.OpenStream()
+	if err != nil {
+		return 0, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(timeout))
+	var b [9]byte
+	b[0] = StreamTypePing
+	start := time.Now()
+	binary.BigEndian.PutUint64(b[1:], uint64(start.UnixNano()))
+	if _, err := stream.Write(b[:]); err != nil {
+		return 0, err
+	}
+	if _, err := io.ReadFull(stream, b[1:]); err != nil {
+		return 0, fmt.Errorf("ping: %w", err)
+	}
+	if int64(binary.BigEndian.Uint64(b[1:])) != start.UnixNano() {
+		return 0, fmt.Errorf("ping: reply mismatch")
+	}
+	return time.Since(start), nil
+}
+
+// servePing answers a ping stream (type byte already read).
+func servePing(stream io.ReadWriter) {
+	var b [8]byte
+	if _, err := io.ReadFull(stream, b[:]); err != nil {
+		return
+	}
+	stream.Write(b[:])
+}
+
+// smoothRTT folds d into the EWMA at addr (ns, 0 = none yet).
+func smoothRTT(addr *int64, d time.Duration) {
+	old := atomic.LoadInt64(addr)
+	if old == 0 {
+		atomic.StoreInt64(addr, int64(d))
+	} else {
+		atomic.StoreInt64(addr, (7*old+int64(d))/8)
+	}
+}
+
+// ──────────── Client ────────────
+
+// probeRTT pings cs until the session closes.
+func (c *Client) probeRTT(cs *clientSession) {
+	every := pingInterval(c.cfg)
+	if every <= 0 {
+		return
+	}
+	for {
+		if d, err := pingRTT(cs.sess, 5*time.Second); err == nil {
+			smoothRTT(&cs.rtt, d)
+			c.stats.observeRTT(d)
+			if c.verbose {
+				log.Printf("[PING] path %d: %v (avg %v)", cs.path, d.Round(100*time.Microsecond),
+					time.Duration(atomic.LoadInt64(&cs.rtt)).Round(100*time.Microsecond))
+			}
+		}
+		if !c.life.sleep(every) || cs.sess.IsClosed() {
+			return
+		}
+	}
+}
+
+// ──────────── Server ────────────
+
+// probeSession pings ss once its client has shown it speaks ping.
+func (s *Server) probeSession(ss *serverSession) {
+	every := pingInterval(s.Config)
+	if every <= 0 {
+		return
+	}
+	for s.life.sleep(every) && !ss.sess.IsClosed() {
+		if atomic.LoadInt32(&ss.pings) == 0 {
+			continue
+		}
+		d, err := pingRTT(ss.sess, 5*time.Second)
+		if err != nil {
+			continue
+		}
+		smoothRTT(&ss.rtt, d)
+		s.stats.observeRTT(d)
+		if s.Verbose {
+			log.Printf("[PING] %s%s: %v", ss.remote, ss.userTag(), d.Round(100*time.Microsecond))
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package httpmux
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Port hopping (both ends)
+//
+//   port_hopping:
+//     enabled: true
+//     range: "20000-40000"   # ports the schedule draws from
+//     interval: 10           # minutes per port
+//     window: 1              # neighbouring slots the server also listens on (1-10)
+//     seed: ""               # default: psk
+//
+// Time is cut into slots of `interval` minutes. The port for a slot is
+// HMAC-SHA256(seed, slot number) mapped into `range`, so both ends
+// compute the same schedule without talking and an observer without
+// the PSK can't predict it. The server listens on the current slot's
+// port plus `window` slots either side, which covers clock skew and
+// lets a client whose port is blocked try the next one; listeners
+// leaving the window are closed, sessions on them keep running.
+//
+// The client dials the current slot's port on every path (the port in
+// the path addr is ignored); after a failed dial it moves on to the
+// next slot's port, up to `window` ahead. Both clocks must be roughly
+// right (NTP).
+// ═══════════════════════════════════════════════════════════════
+
+type PortHopConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Range    string `yaml:"range"`
+	Interval int    `yaml:"interval"` // minutes, default 10
+	Window   int    `yaml:"window"`   // default 1
+	Seed     string `yaml:"seed"`
+}
+
+const maxHopWindow = 10
+
+type hopSchedule struct {
+	key    []byte
+	lo, n  int
+	every  time.Duration
+	window int
+	miss   int32 // atomic, client: failed dials since the last success
+}
+
+// newHopSchedule returns cfg's schedule, nil when port hopping is off.
+func newHopSchedule(cfg *Config) (*hopSchedule, error) {
+	h := &cfg.PortHopping
+	if !h.Enabled {
+		return nil, nil
+	}
+	loS, hiS, ok := strings.Cut(strings.TrimSpace(h.Range), "-")
+	lo, err1 := strconv.Atoi(strings.TrimSpace(loS))
+	hi, err2 := strconv.Atoi(strings.TrimSpace(hiS))
+	if !ok || err1 != nil || err2 != nil || lo < 1 || hi > 65535 || lo > hi {
+		return nil, fmt.Errorf("range %q: want \"low-high\" within 1-65535", h.Range)
+	}
+	if h.Interval < 0 || h.Window < 0 || h.Window > maxHopWindow {
+		return nil, fmt.Errorf("interval must be positive and window 1-%d", maxHopWindow)
+	}
+	seed := h.Seed
+	if seed == "" {
+		seed = cfg.PSK
+	}
+	if seed == "" {
+		return nil, errors.New("needs psk or seed")
+	}
+	s := &hopSchedule{key: []byte(seed), lo: lo, n: hi - lo + 1, every: 10 * time.Minute, window: max(h.Window, 1)}
+	if h.Interval > 0 {
+		s.every = time.Duration(h.Interval) * time.Minute
+	}
+	return s, nil
+}
+
+func (h *hopSchedule) slot(t time.Time) int64 {
+	return t.UnixNano() / int64(h.every)
+}
+
+// slotStart is when slot begins.
+func (h *hopSchedule) slotStart(slot int64) time.Time {
+	return time.Unix(0, slot*int64(h.every))
+}
+
+func (h *hopSchedule) port(slot int64) int {
+	m := hmac.New(sha256.New, h.key)
+	m.Write([]byte("picotun-port-hop"))
+	binary.Write(m, binary.BigEndian, slot)
+	return h.lo + int(binary.BigEndian.Uint64(m.Sum(nil))%uint64(h.n))
+}
+
+// ──────────── Client ────────────
+
+// dialPort is the port for the next dial: the current slot's, or a
+// later one after failed dials.
+func (h *hopSchedule) dialPort() string {
+	ahead := int64(atomic.LoadInt32(&h.miss)) % int64(h.window+1)
+	return strconv.Itoa(h.port(h.slot(time.Now()) + ahead))
+}
+
+func (h *hopSchedule) dialed(err error) {
+	if err != nil {
+		atomic.AddInt32(&h.miss, 1)
+	} else {
+		atomic.StoreInt32(&h.miss, 0)
+	}
+}
+
+// ──────────── Server ────────────
+
+// runPortHop listens on the schedule's ports around now, reshuffling
+// at every slot boundary until shutdown.
+func (s *Server) runPortHop(host string) error {
+	h := s.hop
+	server := s.tunnelServer(net.JoinHostPort(host, s.Config.PortHopping.Range))
+	open := map[int]net.Listener{}
+	defer func() {
+		for _, ln := range open {
+			ln.Close()
+		}
+	}()
+	for {
+		cur := h.slot(time.Now())
+		want := map[int]bool{}
+		for d := -h.window; d <= h.window; d++ {
+			want[h.port(cur+int64(d))] = true
+		}
+		for p, ln := range open {
+			if !want[p] {
+				ln.Close()
+				delete(open, p)
+			}
+		}
+		for p := range want {
+			if open[p] != nil {
+				continue
+			}
+			addr := net.JoinHostPort(host, strconv.Itoa(p))
+			raw, err := s.sockets.listen(addr)
+			if err != nil {
+				logDedupf("hop"+addr, "[HOP] listen %s: %v", addr, err)
+				continue
+			}
+			var ln net.Listener = raw
+			if s.tlsConfig != nil {
+				ln = s.tlsListener(server, raw)
+			}
+			open[p] = ln
+			go server.Serve(ln)
+		}
+		log.Printf("[HOP] slot %d: port %d, listening on %d port(s)", cur, h.port(cur), len(open))
+		if len(open) > 0 {
+			s.sd.ready("tunnel listening (port hopping)")
+		}
+		if !s.life.sleep(time.Until(h.slotStart(cur + 1))) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package httpmux
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Active probe (picotun probe https://myserver/)
+//
+// Looks at a server the way a censor's active prober does and reports
+// what would tell it apart from an ordinary website:
+//
+//   tls       certificate chain (trusted for the name? self-signed?
+//             expiring?), version, cipher, and whether h2 is offered
+//   decoy     several GETs of / and of a missing page, each on a new
+//             connection: a Server header or status that changes
+//             between identical requests, a missing Date, Go's stock
+//             404 body, headers in Go's sorted order behind a Server
+//             that claims nginx or Apache
+//   tunnel    a well-formed WebSocket upgrade on the tunnel path: a 101
+//             followed by bytes that aren't WebSocket frames
+//   timing    connect RTT against time to first byte, and whether the
+//             tunnel path answers measurably slower than the decoy
+//
+// Findings are "high" (enough on its own), "medium" (narrows it down)
+// or "info". The probe is scored by the server's probe detector like
+// any other (see decoy_site), so expect a [PROBE] line for your IP.
+// ═══════════════════════════════════════════════════════════════
+
+// ProbeOptions tunes Probe. Zero values use the defaults.
+type ProbeOptions struct {
+	Host     string        // Host header and SNI; default the URL's host
+	Path     string        // tunnel path; default the URL's path, or /search
+	Requests int           // GETs of / (default 5)
+	Timeout  time.Duration // per connection (default 10s)
+}
+
+// ProbeFinding is one thing a prober could key on.
+type ProbeFinding struct {
+	Severity string `json:"severity"` // high | medium | info
+	Check    string `json:"check"`    // tls | decoy | tunnel | timing
+	Detail   string `json:"detail"`
+}
+
+// ProbeResponse is one request and what came back.
+type ProbeResponse struct {
+	Request string        `json:"request"`
+	Status  int           `json:"status"`
+	Headers []string      `json:"headers"` // names, in wire order
+	Server  string        `json:"server,omitempty"`
+	Date    string        `json:"date,omitempty"`
+	Body    string        `json:"body_sha256"`
+	Size    int           `json:"size"`
+	Connect time.Duration `json:"connect_ns"`
+	TTFB    time.Duration `json:"ttfb_ns"` // request written to first response byte
+
+	goNotFound bool // net/http's stock 404 body
+}
+
+// ProbeTLS is the TLS side of the server.
+type ProbeTLS struct {
+	Version string   `json:"version"`
+	Cipher  string   `json:"cipher"`
+	ALPN    string   `json:"alpn"`
+	Chain   []string `json:"chain"` // subject ← issuer, leaf first
+	Expires string   `json:"expires"`
+}
+
+// ProbeReport is everything Probe saw.
+type ProbeReport struct {
+	URL       string          `json:"url"`
+	TLS       *ProbeTLS       `json:"tls,omitempty"`
+	Responses []ProbeResponse `json:"responses"`
+	Findings  []ProbeFinding  `json:"findings"`
+}
+
+func (r *ProbeReport) find(sev, check, format string, a ...any) {
+	r.Findings = append(r.Findings, ProbeFinding{sev, check, fmt.Sprintf(format, a...)})
+}
+
+// Worst returns the most severe finding's severity, "" when none.
+func (r *ProbeReport) Worst() string {
+	worst := ""
+	for _, f := range r.Findings {
+		switch {
+		case f.Severity == "high":
+			return "high"
+		case f.Severity == "medium":
+			worst = "medium"
+		case worst == "":
+			worst = f.Severity
+		}
+	}
+	return worst
+}
+
+const probeUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/123.0.0.0 Safari/537.36"
+
+type prober struct {
+	addr    string
+	host    string
+	timeout time.Duration
+	tls     bool
+}
+
+// Probe probes the server at rawURL (http:// or https://).
+func Probe(rawURL string, opts ProbeOptions) (*ProbeReport, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, fmt.Errorf("want http(s)://host[:port]/[path], got %q", rawURL)
+	}
+	p := &prober{host: opts.Host, timeout: opts.Timeout, tls: u.Scheme == "https"}
+	if p.host == "" {
+		p.host = u.Hostname()
+	}
+	if p.timeout <= 0 {
+		p.timeout = 10 * time.Second
+	}
+	p.addr = u.Host
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), map[bool]string{true: "443", false: "80"}[p.tls])
+	}
+	path := opts.Path
+	if path == "" {
+		path = u.Path
+	}
+	if path == "" || path == "/" {
+		path = "/search"
+	}
+	n := opts.Requests
+	if n <= 0 {
+		n = 5
+	}
+
+	r := &ProbeReport{URL: u.String()}
+	if p.tls {
+		if err := p.checkTLS(r); err != nil {
+			return nil, err
+		}
+	}
+	var pages []ProbeResponse
+	for range n {
+		resp, err := p.get("/", nil)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, resp)
+	}
+	missing := "/" + randomHex(6) + ".html"
+	miss, err := p.get(missing, nil)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	knock, err := p.knock(path, base64.StdEncoding.EncodeToString(key), r)
+	if err != nil {
+		return nil, err
+	}
+	r.Responses = append(append(pages, miss), knock)
+
+	checkDecoy(r, append(pages, miss))
+	checkTiming(r, pages, knock)
+	return r, nil
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// dial connects, with TLS for https, and returns the connect time.
+func (p *prober) dial() (net.Conn, time.Duration, *tls.ConnectionState, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", p.addr, p.timeout)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	rtt := time.Since(start)
+	conn.SetDeadline(time.Now().Add(p.timeout))
+	if !p.tls {
+		return conn, rtt, nil, nil
+	}
+	tc := tls.Client(conn, &tls.Config{
+		ServerName:         p.host,
+		InsecureSkipVerify: true, // verified by checkTLS, against the same name
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, 0, nil, fmt.Errorf("tls: %w", err)
+	}
+	st := tc.ConnectionState()
+	return tc, rtt, &st, nil
+}
+
+func (p *prober) checkTLS(r *ProbeReport) error {
+	conn, _, st, err := p.dial()
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	certs := st.PeerCertificates
+	if len(certs) == 0 {
+		r.find("high", "tls", "no certificate")
+		return nil
+	}
+	leaf := certs[0]
+	r.TLS = &ProbeTLS{
+		Version: tls.VersionName(st.Version),
+		Cipher:  tls.CipherSuiteName(st.CipherSuite),
+		ALPN:    st.NegotiatedProtocol,
+		Expires: leaf.NotAfter.UTC().Format(time.DateOnly),
+	}
+	for _, c := range certs {
+		r.TLS.Chain = append(r.TLS.Chain, c.Subject.CommonName+" ← "+c.Issuer.CommonName)
+	}
+
+	inter := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		inter.AddCert(c)
+	}
+	_, verr := leaf.Verify(x509.VerifyOptions{DNSName: p.host, Intermediates: inter})
+	switch {
+	case len(certs) == 1 && bytes.Equal(leaf.RawIssuer, leaf.RawSubject):
+		r.find("high", "tls", "self-signed certificate for %q", leaf.Subject.CommonName)
+	case verr != nil:
+		r.find("high", "tls", "certificate not trusted for %s: %v", p.host, verr)
+	}
+	if left := time.Until(leaf.NotAfter); left > 0 && left < 7*24*time.Hour {
+		r.find("medium", "tls", "certificate expires in %v", left.Round(time.Hour))
+	}
+	if life := leaf.NotAfter.Sub(leaf.NotBefore); life > 398*24*time.Hour {
+		r.find("medium", "tls", "certificate valid for %d days; public CAs issue at most 398", int(life.Hours()/24))
+	}
+	if st.NegotiatedProtocol != "h2" {
+		r.find("medium", "tls", "h2 not offered (ALPN %q); almost every HTTPS site speaks it", st.NegotiatedProtocol)
+	}
+	if st.Version < tls.VersionTLS13 {
+		r.find("info", "tls", "negotiated %s, not TLS 1.3", tls.VersionName(st.Version))
+	}
+	return nil
+}
+
+// get sends a browser-like GET of path on a new connection.
+func (p *prober) get(path string, extra http.Header) (ProbeResponse, error) {
+	res, _, err := p.roundTrip(path, extra)
+	return res, err
+}
+
+// roundTrip sends one HTTP/1.1 GET and reads the response. The
+// connection is returned open after a 101, closed otherwise.
+func (p *prober) roundTrip(path string, extra http.Header) (ProbeResponse, net.Conn, error) {
+	res := ProbeResponse{Request: "GET " + path}
+	conn, rtt, _, err := p.dial()
+	if err != nil {
+		return res, nil, err
+	}
+	res.Connect = rtt
+
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "GET %s HTTP/1.1\r\nHost: %s\r\n", path, p.host)
+	fmt.Fprintf(&req, "User-Agent: %s\r\n", probeUA)
+	req.WriteString("Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n")
+	req.WriteString("Accept-Language: en-US,en;q=0.9\r\n")
+	for k, vs := range extra {
+		for _, v := range vs {
+			fmt.Fprintf(&req, "%s: %s\r\n", k, v)
+		}
+	}
+	if extra.Get("Connection") == "" {
+		req.WriteString("Connection: close\r\n")
+	}
+	req.WriteString("\r\n")
+	start := time.Now()
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		conn.Close()
+		return res, nil, err
+	}
+	br := bufio.NewReader(conn)
+	if _, err := br.Peek(1); err != nil {
+		conn.Close()
+		return res, nil, fmt.Errorf("%s: no response: %w", res.Request, err)
+	}
+	res.TTFB = time.Since(start)
+
+	// The header names in wire order, before net/http maps them.
+	var head bytes.Buffer
+	for first := true; ; first = false {
+		line, err := br.ReadSlice('\n')
+		head.Write(line)
+		if err != nil {
+			conn.Close()
+			return res, nil, fmt.Errorf("%s: %w", res.Request, err)
+		}
+		l := strings.TrimRight(string(line), "\r\n")
+		if l == "" {
+			break
+		}
+		if name, _, ok := strings.Cut(l, ":"); ok && !first {
+			res.Headers = append(res.Headers, name)
+		}
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(&head, br)), nil)
+	if err != nil {
+		conn.Close()
+		return res, nil, fmt.Errorf("%s: %w", res.Request, err)
+	}
+	res.Status = resp.StatusCode
+	res.Server = resp.Header.Get("Server")
+	res.Date = resp.Header.Get("Date")
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return res, &bufferedConn{Conn: conn, r: br}, nil
+	}
+	defer conn.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	sum := sha256.Sum256(body)
+	res.Body = hex.EncodeToString(sum[:8])
+	res.Size = len(body)
+	res.goNotFound = string(body) == "404 page not found\n"
+	return res, nil, nil
+}
+
+// knock sends a valid WebSocket upgrade to the tunnel path.
+func (p *prober) knock(path, key string, r *ProbeReport) (ProbeResponse, error) {
+	res, conn, err := p.roundTrip(path, http.Header{
+		"Upgrade":               {"websocket"},
+		"Connection":            {"Upgrade"},
+		"Sec-WebSocket-Key":     {key},
+		"Sec-WebSocket-Version": {"13"},
+	})
+	res.Request = "GET " + path + " (WebSocket upgrade)"
+	if err != nil || conn == nil {
+		return res, err
+	}
+	defer conn.Close()
+	// A WebSocket server waits for the client's first frame, or sends
+	// a well-formed one of its own.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var b [2]byte
+	n, _ := io.ReadFull(conn, b[:])
+	switch {
+	case n == 0:
+		r.find("info", "tunnel", "%s upgrades to WebSocket and waits, like a WebSocket endpoint", path)
+	case !validWSFrameHead(b[0], b[1]):
+		r.find("high", "tunnel", "%s answers a WebSocket upgrade with 101 and then non-WebSocket bytes (%x…)", path, b[:n])
+	default:
+		r.find("info", "tunnel", "%s upgrades to WebSocket and sends a frame", path)
+	}
+	return res, nil
+}
+
+// validWSFrameHead reports whether b0 b1 can start a server-to-client
+// WebSocket frame: no reserved bits, a known opcode, unmasked.
+func validWSFrameHead(b0, b1 byte) bool {
+	if b0&0x70 != 0 || b1&0x80 != 0 {
+		return false
+	}
+	switch b0 & 0x0f {
+	case 0x0, 0x1, 0x2, 0x8, 0x9, 0xa:
+		return true
+	}
+	return false
+}
+
+func checkDecoy(r *ProbeReport, resps []ProbeResponse) {
+	pages := resps[:len(resps)-1]
+	servers := map[string]bool{}
+	statuses := map[int]bool{}
+	for _, p := range pages {
+		servers[p.Server] = true
+		statuses[p.Status] = true
+	}
+	if len(servers) > 1 {
+		r.find("high", "decoy", "Server header changes between identical requests (%s)", strings.Join(sortedKeys(servers), ", "))
+	}
+	if len(statuses) > 1 {
+		r.find("high", "decoy", "status of / changes between identical requests")
+	}
+	for _, p := range resps {
+		if p.Date == "" {
+			r.find("medium", "decoy", "%s: no Date header; HTTP servers always send one", p.Request)
+			break
+		}
+	}
+	for _, p := range resps {
+		if p.goNotFound {
+			r.find("high", "decoy", "%s: Go net/http's stock \"404 page not found\"", p.Request)
+			break
+		}
+	}
+	for _, p := range resps {
+		s := strings.ToLower(p.Server)
+		if (strings.HasPrefix(s, "nginx") || strings.HasPrefix(s, "apache")) && len(p.Headers) > 0 && p.Headers[0] != "Server" {
+			r.find("medium", "decoy", "%s: claims %s but sends %s first; nginx and Apache send Server first", p.Request, p.Server, p.Headers[0])
+			break
+		}
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		if k == "" {
+			k = "(none)"
+		}
+		out = append(out, k)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func checkTiming(r *ProbeReport, pages []ProbeResponse, knock ProbeResponse) {
+	var rtts, ttfbs []time.Duration
+	for _, p := range pages {
+		rtts = append(rtts, p.Connect)
+		ttfbs = append(ttfbs, p.TTFB)
+	}
+	rtt, ttfb := median(rtts), median(ttfbs)
+	r.find("info", "timing", "connect %v, first byte of / after %v", rtt.Round(time.Millisecond/10), ttfb.Round(time.Millisecond/10))
+	if ttfb > 3*rtt+50*time.Millisecond {
+		r.find("medium", "timing", "/ takes %v beyond the round trip; a static page comes back in about one", (ttfb - rtt).Round(time.Millisecond))
+	}
+	if knock.Status != http.StatusSwitchingProtocols && knock.TTFB > 2*ttfb+20*time.Millisecond {
+		r.find("medium", "timing", "the tunnel path is refused %v slower than / is served", (knock.TTFB - ttfb).Round(time.Millisecond))
+	}
+}
+
+func median(d []time.Duration) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	s := slices.Clone(d)
+	slices.Sort(s)
+	return s[len(s)/2]
+}
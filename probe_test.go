@@ -0,0 +1,70 @@
+package httpmux
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	tun := startTunnel(t, tunnelOpts{transport: "httpsmux", tls: true})
+	r, err := Probe("https://"+tun.addr+"/", ProbeOptions{Requests: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Responses) != 14 || r.TLS == nil || r.TLS.ALPN != "http/1.1" {
+		t.Fatalf("report %+v", r)
+	}
+	if r.Worst() != "high" {
+		t.Errorf("worst %q", r.Worst())
+	}
+	// The built-in decoy and a bare test server give themselves away.
+	for _, want := range []string{
+		"tls:self-signed certificate",
+		"tls:h2 not offered",
+		"decoy:Server header changes",
+		"tunnel:/search answers a WebSocket upgrade with 101 and then non-WebSocket bytes",
+	} {
+		check, detail, _ := strings.Cut(want, ":")
+		found := false
+		for _, f := range r.Findings {
+			found = found || f.Check == check && strings.HasPrefix(f.Detail, detail)
+		}
+		if !found {
+			t.Errorf("no finding %q in %+v", want, r.Findings)
+		}
+	}
+}
+
+func TestProbeFixedDecoy(t *testing.T) {
+	tun := startTunnel(t, tunnelOpts{server: "decoy_responses:\n  - {status: 404, headers: {Server: nginx}, body: gone}\n"})
+	r, err := Probe("http://"+tun.addr+"/", ProbeOptions{Path: "/nowhere"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range r.Findings {
+		if f.Check == "decoy" && !strings.Contains(f.Detail, "claims nginx but sends") {
+			t.Errorf("finding %+v", f)
+		}
+		if f.Check == "tunnel" {
+			t.Errorf("%s upgraded: %+v", "/nowhere", f)
+		}
+	}
+}
+
+func TestValidWSFrameHead(t *testing.T) {
+	for _, c := range []struct {
+		b0, b1 byte
+		ok     bool
+	}{
+		{0x81, 0x05, true},  // text, final
+		{0x82, 0x7e, true},  // binary, 16-bit length
+		{0x89, 0x00, true},  // ping
+		{0x83, 0x00, false}, // reserved opcode
+		{0xc1, 0x00, false}, // RSV1
+		{0x81, 0x85, false}, // masked from a server
+	} {
+		if validWSFrameHead(c.b0, c.b1) != c.ok {
+			t.Errorf("%#x %#x: want %v", c.b0, c.b1, c.ok)
+		}
+	}
+}
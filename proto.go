@@ -0,0 +1,211 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Protocol version and features
+//
+// The session hello (sessinfo.go) says which protocol version and
+// optional features the client speaks, and the server answers on the
+// same stream with its own:
+//
+//   client → server:  [0x04][2B len]["proto=1\nfeat=compress,datagram,...\n"]
+//   server → client:        [2B len]["proto=1\nfeat=compress,datagram,...\n"]
+//
+// A feature is anything one end may ask of the other that an older or
+// smaller build may not understand: a stream target scheme (udp://,
+// echo://), a scheme flag (tcp+compress-zstd://) or a stream type.
+// Before a stream is opened the opener checks its target against what
+// the peer announced — the server skips sessions whose client lacks a
+// map's features, the client skips sessions whose server can't serve
+// a target — so a mismatch is a clear error instead of a stream the
+// other end misreads.
+//
+// Peers that predate negotiation announce nothing; they are taken to
+// speak legacyFeatures, which is everything that existed then. Once the
+// server has answered, the client also stops guessing at streams with
+// an unknown type byte (handleLegacyStream is only for v2.4 servers).
+//
+// Bump protoVersion only for changes every stream sees (framing, the
+// stream header); add a feature for everything else.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	protoVersion = 1
+
+	protoReplyTimeout = 5 * time.Second
+)
+
+// Features this build speaks, announced in every hello.
+const (
+	featDatagram  = "datagram"  // udp:// streams (datagram.go)
+	featCompress  = "compress"  // +compress- flag (compress.go)
+	featKeep      = "keep"      // +keep flag (idlekeep.go)
+	featWarm      = "warm"      // +warm flag (warmpool.go)
+	featFrom      = "from"      // +from flag (realip.go)
+	featResume    = "resume"    // +resume flag (resume.go)
+	featBond      = "bond"      // bond:// streams (bond.go)
+	featEcho      = "echo"      // echo:// streams (echo.go)
+	featBench     = "bench"     // bench:// and speedtest:// streams
+	featUDPAssoc  = "udpassoc"  // SOCKS5 UDP ASSOCIATE relay (socks5.go)
+	featBreaker   = "breaker"   // breaker:// reports (breaker.go)
+	featDiscovery = "discovery" // discovery:// relay (discovery.go)
+	featMaps      = "maps"      // StreamTypeMaps (pushmaps.go)
+	featPrio      = "prio"      // +prio- flag (qos.go), optional
+	featExpose    = "expose"    // maps requested in the hello (clientmaps.go)
+	featStatus    = "status"    // +status flag (streamlimit.go), optional
+)
+
+// optionalFeatures are flags a peer can do without: they are stripped
+// from targets for peers lacking them instead of ruling the peer out.
+var optionalFeatures = []string{featPrio, featStatus}
+
+// legacyFeatures is what peers that announce no protocol speak:
+// everything that existed before negotiation. Never change it.
+var legacyFeatures = []string{
+	featDatagram, featCompress, featKeep, featWarm, featFrom, featResume, featBond,
+	featEcho, featBench, featUDPAssoc, featBreaker, featDiscovery, featMaps,
+}
+
+// protoFeatures is what this build announces; new features go here.
+var protoFeatures = append(slices.Clone(legacyFeatures), featPrio, featExpose, featStatus)
+
+// supports reports whether the peer that sent si speaks feature f. A
+// nil si (no hello yet) is treated as a legacy peer.
+func (si *sessionInfo) supports(f string) bool {
+	if si == nil || si.Proto == 0 {
+		return slices.Contains(legacyFeatures, f)
+	}
+	return slices.Contains(si.Features, f)
+}
+
+// lacks returns the first feature target needs that the peer doesn't
+// speak, or "".
+func (si *sessionInfo) lacks(target string) string {
+	for _, f := range targetFeatures(target) {
+		if !si.supports(f) && !slices.Contains(optionalFeatures, f) {
+			return f
+		}
+	}
+	return ""
+}
+
+// fitTarget strips the optional flags si's peer doesn't speak.
+func (si *sessionInfo) fitTarget(target string) string {
+	for _, f := range optionalFeatures {
+		if !si.supports(f) {
+			target, _, _ = takeTargetFlag(target, f)
+		}
+	}
+	return target
+}
+
+// targetFeatures lists the features a stream to target relies on.
+func targetFeatures(target string) []string {
+	if strings.HasPrefix(target, bondScheme) {
+		parts := strings.SplitN(strings.TrimPrefix(target, bondScheme), "/", 4)
+		if len(parts) == 4 {
+			return append([]string{featBond}, targetFeatures(parts[3])...)
+		}
+		return []string{featBond}
+	}
+	scheme, _, ok := strings.Cut(target, "://")
+	if !ok {
+		return nil
+	}
+	flags := strings.Split(scheme, "+")
+	var out []string
+	switch flags[0] {
+	case "udp":
+		out = append(out, featDatagram)
+	case "echo":
+		out = append(out, featEcho)
+	case "bench", "speedtest":
+		out = append(out, featBench)
+	case "udpassoc":
+		out = append(out, featUDPAssoc)
+	case "breaker":
+		out = append(out, featBreaker)
+	case "discovery":
+		out = append(out, featDiscovery)
+	}
+	for _, fl := range flags[1:] {
+		// A flag's name is its leading letters: compress-zstd, warm4, resume30.
+		if i := strings.IndexFunc(fl, func(r rune) bool { return r < 'a' || r > 'z' }); i >= 0 {
+			fl = fl[:i]
+		}
+		out = append(out, fl)
+	}
+	return out
+}
+
+// protoAnnounce is this build's side of the exchange.
+func protoAnnounce(si *sessionInfo) {
+	si.Proto = protoVersion
+	si.Features = protoFeatures
+}
+
+// protoString formats what a peer announced for logs and the admin API.
+func (si *sessionInfo) protoString() string {
+	if si == nil || si.Proto == 0 {
+		return "legacy"
+	}
+	return "v" + strconv.Itoa(si.Proto) + " [" + strings.Join(si.Features, ",") + "]"
+}
+
+// ──────────── Server ────────────
+
+// features is what this server announces: protoFeatures, less the
+// services it has switched off.
+func (s *Server) features() []string {
+	if s.discovery != nil {
+		return protoFeatures
+	}
+	return slices.DeleteFunc(slices.Clone(protoFeatures), func(f string) bool { return f == featDiscovery })
+}
+
+// answerHello tells a negotiating client what this server speaks.
+// Clients that predate negotiation close the stream after their hello
+// and never read it.
+func answerHello(stream io.Writer, client *sessionInfo, features, refused []string) {
+	if client.Proto == 0 {
+		return
+	}
+	si := sessionInfo{Proto: protoVersion, Features: features, Refused: refused}
+	payload := si.encode()
+	msg := make([]byte, 2+len(payload))
+	binary.BigEndian.PutUint16(msg[:2], uint16(len(payload)))
+	copy(msg[2:], payload)
+	stream.Write(msg)
+}
+
+// ──────────── Client ────────────
+
+// readHelloAnswer reads the server's answer to our hello. Servers that
+// predate negotiation close the stream without one: cs keeps a legacy
+// (Proto 0) peer.
+func (c *Client) readHelloAnswer(cs *clientSession, stream io.Reader) {
+	peer := &sessionInfo{}
+	defer func() { cs.peer.Store(peer) }()
+	var hdr [2]byte
+	if _, err := io.ReadFull(stream, hdr[:]); err != nil {
+		return
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(stream, payload); err != nil {
+		return
+	}
+	si := parseSessionInfo(payload)
+	peer = &si
+	logRefusedMaps(peer, len(c.exposed))
+	if peer.Proto > protoVersion && c.verbose {
+		logDedupf("proto", "[PROTO] server speaks v%d, this client v%d: newer features stay off", peer.Proto, protoVersion)
+	}
+}
@@ -0,0 +1,169 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Server-pushed map list (server → client)
+//
+// Once a client's session hello says it understands it (push=1), the
+// server opens one StreamTypeMaps stream listing the maps that client
+// serves — untagged maps and maps with its tag:
+//
+//   [0x05][2B len]["tcp 0.0.0.0:443 127.0.0.1:8443 web\nudp 0.0.0.0:53 @dns\n"]
+//
+// one "network bind target [name]" line per map, tls_passthrough and
+// vhosts routes as extra lines. It is sent again to every session whenever a
+// map is added or removed through the admin API.
+//
+// The client logs the list and warns about "@name" targets missing
+// from its services:. With accept_server_maps: true the pushed literal
+// targets also pass the services: whitelist, so adding a map only
+// means editing the server:
+//
+//   # client
+//   services: { web: "127.0.0.1:8443" }
+//   accept_server_maps: true
+// ═══════════════════════════════════════════════════════════════
+
+type pushedMap struct {
+	Network string
+	Bind    string
+	Target  string
+	Name    string
+}
+
+func encodePushedMaps(maps []pushedMap) []byte {
+	var b strings.Builder
+	for _, m := range maps {
+		line := m.Network + " " + m.Bind + " " + m.Target
+		if m.Name != "" {
+			line += " " + m.Name
+		}
+		if b.Len()+len(line)+1 > 0xFFFF {
+			break
+		}
+		b.WriteString(line + "\n")
+	}
+	return []byte(b.String())
+}
+
+func parsePushedMaps(p []byte) []pushedMap {
+	var out []pushedMap
+	for _, line := range strings.Split(string(p), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 || (f[0] != "tcp" && f[0] != "udp") {
+			continue
+		}
+		m := pushedMap{Network: f[0], Bind: f[1], Target: f[2]}
+		if len(f) > 3 {
+			m.Name = f[3]
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// ──────────── Server ────────────
+
+// mapsForTag lists the running maps a client with tag serves.
+func (s *Server) mapsForTag(tag string) []pushedMap {
+	s.mapsMu.Lock()
+	defer s.mapsMu.Unlock()
+	var out []pushedMap
+	for _, am := range s.maps {
+		if am.pm.Tag != "" && am.pm.Tag != tag || isEchoTarget(am.target) {
+			continue
+		}
+		out = append(out, pushedMap{am.network, am.bind, am.target, am.pm.Name})
+		for _, t := range am.pm.TLSPassthrough {
+			out = append(out, pushedMap{am.network, am.bind, t, am.pm.Name})
+		}
+		for _, t := range am.pm.VHosts {
+			out = append(out, pushedMap{am.network, am.bind, t, am.pm.Name})
+		}
+	}
+	slices.SortFunc(out, func(a, b pushedMap) int {
+		return strings.Compare(a.Network+a.Bind+a.Target, b.Network+b.Bind+b.Target)
+	})
+	return out
+}
+
+// pushMaps sends ss the maps its client serves, if it asked for them.
+func (s *Server) pushMaps(ss *serverSession) {
+	si := ss.info.Load()
+	if si == nil || !si.Push || ss.sess.IsClosed() {
+		return
+	}
+	payload := encodePushedMaps(s.mapsForTag(si.Tag))
+	stream, err := ss.sess.OpenStream()
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	stream.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	msg := make([]byte, 3+len(payload))
+	msg[0] = StreamTypeMaps
+	binary.BigEndian.PutUint16(msg[1:3], uint16(len(payload)))
+	copy(msg[3:], payload)
+	stream.Write(msg)
+}
+
+// pushMapsAll re-sends the map list on every session after a change.
+func (s *Server) pushMapsAll() {
+	s.poolMu.RLock()
+	sessions := append([]*serverSession(nil), s.sessions...)
+	s.poolMu.RUnlock()
+	for _, ss := range sessions {
+		go s.pushMaps(ss)
+	}
+}
+
+// ──────────── Client ────────────
+
+// servePushedMaps records the server's map list (type byte already
+// read).
+func (c *Client) servePushedMaps(stream io.Reader) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(stream, hdr[:]); err != nil {
+		return
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(stream, payload); err != nil {
+		return
+	}
+	maps := parsePushedMaps(payload)
+	if old := c.pushed.Swap(&maps); old != nil && slices.Equal(*old, maps) {
+		return // every session gets the same list
+	}
+	lines := make([]string, 0, len(maps))
+	for _, m := range maps {
+		lines = append(lines, m.Network+" "+m.Bind+" → "+m.Target)
+		if name, ok := strings.CutPrefix(m.Target, servicePrefix); ok {
+			if _, known := c.cfg.Services[name]; !known {
+				log.Printf("[PUSH] server map %s %s → %s: no such service in services:", m.Network, m.Bind, m.Target)
+			}
+		}
+	}
+	log.Printf("[PUSH] server maps (%d): %s", len(maps), strings.Join(lines, ", "))
+}
+
+// serverMapTarget reports whether the server pushed a map to addr.
+func (c *Client) serverMapTarget(addr string) bool {
+	p := c.pushed.Load()
+	if p == nil {
+		return false
+	}
+	for _, m := range *p {
+		if m.Target == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,343 @@
+package httpmux
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Per-map bandwidth limits and priority (server, client)
+//
+//   maps:
+//     - { type: tcp, bind: "8080",  target: "...", rate: "20mbit", priority: bulk }
+//     - { type: udp, bind: "27015", target: "...", priority: high }
+//
+// rate caps the map's traffic in each direction, shared by all of its
+// visitors: "20mbit", "500kbit", or bytes per second as "2MB" (see
+// parseByteSize). The server enforces it on the visitor side, so it
+// holds whatever the client runs.
+//
+// priority (high, normal — the default — or bulk) orders the writes
+// of streams that share a session. Both ends pass every stream write
+// through the session's scheduler, which lets qosSlots writes in at a
+// time and, when more wait, picks the next by weighted round robin:
+// 8 high, 4 normal, 1 bulk. A big download on a bulk map then can't
+// fill the session's send queue ahead of a game's packets. Once any
+// map sets a priority the server tags all its reverse streams
+// ("+prio-bulk") so the client schedules its side the same way;
+// clients without the prio feature just don't get the tag.
+//
+// A write waits at most qosMaxWait for its turn, so a stream stuck on
+// a full window can't hold the session up. Turns are qosChunk bytes,
+// but while a higher class waits, lower ones only get qosYield: under
+// congestion a bulk stream gives way after a few KB rather than 16.
+//
+// Streams the client opens take their priority from the routing rule
+// that sent them through the tunnel (routing.go); the server schedules
+// its replies by the tag as well.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	prioHigh   = "high"
+	prioNormal = "normal"
+	prioBulk   = "bulk"
+
+	qosSlots   = 2
+	qosChunk   = 16 * 1024 // bytes written per turn
+	qosYield   = 4 * 1024  // per turn while a higher class waits
+	qosMaxWait = time.Second
+)
+
+// prioClasses in scheduling order, with their weights.
+var (
+	prioClasses = []string{prioHigh, prioNormal, prioBulk}
+	prioWeights = []int{8, 4, 1}
+)
+
+func validPriority(p string) bool {
+	return p == "" || slices.Contains(prioClasses, p)
+}
+
+// parseRate reads a map rate into bytes per second (0 = unlimited).
+func parseRate(s string) (int64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	for unit, mult := range map[string]float64{"kbit": 1e3, "mbit": 1e6, "gbit": 1e9} {
+		if num, ok := strings.CutSuffix(s, unit); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad rate %q (e.g. 20mbit, 2MB)", s)
+			}
+			return int64(n * mult / 8), nil
+		}
+	}
+	return parseByteSize(strings.TrimSuffix(s, "/s"))
+}
+
+// ──────────── Rate limit ────────────
+
+// tokenBucket paces a byte stream to rate; nil means unlimited.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes (stream_rate: streams) per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(float64(rate)/10, qosChunk) // 100ms worth
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take spends n bytes and sleeps off any debt, or returns early when
+// done closes.
+func (b *tokenBucket) take(n int, done <-chan struct{}) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	debt := b.tokens
+	b.mu.Unlock()
+	if debt >= 0 {
+		return
+	}
+	t := time.NewTimer(time.Duration(-debt / b.rate * float64(time.Second)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-done:
+	}
+}
+
+// allow spends n tokens if the bucket holds them, without waiting.
+func (b *tokenBucket) allow(n int) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// chunk is the most a single read or write may move at once.
+func (b *tokenBucket) chunk(n int) int {
+	if b == nil {
+		return n
+	}
+	return min(n, int(b.burst))
+}
+
+// shapedConn paces reads with in and writes with out.
+type shapedConn struct {
+	io.ReadWriteCloser
+	in, out *tokenBucket
+	done    <-chan struct{}
+	packets bool // datagrams: pace but never split
+}
+
+func (c *shapedConn) Read(p []byte) (int, error) {
+	if !c.packets {
+		p = p[:c.in.chunk(len(p))]
+	}
+	n, err := c.ReadWriteCloser.Read(p)
+	c.in.take(n, c.done)
+	return n, err
+}
+
+func (c *shapedConn) Write(p []byte) (int, error) {
+	if c.packets {
+		c.out.take(len(p), c.done)
+		return c.ReadWriteCloser.Write(p)
+	}
+	written := 0
+	for written < len(p) {
+		k := c.out.chunk(len(p) - written)
+		c.out.take(k, c.done)
+		n, err := c.ReadWriteCloser.Write(p[written : written+k])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// mapRate is the running map's pair of buckets (nil = unlimited).
+type mapRate struct {
+	in, out *tokenBucket // visitor → tunnel, tunnel → visitor
+}
+
+func newMapRate(pm *PortMap) *mapRate {
+	rate, _ := parseRate(pm.Rate) // checked by prepareConfig
+	if rate <= 0 {
+		return nil
+	}
+	return &mapRate{in: newTokenBucket(rate), out: newTokenBucket(rate)}
+}
+
+// shape wraps a visitor connection; a nil r returns it as is.
+func (r *mapRate) shape(conn io.ReadWriteCloser, done <-chan struct{}) io.ReadWriteCloser {
+	if r == nil {
+		return conn
+	}
+	return &shapedConn{ReadWriteCloser: conn, in: r.in, out: r.out, done: done}
+}
+
+// mapRate returns the running map's rate limit.
+func (s *Server) mapRate(network, bind string) *mapRate {
+	s.mapsMu.Lock()
+	defer s.mapsMu.Unlock()
+	if am, ok := s.maps[network+":"+bind]; ok {
+		return am.rate
+	}
+	return nil
+}
+
+// ──────────── Priority ────────────
+
+// writeSched orders the stream writes of one session. The zero value
+// is ready to use.
+type writeSched struct {
+	mu      sync.Mutex
+	busy    int // writes in flight
+	waiting [3][]chan struct{}
+	credit  [3]int
+}
+
+func prioClass(p string) int {
+	if i := slices.Index(prioClasses, p); i >= 0 {
+		return i
+	}
+	return 1
+}
+
+// acquire waits for a write turn for class, at most qosMaxWait.
+func (w *writeSched) acquire(class int) {
+	w.mu.Lock()
+	if w.busy < qosSlots && w.queued() == 0 {
+		w.busy++
+		w.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	w.waiting[class] = append(w.waiting[class], ch)
+	w.mu.Unlock()
+
+	t := time.NewTimer(qosMaxWait)
+	defer t.Stop()
+	select {
+	case <-ch:
+	case <-t.C:
+		w.mu.Lock()
+		if i := slices.Index(w.waiting[class], ch); i >= 0 {
+			// Not handed a turn: go anyway, over the slot count.
+			w.waiting[class] = slices.Delete(w.waiting[class], i, i+1)
+			w.busy++
+		}
+		w.mu.Unlock()
+	}
+}
+
+// release hands the turn to the next waiter, by weight.
+func (w *writeSched) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.queued() == 0 {
+		w.busy--
+		return
+	}
+	for {
+		for c := range w.waiting {
+			if len(w.waiting[c]) > 0 && w.credit[c] > 0 {
+				w.credit[c]--
+				next := w.waiting[c][0]
+				w.waiting[c] = w.waiting[c][1:]
+				close(next) // the turn passes on; busy is unchanged
+				return
+			}
+		}
+		copy(w.credit[:], prioWeights)
+	}
+}
+
+func (w *writeSched) queued() int {
+	return len(w.waiting[0]) + len(w.waiting[1]) + len(w.waiting[2])
+}
+
+// turn is how much a write of class may send in its turn.
+func (w *writeSched) turn(class int) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for c := 0; c < class; c++ {
+		if len(w.waiting[c]) > 0 {
+			return qosYield
+		}
+	}
+	return qosChunk
+}
+
+// schedConn writes to a mux stream through its session's scheduler.
+type schedConn struct {
+	net.Conn
+	sched *writeSched
+	class int
+}
+
+func (w *writeSched) wrap(stream net.Conn, prio string) net.Conn {
+	return &schedConn{Conn: stream, sched: w, class: prioClass(prio)}
+}
+
+func (c *schedConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		c.sched.acquire(c.class)
+		k := min(len(p)-written, c.sched.turn(c.class))
+		n, err := c.Conn.Write(p[written : written+k])
+		c.sched.release()
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// prioTarget tags a reverse stream target with the map's priority.
+func prioTarget(target, prio string) string {
+	if prio == "" {
+		prio = prioNormal
+	}
+	return addTargetFlag(target, featPrio+"-"+prio)
+}
+
+// splitPrioTarget strips the priority tag from a stream target.
+func splitPrioTarget(target string) (string, string) {
+	rest, v, ok := takeTargetFlag(target, featPrio+"-")
+	if !ok {
+		return target, ""
+	}
+	return rest, v
+}
@@ -0,0 +1,145 @@
+package httpmux
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for in, want := range map[string]int64{
+		"":        0,
+		"20mbit":  2_500_000,
+		"500kbit": 62_500,
+		"1.5Gbit": 187_500_000,
+		"2MB":     2_000_000,
+		"2MB/s":   2_000_000,
+		"64KiB":   65536,
+		"1000":    1000,
+	} {
+		if got, err := parseRate(in); err != nil || got != want {
+			t.Errorf("parseRate(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"fast", "-1mbit", "10 parsecs"} {
+		if _, err := parseRate(in); err == nil {
+			t.Errorf("parseRate(%q) accepted", in)
+		}
+	}
+}
+
+func TestShapedConnPaces(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	go io.Copy(io.Discard, b)
+	rate := int64(1 << 20) // 1 MiB/s, 100 KiB burst
+	c := &shapedConn{ReadWriteCloser: a, out: newTokenBucket(rate)}
+	start := time.Now()
+	if _, err := c.Write(make([]byte, 400<<10)); err != nil {
+		t.Fatal(err)
+	}
+	// 400 KiB less the burst is 300 KiB of debt: about 0.3s.
+	if took := time.Since(start); took < 250*time.Millisecond || took > 2*time.Second {
+		t.Fatalf("400 KiB at 1 MiB/s took %v", took)
+	}
+}
+
+func TestWriteSchedOrder(t *testing.T) {
+	var w writeSched
+	for i := 0; i < qosSlots; i++ {
+		w.acquire(prioClass(prioNormal)) // every slot busy
+	}
+	order := make(chan string, 8)
+	queue := func(prio string) {
+		go func() {
+			w.acquire(prioClass(prio))
+			order <- prio
+		}()
+		time.Sleep(20 * time.Millisecond) // queued in this order
+	}
+	queue(prioBulk)
+	queue(prioBulk)
+	queue(prioHigh)
+	queue(prioHigh)
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		w.release()
+		got = append(got, <-order)
+	}
+	want := []string{prioHigh, prioHigh, prioBulk, prioBulk}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("turns went %v, want %v", got, want)
+	}
+}
+
+func TestWriteSchedNoStarvation(t *testing.T) {
+	var w writeSched
+	w.acquire(0)
+	w.acquire(0)
+	done := make(chan struct{})
+	go func() {
+		w.acquire(prioClass(prioBulk))
+		close(done)
+	}()
+	select {
+	case <-done: // gone ahead after qosMaxWait though nobody released
+	case <-time.After(qosMaxWait + time.Second):
+		t.Fatal("a waiting write was held past qosMaxWait")
+	}
+}
+
+func TestWriteSchedYield(t *testing.T) {
+	var w writeSched
+	if n := w.turn(prioClass(prioBulk)); n != qosChunk {
+		t.Fatalf("uncontended bulk turn %d, want %d", n, qosChunk)
+	}
+	w.acquire(0)
+	w.acquire(0)
+	go w.acquire(prioClass(prioHigh))
+	time.Sleep(20 * time.Millisecond) // queued
+	if n := w.turn(prioClass(prioBulk)); n != qosYield {
+		t.Errorf("bulk turn %d with high waiting, want %d", n, qosYield)
+	}
+	if n := w.turn(prioClass(prioHigh)); n != qosChunk {
+		t.Errorf("high turn %d, want %d", n, qosChunk)
+	}
+	w.release()
+}
+
+// TestMapQoS runs a rate limited bulk map next to a high priority map
+// over one session.
+func TestMapQoS(t *testing.T) {
+	echo := tcpEcho(t)
+	bulk, high := freeAddr(t), freeAddr(t)
+	startTunnel(t, tunnelOpts{server: fmt.Sprintf("maps:\n"+
+		"  - {type: tcp, bind: %q, target: %q, rate: \"2mbit\", priority: bulk}\n"+
+		"  - {type: tcp, bind: %q, target: %q, priority: high}\n", bulk, echo, high, echo)})
+
+	c := dialMap(t, "tcp", bulk)
+	start := time.Now()
+	checkEcho(t, c, randomBytes(t, 128<<10))
+	// 2mbit is 250 KB/s each way, 25 KB burst: ~0.4s per direction.
+	if took := time.Since(start); took < 400*time.Millisecond {
+		t.Fatalf("128 KiB through a 2mbit map took only %v", took)
+	}
+	checkEcho(t, dialMap(t, "tcp", high), randomBytes(t, 256<<10))
+}
+
+// TestSOCKS5Priority sends SOCKS5 traffic through the tunnel under
+// routing rules with priorities.
+func TestSOCKS5Priority(t *testing.T) {
+	echo := tcpEcho(t)
+	socks := freeAddr(t)
+	startTunnel(t, tunnelOpts{client: fmt.Sprintf("socks5: {listen: %q}\n"+
+		"routing:\n  rules:\n    - {via: tunnel, cidr: \"127.0.0.0/8\", priority: bulk}\n", socks)})
+	waitListening(t, socks)
+	c, err := socksConnect(t, socks, echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	checkEcho(t, c, randomBytes(t, 256<<10))
+}
@@ -0,0 +1,136 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Real visitor address (TCP maps)
+//
+//   maps:
+//     - { type: tcp, bind: "2222", target: "127.0.0.1:22", real_ip: proxy }
+//
+//   real_ip: log        # the client logs who each visitor is
+//   real_ip: proxy      # … and sends a PROXY protocol v1 header to the target
+//   real_ip: proxy_v2   # … binary PROXY protocol v2 header
+//
+// Without it the target only ever sees the client's own address. The
+// server puts the visitor's address and the map's bind address in the
+// stream target as a "from" flag ("tcp+from2-1.2.3.4:5678,10.0.0.1:2222://…");
+// the client strips it, logs it, and for proxy/proxy_v2 writes the
+// header before any visitor bytes so sshd, nginx, HAProxy and the like
+// see the real source for fail2ban, rate limits and geo stats. Only
+// enable proxy/proxy_v2 when the target expects the header.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	realIPLog     = "log"
+	realIPProxy   = "proxy"
+	realIPProxyV2 = "proxy_v2"
+)
+
+func validRealIP(mode string) bool {
+	switch mode {
+	case "", realIPLog, realIPProxy, realIPProxyV2:
+		return true
+	}
+	return false
+}
+
+// fromTarget records the visitor src and the bind dst on a stream target.
+func fromTarget(target, mode string, src, dst net.Addr) string {
+	v := ""
+	switch mode {
+	case realIPProxy:
+		v = "1"
+	case realIPProxyV2:
+		v = "2"
+	}
+	return addTargetFlag(target, "from"+v+"-"+src.String()+","+dst.String())
+}
+
+// splitFromTarget strips the real_ip marker, returning the visitor and
+// bind addresses and the PROXY protocol version (0 = don't send one).
+func splitFromTarget(target string) (rest, src, dst string, proxy int) {
+	rest, v, ok := takeTargetFlag(target, "from")
+	if !ok {
+		return target, "", "", 0
+	}
+	ver, addrs, _ := strings.Cut(v, "-")
+	src, dst, _ = strings.Cut(addrs, ",")
+	switch ver {
+	case "1":
+		proxy = 1
+	case "2":
+		proxy = 2
+	}
+	return rest, src, dst, proxy
+}
+
+// logVisitor reports a real_ip stream on the client.
+func logVisitor(src, addr string) {
+	if src != "" {
+		log.Printf("[REVERSE] %s → %s", src, addr)
+	}
+}
+
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// writeProxyHeader writes a PROXY protocol header for a TCP connection
+// from src to dst. Addresses it can't parse become "UNKNOWN" (v1) or
+// LOCAL (v2), which targets accept and treat as a direct connection.
+func writeProxyHeader(w io.Writer, version int, src, dst string) error {
+	s, serr := netip.ParseAddrPort(src)
+	d, derr := netip.ParseAddrPort(dst)
+	known := serr == nil && derr == nil
+	if known {
+		s = netip.AddrPortFrom(s.Addr().Unmap(), s.Port())
+		d = netip.AddrPortFrom(d.Addr().Unmap(), d.Port())
+		if s.Addr().Is4() != d.Addr().Is4() {
+			// Mixed families: send both as IPv6 (v4-mapped).
+			s = netip.AddrPortFrom(netip.AddrFrom16(s.Addr().As16()), s.Port())
+			d = netip.AddrPortFrom(netip.AddrFrom16(d.Addr().As16()), d.Port())
+		}
+	}
+	if version == 1 {
+		line := "PROXY UNKNOWN\r\n"
+		if known {
+			fam := "TCP4"
+			if !s.Addr().Is4() {
+				fam = "TCP6"
+			}
+			line = fmt.Sprintf("PROXY %s %s %s %d %d\r\n", fam, s.Addr(), d.Addr(), s.Port(), d.Port())
+		}
+		_, err := io.WriteString(w, line)
+		return err
+	}
+
+	b := append([]byte{}, proxyV2Sig...)
+	if !known {
+		b = append(b, 0x20, 0x00, 0, 0) // v2 LOCAL, no address
+		_, err := w.Write(b)
+		return err
+	}
+	var addrs []byte
+	fam := byte(0x11) // TCP over IPv4
+	if s.Addr().Is4() {
+		s4, d4 := s.Addr().As4(), d.Addr().As4()
+		addrs = append(append(addrs, s4[:]...), d4[:]...)
+	} else {
+		fam = 0x21 // TCP over IPv6
+		s16, d16 := s.Addr().As16(), d.Addr().As16()
+		addrs = append(append(addrs, s16[:]...), d16[:]...)
+	}
+	addrs = binary.BigEndian.AppendUint16(addrs, s.Port())
+	addrs = binary.BigEndian.AppendUint16(addrs, d.Port())
+	b = append(b, 0x21, fam) // v2 PROXY
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+	_, err := w.Write(append(b, addrs...))
+	return err
+}
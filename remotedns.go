@@ -0,0 +1,177 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Remote DNS for the SOCKS5 frontend (client)
+//
+//   socks5:
+//     listen: "127.0.0.1:1080"
+//     remote_dns: "127.0.0.1:5300"      # point the apps' DNS here
+//     remote_dns_range: "198.18.0.0/15" # placeholder addresses
+//
+// SOCKS5 already sends domain targets through the tunnel unresolved
+// (socks5h:// in curl terms), so the server resolves them. Apps that
+// resolve names themselves first still ask the local network's DNS,
+// which leaks every name visited and takes poisoned answers.
+//
+// remote_dns answers those lookups without asking anyone: each name
+// gets a placeholder IPv4 address from remote_dns_range (AAAA and
+// other types get an empty answer), and a SOCKS CONNECT or UDP
+// datagram to a placeholder goes into the tunnel as the name, for the
+// server to resolve (resolver.go). Addresses are handed out in turn
+// and reused, oldest first, once the range is used up.
+//
+// UDP replies come back from the real address, which some apps drop.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	remoteDNSRange = "198.18.0.0/15" // RFC 2544, not routed
+	remoteDNSTTL   = 60
+)
+
+type remoteDNS struct {
+	base uint32 // first address of the range
+	size uint32
+
+	mu     sync.Mutex
+	next   uint32 // offset of the next address to hand out
+	byName map[string]netip.Addr
+	byAddr map[netip.Addr]string
+}
+
+func newRemoteDNS(cidr string) (*remoteDNS, error) {
+	if cidr == "" {
+		cidr = remoteDNSRange
+	}
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil || !p.Addr().Is4() || p.Bits() > 24 {
+		return nil, fmt.Errorf("remote_dns_range %q: want an IPv4 range of /24 or larger", cidr)
+	}
+	p = p.Masked()
+	a := p.Addr().As4()
+	return &remoteDNS{
+		base:   binary.BigEndian.Uint32(a[:]),
+		size:   1 << (32 - p.Bits()),
+		next:   1, // skip the network address
+		byName: map[string]netip.Addr{},
+		byAddr: map[netip.Addr]string{},
+	}, nil
+}
+
+// addrFor returns name's placeholder, handing out a new one if needed.
+func (d *remoteDNS) addrFor(name string) netip.Addr {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if a, ok := d.byName[name]; ok {
+		return a
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], d.base+d.next)
+	a := netip.AddrFrom4(b)
+	if d.next++; d.next >= d.size-1 { // and the broadcast address
+		d.next = 1
+	}
+	if old, ok := d.byAddr[a]; ok {
+		delete(d.byName, old)
+	}
+	d.byName[name] = a
+	d.byAddr[a] = name
+	return a
+}
+
+// unmap turns "placeholder:port" back into "name:port"; other
+// addresses, and a nil d, pass through.
+func (d *remoteDNS) unmap(addr string) string {
+	if d == nil {
+		return addr
+	}
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return addr
+	}
+	d.mu.Lock()
+	name, ok := d.byAddr[ap.Addr().Unmap()]
+	d.mu.Unlock()
+	if !ok {
+		return addr
+	}
+	return net.JoinHostPort(name, fmt.Sprint(ap.Port()))
+}
+
+// answer builds the response for one query packet, or nil to drop it.
+func (d *remoteDNS) answer(query []byte) []byte {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil || hdr.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	rh := dnsmessage.Header{ID: hdr.ID, Response: true, OpCode: hdr.OpCode,
+		RecursionDesired: hdr.RecursionDesired, RecursionAvailable: true}
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), rh)
+	b.EnableCompression()
+	if b.StartQuestions() != nil || b.Question(q) != nil || b.StartAnswers() != nil {
+		return nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(q.Name.String()), ".")
+	if q.Class == dnsmessage.ClassINET && q.Type == dnsmessage.TypeA && name != "" {
+		rhdr := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: remoteDNSTTL}
+		b.AResource(rhdr, dnsmessage.AResource{A: d.addrFor(name).As4()})
+	}
+	resp, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+// startRemoteDNS serves socks5.remote_dns until shutdown begins.
+func (c *Client) startRemoteDNS() {
+	cfg := &c.cfg.SOCKS5
+	if cfg.RemoteDNS == "" {
+		return
+	}
+	d, err := newRemoteDNS(cfg.RemoteDNSRange)
+	if err != nil {
+		log.Printf("[SOCKS5] %v — remote_dns off", err)
+		return
+	}
+	pc, err := net.ListenPacket("udp", cfg.RemoteDNS)
+	if err != nil {
+		log.Printf("[SOCKS5] FAILED remote_dns listen %s: %v", cfg.RemoteDNS, err)
+		return
+	}
+	c.life.track(pc)
+	c.remoteDNS = d
+	log.Printf("[SOCKS5] remote_dns %s: names resolve on the server", cfg.RemoteDNS)
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				if c.life.isClosing() {
+					return
+				}
+				continue
+			}
+			if resp := d.answer(buf[:n]); resp != nil {
+				pc.WriteTo(resp, addr)
+			}
+		}
+	}()
+}
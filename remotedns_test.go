@@ -0,0 +1,83 @@
+package httpmux
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func remoteDNSQuery(t *testing.T, d *remoteDNS, name string, typ dnsmessage.Type) []dnsmessage.Resource {
+	t.Helper()
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 7, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET})
+	q, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(d.answer(q)); err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != 7 || m.RCode != dnsmessage.RCodeSuccess {
+		t.Fatalf("%s: id %d rcode %v", name, m.ID, m.RCode)
+	}
+	return m.Answers
+}
+
+func TestRemoteDNSPlaceholders(t *testing.T) {
+	d, err := newRemoteDNS("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ans := remoteDNSQuery(t, d, "Example.COM.", dnsmessage.TypeA)
+	if len(ans) != 1 {
+		t.Fatalf("A: %d answers, want 1", len(ans))
+	}
+	ip := netip.AddrFrom4(ans[0].Body.(*dnsmessage.AResource).A)
+	if !netip.MustParsePrefix(remoteDNSRange).Contains(ip) {
+		t.Fatalf("placeholder %v outside %s", ip, remoteDNSRange)
+	}
+	if again := remoteDNSQuery(t, d, "example.com.", dnsmessage.TypeA); again[0].Body.(*dnsmessage.AResource).A != ip.As4() {
+		t.Fatal("same name got another placeholder")
+	}
+	if ans := remoteDNSQuery(t, d, "example.com.", dnsmessage.TypeAAAA); len(ans) != 0 {
+		t.Fatalf("AAAA: %d answers, want none", len(ans))
+	}
+
+	if got := d.unmap(ip.String() + ":443"); got != "example.com:443" {
+		t.Fatalf("unmap = %q", got)
+	}
+	for _, addr := range []string{"198.18.200.1:443", "10.0.0.1:80", "example.org:80"} {
+		if got := d.unmap(addr); got != addr {
+			t.Fatalf("unmap(%q) = %q, want it unchanged", addr, got)
+		}
+	}
+}
+
+func TestRemoteDNSReuse(t *testing.T) {
+	d, err := newRemoteDNS("10.99.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := d.addrFor("n0.test")
+	for i := 1; i < 254; i++ {
+		d.addrFor(fmt.Sprintf("n%d.test", i))
+	}
+	if got := d.unmap(first.String() + ":1"); got != "n0.test:1" {
+		t.Fatalf("before the range is used up: %q", got)
+	}
+	if a := d.addrFor("n254.test"); a != first {
+		t.Fatalf("reused %v, want the oldest %v", a, first)
+	}
+	if got := d.unmap(first.String() + ":1"); got != "n254.test:1" {
+		t.Fatalf("after reuse: %q", got)
+	}
+	for _, bad := range []string{"10.0.0.0/25", "fd00::/64", "nonsense"} {
+		if _, err := newRemoteDNS(bad); err == nil {
+			t.Errorf("range %q accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package httpmux
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Resolver for target dials (server and client)
+//
+//   resolver:
+//     servers:
+//       - "1.1.1.1"                          # UDP, port 53
+//       - "tcp://9.9.9.9"                    # plain TCP
+//       - "tls://1.1.1.1:853"                # DNS over TLS
+//       - "https://1.1.1.1/dns-query"        # DNS over HTTPS
+//     cache_ttl: 300   # seconds an answer is kept at most; -1 = no cache
+//     timeout: 5       # seconds per query
+//
+// Forward stream targets (server) and reverse stream targets (client)
+// are host names more often than not, and the system resolver of a
+// censored network answers some of them with bogus addresses or not
+// at all. With servers set, those names are asked of the listed
+// resolvers instead, in order until one answers; the addresses are
+// raced like path dials (dialer.go), IPv4 first. The acl: cidr rules
+// see the same addresses.
+//
+// Names without a dot (localhost, container names) still go to the
+// system resolver, which knows /etc/hosts. TLS and HTTPS servers are
+// best given as IP addresses — a name there is looked up by the system
+// resolver — and their certificate is checked against that name or IP.
+// (dns: is the map discovery responder, dns.go.)
+// ═══════════════════════════════════════════════════════════════
+
+type ResolverConfig struct {
+	Servers  []string `yaml:"servers"`
+	CacheTTL int      `yaml:"cache_ttl"` // seconds, 0 = 300, -1 = off
+	Timeout  int      `yaml:"timeout"`   // seconds per query, 0 = 5
+}
+
+const (
+	resolverCacheTTL = 300 * time.Second
+	resolverNegTTL   = 30 * time.Second // NXDOMAIN and empty answers
+	resolverMinTTL   = 5 * time.Second
+	resolverTimeout  = 5 * time.Second
+	resolverCacheMax = 4096
+	resolverUDPSize  = 1232 // EDNS0 buffer, no fragmentation
+)
+
+var errNoSuchHost = errors.New("no such host")
+
+type upstream struct {
+	scheme string // udp, tcp, tls, https
+	addr   string // host:port, or the URL for https
+	name   string // certificate name for tls
+}
+
+type resolverEntry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+type resolver struct {
+	servers []upstream
+	ttl     time.Duration // 0 = no cache
+	timeout time.Duration
+	http    *http.Client
+
+	mu    sync.Mutex
+	cache map[string]resolverEntry
+}
+
+// parseUpstream reads one resolver.servers entry.
+func parseUpstream(s string) (upstream, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		scheme, rest = "udp", s
+	}
+	switch scheme {
+	case "https":
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return upstream{}, fmt.Errorf("%q: bad url", s)
+		}
+		return upstream{scheme: scheme, addr: s}, nil
+	case "udp", "tcp", "tls":
+		port := "53"
+		if scheme == "tls" {
+			port = "853"
+		}
+		host := rest
+		if h, p, err := net.SplitHostPort(rest); err == nil {
+			host, port = h, p
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			return upstream{}, fmt.Errorf("%q: no host", s)
+		}
+		return upstream{scheme: scheme, addr: net.JoinHostPort(host, port), name: host}, nil
+	}
+	return upstream{}, fmt.Errorf("%q: unknown scheme %s (udp, tcp, tls or https)", s, scheme)
+}
+
+// newResolver returns nil unless resolver.servers is set; bad entries
+// are logged and skipped.
+func newResolver(cfg *Config) *resolver {
+	rc := cfg.Resolver
+	r := &resolver{
+		ttl:     resolverCacheTTL,
+		timeout: resolverTimeout,
+		cache:   map[string]resolverEntry{},
+	}
+	for _, s := range rc.Servers {
+		u, err := parseUpstream(strings.TrimSpace(s))
+		if err != nil {
+			log.Printf("[RESOLVER] servers: %v — skipped", err)
+			continue
+		}
+		r.servers = append(r.servers, u)
+	}
+	if len(r.servers) == 0 {
+		return nil
+	}
+	if rc.CacheTTL < 0 {
+		r.ttl = 0
+	} else if rc.CacheTTL > 0 {
+		r.ttl = time.Duration(rc.CacheTTL) * time.Second
+	}
+	if rc.Timeout > 0 {
+		r.timeout = time.Duration(rc.Timeout) * time.Second
+	}
+	r.http = &http.Client{Timeout: r.timeout}
+	return r
+}
+
+func (r *resolver) String() string {
+	names := make([]string, len(r.servers))
+	for i, u := range r.servers {
+		if u.scheme == "https" {
+			names[i] = u.addr
+		} else {
+			names[i] = u.scheme + "://" + u.addr
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func logResolver(r *resolver) {
+	if r == nil {
+		return
+	}
+	cache := "off"
+	if r.ttl > 0 {
+		cache = r.ttl.String()
+	}
+	log.Printf("[RESOLVER] target names via %s (cache %s)", r, cache)
+}
+
+// usesSystem reports whether host is left to the system resolver.
+func usesSystem(host string) bool {
+	return !strings.Contains(strings.TrimSuffix(host, "."), ".")
+}
+
+// dial resolves addr's host through r and races the addresses. A nil
+// r, IP literals and dotless names dial the usual way.
+func (r *resolver) dial(ctx context.Context, network, addr string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if r == nil || err != nil || usesSystem(host) || net.ParseIP(host) != nil {
+		d := net.Dialer{Timeout: timeout}
+		return d.DialContext(ctx, network, addr)
+	}
+	deadline := time.Now().Add(timeout)
+	lctx, cancel := context.WithDeadline(ctx, deadline)
+	ips, err := r.lookup(lctx, host)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", host, err)
+	}
+	var addrs []string
+	for _, ip := range ips {
+		if (strings.HasSuffix(network, "4") && !ip.Is4()) || (strings.HasSuffix(network, "6") && !ip.Is6()) {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("resolve %s: no %s addresses", host, network)
+	}
+	dial := func(ctx context.Context, a string, t time.Duration) (net.Conn, error) {
+		d := net.Dialer{Timeout: t}
+		return d.DialContext(ctx, network, a)
+	}
+	if strings.HasPrefix(network, "udp") {
+		return dial(ctx, addrs[0], timeout) // nothing to race without a handshake
+	}
+	return raceDial(ctx, addrs, deadline, dial)
+}
+
+// lookupIP is for the ACL: r's answer, or the system resolver's when
+// r is nil or host is dotless.
+func (r *resolver) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if r == nil || usesSystem(host) {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		out := make([]net.IP, len(ips))
+		for i, ip := range ips {
+			out[i] = ip.IP
+		}
+		return out, err
+	}
+	addrs, err := r.lookup(ctx, host)
+	out := make([]net.IP, len(addrs))
+	for i, a := range addrs {
+		out[i] = a.AsSlice()
+	}
+	return out, err
+}
+
+// resolveUDP is net.ResolveUDPAddr through r.
+func (r *resolver) resolveUDP(ctx context.Context, addr string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if r == nil || err != nil || usesSystem(host) || net.ParseIP(host) != nil {
+		return net.ResolveUDPAddr("udp", addr)
+	}
+	lctx, cancel := context.WithTimeout(ctx, r.timeout)
+	ips, err := r.lookup(lctx, host)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(ips[0].String(), port))
+}
+
+// lookup returns host's IPv4 then IPv6 addresses, from the cache when
+// they are fresh.
+func (r *resolver) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	key := strings.ToLower(strings.TrimSuffix(host, ".")) + "."
+	now := time.Now()
+	r.mu.Lock()
+	e, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.addrs, e.err
+	}
+
+	type answer struct {
+		addrs []netip.Addr
+		ttl   time.Duration
+		err   error
+	}
+	v4, v6 := make(chan answer, 1), make(chan answer, 1)
+	for _, q := range []struct {
+		t  dnsmessage.Type
+		ch chan answer
+	}{{dnsmessage.TypeA, v4}, {dnsmessage.TypeAAAA, v6}} {
+		go func() {
+			addrs, ttl, err := r.query(ctx, key, q.t)
+			q.ch <- answer{addrs, ttl, err}
+		}()
+	}
+	a4, a6 := <-v4, <-v6
+
+	var addrs []netip.Addr
+	ttl := r.ttl
+	for _, a := range []answer{a4, a6} {
+		if a.err == nil {
+			addrs = append(addrs, a.addrs...)
+			if len(a.addrs) > 0 {
+				ttl = min(ttl, a.ttl)
+			}
+		}
+	}
+	var err error
+	switch {
+	case len(addrs) > 0:
+		ttl = max(ttl, resolverMinTTL)
+	case a4.err != nil && !errors.Is(a4.err, errNoSuchHost):
+		return nil, a4.err // servers unreachable: not cached
+	case a6.err != nil && !errors.Is(a6.err, errNoSuchHost):
+		return nil, a6.err
+	default:
+		err = errNoSuchHost
+		ttl = min(ttl, resolverNegTTL)
+	}
+	if r.ttl > 0 {
+		r.mu.Lock()
+		if len(r.cache) >= resolverCacheMax {
+			for k, old := range r.cache {
+				if now.After(old.expires) {
+					delete(r.cache, k)
+				}
+			}
+			if len(r.cache) >= resolverCacheMax {
+				clear(r.cache)
+			}
+		}
+		r.cache[key] = resolverEntry{addrs: addrs, err: err, expires: now.Add(ttl)}
+		r.mu.Unlock()
+	}
+	return addrs, err
+}
+
+// query asks the servers in order for name's records of type t.
+func (r *resolver) query(ctx context.Context, name string, t dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+	msg, id, err := buildQuery(name, t)
+	if err != nil {
+		return nil, 0, err
+	}
+	var firstErr error
+	for _, u := range r.servers {
+		qctx, cancel := context.WithTimeout(ctx, r.timeout)
+		resp, err := r.exchange(qctx, u, msg)
+		cancel()
+		if err == nil {
+			var addrs []netip.Addr
+			var ttl time.Duration
+			addrs, ttl, err = parseAnswer(resp, id, t)
+			if err == nil || errors.Is(err, errNoSuchHost) {
+				return addrs, ttl, err
+			}
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, firstErr
+}
+
+func buildQuery(name string, t dnsmessage.Type) ([]byte, uint16, error) {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	var idb [2]byte
+	rand.Read(idb[:])
+	id := binary.BigEndian.Uint16(idb[:])
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: n, Type: t, Class: dnsmessage.ClassINET})
+	b.StartAdditionals()
+	var opt dnsmessage.ResourceHeader
+	opt.SetEDNS0(resolverUDPSize, dnsmessage.RCodeSuccess, false)
+	b.OPTResource(opt, dnsmessage.OPTResource{})
+	msg, err := b.Finish()
+	return msg, id, err
+}
+
+// parseAnswer collects the A or AAAA records of resp; CNAMEs are
+// followed by the server.
+func parseAnswer(resp []byte, id uint16, t dnsmessage.Type) ([]netip.Addr, time.Duration, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	if h.ID != id || !h.Response {
+		return nil, 0, fmt.Errorf("mismatched reply")
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, errNoSuchHost
+	default:
+		return nil, 0, fmt.Errorf("server answered %v", h.RCode)
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, 0, err
+	}
+	var addrs []netip.Addr
+	ttl := time.Duration(-1)
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if rh.Type != t || rh.Class != dnsmessage.ClassINET {
+			p.SkipAnswer()
+			continue
+		}
+		var ip netip.Addr
+		if t == dnsmessage.TypeA {
+			a, err := p.AResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ip = netip.AddrFrom4(a.A)
+		} else {
+			a, err := p.AAAAResource()
+			if err != nil {
+				return nil, 0, err
+			}
+			ip = netip.AddrFrom16(a.AAAA)
+		}
+		addrs = append(addrs, ip)
+		if d := time.Duration(rh.TTL) * time.Second; ttl < 0 || d < ttl {
+			ttl = d
+		}
+	}
+	return addrs, max(ttl, 0), nil
+}
+
+// exchange sends msg to u and returns the reply.
+func (r *resolver) exchange(ctx context.Context, u upstream, msg []byte) ([]byte, error) {
+	switch u.scheme {
+	case "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.addr, bytes.NewReader(msg))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+		resp, err := r.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", u.addr, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 65535))
+	case "udp":
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "udp", u.addr)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if dl, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(dl)
+		}
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, resolverUDPSize)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// TC bit: the answer didn't fit, ask again over TCP.
+		if n >= 3 && buf[2]&0x02 != 0 {
+			return r.exchange(ctx, upstream{scheme: "tcp", addr: u.addr}, msg)
+		}
+		return buf[:n], nil
+	}
+
+	var conn net.Conn
+	var err error
+	if u.scheme == "tls" {
+		d := tls.Dialer{Config: &tls.Config{ServerName: u.name, MinVersion: tls.VersionTLS12}}
+		conn, err = d.DialContext(ctx, "tcp", u.addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", u.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+	framed := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(msg)), uint16(len(msg)))
+	if _, err := conn.Write(append(framed, msg...)); err != nil {
+		return nil, err
+	}
+	var lb [2]byte
+	if _, err := io.ReadFull(conn, lb[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(lb[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,176 @@
+package httpmux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsStub answers A queries for echo.test. with 127.0.0.1, AAAA with
+// nothing and everything else with NXDOMAIN, over UDP and TCP on the
+// same port.
+type dnsStub struct {
+	addr    string
+	queries atomic.Int64
+}
+
+func startDNSStub(t *testing.T) *dnsStub {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Skipf("tcp port of the stub taken: %v", err)
+	}
+	t.Cleanup(func() { pc.Close(); ln.Close() })
+	d := &dnsStub{addr: pc.LocalAddr().String()}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := d.answer(buf[:n]); resp != nil {
+				pc.WriteTo(resp, from)
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var lb [2]byte
+				if _, err := io.ReadFull(c, lb[:]); err != nil {
+					return
+				}
+				q := make([]byte, binary.BigEndian.Uint16(lb[:]))
+				if _, err := io.ReadFull(c, q); err != nil {
+					return
+				}
+				resp := d.answer(q)
+				c.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			}()
+		}
+	}()
+	return d
+}
+
+func (d *dnsStub) answer(q []byte) []byte {
+	d.queries.Add(1)
+	var p dnsmessage.Parser
+	h, err := p.Start(q)
+	if err != nil {
+		return nil
+	}
+	question, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	rh := dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true}
+	known := question.Name.String() == "echo.test."
+	if !known {
+		rh.RCode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, rh)
+	b.StartQuestions()
+	b.Question(question)
+	b.StartAnswers()
+	if known && question.Type == dnsmessage.TypeA {
+		b.AResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60},
+			dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})
+	}
+	resp, _ := b.Finish()
+	return resp
+}
+
+func TestParseUpstream(t *testing.T) {
+	for in, want := range map[string]upstream{
+		"1.1.1.1":                   {scheme: "udp", addr: "1.1.1.1:53", name: "1.1.1.1"},
+		"tcp://9.9.9.9:5353":        {scheme: "tcp", addr: "9.9.9.9:5353", name: "9.9.9.9"},
+		"tls://1.1.1.1":             {scheme: "tls", addr: "1.1.1.1:853", name: "1.1.1.1"},
+		"tls://[2606:4700::1111]":   {scheme: "tls", addr: "[2606:4700::1111]:853", name: "2606:4700::1111"},
+		"https://1.1.1.1/dns-query": {scheme: "https", addr: "https://1.1.1.1/dns-query"},
+	} {
+		got, err := parseUpstream(in)
+		if err != nil || got != want {
+			t.Errorf("parseUpstream(%q) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"quic://1.1.1.1", "https:///dns-query", "tcp://"} {
+		if _, err := parseUpstream(in); err == nil {
+			t.Errorf("parseUpstream(%q) accepted", in)
+		}
+	}
+}
+
+func TestResolverLookup(t *testing.T) {
+	stub := startDNSStub(t)
+	for _, scheme := range []string{"udp", "tcp"} {
+		t.Run(scheme, func(t *testing.T) {
+			r := newResolver(&Config{Resolver: ResolverConfig{Servers: []string{scheme + "://" + stub.addr}}})
+			ctx := context.Background()
+			ips, err := r.lookup(ctx, "echo.test")
+			if err != nil || len(ips) != 1 || ips[0].String() != "127.0.0.1" {
+				t.Fatalf("lookup = %v, %v; want [127.0.0.1]", ips, err)
+			}
+			before := stub.queries.Load()
+			if _, err := r.lookup(ctx, "ECHO.test."); err != nil {
+				t.Fatal(err)
+			}
+			if n := stub.queries.Load() - before; n != 0 {
+				t.Fatalf("cached name asked again (%d queries)", n)
+			}
+			if _, err := r.lookup(ctx, "missing.test"); !errors.Is(err, errNoSuchHost) {
+				t.Fatalf("missing name: err = %v, want %v", err, errNoSuchHost)
+			}
+		})
+	}
+}
+
+func TestResolverFailsOver(t *testing.T) {
+	stub := startDNSStub(t)
+	dead := freeAddr(t) // nothing listens: the TCP query is refused
+	r := newResolver(&Config{Resolver: ResolverConfig{
+		Servers: []string{"tcp://" + dead, stub.addr},
+		Timeout: 2,
+	}})
+	if ips, err := r.lookup(context.Background(), "echo.test"); err != nil || len(ips) != 1 {
+		t.Fatalf("lookup = %v, %v", ips, err)
+	}
+}
+
+func TestResolverDial(t *testing.T) {
+	stub := startDNSStub(t)
+	echo := tcpEcho(t)
+	_, port, _ := net.SplitHostPort(echo)
+
+	life := newLifecycle()
+	life.resolver = newResolver(&Config{Resolver: ResolverConfig{Servers: []string{stub.addr}}})
+	c, err := life.dial("tcp", net.JoinHostPort("echo.test", port), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	checkEcho(t, c, []byte("through the configured resolver"))
+
+	_, err = life.dial("tcp", net.JoinHostPort("missing.test", port), 5*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "no such host") {
+		t.Fatalf("dial of an unknown name: err = %v", err)
+	}
+}
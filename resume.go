@@ -0,0 +1,110 @@
+package httpmux
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Resumable streams (TCP maps, server → client)
+//
+//   maps:
+//     - { type: tcp, bind: "2222", target: "127.0.0.1:22", resume: 60 }
+//
+// Normally a visitor's connection dies with the session carrying it.
+// With resume, the visitor is carried like a one-wide bond (bond.go):
+// data travels in sequence-numbered frames that stay buffered until
+// the other end acknowledges them, and the bond id doubles as the
+// resume token. When the session dies the visitor and the target stay
+// connected; the server reopens the stream on the next session that
+// comes up, tagged with the same id, the client attaches it to the
+// waiting bond, and both ends re-send whatever the other hasn't
+// acknowledged. Past `resume` seconds without a session the
+// connection is dropped as before.
+//
+// A dead session is noticed by mux keepalive or a stalled write, so
+// set resume above session_timeout. Works together with bond:;
+// idle_keep, warm_pool and compress don't apply to resumable maps.
+// ═══════════════════════════════════════════════════════════════
+
+const maxResume = 3600 // seconds
+
+// resumeTarget marks a bond's inner target as resumable.
+func resumeTarget(target string, window time.Duration) string {
+	return addTargetFlag(target, "resume"+strconv.Itoa(int(window/time.Second)))
+}
+
+// splitResumeTarget strips the resume marker and returns its window.
+func splitResumeTarget(target string) (string, time.Duration) {
+	rest, v, ok := takeTargetFlag(target, "resume")
+	if !ok {
+		return target, 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return rest, 0
+	}
+	return rest, time.Duration(min(n, maxResume)) * time.Second
+}
+
+// startResume keeps b open for a new sub-stream after the last one
+// died. Caller holds b.mu.
+func (b *bondConn) startResume() {
+	b.lostGen++
+	gen := b.lostGen
+	time.AfterFunc(b.resume, func() {
+		b.mu.Lock()
+		expired := b.alive == 0 && b.lostGen == gen
+		b.mu.Unlock()
+		if expired {
+			logDedupf("resume", "[RESUME] no new session within %v, dropping stream", b.resume)
+			b.fail(io.ErrUnexpectedEOF)
+		}
+	})
+	select {
+	case b.lost <- struct{}{}:
+	default:
+	}
+}
+
+func (b *bondConn) aliveCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.alive
+}
+
+// resumeBond reopens b's stream on a new session each time every
+// sub-stream is lost, until b closes. Rejoining sub-streams are
+// numbered from width up so the client never starts a bond from one.
+func (s *Server) resumeBond(b *bondConn, id, target, tag string, width int) {
+	next := width
+	for {
+		select {
+		case <-b.lost:
+		case <-b.done:
+			return
+		}
+		lostAt := time.Now()
+		for b.aliveCount() == 0 && !b.closed() {
+			wake := s.sessionSignal()
+			stream, ss, err := s.openReverseStream(bondHeader(id, next, width, target), tag)
+			if err == nil {
+				next++
+				b.add(&closeHook{ReadWriteCloser: stream, fn: func() { atomic.AddInt64(&ss.streams, -1) }})
+				if s.Verbose {
+					log.Printf("[RESUME] %s resumed after %v", id, time.Since(lostAt).Round(time.Millisecond))
+				}
+				break
+			}
+			select {
+			case <-wake:
+			case <-time.After(time.Second):
+			case <-b.done:
+				return
+			}
+		}
+	}
+}
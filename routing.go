@@ -0,0 +1,392 @@
+package httpmux
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Split routing for the SOCKS5 and tproxy frontends (client)
+//
+//   routing:
+//     default: tunnel                   # tunnel (default) | direct
+//     geoip: "/etc/picotun/geoip.csv"   # for geoip: rules
+//     resolve: false                    # see below
+//     rules:
+//       - { via: direct, domain: ".ir" }
+//       - { via: direct, geoip: "IR" }
+//       - { via: direct, cidr: "192.168.0.0/16" }
+//       - { via: tunnel, ports: "25" }
+//       - { via: tunnel, domain: ".bank.example", fallback: closed }
+//       - { via: tunnel, ports: "22", priority: high }
+//     fallback: closed                  # closed (default) | direct
+//     fallback_grace: 5                 # seconds
+//
+// Decides per SOCKS5 or tproxy connection (and per SOCKS5 UDP datagram)
+// whether it goes through the tunnel or straight out of the client's
+// own interface, so domestic sites don't take the round trip abroad.
+// Rules are the acl: rules (acl.go) with via instead of action and a
+// geoip country code; the first match decides.
+//
+// cidr and geoip rules only see IP targets: apps using socks5h:// and
+// remote_dns (remotedns.go) send names, and looking those up here
+// would leak them to the local DNS. resolve: true does look them up
+// (through resolver:, resolver.go) — for setups that don't mind.
+//
+// fallback is what happens to tunnel traffic while no session is up:
+// it waits fallback_grace seconds for one, then is refused (closed)
+// or dialed directly (direct — fail open; counted as errors["fallback"]
+// and shown as direct in the stats). A rule's own fallback overrides
+// it. UDP associations opened while the tunnel is down stay direct.
+//
+// A tunnel rule's priority (high, normal, bulk) tags the streams it
+// opens, the way a map's does (qos.go): both ends schedule their
+// writes by it, so an ssh session keeps its latency under a download.
+//
+// The geoip file is CSV, one range per line, as "start,end,CC" (the
+// db-ip.com and IP2Location lite country files, the latter with
+// decimal IPv4 numbers) or "cidr,CC". Only the countries used by the
+// rules are kept in memory.
+// ═══════════════════════════════════════════════════════════════
+
+type RoutingConfig struct {
+	Default       string        `yaml:"default"`
+	GeoIP         string        `yaml:"geoip"`
+	Resolve       bool          `yaml:"resolve"`
+	Rules         []RoutingRule `yaml:"rules"`
+	Fallback      string        `yaml:"fallback"`       // closed | direct
+	FallbackGrace int           `yaml:"fallback_grace"` // seconds
+}
+
+type RoutingRule struct {
+	Via    string `yaml:"via"` // tunnel | direct
+	CIDR   string `yaml:"cidr"`
+	Domain string `yaml:"domain"`
+	Ports  string `yaml:"ports"`
+	GeoIP  string `yaml:"geoip"` // country code
+
+	Fallback string `yaml:"fallback"` // "" = routing.fallback
+	Priority string `yaml:"priority"` // high | normal | bulk
+}
+
+type routeRule struct {
+	aclRule         // allow = direct
+	country  string // "" = any
+	failOpen *bool  // nil = the router's
+	prio     string
+}
+
+// routeResult is where one connection goes.
+type routeResult struct {
+	direct   bool
+	failOpen bool   // may go direct while the tunnel is down
+	prio     string // stream priority through the tunnel
+}
+
+type router struct {
+	direct   bool // default
+	failOpen bool // dial tunnel traffic directly while no session is up
+	grace    time.Duration
+	rules    []routeRule
+	geo      *geoDB
+	needsIP  bool // resolve names for cidr/geoip rules
+	resolver *resolver
+}
+
+// newRouter compiles cfg and loads its geoip file. A nil *router
+// sends everything through the tunnel.
+func newRouter(cfg *RoutingConfig, res *resolver) (*router, error) {
+	r, err := compileRouting(cfg)
+	if r == nil || err != nil {
+		return nil, err
+	}
+	r.resolver = res
+	countries := map[string]bool{}
+	for _, rule := range r.rules {
+		if rule.country != "" {
+			countries[rule.country] = true
+		}
+	}
+	if len(countries) > 0 {
+		if r.geo, err = loadGeoIP(cfg.GeoIP, countries); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// compileRouting checks and compiles the rules, without the geoip file.
+func compileRouting(cfg *RoutingConfig) (*router, error) {
+	r := &router{}
+	switch strings.ToLower(strings.TrimSpace(cfg.Default)) {
+	case "", "tunnel":
+	case "direct":
+		r.direct = true
+	default:
+		return nil, fmt.Errorf("default %q: want tunnel or direct", cfg.Default)
+	}
+	var err error
+	if r.failOpen, err = parseFallback(cfg.Fallback); err != nil {
+		return nil, err
+	}
+	if cfg.FallbackGrace < 0 {
+		return nil, fmt.Errorf("fallback_grace %d: want seconds >= 0", cfg.FallbackGrace)
+	}
+	r.grace = time.Duration(cfg.FallbackGrace) * time.Second
+	if len(cfg.Rules) == 0 && !r.direct && !r.failOpen && r.grace == 0 {
+		return nil, nil
+	}
+	acfg := ACLConfig{Default: "deny"}
+	usesIP, usesGeo := false, false
+	for i, rule := range cfg.Rules {
+		action := "deny"
+		switch strings.ToLower(strings.TrimSpace(rule.Via)) {
+		case "direct":
+			action = "allow"
+		case "tunnel":
+		default:
+			return nil, fmt.Errorf("rule %d: via %q: want tunnel or direct", i+1, rule.Via)
+		}
+		acfg.Rules = append(acfg.Rules, ACLRule{Action: action, CIDR: rule.CIDR, Domain: rule.Domain, Ports: rule.Ports})
+		geo := strings.TrimSpace(rule.GeoIP) != ""
+		usesGeo = usesGeo || geo
+		usesIP = usesIP || geo || strings.TrimSpace(rule.CIDR) != ""
+	}
+	if usesGeo && cfg.GeoIP == "" {
+		return nil, fmt.Errorf("geoip rules need routing.geoip")
+	}
+	compiled, err := newACL(&acfg)
+	if err != nil {
+		return nil, err
+	}
+	for i, ar := range compiled.rules {
+		rr := routeRule{aclRule: ar, country: strings.ToUpper(strings.TrimSpace(cfg.Rules[i].GeoIP))}
+		rr.prio = strings.ToLower(strings.TrimSpace(cfg.Rules[i].Priority))
+		if !validPriority(rr.prio) {
+			return nil, fmt.Errorf("rule %d: priority %q: want high, normal or bulk", i+1, cfg.Rules[i].Priority)
+		}
+		if f := cfg.Rules[i].Fallback; strings.TrimSpace(f) != "" {
+			open, err := parseFallback(f)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %v", i+1, err)
+			}
+			rr.failOpen = &open
+		}
+		r.rules = append(r.rules, rr)
+	}
+	r.needsIP = usesIP && cfg.Resolve
+	return r, nil
+}
+
+func parseFallback(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "closed":
+		return false, nil
+	case "direct":
+		return true, nil
+	}
+	return false, fmt.Errorf("fallback %q: want closed or direct", s)
+}
+
+// route decides where addr ("host:port") goes.
+func (r *router) route(ctx context.Context, addr string) routeResult {
+	if r == nil {
+		return routeResult{}
+	}
+	h, ps, err := net.SplitHostPort(addr)
+	if err != nil {
+		return routeResult{}
+	}
+	port, _ := strconv.Atoi(ps)
+	host := strings.ToLower(strings.TrimSuffix(h, "."))
+	ip := net.ParseIP(h)
+	if ip != nil {
+		host = ""
+	} else if r.needsIP {
+		lctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		if ips, err := r.resolver.lookupIP(lctx, host); err == nil && len(ips) > 0 {
+			ip = ips[0]
+		}
+		cancel()
+	}
+	for i := range r.rules {
+		rule := &r.rules[i]
+		if !rule.matches(host, ip, port) {
+			continue
+		}
+		if rule.country != "" && (ip == nil || r.geo.country(ip) != rule.country) {
+			continue
+		}
+		res := routeResult{direct: rule.allow, failOpen: r.failOpen, prio: rule.prio}
+		if rule.failOpen != nil {
+			res.failOpen = *rule.failOpen
+		}
+		return res
+	}
+	return routeResult{direct: r.direct, failOpen: r.failOpen}
+}
+
+// mayFailOpen reports whether any traffic falls back to direct.
+func (r *router) mayFailOpen() bool {
+	if r == nil {
+		return false
+	}
+	for _, rule := range r.rules {
+		if rule.failOpen != nil && *rule.failOpen {
+			return true
+		}
+	}
+	return r.failOpen
+}
+
+// fallbackGrace is how long tunnel traffic waits for a session.
+func (r *router) fallbackGrace() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.grace
+}
+
+// waitSession waits up to d for a session, false if none came up.
+func (c *Client) waitSession(d time.Duration) bool {
+	deadline := time.Now().Add(d)
+	for c.sessionCount() == 0 {
+		if !time.Now().Before(deadline) || !c.life.sleep(100*time.Millisecond) {
+			return false
+		}
+	}
+	return true
+}
+
+func logRouter(r *router) {
+	if r == nil {
+		return
+	}
+	def := "tunnel"
+	if r.direct {
+		def = "direct"
+	}
+	geo := ""
+	if r.geo != nil {
+		geo = fmt.Sprintf(", %d geoip ranges", len(r.geo.ranges))
+	}
+	fallback := "closed"
+	if r.failOpen {
+		fallback = "direct"
+	}
+	log.Printf("[ROUTE] %d rule(s), default %s%s, fallback %s after %v", len(r.rules), def, geo, fallback, r.grace)
+}
+
+// ──────────── GeoIP ranges ────────────
+
+type geoRange struct {
+	lo, hi netip.Addr
+	cc     string
+}
+
+type geoDB struct {
+	ranges []geoRange // sorted by lo, not overlapping
+}
+
+// loadGeoIP reads the ranges of the wanted countries from path.
+func loadGeoIP(path string, want map[string]bool) (*geoDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+	defer f.Close()
+	g := &geoDB{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		for i := range fields {
+			fields[i] = strings.Trim(strings.TrimSpace(fields[i]), `"`)
+		}
+		var gr geoRange
+		var ok bool
+		switch {
+		case len(fields) == 2:
+			gr, ok = geoPrefix(fields[0], fields[1])
+		case len(fields) >= 3:
+			gr.lo, ok = geoAddr(fields[0])
+			if ok {
+				gr.hi, ok = geoAddr(fields[1])
+			}
+			gr.cc = fields[2]
+		}
+		gr.cc = strings.ToUpper(gr.cc)
+		if !ok || !want[gr.cc] || gr.lo.Is4() != gr.hi.Is4() || gr.hi.Less(gr.lo) {
+			continue // header lines, other countries
+		}
+		g.ranges = append(g.ranges, gr)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+	slices.SortFunc(g.ranges, func(a, b geoRange) int { return a.lo.Compare(b.lo) })
+	return g, nil
+}
+
+func geoPrefix(cidr, cc string) (geoRange, bool) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return geoRange{}, false
+	}
+	p = p.Masked()
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	hi, _ := netip.AddrFromSlice(b)
+	return geoRange{lo: p.Addr(), hi: hi, cc: cc}, true
+}
+
+// geoAddr parses an address or a decimal IPv4 number.
+func geoAddr(s string) (netip.Addr, bool) {
+	if a, err := netip.ParseAddr(s); err == nil {
+		return a.Unmap(), true
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	return netip.AddrFrom4(b), true
+}
+
+// country returns the code of the range holding ip, or "".
+func (g *geoDB) country(ip net.IP) string {
+	a, ok := netip.AddrFromSlice(ip)
+	if g == nil || !ok {
+		return ""
+	}
+	a = a.Unmap()
+	i, _ := slices.BinarySearchFunc(g.ranges, a, func(r geoRange, a netip.Addr) int {
+		if r.lo.Compare(a) <= 0 {
+			return -1
+		}
+		return 1
+	})
+	if i == 0 {
+		return ""
+	}
+	if r := g.ranges[i-1]; r.lo.Is4() == a.Is4() && a.Compare(r.hi) <= 0 {
+		return r.cc
+	}
+	return ""
+}
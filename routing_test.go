@@ -0,0 +1,121 @@
+package httpmux
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeoIPFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	csv := `# ranges
+ip_start,ip_end,country
+2.144.0.0,2.147.255.255,IR
+"16777216","16777471","AU","Australia"
+5.56.0.0/16,DE
+2a01:5ec0::/29,IR
+not,a,range
+`
+	if err := os.WriteFile(path, []byte(csv), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	g, err := loadGeoIP(path, map[string]bool{"IR": true, "AU": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.ranges) != 3 {
+		t.Fatalf("%d ranges kept, want 3 (DE isn't used)", len(g.ranges))
+	}
+	for ip, want := range map[string]string{
+		"2.144.0.1":        "IR",
+		"2.147.255.255":    "IR",
+		"2.148.0.0":        "",
+		"1.0.0.200":        "AU",
+		"5.56.1.1":         "",
+		"2a01:5ec0::1":     "IR",
+		"2a01:5ec8::1":     "",
+		"::ffff:2.145.0.9": "IR",
+	} {
+		if got := g.country(net.ParseIP(ip)); got != want {
+			t.Errorf("country(%s) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+func TestRouterRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	if err := os.WriteFile(path, []byte("2.144.0.0/14,IR\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newRouter(&RoutingConfig{
+		GeoIP: path,
+		Rules: []RoutingRule{
+			{Via: "tunnel", Ports: "25"},
+			{Via: "direct", Domain: ".ir"},
+			{Via: "direct", GeoIP: "ir"},
+			{Via: "direct", CIDR: "192.168.0.0/16"},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for addr, direct := range map[string]bool{
+		"digikala.ir:443":    true,
+		"mail.example.ir:25": false, // port rule first
+		"2.146.10.1:443":     true,
+		"192.168.1.5:80":     true,
+		"8.8.8.8:53":         false,
+		"example.com:443":    false, // names don't meet ip rules without resolve
+	} {
+		if got := r.route(ctx, addr).direct; got != direct {
+			t.Errorf("route(%s) direct = %v, want %v", addr, got, direct)
+		}
+	}
+
+	r, err = newRouter(&RoutingConfig{
+		Fallback: "direct",
+		Rules:    []RoutingRule{{Via: "tunnel", Domain: ".bank.example", Fallback: "closed"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.route(ctx, "www.bank.example:443").failOpen {
+		t.Error("fallback: closed rule fails open")
+	}
+	if !r.route(ctx, "example.com:443").failOpen {
+		t.Error("routing.fallback: direct doesn't fail open")
+	}
+
+	r, err = newRouter(&RoutingConfig{
+		Rules: []RoutingRule{{Via: "tunnel", Ports: "22", Priority: "high"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := r.route(ctx, "git.example:22").prio; p != prioHigh {
+		t.Errorf("port 22 priority %q, want high", p)
+	}
+	if p := r.route(ctx, "git.example:443").prio; p != "" {
+		t.Errorf("unmatched priority %q, want none", p)
+	}
+
+	if r, err := newRouter(&RoutingConfig{}, nil); r != nil || err != nil {
+		t.Fatalf("empty routing: %v, %v; want nil (all through the tunnel)", r, err)
+	}
+	for _, bad := range []RoutingConfig{
+		{Default: "sideways"},
+		{Rules: []RoutingRule{{Via: "around"}}},
+		{Rules: []RoutingRule{{Via: "direct", GeoIP: "IR"}}}, // no geoip file
+		{Rules: []RoutingRule{{Via: "direct", CIDR: "300.0.0.0/8"}}},
+		{Fallback: "maybe"},
+		{FallbackGrace: -1},
+		{Rules: []RoutingRule{{Via: "tunnel", Priority: "urgent"}}},
+	} {
+		if _, err := compileRouting(&bad); err == nil {
+			t.Errorf("%+v accepted", bad)
+		}
+	}
+}
@@ -1,13 +1,18 @@
 package httpmux
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -33,6 +38,9 @@ import (
 const (
 	StreamTypeForward byte = 0x01 // client→server initiated (forward proxy)
 	StreamTypeReverse byte = 0x02 // server→client initiated (port mapping)
+	StreamTypePing    byte = 0x03 // either direction: tunnel RTT probe (ping.go)
+	StreamTypeHello   byte = 0x04 // client→server: session info, e.g. tag (sessinfo.go)
+	StreamTypeMaps    byte = 0x05 // server→client: the maps it serves (pushmaps.go)
 )
 
 type Server struct {
@@ -42,43 +50,171 @@ type Server struct {
 	PSK     string
 	Verbose bool
 
-	poolMu   sync.RWMutex
-	sessions []*serverSession
-	poolIdx  uint64
+	creds          []authCredential
+	stats          *Stats
+	life           *lifecycle
+	tlsConfig      *tls.Config      // nil = plain HTTP
+	discovery      *discoveryRelay  // nil = discovery relay off
+	encModes       []byte           // accepted encryption modes
+	site           *decoySite       // nil = random error pages
+	upstream       http.Handler     // decoy_upstream; nil = site or error pages
+	decoyTemplates []*decoyTemplate // decoy_responses; nil = built-in error pages
+	xhttp          *xhttpPairs      // nil unless transport is xhttpmux/xhttpsmux
+	probes         *probeTracker
+	breakers       *breakerBoard
+	acl            *acl
+	sticky         *stickyTable
+	usage          *usageFile  // nil = no accounting.file
+	state          *stateStore // nil = no state.path
+	tracer         *tracer     // nil = no tracing.endpoint
+	sockets        *socketOpts // nil = default tunnel sockets
+
+	clientMaps   *clientMapPolicy // nil = clients may not open maps
+	clientMapsMu sync.Mutex
+
+	mapsMu sync.Mutex
+	maps   map[string]*activeMap // "tcp:0.0.0.0:80" → running map
+
+	nextSessionID uint64
+	held          int64        // atomic: visitors waiting in holdForSession
+	conns         *connLimiter // advanced.max_connections
+	fds           *connLimiter // advanced.fd_budget (streamlimit.go)
+	hop           *hopSchedule // nil = fixed listen ports
+	sd            *systemd     // nil = not started by systemd (Type=notify)
+	portsPending  int32        // atomic: listen ports not bound yet
+	prioritized   atomic.Bool  // some map has a priority (qos.go)
+
+	poolMu      sync.RWMutex
+	sessions    []*serverSession
+	poolIdx     uint64
+	sessionUp   chan struct{}   // closed and replaced by signalSession
+	warmClients map[string]bool // client ids that reached min_sessions
 }
 
 type serverSession struct {
-	sess    *smux.Session
+	id      uint64
+	sess    muxSession
 	remote  string
+	user    string // authenticated user ("" = shared psk)
+	nonce   []byte // auth nonce, ties stream spans to the client's (tracing.go)
 	created time.Time
-	streams int64 // atomic: active stream count
+	streams int64                       // atomic: active stream count
+	rtt     int64                       // atomic: smoothed ping RTT in ns, 0 = not measured
+	pings   int32                       // atomic: 1 once the client has pinged us
+	info    atomic.Pointer[sessionInfo] // from the client's hello, nil until then
+	ready   atomic.Bool                 // client reached its min_sessions
+	traffic mapStats                    // relayed conns and bytes (accounting.go)
+	sched   writeSched                  // stream write order by map priority (qos.go)
+	opens   *tokenBucket                // stream_rate, nil = unlimited (streamlimit.go)
 }
 
 func NewServer(cfg *Config) *Server {
-	return &Server{
-		Config:  cfg,
-		Mimic:   &cfg.Mimic,
-		Obfs:    &cfg.Obfs,
-		PSK:     cfg.PSK,
-		Verbose: cfg.Verbose,
+	probes := newProbeTracker()
+	rules, err := newACL(&cfg.ACL)
+	if err != nil {
+		log.Printf("[ACL] %v — denying all forward streams", err)
+		rules = &acl{}
+	}
+	res := newResolver(cfg)
+	if rules != nil {
+		rules.resolver = res
+	}
+	var site *decoySite
+	if cfg.DecoySite.Enabled {
+		site = newDecoySite(cfg, probes)
+	}
+	s := &Server{
+		Config:      cfg,
+		Mimic:       &cfg.Mimic,
+		Obfs:        &cfg.Obfs,
+		PSK:         cfg.PSK,
+		Verbose:     cfg.Verbose,
+		creds:       buildCredentials(cfg),
+		encModes:    serverEncModes(cfg),
+		site:        site,
+		probes:      probes,
+		breakers:    newBreakerBoard(),
+		acl:         rules,
+		sticky:      newStickyTable(),
+		maps:        map[string]*activeMap{},
+		stats:       NewStats(),
+		sessionUp:   make(chan struct{}),
+		warmClients: map[string]bool{},
+		conns:       newConnLimiter(cfg.Advanced.MaxConnections),
+		fds:         newConnLimiter(cfg.Advanced.FDBudget),
+		life:        newLifecycle(),
+		sd:          newSystemd(),
+	}
+	s.life.resolver = res
+	s.usage = newUsageFile(cfg, s.stats)
+	s.state = openStateStore(cfg, s.stats)
+	s.tracer = newTracer(cfg, s.stats)
+	if isXHTTP(cfg.Transport) {
+		s.xhttp = newXHTTPPairs()
+	}
+	if s.sockets, err = newSocketOpts(&cfg.Socket); err != nil {
+		log.Printf("[SOCK] socket: %v — using default options", err)
+	}
+	if s.clientMaps, err = newClientMapPolicy(&cfg.ClientMaps); err != nil {
+		log.Printf("[CMAP] client_maps: %v — clients may not open maps", err)
+	}
+	if s.hop, err = newHopSchedule(cfg); err != nil {
+		log.Printf("[HOP] port_hopping: %v — using listen", err)
+	}
+	if s.decoyTemplates, err = newDecoyTemplates(cfg.DecoyResponses); err != nil {
+		log.Printf("[DECOY] %v — using built-in pages", err)
 	}
+	if s.upstream, err = newDecoyUpstream(cfg.DecoyUpstream, s.writeDecoyStatus); err != nil {
+		log.Printf("[DECOY] decoy_upstream: %v — using built-in pages", err)
+	}
+	return s
 }
 
-func (s *Server) Start() error {
+// Stats returns the server's traffic counters.
+func (s *Server) Stats() *Stats { return s.stats }
+
+// Start runs the server until Shutdown is called or ctx is cancelled,
+// which shuts it down the same way.
+func (s *Server) Start(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { s.Shutdown() })
+	defer stop()
 	log.Printf("[SERVER] maps: tcp=%d udp=%d", len(s.Config.Forward.TCP), len(s.Config.Forward.UDP))
+	logLegacyForward(s.Config)
 
 	for _, m := range s.Config.Forward.TCP {
 		if bind, target, ok := SplitMap(m); ok {
-			go s.startReverseTCP(bind, target)
+			if err := s.openMap("tcp", bind, target, nil); err != nil {
+				log.Printf("[RTCP] FAILED listen %s: %v", bind, err)
+			}
 		}
 	}
 	for _, m := range s.Config.Forward.UDP {
 		if bind, target, ok := SplitMap(m); ok {
-			go s.startReverseUDP(bind, target)
+			if err := s.openMap("udp", bind, target, nil); err != nil {
+				log.Printf("[RUDP] FAILED listen %s: %v", bind, err)
+			}
 		}
 	}
 
+	logACL(s.acl)
+	logResolver(s.life.resolver)
+	logHold(s.Config)
+	logTags(s.Config)
+	s.startAdmin()
+	go s.usage.run(s.life)
+	go s.state.run(s)
+	go s.tracer.run(s.life)
 	go s.healthMonitor()
+	go s.sd.watchdog(s.life)
+	s.startMapHealth()
+	startMapDNS(s.Config, s.life)
+	s.discovery = newDiscoveryRelay(&s.Config.Discovery, s.life)
+
+	tlsConfig, err := s.serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	s.tlsConfig = tlsConfig
 
 	// ─── Multi-Port Listen (v2.5) ───
 	// Start HTTP server on each listen port. All ports share the
@@ -88,18 +224,29 @@ func (s *Server) Start() error {
 		ports = []string{s.Config.Listen}
 	}
 
-	sc := buildSmuxConfig(s.Config)
+	sc := buildSmuxConfig(s.Config, nil)
 	log.Printf("[SERVER] listening on %d port(s): %v  profile=%s",
 		len(ports), ports, s.Config.Profile)
 	log.Printf("[SERVER] smux: keepalive=%v timeout=%v frame=%d maxrecv=%d maxstream=%d",
 		sc.KeepAliveInterval, sc.KeepAliveTimeout,
 		sc.MaxFrameSize, sc.MaxReceiveBuffer, sc.MaxStreamBuffer)
-	log.Printf("[SERVER] limits: max_streams_per_session=%d max_connections=%d",
-		s.Config.Advanced.MaxStreamsPerSession, s.Config.Advanced.MaxConnections)
+	log.Printf("[SERVER] limits: max_streams_per_session=%d max_connections=%d (wait %ds)",
+		s.Config.Advanced.MaxStreamsPerSession, s.Config.Advanced.MaxConnections, s.Config.Advanced.MaxConnectionsWait)
+	logStreamLimits(&s.Config.Advanced)
+	if len(s.Config.Users) > 0 {
+		log.Printf("[SERVER] users: %d configured, %d enabled (top-level psk ignored)",
+			len(s.Config.Users), len(s.creds))
+	}
+
+	atomic.StoreInt32(&s.portsPending, int32(len(ports)))
+	if s.hop != nil {
+		host, _, _ := net.SplitHostPort(ports[0])
+		return s.waitStopped(s.runPortHop(host))
+	}
 
 	if len(ports) == 1 {
 		// Single port — blocking
-		return s.listenOnPort(ports[0])
+		return s.waitStopped(s.listenOnPort(ports[0]))
 	}
 
 	// Multiple ports — launch goroutines, wait for first error
@@ -111,10 +258,46 @@ func (s *Server) Start() error {
 		// Small delay between port starts to avoid thundering herd
 		time.Sleep(100 * time.Millisecond)
 	}
-	return <-errCh
+	return s.waitStopped(<-errCh)
+}
+
+// waitStopped turns a listener exit caused by Shutdown into a clean
+// return once draining has finished.
+func (s *Server) waitStopped(err error) error {
+	if !s.life.isClosing() {
+		return err
+	}
+	<-s.life.stopped
+	return nil
 }
 
 func (s *Server) listenOnPort(addr string) error {
+	server := s.tunnelServer(addr)
+	lns, err := s.sockets.listenShards(addr)
+	if err != nil {
+		return err
+	}
+	if atomic.AddInt32(&s.portsPending, -1) == 0 {
+		s.sd.ready("tunnel listening")
+	}
+	serve := func(ln net.Listener) error {
+		if s.tlsConfig != nil {
+			return server.Serve(s.tlsListener(server, ln))
+		}
+		return server.Serve(ln)
+	}
+	if len(lns) > 1 {
+		log.Printf("[SOCK] %s: %d listeners", addr, len(lns))
+	}
+	for _, ln := range lns[1:] {
+		go serve(ln)
+	}
+	return serve(lns[0])
+}
+
+// tunnelServer builds the HTTP server answering tunnel and decoy
+// requests on addr.
+func (s *Server) tunnelServer(addr string) *http.Server {
 	tunnelPath := mimicPath(s.Mimic)
 	prefix := strings.Split(tunnelPath, "{")[0]
 
@@ -129,14 +312,36 @@ func (s *Server) listenOnPort(addr string) error {
 		Handler:           mux,
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       120 * time.Second,
-		MaxHeaderBytes:    1 << 16,
+		MaxHeaderBytes:    maxHandshakeHeadBytes,
+	}
+	s.life.track(server)
+	h2 := s.Config.Transport == "h2mux"
+	if s.tlsConfig != nil {
+		server.TLSConfig = s.tlsConfig
+		if h2 {
+			server.TLSConfig = h2TLSConfig(s.tlsConfig)
+		} else {
+			// Disable HTTP/2: the tunnel upgrade must be hijackable.
+			server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+	} else if h2 {
+		server.Handler = h2cHandler(mux)
 	}
-	return server.ListenAndServe()
+	return server
 }
 
 // ──────────────── Tunnel Handler ────────────────
 
 func (s *Server) handleTunnel(w http.ResponseWriter, r *http.Request) {
+	defer guardPanic("server tunnel")
+	if r.ProtoMajor == 2 {
+		s.handleH2Tunnel(w, r)
+		return
+	}
+	if id := r.URL.Query().Get(xhttpIDParam); s.xhttp != nil && id != "" && r.Header.Get("Upgrade") == "" {
+		s.handleXHTTP(w, r, id)
+		return
+	}
 	if !s.validateRequest(w, r) {
 		return
 	}
@@ -148,24 +353,7 @@ func (s *Server) handleTunnel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Send 101 Switching Protocols — v2.5.1: randomize response to break fingerprints
-	serverNames := []string{"nginx/1.24.0", "nginx/1.25.4", "cloudflare", "gws", "Microsoft-IIS/10.0", "Apache/2.4.58"}
-	srvName := serverNames[secureRandInt(len(serverNames))]
-	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
-		"Upgrade: websocket\r\n" +
-		"Connection: Upgrade\r\n" +
-		"Sec-WebSocket-Accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n" +
-		"Server: " + srvName + "\r\n"
-	// Random extra headers to vary response fingerprint
-	if secureRandInt(2) == 0 {
-		resp += "X-Content-Type-Options: nosniff\r\n"
-	}
-	if secureRandInt(2) == 0 {
-		resp += "X-Frame-Options: SAMEORIGIN\r\n"
-	}
-	if secureRandInt(3) == 0 {
-		resp += fmt.Sprintf("Alt-Svc: h3=\":443\"; ma=%d\r\n", 86400+secureRandInt(86400))
-	}
-	resp += "\r\n"
+	resp := upgradeResponse(r.Header.Get("Sec-WebSocket-Key"), true)
 
 	conn, buf, err := hj.Hijack()
 	if err != nil {
@@ -176,7 +364,23 @@ func (s *Server) handleTunnel(w http.ResponseWriter, r *http.Request) {
 	// Set TCP options for performance
 	s.setTCPOptions(conn)
 
-	if _, err := conn.Write([]byte(resp)); err != nil {
+	// Bytes net/http read past the request head: tunnel payload is kept,
+	// a pipelined second request is a desync attempt and gets dropped.
+	if buf != nil {
+		if err := drainPipelined(buf.Reader); err != nil {
+			log.Printf("[SECURITY] %s: %v", r.RemoteAddr, err)
+			conn.Close()
+			return
+		}
+	}
+
+	// Auth challenge rides in the same write as the 101 head
+	hello, err := newServerHello()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if _, err := conn.Write(append([]byte(resp), hello.bytes()...)); err != nil {
 		conn.Close()
 		return
 	}
@@ -184,37 +388,85 @@ func (s *Server) handleTunnel(w http.ResponseWriter, r *http.Request) {
 	// Flush any buffered data
 	if buf != nil {
 		buf.Flush()
+		if buf.Reader.Buffered() > 0 {
+			conn = &bufferedConn{Conn: conn, r: buf.Reader}
+		}
 	}
+	s.serveTunnel(conn, r.RemoteAddr, hello)
+}
 
-	// Wrap with encryption
-	ec, err := NewEncryptedConn(conn, s.PSK, s.Obfs, &s.Config.Stealth)
+// serveTunnel runs auth, encryption and the mux session on a tunnel
+// connection whose server hello has been sent; it returns when the
+// session ends.
+func (s *Server) serveTunnel(conn net.Conn, remote string, hello *serverHello) {
+	// Reject clients without the PSK before any session state exists
+	cred, mode, key, err := serverVerifyAuth(conn, s.creds, s.encModes, hello, authSkew(s.Config))
 	if err != nil {
+		logDedupf(hostOnly(remote), "[AUTH] rejected %s: %v", remote, err)
+		if errors.As(err, new(*clockSkewError)) {
+			s.stats.incError("clock_skew")
+		} else {
+			s.stats.incError("auth")
+		}
+		conn.Close()
+		return
+	}
+	if s.life.isClosing() {
+		conn.Close()
+		return
+	}
+	if cred.maxSessions > 0 && s.userSessions(cred.user) >= cred.maxSessions {
+		logDedupf(cred.user, "[AUTH] rejected %s: user %q at max_sessions=%d", remote, cred.user, cred.maxSessions)
+		conn.Close()
+		return
+	}
+	if s.state.overQuota(cred.user) {
+		logDedupf(cred.user, "[AUTH] rejected %s: user %q over quota", remote, cred.user)
+		conn.Close()
+		return
+	}
+
+	// Wrap with encryption — per-connection key from the exchange
+	var ec *EncryptedConn
+	if mode == encModeNone {
+		ec = NewIntegrityConn(conn, key, s.Obfs, &s.Config.Stealth)
+	} else if ec, err = NewEncryptedConnKey(conn, key, s.Obfs, &s.Config.Stealth); err != nil {
 		log.Printf("[ERR] encrypt: %v", err)
 		conn.Close()
 		return
 	}
+	ec.BindSession(hello.nonce, true)
 
-	// Create smux session
-	sc := buildSmuxConfig(s.Config)
-	sess, err := smux.Server(ec, sc)
+	// Create mux session (smux or yamux, mux.go)
+	sess, err := newMuxServer(s.Config, ec)
 	if err != nil {
-		log.Printf("[ERR] smux server: %v", err)
+		log.Printf("[ERR] mux server: %v", err)
 		ec.Close()
 		return
 	}
 
 	ss := &serverSession{
+		id:      atomic.AddUint64(&s.nextSessionID, 1),
 		sess:    sess,
-		remote:  r.RemoteAddr,
+		remote:  remote,
+		user:    cred.user,
+		nonce:   hello.nonce,
 		created: time.Now(),
+		opens:   newStreamOpens(&s.Config.Advanced),
+	}
+	if !s.tryAddSession(ss, cred.maxSessions) {
+		logDedupf(cred.user, "[AUTH] rejected %s: user %q at max_sessions=%d", remote, cred.user, cred.maxSessions)
+		sess.Close()
+		return
 	}
-	s.addSession(ss)
-	log.Printf("[SESSION] new from %s (pool: %d)", r.RemoteAddr, s.poolSize())
+	log.Printf("[SESSION] new from %s%s (pool: %d)", remote, ss.userTag(), s.poolSize())
 
 	// Start fake traffic generator if enabled
 	if s.Config.Stealth.FakeTraffic {
 		go s.fakeTrafficLoop(ss)
 	}
+	go s.probeSession(ss)
+	go s.heartbeat(ss)
 
 	// Accept streams from client (forward proxy direction)
 	for {
@@ -227,18 +479,25 @@ func (s *Server) handleTunnel(w http.ResponseWriter, r *http.Request) {
 
 	s.removeSession(ss)
 	sess.Close()
-	log.Printf("[SESSION] closed %s after %v (pool: %d)",
-		r.RemoteAddr, time.Since(ss.created).Round(time.Second), s.poolSize())
+	log.Printf("[SESSION] closed %s%s after %v (pool: %d)",
+		remote, ss.userTag(), time.Since(ss.created).Round(time.Second), s.poolSize())
 }
 
 // handleStream reads the stream type tag and routes accordingly.
 // v2.5 FIX: This prevents port mapping confusion by explicitly
 // identifying each stream's purpose with a type byte.
-func (s *Server) handleStream(ss *serverSession, stream *smux.Stream) {
+func (s *Server) handleStream(ss *serverSession, stream net.Conn) {
+	defer guardPanic("server stream")
+	// Draining: in-flight relays finish, new streams are refused
+	if !s.life.acquire() {
+		refuseStream(stream)
+		return
+	}
 	atomic.AddInt64(&ss.streams, 1)
 	defer func() {
 		atomic.AddInt64(&ss.streams, -1)
 		stream.Close()
+		s.life.release()
 	}()
 
 	// Read stream type tag (1 byte, 5s timeout)
@@ -252,16 +511,24 @@ func (s *Server) handleStream(ss *serverSession, stream *smux.Stream) {
 
 	switch typeBuf[0] {
 	case StreamTypeForward:
-		s.handleForwardStream(stream)
+		s.handleForwardStream(ss, stream)
+	case StreamTypePing:
+		atomic.StoreInt32(&ss.pings, 1)
+		servePing(stream)
+	case StreamTypeHello:
+		s.serveSessionHello(ss, stream)
 	default:
 		// Unknown type — ignore
 		if s.Verbose {
-			log.Printf("[STREAM] unknown type 0x%02x from %s", typeBuf[0], ss.remote)
+			logDedupf(ss.remote, "[STREAM] unknown type 0x%02x from %s", typeBuf[0], ss.remote)
 		}
 	}
 }
 
-func (s *Server) handleForwardStream(stream *smux.Stream) {
+func (s *Server) handleForwardStream(ss *serverSession, stream net.Conn) {
+	sp := s.tracer.startStream("forward", spanKindClient)
+	sp.link(ss.nonce, stream, false)
+
 	// Read target header: [2B length][target string]
 	stream.SetReadDeadline(time.Now().Add(10 * time.Second))
 	hdr := make([]byte, 2)
@@ -277,50 +544,284 @@ func (s *Server) handleForwardStream(stream *smux.Stream) {
 		return
 	}
 	stream.SetReadDeadline(time.Time{})
+	tStr, _, status := takeTargetFlag(string(tBuf), "status")
+	tBuf = []byte(tStr)
+
+	if !ss.opens.allow(1) {
+		s.stats.incError("stream_rate")
+		logDedupf("stream_rate"+ss.remote, "[LIMIT] %s%s: over stream_rate=%d/s, refusing streams",
+			ss.remote, ss.userTag(), s.Config.Advanced.StreamRate)
+		sendStreamStatus(stream, status, streamRateLimited)
+		return
+	}
+	release, code := s.admitConn("tcp", "")
+	if release == nil {
+		sendStreamStatus(stream, status, code)
+		return
+	}
+	defer release()
 
-	network, addr := splitTarget(string(tBuf))
+	if isEchoTarget(string(tBuf)) {
+		serveEcho(stream)
+		return
+	}
+	if isBenchTarget(string(tBuf)) {
+		serveBench(stream, string(tBuf))
+		return
+	}
+	if isSpeedtestTarget(string(tBuf)) {
+		serveSpeedtest(stream, string(tBuf))
+		return
+	}
+	if string(tBuf) == udpAssocTarget {
+		s.serveUDPAssociation(stream)
+		return
+	}
+	if strings.HasPrefix(string(tBuf), breakerTarget) {
+		s.breakers.handle(ss, strings.TrimPrefix(string(tBuf), breakerTarget))
+		return
+	}
+	if string(tBuf) == discoveryTarget {
+		if s.discovery == nil {
+			stream.Close()
+			return
+		}
+		s.discovery.serve(stream)
+		return
+	}
 
-	remote, err := net.DialTimeout(network, addr, 10*time.Second)
+	sp.event("header_read")
+	defer sp.end()
+	sp.attr("picotun.target", string(tBuf))
+	target, prio := splitPrioTarget(string(tBuf))
+	if prio != "" {
+		stream = ss.sched.wrap(stream, prio)
+	}
+	network, addr := splitTarget(target)
+	dial, ok := s.aclCheck(network, addr)
+	if !ok {
+		sp.fail(fmt.Errorf("denied by acl"))
+		sendStreamStatus(stream, status, streamDenied)
+		return
+	}
+
+	remote, err := s.life.dial(network, dial, 10*time.Second)
 	if err != nil {
+		sp.fail(err)
+		s.stats.incError("dial")
 		if s.Verbose {
-			log.Printf("[FWD] dial %s://%s: %v", network, addr, err)
+			logDedupf(network+addr, "[FWD] dial %s://%s: %v", network, addr, err)
 		}
+		sendStreamStatus(stream, status, streamDialFailed)
 		return
 	}
+	sp.event("dialed")
 	defer remote.Close()
-	relay(stream, remote)
+	sendStreamStatus(stream, status, streamOK)
+	m, done := s.stats.connOpened("forward")
+	defer done()
+	sm, am := s.meter(ss)
+	counted := &countedConn{ReadWriteCloser: stream, st: s.stats, m: m, sess: sm, acct: am, span: sp}
+	if network == "udp" {
+		relayDatagrams(newDatagramConn(counted), remote, time.Duration(s.Config.Advanced.UDPFlowTimeout)*time.Second)
+		return
+	}
+	relay(counted, remote, streamIdle(s.Config))
+}
+
+// ──────────────── Running maps ────────────────
+
+// activeMap is one listening reverse map. pm is the config's maps:
+// entry, or the entry added at runtime through the admin API or by a
+// client's expose: (owner).
+type activeMap struct {
+	network string
+	bind    string
+	target  string
+	pm      *PortMap
+	runtime bool
+	owner   string // client id of an expose: map (clientmaps.go)
+	closer  io.Closer
+	limit   *connLimiter // pm.MaxConnections, nil = unlimited
+	rate    *mapRate     // pm.Rate, nil = unlimited
+}
+
+// openMap listens on bind and serves the map in the background. A nil
+// pm means the map comes from the config file.
+func (s *Server) openMap(network, bind, target string, pm *PortMap) error {
+	key := network + ":" + bind
+	s.mapsMu.Lock()
+	defer s.mapsMu.Unlock()
+	if _, ok := s.maps[key]; ok {
+		return fmt.Errorf("%s already mapped", key)
+	}
+	am := &activeMap{network: network, bind: bind, target: target, pm: pm, runtime: pm != nil}
+	if pm == nil {
+		am.pm = s.Config.mapFor(bind)
+	} else if am.owner = mapOwner(pm.Tag); am.owner != "" {
+		am.runtime = false // the client's, not the admin API's
+	}
+	am.limit = newConnLimiter(am.pm.MaxConnections)
+	am.rate = newMapRate(am.pm)
+	if am.pm.Priority != "" {
+		s.prioritized.Store(true)
+	}
+	switch network {
+	case "udp":
+		addr, err := net.ResolveUDPAddr("udp", bind)
+		if err != nil {
+			return err
+		}
+		ln, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			return err
+		}
+		log.Printf("[RUDP] %s → %s", bind, target)
+		s.life.track(ln)
+		am.closer = ln
+		go s.serveReverseUDP(ln, bind, target)
+	default:
+		ln, err := listenTCP(bind, am.pm.MSS)
+		if err != nil {
+			return err
+		}
+		if am.pm.TLS {
+			tcfg, err := mapTLSConfig(am.pm, s.Config)
+			if err != nil {
+				ln.Close()
+				return err
+			}
+			ln = tls.NewListener(ln, tcfg)
+		}
+		log.Printf("[RTCP] %s → %s", bind, target)
+		s.life.track(ln)
+		am.closer = ln
+		go s.serveReverseTCP(ln, bind, target)
+	}
+	s.maps[key] = am
+	return nil
+}
+
+// closeMap stops accepting on a map. Connections already relayed keep
+// running until they end.
+func (s *Server) closeMap(network, bind string) bool {
+	key := network + ":" + bind
+	s.mapsMu.Lock()
+	am, ok := s.maps[key]
+	delete(s.maps, key)
+	s.mapsMu.Unlock()
+	if ok {
+		am.closer.Close()
+		log.Printf("[MAP] closed %s", key)
+	}
+	return ok
+}
+
+// mapFor returns the per-map options for a running map.
+func (s *Server) mapFor(network, bind string) *PortMap {
+	s.mapsMu.Lock()
+	am, ok := s.maps[network+":"+bind]
+	s.mapsMu.Unlock()
+	if ok {
+		return am.pm
+	}
+	return s.Config.mapFor(bind)
 }
 
 // ──────────────── Reverse TCP (Port Mapping) ────────────────
 // v2.5 FIX: Each reverse stream is now tagged with StreamTypeReverse
 // so the client can distinguish it from forward streams.
 
-func (s *Server) startReverseTCP(bind, target string) {
-	ln, err := net.Listen("tcp", bind)
-	if err != nil {
-		log.Printf("[RTCP] FAILED listen %s: %v", bind, err)
-		return
-	}
-	log.Printf("[RTCP] %s → %s", bind, target)
-
+func (s *Server) serveReverseTCP(ln net.Listener, bind, target string) {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
+			if s.life.isClosing() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
-		go s.handleReverseTCPConn(conn, target)
+		go s.handleReverseTCPConn(conn, bind, target)
 	}
 }
 
-func (s *Server) handleReverseTCPConn(conn net.Conn, target string) {
+func (s *Server) handleReverseTCPConn(conn net.Conn, bind, target string) {
+	defer guardPanic("reverse tcp " + bind)
 	defer conn.Close()
+	if !s.life.acquire() {
+		return
+	}
+	defer s.life.release()
+	sp := s.tracer.startStream("map tcp:"+bind, spanKindServer)
+	defer sp.end()
+	sp.attr("client.address", conn.RemoteAddr().String())
+	release, _ := s.admitConn("tcp", bind)
+	if release == nil {
+		refuseVisitor(conn, "")
+		return
+	}
+	defer release()
+
+	pm := s.mapFor("tcp", bind)
+	conn, target, ok := s.mapTLS(conn, pm, target)
+	if !ok {
+		return
+	}
+	if conn, target, ok = s.mapVHost(conn, pm, target); !ok {
+		return
+	}
 
 	// Open stream on a session from pool
-	stream, ss, err := s.openReverseStream("tcp://" + target)
+	streamTarget := "tcp://" + target
+	if isEchoTarget(target) {
+		streamTarget = target
+	}
+	if pm.CircuitBreaker && s.breakerOpen(streamTarget, pm.Tag) {
+		s.stats.incError("breaker_open")
+		refuseVisitor(conn, pm.DownBanner)
+		return
+	}
+	if pm.RealIP != "" && !isEchoTarget(target) {
+		streamTarget = fromTarget(streamTarget, pm.RealIP, conn.RemoteAddr(), conn.LocalAddr())
+	}
+	if (pm.Bond > 1 || pm.Resume > 0) && !isEchoTarget(target) &&
+		s.relayBonded(conn, bind, streamTarget, pm.Tag, max(pm.Bond, 1), time.Duration(pm.Resume)*time.Second) {
+		return
+	}
+	if pm.IdleKeep {
+		streamTarget = keepTarget(streamTarget)
+	}
+	if pm.WarmPool > 0 && !isEchoTarget(target) {
+		streamTarget = warmTarget(streamTarget, pm.WarmPool)
+	}
+	if pm.Compress != "" {
+		streamTarget = compressTarget(streamTarget, pm.Compress)
+	}
+	prioritized := s.prioritized.Load()
+	if prioritized {
+		streamTarget = prioTarget(streamTarget, pm.Priority)
+	}
+	var stream net.Conn
+	var ss *serverSession
+	var err error
+	if pm.Sticky {
+		stream, ss, err = s.openStickyStream(streamTarget, pm.Tag, conn.RemoteAddr())
+	} else {
+		stream, ss, err = s.openReverseStream(streamTarget, pm.Tag)
+	}
+	if err != nil && pm.HoldTimeout > 0 {
+		stream, ss, err = s.holdForSession(streamTarget, pm.Tag, bind, time.Duration(pm.HoldTimeout)*time.Second)
+	}
 	if err != nil {
+		sp.fail(err)
+		s.stats.incError("no_session")
+		if fb := pm.FallbackTarget; fb != "" {
+			s.relayFallback(conn, "tcp", bind, fb)
+			return
+		}
 		if s.Verbose {
-			log.Printf("[RTCP] no session for %s: %v", target, err)
+			logDedupf(target, "[RTCP] no session for %s: %v", target, err)
 		}
 		return
 	}
@@ -328,13 +829,54 @@ func (s *Server) handleReverseTCPConn(conn net.Conn, target string) {
 		stream.Close()
 		atomic.AddInt64(&ss.streams, -1)
 	}()
+	sp.link(ss.nonce, stream, true)
+	sp.event("stream_opened")
+	if prioritized {
+		stream = ss.sched.wrap(stream, pm.Priority)
+	}
 
-	relay(conn, stream)
+	var tunnel io.ReadWriteCloser = stream
+	if pm.IdleKeep {
+		tunnel = newKeepConn(stream)
+	}
+	if pm.Compress != "" {
+		tunnel = newCompressConn(tunnel, pm.Compress)
+	}
+
+	m, done := s.stats.connOpened("tcp:" + bind)
+	defer done()
+	sm, am := s.meter(ss)
+	idle := streamIdle(s.Config)
+	if pm.IdleKeep {
+		idle = 0 // meant to sit silent; keep frames prove the peer alive
+	}
+	visitor := s.mapRate("tcp", bind).shape(conn, s.life.done)
+	relay(&countedConn{ReadWriteCloser: visitor, st: s.stats, m: m, sess: sm, acct: am, span: sp}, tunnel, idle)
 }
 
-// openReverseStream opens a stream on a session, writes the type tag
-// and target header. Returns the stream ready for data relay.
-func (s *Server) openReverseStream(target string) (*smux.Stream, *serverSession, error) {
+// relayFallback serves a visitor by dialing the map's fallback_target
+// directly, used while no client session is available.
+func (s *Server) relayFallback(conn net.Conn, network, bind, fallback string) {
+	remote, err := s.life.dial(network, fallback, 10*time.Second)
+	if err != nil {
+		s.stats.incError("dial")
+		if s.Verbose {
+			logDedupf(network+fallback, "[FALLBACK] dial %s://%s: %v", network, fallback, err)
+		}
+		return
+	}
+	defer remote.Close()
+	if s.Verbose {
+		log.Printf("[FALLBACK] %s → %s (no session)", bind, fallback)
+	}
+	m, done := s.stats.connOpened(network + ":" + bind)
+	defer done()
+	relay(&countedConn{ReadWriteCloser: conn, st: s.stats, m: m}, remote, streamIdle(s.Config))
+}
+
+// openReverseStream opens a stream on a session serving tag, writes the
+// type tag and target header. Returns the stream ready for data relay.
+func (s *Server) openReverseStream(target, tag string) (net.Conn, *serverSession, error) {
 	s.poolMu.RLock()
 	n := len(s.sessions)
 	if n == 0 {
@@ -351,7 +893,7 @@ func (s *Server) openReverseStream(target string) (*smux.Stream, *serverSession,
 	for i := 0; i < n; i++ {
 		idx := (startIdx + i) % n
 		ss := s.sessions[idx]
-		if ss.sess.IsClosed() {
+		if ss.sess.IsClosed() || !ss.serves(tag) || ss.info.Load().lacks(target) != "" {
 			continue
 		}
 		active := atomic.LoadInt64(&ss.streams)
@@ -365,18 +907,34 @@ func (s *Server) openReverseStream(target string) (*smux.Stream, *serverSession,
 
 	if bestSS == nil {
 		// All sessions overloaded — try least loaded
-		bestSS = s.leastLoadedSession()
+		bestSS = s.leastLoadedSession(tag)
 		if bestSS == nil {
+			if tag != "" {
+				return nil, nil, fmt.Errorf("no session tagged %q", tag)
+			}
 			return nil, nil, fmt.Errorf("all sessions full")
 		}
 	}
+	stream, err := s.openReverseStreamOn(bestSS, target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stream, bestSS, nil
+}
 
+// openReverseStreamOn opens a reverse stream for target on bestSS and
+// counts it in bestSS.streams.
+func (s *Server) openReverseStreamOn(bestSS *serverSession, target string) (net.Conn, error) {
+	if f := bestSS.info.Load().lacks(target); f != "" {
+		return nil, fmt.Errorf("client %s doesn't support %s streams", bestSS.remote, f)
+	}
+	target = bestSS.info.Load().fitTarget(target)
 	stream, err := bestSS.sess.OpenStream()
 	if err != nil {
 		// Session might be dead — evict and retry once
 		s.removeSession(bestSS)
 		bestSS.sess.Close()
-		return nil, nil, fmt.Errorf("open stream: %w", err)
+		return nil, fmt.Errorf("open stream: %w", err)
 	}
 	atomic.AddInt64(&bestSS.streams, 1)
 
@@ -384,7 +942,7 @@ func (s *Server) openReverseStream(target string) (*smux.Stream, *serverSession,
 	if _, err := stream.Write([]byte{StreamTypeReverse}); err != nil {
 		stream.Close()
 		atomic.AddInt64(&bestSS.streams, -1)
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Write target header
@@ -395,20 +953,20 @@ func (s *Server) openReverseStream(target string) (*smux.Stream, *serverSession,
 	if _, err := stream.Write(hdr); err != nil {
 		stream.Close()
 		atomic.AddInt64(&bestSS.streams, -1)
-		return nil, nil, err
+		return nil, err
 	}
 
-	return stream, bestSS, nil
+	return stream, nil
 }
 
-func (s *Server) leastLoadedSession() *serverSession {
+func (s *Server) leastLoadedSession(tag string) *serverSession {
 	s.poolMu.RLock()
 	defer s.poolMu.RUnlock()
 
 	var best *serverSession
 	bestLoad := int64(1<<63 - 1)
 	for _, ss := range s.sessions {
-		if ss.sess.IsClosed() {
+		if ss.sess.IsClosed() || !ss.serves(tag) {
 			continue
 		}
 		load := atomic.LoadInt64(&ss.streams)
@@ -422,33 +980,25 @@ func (s *Server) leastLoadedSession() *serverSession {
 
 // ──────────────── Reverse UDP ────────────────
 
-func (s *Server) startReverseUDP(bind, target string) {
-	addr, err := net.ResolveUDPAddr("udp", bind)
-	if err != nil {
-		log.Printf("[RUDP] FAILED resolve %s: %v", bind, err)
-		return
-	}
-	ln, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		log.Printf("[RUDP] FAILED listen %s: %v", bind, err)
-		return
-	}
-	log.Printf("[RUDP] %s → %s", bind, target)
-
-	var mu sync.Mutex
-	peers := map[string]*udpPeer{}
+func (s *Server) serveReverseUDP(ln *net.UDPConn, bind, target string) {
+	defer guardPanic("reverse udp " + bind)
+	flows := newUDPFlows(s.Config.Advanced.MaxUDPFlows, s.stats)
+	defer flows.closeAll()
+	stop := make(chan struct{})
+	defer close(stop)
 
 	go func() {
-		for range time.NewTicker(30 * time.Second).C {
-			mu.Lock()
-			now := time.Now().Unix()
-			for k, p := range peers {
-				if now-atomic.LoadInt64(&p.lastSeen) > int64(s.Config.Advanced.UDPFlowTimeout) {
-					p.stream.Close() // triggers reader goroutine exit + cleanup
-					delete(peers, k)
-				}
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.life.done:
+				return
+			case <-stop:
+				return
 			}
-			mu.Unlock()
+			flows.expire(time.Duration(s.Config.Advanced.UDPFlowTimeout) * time.Second)
 		}
 	}()
 
@@ -456,27 +1006,70 @@ func (s *Server) startReverseUDP(bind, target string) {
 	for {
 		n, raddr, err := ln.ReadFromUDP(buf)
 		if err != nil || n == 0 {
+			if s.life.isClosing() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			continue
 		}
 
 		key := raddr.String()
-		mu.Lock()
-		p, ok := peers[key]
-		if !ok {
-			stream, ss, err := s.openReverseStream("udp://" + target)
-			if err != nil {
-				mu.Unlock()
+		p := flows.get(key)
+		if p == nil {
+			if !s.life.acquire() {
 				continue
 			}
-			p = &udpPeer{
-				stream:   stream,
-				ss:       ss,
-				lastSeen: time.Now().Unix(),
+			var stream io.ReadWriteCloser
+			var ss *serverSession
+			var st net.Conn
+			var sess *serverSession
+			pm := s.mapFor("udp", bind)
+			streamTarget := "udp://" + target
+			prioritized := s.prioritized.Load()
+			if prioritized {
+				streamTarget = prioTarget(streamTarget, pm.Priority)
+			}
+			if pm.Sticky {
+				st, sess, err = s.openStickyStream(streamTarget, pm.Tag, raddr)
+			} else {
+				st, sess, err = s.openReverseStream(streamTarget, pm.Tag)
 			}
-			peers[key] = p
+			if err == nil {
+				if prioritized {
+					st = sess.sched.wrap(st, pm.Priority)
+				}
+				// One frame per packet so coalesced reads can't merge datagrams.
+				stream, ss = newDatagramConn(st), sess
+			} else {
+				s.stats.incError("no_session")
+				// No client session — talk to fallback_target directly
+				fb := pm.FallbackTarget
+				if fb == "" {
+					s.life.release()
+					continue
+				}
+				fc, ferr := s.life.dial("udp", fb, 5*time.Second)
+				if ferr != nil {
+					s.stats.incError("dial")
+					s.life.release()
+					continue
+				}
+				stream = fc
+			}
+			if r := s.mapRate("udp", bind); r != nil {
+				// The stream carries whole packets: no chunking.
+				stream = &shapedConn{ReadWriteCloser: stream, in: r.out, out: r.in, done: s.life.done, packets: true}
+			}
+			p = &udpPeer{key: key, stream: stream, ss: ss}
+			var done func()
+			p.m, done = s.stats.connOpened("udp:" + bind)
+			p.sm, p.am = s.meter(ss)
+			p.queue = newUDPQueue(stream, pm, bind, p.m)
+			flows.add(p)
 
 			go func(p *udpPeer, raddr *net.UDPAddr) {
 				defer func() {
+					done()
+					s.life.release()
 					if p.ss != nil {
 						atomic.AddInt64(&p.ss.streams, -1)
 					}
@@ -488,32 +1081,44 @@ func (s *Server) startReverseUDP(bind, target string) {
 						break
 					}
 					ln.WriteToUDP(rbuf[:rn], raddr)
-					atomic.StoreInt64(&p.lastSeen, time.Now().Unix())
+					flows.touch(p)
+					atomic.AddInt64(&s.stats.bytesOut, int64(rn))
+					p.m.add(0, int64(rn))
+					p.sm.add(0, int64(rn))
+					p.am.add(0, int64(rn))
 				}
-				mu.Lock()
-				delete(peers, raddr.String())
-				mu.Unlock()
+				flows.remove(p)
+				p.queue.close()
 			}(p, raddr)
 		}
-		mu.Unlock()
 
-		atomic.StoreInt64(&p.lastSeen, time.Now().Unix())
-		p.stream.Write(buf[:n])
+		atomic.AddInt64(&s.stats.bytesIn, int64(n))
+		p.m.add(int64(n), 0)
+		p.sm.add(int64(n), 0)
+		p.am.add(int64(n), 0)
+		p.queue.push(buf[:n])
 	}
 }
 
-type udpPeer struct {
-	stream   *smux.Stream
-	ss       *serverSession
-	lastSeen int64
-}
-
 // ──────────────── Session Pool ────────────────
 
-func (s *Server) addSession(ss *serverSession) {
+// tryAddSession adds ss to the pool unless its user already has limit
+// live sessions (limit 0 = unlimited). Counting and adding under one lock
+// keeps simultaneous handshakes of one user within max_sessions.
+func (s *Server) tryAddSession(ss *serverSession, limit int) bool {
 	s.poolMu.Lock()
+	if limit > 0 && s.userSessionsLocked(ss.user) >= limit {
+		s.poolMu.Unlock()
+		return false
+	}
 	s.sessions = append(s.sessions, ss)
 	s.poolMu.Unlock()
+	s.signalSession()
+	s.stats.sessionAdded()
+	ev := SessionEvent{Event: "up", ID: ss.id, Remote: ss.remote, User: ss.user}
+	s.stats.sessionEvent(ev)
+	s.state.logSession(ev)
+	return true
 }
 
 func (s *Server) removeSession(ss *serverSession) {
@@ -521,10 +1126,18 @@ func (s *Server) removeSession(ss *serverSession) {
 	for i, e := range s.sessions {
 		if e == ss {
 			s.sessions = append(s.sessions[:i], s.sessions[i+1:]...)
+			s.stats.sessionRemoved()
+			ev := SessionEvent{Event: "down", ID: ss.id, Remote: ss.remote, User: ss.user,
+				Client: ss.clientName(), Lifetime: int64(time.Since(ss.created).Seconds())}
+			s.stats.sessionEvent(ev)
+			s.state.logSession(ev)
+			s.forgetWarm(ss)
 			break
 		}
 	}
 	s.poolMu.Unlock()
+	s.breakers.drop(ss)
+	s.closeClientMapsLater(ss.clientID())
 }
 
 func (s *Server) poolSize() int {
@@ -533,6 +1146,41 @@ func (s *Server) poolSize() int {
 	return len(s.sessions)
 }
 
+// userSessions counts live sessions authenticated as user.
+func (s *Server) userSessions(user string) int {
+	s.poolMu.RLock()
+	defer s.poolMu.RUnlock()
+	return s.userSessionsLocked(user)
+}
+
+func (s *Server) userSessionsLocked(user string) int {
+	n := 0
+	for _, ss := range s.sessions {
+		if ss.user == user && !ss.sess.IsClosed() {
+			n++
+		}
+	}
+	return n
+}
+
+// userTag is the log suffix telling sessions apart beyond the remote
+// address: user, and client_name/tag once the client announced them.
+func (ss *serverSession) userTag() string {
+	var b strings.Builder
+	if ss.user != "" {
+		b.WriteString(" user=" + ss.user)
+	}
+	if si := ss.info.Load(); si != nil {
+		if si.Name != "" {
+			b.WriteString(" name=" + strconv.Quote(si.Name))
+		}
+		if si.Tag != "" {
+			b.WriteString(" tag=" + si.Tag)
+		}
+	}
+	return b.String()
+}
+
 // healthMonitor proactively evicts dead sessions
 func (s *Server) healthMonitor() {
 	interval := time.Duration(s.Config.Advanced.CleanupInterval) * time.Second
@@ -540,7 +1188,14 @@ func (s *Server) healthMonitor() {
 		interval = 3 * time.Second
 	}
 
-	for range time.NewTicker(interval).C {
+	ticker := time.NewTicker(s.sd.healthEvery(interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.life.done:
+			return
+		}
 		s.poolMu.Lock()
 		alive := s.sessions[:0]
 		evicted := 0
@@ -548,12 +1203,14 @@ func (s *Server) healthMonitor() {
 			if ss.sess.IsClosed() {
 				evicted++
 				ss.sess.Close()
+				s.stats.sessionRemoved()
 			} else {
 				alive = append(alive, ss)
 			}
 		}
 		s.sessions = alive
 		s.poolMu.Unlock()
+		s.sd.alive()
 
 		if evicted > 0 {
 			log.Printf("[HEALTH] evicted %d dead sessions (alive: %d)", evicted, len(alive))
@@ -612,42 +1269,78 @@ func (s *Server) sendFakeData(ss *serverSession) {
 
 func (s *Server) validateRequest(w http.ResponseWriter, r *http.Request) bool {
 	if r.Method != "GET" {
-		s.writeDecoy(w)
+		s.rejectUpgrade(w, r, "bad_method")
 		return false
 	}
-	// v2.5.1: When domain rotation is active, clients send varied Host headers.
-	// Only validate domain when rotation is OFF.
-	if !s.Config.Stealth.RotateDomain {
-		if s.Mimic != nil && s.Mimic.FakeDomain != "" {
-			host := r.Host
-			if h, _, err := net.SplitHostPort(host); err == nil {
-				host = h
-			}
-			if host != s.Mimic.FakeDomain && !strings.HasSuffix(host, "."+s.Mimic.FakeDomain) {
-				if net.ParseIP(host) == nil {
-					s.writeDecoy(w)
-					return false
-				}
-			}
-		}
+	// The upgrade request never has a body — any framing header here is
+	// either a broken client or a CL/TE smuggling probe.
+	if err := checkNoBody(r.Header); err != nil || r.ContentLength > 0 || len(r.TransferEncoding) > 0 {
+		s.rejectUpgrade(w, r, "bad_framing")
+		return false
 	}
-	upgrade := strings.ToLower(r.Header.Get("Upgrade"))
-	conn := strings.ToLower(r.Header.Get("Connection"))
-	if !strings.Contains(upgrade, "websocket") || !strings.Contains(conn, "upgrade") {
-		s.writeDecoy(w)
+	if !s.validHost(r.Host) {
+		s.rejectUpgrade(w, r, "bad_host")
+		return false
+	}
+	if !headerHasToken(r.Header, "Upgrade", "websocket") || !headerHasToken(r.Header, "Connection", "upgrade") {
+		s.rejectUpgrade(w, r, "bad_upgrade")
+		return false
+	}
+	// A malformed key/version is what probes send; real servers refuse it.
+	if !validWebSocketRequest(r.Header) {
+		s.rejectUpgrade(w, r, "bad_ws_key")
 		return false
 	}
 	return true
 }
 
+// validHost checks the tunnel request's Host against mimic.fake_domain.
+func (s *Server) validHost(host string) bool {
+	// v2.5.1: When domain rotation is active, clients send varied Host headers.
+	// Only validate domain when rotation is OFF.
+	if s.Config.Stealth.RotateDomain || s.Mimic == nil || s.Mimic.FakeDomain == "" {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == s.Mimic.FakeDomain || strings.HasSuffix(host, "."+s.Mimic.FakeDomain) {
+		return true
+	}
+	return net.ParseIP(host) != nil
+}
+
 func (s *Server) handleDecoy(w http.ResponseWriter, r *http.Request) {
+	s.probes.record(r.RemoteAddr, "decoy", 1)
+	s.serveDecoy(w, r)
+}
+
+// rejectUpgrade answers a failed tunnel upgrade like any other page and
+// scores the sender: clients never get this wrong.
+func (s *Server) rejectUpgrade(w http.ResponseWriter, r *http.Request, reason string) {
+	s.probes.record(r.RemoteAddr, reason, 5)
+	s.serveDecoy(w, r)
+}
+
+func (s *Server) serveDecoy(w http.ResponseWriter, r *http.Request) {
+	if s.upstream != nil {
+		s.upstream.ServeHTTP(w, r)
+		return
+	}
+	if s.site != nil {
+		s.site.ServeHTTP(w, r)
+		return
+	}
 	s.writeDecoy(w)
 }
 
 func (s *Server) writeDecoy(w http.ResponseWriter) {
+	if len(s.decoyTemplates) > 0 {
+		s.decoyTemplates[secureRandInt(len(s.decoyTemplates))].write(w)
+		return
+	}
 	// v2.5.1: Randomize decoy to prevent DPI fingerprinting
-	servers := []string{"nginx/1.24.0", "nginx/1.25.4", "Apache/2.4.58", "cloudflare"}
-	w.Header().Set("Server", servers[secureRandInt(len(servers))])
+	w.Header().Set("Server", decoyServers[secureRandInt(len(decoyServers))])
 	w.Header().Set("Content-Type", "text/html")
 	decoyIdx := secureRandInt(3)
 	switch decoyIdx {
@@ -663,6 +1356,30 @@ func (s *Server) writeDecoy(w http.ResponseWriter) {
 	}
 }
 
+// writeDecoyStatus is writeDecoy for a known status: a decoy_responses
+// entry with that status if there is one (else any entry), nginx's page
+// without templates. Status 0 is writeDecoy.
+func (s *Server) writeDecoyStatus(w http.ResponseWriter, status int) {
+	if status == 0 {
+		s.writeDecoy(w)
+		return
+	}
+	if len(s.decoyTemplates) == 0 {
+		writeNginxPage(w, status)
+		return
+	}
+	var match []*decoyTemplate
+	for _, t := range s.decoyTemplates {
+		if t.status == status {
+			match = append(match, t)
+		}
+	}
+	if len(match) == 0 {
+		match = s.decoyTemplates
+	}
+	match[secureRandInt(len(match))].write(w)
+}
+
 func (s *Server) setTCPOptions(conn net.Conn) {
 	if tc, ok := conn.(*net.TCPConn); ok {
 		tc.SetNoDelay(true)
@@ -675,14 +1392,20 @@ func (s *Server) setTCPOptions(conn net.Conn) {
 
 // ──────────────── Shared Helpers ────────────────
 
-func buildSmuxConfig(cfg *Config) *smux.Config {
+// buildSmuxConfig returns the smux settings for a session on path;
+// nil (the server, which can't tell paths apart) uses the global ones.
+func buildSmuxConfig(cfg *Config, path *PathConfig) *smux.Config {
+	sm := cfg.Smux
+	if path != nil {
+		sm = path.Smux.over(sm)
+	}
 	sc := smux.DefaultConfig()
-	sc.Version = cfg.Smux.Version
+	sc.Version = sm.Version
 	if sc.Version < 1 {
 		sc.Version = 2
 	}
 
-	keepalive := time.Duration(cfg.Smux.KeepAlive) * time.Second
+	keepalive := time.Duration(sm.KeepAlive) * time.Second
 	if keepalive <= 0 {
 		keepalive = 2 * time.Second
 	}
@@ -703,14 +1426,14 @@ func buildSmuxConfig(cfg *Config) *smux.Config {
 		sc.KeepAliveTimeout = 30 * time.Second
 	}
 
-	if cfg.Smux.MaxRecv > 0 {
-		sc.MaxReceiveBuffer = cfg.Smux.MaxRecv
+	if sm.MaxRecv > 0 {
+		sc.MaxReceiveBuffer = sm.MaxRecv
 	}
-	if cfg.Smux.MaxStream > 0 {
-		sc.MaxStreamBuffer = cfg.Smux.MaxStream
+	if sm.MaxStream > 0 {
+		sc.MaxStreamBuffer = sm.MaxStream
 	}
-	if cfg.Smux.FrameSize > 0 {
-		sc.MaxFrameSize = cfg.Smux.FrameSize
+	if sm.FrameSize > 0 {
+		sc.MaxFrameSize = sm.FrameSize
 	}
 	return sc
 }
@@ -729,6 +1452,32 @@ func splitTarget(s string) (network, addr string) {
 	return "tcp", strings.TrimPrefix(s, "tcp://")
 }
 
+// Stream targets can carry flags in the scheme, "tcp+keep+warm4://x",
+// for per-map behaviour the other end must know about.
+
+// addTargetFlag appends flag to target's scheme.
+func addTargetFlag(target, flag string) string {
+	return strings.Replace(target, "://", "+"+flag+"://", 1)
+}
+
+// takeTargetFlag removes the first scheme flag starting with name and
+// returns the rest of that flag ("warm4" → "4").
+func takeTargetFlag(target, name string) (rest, value string, ok bool) {
+	i := strings.Index(target, "://")
+	if i < 0 {
+		return target, "", false
+	}
+	parts := strings.Split(target[:i], "+")
+	for j := 1; j < len(parts); j++ {
+		if strings.HasPrefix(parts[j], name) {
+			value = parts[j][len(name):]
+			parts = append(parts[:j], parts[j+1:]...)
+			return strings.Join(parts, "+") + target[i:], value, true
+		}
+	}
+	return target, "", false
+}
+
 func sendTarget(w io.Writer, target string) error {
 	b := []byte(target)
 	hdr := make([]byte, 2)
@@ -740,13 +1489,38 @@ func sendTarget(w io.Writer, target string) error {
 	return err
 }
 
-func relay(a, b io.ReadWriteCloser) {
+// relay copies both ways until one side ends, then closes both. With
+// idle > 0 it also closes both once neither side has sent anything for
+// idle, so streams whose peer died silently (crashed client, dropped
+// NAT mapping) don't hold a mux stream and its buffers forever.
+func relay(a, b io.ReadWriteCloser, idle time.Duration) {
+	var last int64 // atomic: unix nanos of the last read on either side
 	done := make(chan struct{}, 2)
 	cp := func(dst io.Writer, src io.Reader) {
+		if idle > 0 {
+			src = &activityReader{r: src, last: &last}
+		}
 		buf := make([]byte, 64*1024) // v2.5.1: 64KB for speed
 		io.CopyBuffer(dst, src, buf)
 		done <- struct{}{}
 	}
+	if idle > 0 {
+		atomic.StoreInt64(&last, time.Now().UnixNano())
+		var t *time.Timer
+		check := func() {
+			if left := idle - time.Since(time.Unix(0, atomic.LoadInt64(&last))); left > 0 {
+				t.Reset(left)
+				return
+			}
+			a.Close()
+			b.Close()
+		}
+		// Created idle and armed only once t is set, since check reads
+		// it from the timer goroutine.
+		t = time.AfterFunc(math.MaxInt64, check)
+		t.Reset(idle)
+		defer t.Stop()
+	}
 	go cp(a, b)
 	go cp(b, a)
 	<-done
@@ -754,3 +1528,21 @@ func relay(a, b io.ReadWriteCloser) {
 	b.Close()
 	<-done
 }
+
+// streamIdle is the relay idle limit from advanced.stream_timeout.
+func streamIdle(cfg *Config) time.Duration {
+	return time.Duration(cfg.Advanced.StreamTimeout) * time.Second
+}
+
+type activityReader struct {
+	r    io.Reader
+	last *int64
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(r.last, time.Now().UnixNano())
+	}
+	return n, err
+}
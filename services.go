@@ -0,0 +1,58 @@
+package httpmux
+
+import (
+	"strings"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Named services (client)
+//
+//   # server
+//   maps:
+//     - { type: tcp, bind: "443", target: "@web" }
+//   # client
+//   services:
+//     web: "127.0.0.1:8443"
+//     ssh: "10.0.0.5:22"
+//
+// Reverse streams normally carry the literal target address and the
+// client dials whatever it is told, so anyone holding the PSK could
+// use the client to reach its whole LAN. A target "@name" is looked up
+// in the client's services: instead. Once services: is set it is also
+// a whitelist: literal targets are accepted only if they equal one of
+// the listed addresses, everything else is refused — except, with
+// accept_server_maps, targets of maps the server pushed (pushmaps.go).
+// ═══════════════════════════════════════════════════════════════
+
+const servicePrefix = "@"
+
+// resolveTarget maps a reverse stream target ("tcp://@web",
+// "udp://host:port") to the address the client may dial.
+func (c *Client) resolveTarget(target string) (string, bool) {
+	network, addr := splitTarget(target)
+	if name, ok := strings.CutPrefix(addr, servicePrefix); ok {
+		local, ok := c.cfg.Services[name]
+		if !ok {
+			return "", false
+		}
+		return network + "://" + local, true
+	}
+	if len(c.cfg.Services) == 0 {
+		return target, true
+	}
+	for _, local := range c.cfg.Services {
+		if local == addr {
+			return target, true
+		}
+	}
+	if c.cfg.AcceptServerMaps && c.serverMapTarget(addr) || c.exposesTarget(addr) {
+		return target, true
+	}
+	return "", false
+}
+
+// refuseTarget counts and logs a target resolveTarget rejected.
+func (c *Client) refuseTarget(target string) {
+	c.stats.incError("service_refused")
+	logDedupf("svc"+target, "[SERVICES] refusing %s — not in services:", target)
+}
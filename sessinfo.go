@@ -0,0 +1,296 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Session hello (client → server)
+//
+// Right after a session is up the client opens one StreamTypeHello
+// stream describing itself:
+//
+//   [0x04][2B len]["name=office-gw\ntag=office\n"]
+//
+// It travels inside the encrypted session like any stream. Servers
+// that predate it log an unknown stream type and carry on. It is sent
+// even when empty: it is also the first frame, which the server uses
+// to detect the multiplexer (mux.go).
+//
+// client_name is free text for operators: it shows in the server's
+// session logs, the admin API and stats (sessions per client). It is
+// not authenticated — anyone with the PSK can claim any name; per-user
+// PSKs (users:) are what identify a client reliably.
+//
+// Tags pin maps to clients. With several clients connected, reverse
+// maps round-robin over every session; a map with `tag:` only uses
+// sessions from clients that announced that tag:
+//
+//   client:   tag: "office"
+//   server:   maps:
+//               - { type: tcp, bind: "3389", target: "10.0.0.5:3389", tag: office }
+//
+// Maps without a tag keep using any session.
+//
+// A tag is announced by the client, so with a shared PSK any client can
+// claim any tag and receive that map's visitors. With users: a tag can
+// be bound to credentials: a user with a tags: list may only announce
+// those, and a tag listed by any user is refused from everyone else.
+// A refused tag is dropped, leaving the session on untagged maps only.
+//
+//   users:
+//     - { name: office, psk: "...", tags: [office] }
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	maxTagLen        = 64
+	maxClientNameLen = 64
+)
+
+type sessionInfo struct {
+	Name  string
+	Tag   string
+	ID    string // client process, for min_sessions
+	Min   int    // the path's min_sessions
+	Drain bool   // session renewed: open no new streams on it
+	Push  bool   // client takes the server's map list (pushmaps.go)
+
+	Expose  []pushedMap // maps the client asks for (clientmaps.go)
+	Refused []string    // answer: "tcp 0.0.0.0:8443 why" per refused map
+
+	Proto    int      // protocol version, 0 = predates negotiation (proto.go)
+	Features []string // optional features the sender speaks
+}
+
+func validClientName(name string) error {
+	if len(name) > maxClientNameLen {
+		return fmt.Errorf("longer than %d bytes", maxClientNameLen)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("%q: contains unprintable characters", name)
+		}
+	}
+	return nil
+}
+
+func validTag(tag string) error {
+	if len(tag) > maxTagLen {
+		return fmt.Errorf("longer than %d characters", maxTagLen)
+	}
+	for _, r := range tag {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("%q: only letters, digits, '-', '_' and '.'", tag)
+		}
+	}
+	return nil
+}
+
+func (si sessionInfo) encode() []byte {
+	var b strings.Builder
+	if si.Name != "" {
+		b.WriteString("name=" + si.Name + "\n")
+	}
+	if si.Tag != "" {
+		b.WriteString("tag=" + si.Tag + "\n")
+	}
+	if si.Min > 1 || len(si.Expose) > 0 {
+		b.WriteString("id=" + si.ID + "\nmin=" + strconv.Itoa(max(si.Min, 1)) + "\n")
+	}
+	for _, m := range si.Expose {
+		b.WriteString("expose=" + m.Network + " " + m.Bind + " " + m.Target + "\n")
+	}
+	for _, r := range si.Refused {
+		b.WriteString("refused=" + r + "\n")
+	}
+	if si.Drain {
+		b.WriteString("drain=1\n")
+	}
+	if si.Push {
+		b.WriteString("push=1\n")
+	}
+	if si.Proto > 0 {
+		b.WriteString("proto=" + strconv.Itoa(si.Proto) + "\nfeat=" + strings.Join(si.Features, ",") + "\n")
+	}
+	return []byte(b.String())
+}
+
+// parseSessionInfo reads the key=value lines, skipping unknown keys
+// and invalid values.
+func parseSessionInfo(p []byte) sessionInfo {
+	var si sessionInfo
+	for _, line := range strings.Split(string(p), "\n") {
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "name":
+			if validClientName(v) == nil {
+				si.Name = v
+			}
+		case "tag":
+			if validTag(v) == nil {
+				si.Tag = v
+			}
+		case "id":
+			if validTag(v) == nil {
+				si.ID = v
+			}
+		case "min":
+			if n, err := strconv.Atoi(v); err == nil && n > 0 && n <= maxMinSessions {
+				si.Min = n
+			}
+		case "expose":
+			if m := parsePushedMaps([]byte(v)); len(m) == 1 {
+				si.Expose = append(si.Expose, m[0])
+			}
+		case "refused":
+			si.Refused = append(si.Refused, v)
+		case "drain":
+			si.Drain = v == "1"
+		case "push":
+			si.Push = v == "1"
+		case "proto":
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				si.Proto = n
+			}
+		case "feat":
+			for _, f := range strings.Split(v, ",") {
+				if f != "" && validTag(f) == nil {
+					si.Features = append(si.Features, f)
+				}
+			}
+		}
+	}
+	return si
+}
+
+// ──────────── Client ────────────
+
+// sendSessionHello announces this client on a new session and reads
+// what the server speaks, or with drain says that the session is being
+// retired.
+func (c *Client) sendSessionHello(cs *clientSession, drain bool) {
+	si := sessionInfo{Name: c.cfg.ClientName, Tag: c.cfg.Tag, ID: c.instanceID,
+		Min: c.paths[cs.path].MinSessions, Drain: drain, Push: true, Expose: c.exposed}
+	if !drain {
+		protoAnnounce(&si)
+	}
+	payload := si.encode()
+	stream, err := cs.sess.OpenStream()
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	stream.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	msg := make([]byte, 3+len(payload))
+	msg[0] = StreamTypeHello
+	binary.BigEndian.PutUint16(msg[1:3], uint16(len(payload)))
+	copy(msg[3:], payload)
+	if _, err := stream.Write(msg); err != nil || drain {
+		return
+	}
+	stream.SetReadDeadline(time.Now().Add(protoReplyTimeout))
+	c.readHelloAnswer(cs, stream)
+}
+
+// ──────────── Server ────────────
+
+// serveSessionHello records what the client announced (type byte
+// already read), answers with what we speak and wakes visitors held
+// for a tagged session.
+func (s *Server) serveSessionHello(ss *serverSession, stream io.ReadWriter) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(stream, hdr[:]); err != nil {
+		return
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(stream, payload); err != nil {
+		return
+	}
+	si := parseSessionInfo(payload)
+	if !tagAllowed(s.Config.Users, ss.user, si.Tag) {
+		logDedupf("tag"+ss.user+si.Tag, "[SESSION] %s: user %q may not announce tag %q, ignoring it", ss.remote, ss.user, si.Tag)
+		si.Tag = ""
+	}
+	ss.info.Store(&si)
+	if si.Drain {
+		if s.Config.Verbose {
+			log.Printf("[SESSION] %s draining (renewed by the client)", ss.remote)
+		}
+		return
+	}
+	answerHello(stream, &si, s.features(), s.openClientMaps(&si))
+	if s.Config.Verbose {
+		log.Printf("[SESSION] %s speaks %s", ss.remote, si.protoString())
+	}
+	s.markWarm(ss, &si)
+	go s.pushMaps(ss)
+	if si.Name != "" {
+		s.stats.clientSession(si.Name)
+	}
+	if si.Name != "" || si.Tag != "" {
+		log.Printf("[SESSION] %s is%s", ss.remote, ss.userTag())
+	}
+	s.signalSession()
+}
+
+func (ss *serverSession) tag() string {
+	if si := ss.info.Load(); si != nil {
+		return si.Tag
+	}
+	return ""
+}
+
+func (ss *serverSession) clientName() string {
+	if si := ss.info.Load(); si != nil {
+		return si.Name
+	}
+	return ""
+}
+
+// serves reports whether ss may carry streams for a map pinned to tag.
+// Sessions of a client still warming up to its min_sessions don't, nor
+// do sessions the client is renewing.
+func (ss *serverSession) serves(tag string) bool {
+	if si := ss.info.Load(); si != nil && (si.Drain || si.Min > 1 && !ss.ready.Load()) {
+		return false
+	}
+	if id := mapOwner(tag); id != "" {
+		return ss.clientID() == id
+	}
+	return tag == "" || ss.tag() == tag
+}
+
+// tagAllowed reports whether a session of user may announce tag: one
+// of the user's tags: if it has any, otherwise a tag no user lists.
+func tagAllowed(users []UserConfig, user, tag string) bool {
+	if tag == "" {
+		return true
+	}
+	reserved := false
+	for _, u := range users {
+		if u.Name == user && len(u.Tags) > 0 {
+			return slices.Contains(u.Tags, tag)
+		}
+		reserved = reserved || slices.Contains(u.Tags, tag)
+	}
+	return !reserved
+}
+
+func logTags(cfg *Config) {
+	for _, m := range cfg.Maps {
+		if m.Tag != "" {
+			log.Printf("[MAP] %s %s pinned to clients tagged %q", m.Type, m.Bind, m.Tag)
+		}
+	}
+}
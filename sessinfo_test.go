@@ -0,0 +1,31 @@
+package httpmux
+
+import "testing"
+
+func TestTagAllowed(t *testing.T) {
+	users := []UserConfig{
+		{Name: "office", Tags: []string{"office", "lab"}},
+		{Name: "home"},
+		{Name: "bob"},
+	}
+	for _, c := range []struct {
+		user, tag string
+		ok        bool
+	}{
+		{"office", "office", true},
+		{"office", "lab", true},
+		{"office", "home", false}, // outside its own list
+		{"office", "", true},
+		{"bob", "office", false}, // reserved by office
+		{"bob", "bob", true},     // unlisted tags stay free
+		{"", "lab", false},       // shared psk
+		{"", "edge", true},
+	} {
+		if got := tagAllowed(users, c.user, c.tag); got != c.ok {
+			t.Errorf("user %q tag %q: %v, want %v", c.user, c.tag, got, c.ok)
+		}
+	}
+	if !tagAllowed(nil, "", "office") {
+		t.Error("tags refused without users:")
+	}
+}
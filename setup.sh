@@ -155,13 +155,20 @@ generate_ssl_cert() {
 
 create_systemd_service() {
     local MODE=$1
+    # The server is ready once its ports are bound. A client is ready only
+    # with a session up, which can take forever while the server is
+    # unreachable, so it stays Type=simple; both get the watchdog.
+    local SVC_TYPE="notify"
+    [ "$MODE" == "client" ] && SVC_TYPE="simple"
     cat > "$SYSTEMD_DIR/picotun-${MODE}.service" << SVCEOF
 [Unit]
 Description=PicoTun ${MODE^} (@amir6dev)
 After=network.target
 
 [Service]
-Type=simple
+Type=${SVC_TYPE}
+NotifyAccess=main
+WatchdogSec=60
 User=root
 WorkingDirectory=$CONFIG_DIR
 ExecStart=$INSTALL_DIR/picotun -c $CONFIG_DIR/${MODE}.yaml
@@ -603,7 +610,7 @@ advanced:
   max_streams_per_session: ${MAX_STREAMS:-512}
   cleanup_interval: 3
   connection_timeout: 30
-  stream_timeout: 60
+  stream_timeout: 300
   max_udp_flows: 1000
   udp_flow_timeout: 300
   udp_buffer_size: 4194304
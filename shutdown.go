@@ -0,0 +1,202 @@
+package httpmux
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Graceful shutdown & connection draining
+//
+// Shutdown() on Server/Client:
+//   ① stops accepting — listeners closed, new streams refused
+//   ② drains — waits for in-flight relays up to advanced.drain_timeout
+//   ③ closes every smux session, then Start() returns nil
+//
+// Start(ctx) calls Shutdown() when ctx is cancelled. Shutdown cancels
+// the lifecycle context at ①: tunnel dials, handshakes, pool workers
+// and per-stream target dials stop right away, while relays already
+// running get the drain period of ②.
+// ═══════════════════════════════════════════════════════════════
+
+// lifecycle is the shutdown state shared by Server and Client.
+type lifecycle struct {
+	closing  int32 // atomic
+	inflight int64 // atomic: active relays
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     <-chan struct{} // ctx.Done()
+	stopped  chan struct{}
+	resolver *resolver // target names (resolver.go), nil = system
+
+	mu      sync.Mutex
+	closers []io.Closer
+}
+
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    ctx.Done(),
+		stopped: make(chan struct{}),
+	}
+}
+
+func (l *lifecycle) isClosing() bool {
+	return atomic.LoadInt32(&l.closing) == 1
+}
+
+// track registers a listener to close when shutdown begins.
+func (l *lifecycle) track(c io.Closer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.isClosing() {
+		c.Close()
+		return
+	}
+	l.closers = append(l.closers, c)
+}
+
+// acquire marks one relay in flight; false once shutdown has begun.
+// It counts the relay before checking: begin sets closing before drain
+// reads inflight, so a relay drain doesn't see is one that sees closing.
+func (l *lifecycle) acquire() bool {
+	atomic.AddInt64(&l.inflight, 1)
+	if l.isClosing() {
+		atomic.AddInt64(&l.inflight, -1)
+		return false
+	}
+	return true
+}
+
+func (l *lifecycle) release() {
+	atomic.AddInt64(&l.inflight, -1)
+}
+
+// begin flips to closing and closes all tracked listeners.
+// Returns false if shutdown was already started.
+func (l *lifecycle) begin() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !atomic.CompareAndSwapInt32(&l.closing, 0, 1) {
+		return false
+	}
+	l.cancel()
+	for _, c := range l.closers {
+		c.Close()
+	}
+	l.closers = nil
+	return true
+}
+
+// drain waits until no relays are in flight or timeout passes and
+// returns how many were still active.
+func (l *lifecycle) drain(timeout time.Duration) int64 {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := atomic.LoadInt64(&l.inflight)
+		if n <= 0 || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (l *lifecycle) finish() {
+	close(l.stopped)
+}
+
+// sleep waits d, returning false early if shutdown begins.
+func (l *lifecycle) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// dial is net.DialTimeout that gives up when shutdown begins and
+// resolves names through resolver: when it is set.
+func (l *lifecycle) dial(network, addr string, timeout time.Duration) (net.Conn, error) {
+	return l.resolver.dial(l.ctx, network, addr, timeout)
+}
+
+func drainTimeout(cfg *Config) time.Duration {
+	return time.Duration(cfg.Advanced.DrainTimeout) * time.Second
+}
+
+// ──────────── Server ────────────
+
+// Shutdown stops accepting tunnels and streams, waits for in-flight
+// relays up to advanced.drain_timeout, then closes all sessions.
+func (s *Server) Shutdown() error {
+	if !s.life.begin() {
+		<-s.life.stopped
+		return nil
+	}
+	defer s.life.finish()
+	s.sd.stopping()
+
+	timeout := drainTimeout(s.Config)
+	log.Printf("[SHUTDOWN] listeners closed, draining %d relays (timeout %v)",
+		atomic.LoadInt64(&s.life.inflight), timeout)
+	left := s.life.drain(timeout)
+
+	s.poolMu.RLock()
+	sessions := append([]*serverSession(nil), s.sessions...)
+	s.poolMu.RUnlock()
+	for _, ss := range sessions {
+		ss.sess.Close()
+	}
+	log.Printf("[SHUTDOWN] closed %d sessions", len(sessions))
+	s.usage.write()
+	s.state.close()
+	s.tracer.close()
+
+	if left > 0 {
+		return fmt.Errorf("drain timeout: %d relays cut", left)
+	}
+	return nil
+}
+
+// ──────────── Client ────────────
+
+// Shutdown stops reconnecting and accepting streams, waits for
+// in-flight relays up to advanced.drain_timeout, then closes sessions.
+func (c *Client) Shutdown() error {
+	if !c.life.begin() {
+		<-c.life.stopped
+		return nil
+	}
+	defer c.life.finish()
+	c.sd.stopping()
+
+	timeout := drainTimeout(c.cfg)
+	log.Printf("[SHUTDOWN] draining %d relays (timeout %v)",
+		atomic.LoadInt64(&c.life.inflight), timeout)
+	left := c.life.drain(timeout)
+
+	c.sessMu.RLock()
+	sessions := append([]*clientSession(nil), c.sessions...)
+	c.sessMu.RUnlock()
+	for _, cs := range sessions {
+		cs.sess.Close()
+	}
+	log.Printf("[SHUTDOWN] closed %d sessions", len(sessions))
+	c.tracer.close()
+
+	if left > 0 {
+		return fmt.Errorf("drain timeout: %d relays cut", left)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package httpmux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// SNI routing (httpsmux server)
+//
+//   sni_routing:
+//     fallback: "127.0.0.1:8443"     # gets every other TLS connection
+//     tunnel: ["cdn.example.com"]    # default: mimic.fake_domain,
+//                                    # stealth.domain_pool, acme.domains
+//
+// The TLS listener reads the ClientHello before handshaking. If its
+// server name is one of the tunnel names the handshake goes on as
+// usual; anything else — another SNI, no SNI, not TLS at all — is
+// passed byte for byte to fallback, e.g. a real website on the same
+// IP. The TLS session is never terminated for those, so an active
+// probe gets that site's genuine certificate and pages.
+//
+// "*.example.com" in tunnel matches any subdomain.
+// ═══════════════════════════════════════════════════════════════
+
+type SNIRoutingConfig struct {
+	Fallback string   `yaml:"fallback"`
+	Tunnel   []string `yaml:"tunnel"`
+}
+
+const maxHelloRecord = 16384 + 5
+
+type sniRouter struct {
+	fallback string
+	exact    map[string]bool
+	suffixes []string // ".example.com"
+	idle     time.Duration
+	stats    *Stats
+}
+
+// newSNIRouter returns nil unless sni_routing.fallback is set.
+func newSNIRouter(cfg *Config, stats *Stats) *sniRouter {
+	sr := &cfg.SNIRouting
+	if sr.Fallback == "" {
+		return nil
+	}
+	names := sr.Tunnel
+	if len(names) == 0 {
+		names = append(names, cfg.Mimic.FakeDomain)
+		names = append(names, cfg.Stealth.DomainPool...)
+		names = append(names, cfg.ACME.Domains...)
+	}
+	r := &sniRouter{fallback: sr.Fallback, exact: map[string]bool{}, idle: streamIdle(cfg), stats: stats}
+	for _, n := range names {
+		n = strings.ToLower(strings.TrimSpace(n))
+		switch {
+		case n == "":
+		case strings.HasPrefix(n, "*."):
+			r.suffixes = append(r.suffixes, n[1:])
+		default:
+			r.exact[n] = true
+		}
+	}
+	return r
+}
+
+func (r *sniRouter) isTunnel(sni string) bool {
+	sni = strings.ToLower(sni)
+	if r.exact[sni] {
+		return true
+	}
+	for _, s := range r.suffixes {
+		if strings.HasSuffix(sni, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *sniRouter) logConfig() {
+	names := make([]string, 0, len(r.exact)+len(r.suffixes))
+	for n := range r.exact {
+		names = append(names, n)
+	}
+	for _, s := range r.suffixes {
+		names = append(names, "*"+s)
+	}
+	log.Printf("[SNI] tunnel for %s, everything else → %s", strings.Join(names, ", "), r.fallback)
+}
+
+// route reads the ClientHello from conn (deadline already set). It
+// returns a conn replaying what was read for the tunnel, or nil after
+// handing conn to the fallback.
+func (r *sniRouter) route(conn net.Conn) net.Conn {
+	hello, sni, err := readClientHello(conn)
+	pc := &prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(hello), conn)}
+	if err == nil && r.isTunnel(sni) {
+		return pc
+	}
+	if err != nil && len(hello) == 0 {
+		conn.Close() // nothing sent before the deadline
+		return nil
+	}
+	conn.SetDeadline(time.Time{})
+	r.stats.incError("sni_fallback")
+	go r.passthrough(pc)
+	return nil
+}
+
+func (r *sniRouter) passthrough(conn net.Conn) {
+	defer guardPanic("sni passthrough")
+	defer conn.Close()
+	backend, err := net.DialTimeout("tcp", r.fallback, 10*time.Second)
+	if err != nil {
+		r.stats.incError("sni_fallback_dial")
+		logDedupf("sni"+r.fallback, "[SNI] fallback %s: %v", r.fallback, err)
+		return
+	}
+	defer backend.Close()
+	m, done := r.stats.connOpened("sni:" + r.fallback)
+	defer done()
+	relay(&countedConn{ReadWriteCloser: conn, st: r.stats, m: m}, backend, r.idle)
+}
+
+var errNotClientHello = errors.New("not a TLS ClientHello")
+
+// readClientHello reads the first TLS record and extracts the SNI.
+// It always returns the bytes it consumed.
+func readClientHello(conn net.Conn) ([]byte, string, error) {
+	buf := make([]byte, 5, 512)
+	if n, err := io.ReadFull(conn, buf); err != nil {
+		return buf[:n], "", err
+	}
+	if buf[0] != 0x16 { // handshake record
+		return buf, "", errNotClientHello
+	}
+	n := int(binary.BigEndian.Uint16(buf[3:5]))
+	if n == 0 || 5+n > maxHelloRecord {
+		return buf, "", errNotClientHello
+	}
+	buf = append(buf, make([]byte, n)...)
+	if m, err := io.ReadFull(conn, buf[5:]); err != nil {
+		return buf[:5+m], "", err
+	}
+	sni, err := parseSNI(buf[5:])
+	return buf, sni, err
+}
+
+// parseSNI finds server_name in a ClientHello handshake message.
+func parseSNI(b []byte) (string, error) {
+	// handshake: type(1) len(3) version(2) random(32)
+	if len(b) < 38 || b[0] != 1 {
+		return "", errNotClientHello
+	}
+	b = b[38:]
+	skip := func(lenBytes int) bool {
+		if len(b) < lenBytes {
+			return false
+		}
+		n := 0
+		for _, c := range b[:lenBytes] {
+			n = n<<8 | int(c)
+		}
+		if len(b) < lenBytes+n {
+			return false
+		}
+		b = b[lenBytes+n:]
+		return true
+	}
+	// session id, cipher suites, compression methods
+	if !skip(1) || !skip(2) || !skip(1) {
+		return "", errNotClientHello
+	}
+	if len(b) < 2 {
+		return "", nil // no extensions
+	}
+	exts := b[2:]
+	if n := int(binary.BigEndian.Uint16(b)); n < len(exts) {
+		exts = exts[:n]
+	}
+	for len(exts) >= 4 {
+		typ := binary.BigEndian.Uint16(exts)
+		n := int(binary.BigEndian.Uint16(exts[2:]))
+		if len(exts) < 4+n {
+			break
+		}
+		data := exts[4 : 4+n]
+		exts = exts[4+n:]
+		if typ != 0 { // server_name
+			continue
+		}
+		// list len(2), then entries: type(1) len(2) name
+		if len(data) < 2 {
+			break
+		}
+		data = data[2:]
+		for len(data) >= 3 {
+			kind := data[0]
+			l := int(binary.BigEndian.Uint16(data[1:]))
+			if len(data) < 3+l {
+				break
+			}
+			if kind == 0 { // host_name
+				return string(data[3 : 3+l]), nil
+			}
+			data = data[3+l:]
+		}
+		break
+	}
+	return "", nil
+}
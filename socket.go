@@ -0,0 +1,160 @@
+package httpmux
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Tunnel socket options (server, client)
+//
+//   socket:
+//     fast_open: true     # TCP_FASTOPEN (server) / TCP_FASTOPEN_CONNECT (client)
+//     reuse_port: true    # SO_REUSEPORT on the tunnel listeners (server)
+//     dscp: af41          # DSCP of tunnel packets: ef, afXY, csN or 0-63
+//     listeners: 4        # accept loops per tunnel port (server)
+//
+// fast_open saves a round trip on every new tunnel connection: a client
+// that has talked to the server before sends its first bytes in the
+// SYN. Both ends must enable it, and the kernel's net.ipv4.tcp_fastopen
+// must allow it (3 = client and server). Middleboxes that drop SYNs
+// carrying data make the kernel fall back on its own.
+//
+// reuse_port lets several picotun processes listen on the same tunnel
+// port, the kernel spreading connections between them — a restart
+// without a gap, or one process per core.
+//
+// listeners opens that many sockets on each tunnel port, SO_REUSEPORT
+// implied, each with its own accept loop and, on TLS transports, its
+// own advanced.handshake_workers: the kernel hashes incoming
+// connections across them, so with many users connecting at once the
+// accepts don't queue behind one goroutine. Around one per core is
+// plenty.
+//
+// dscp marks the tunnel's packets so routers that honour it queue them
+// accordingly (ef for voice-like latency, cs1 for scavenger). Networks
+// that bleach or penalise marks are common; leave it unset unless the
+// path is known to respect it.
+//
+// Linux only (socketSupported); check warns elsewhere.
+// ═══════════════════════════════════════════════════════════════
+
+type SocketConfig struct {
+	FastOpen  bool   `yaml:"fast_open"`
+	ReusePort bool   `yaml:"reuse_port"`
+	DSCP      string `yaml:"dscp"`
+	Listeners int    `yaml:"listeners"`
+}
+
+const maxListeners = 64
+
+// socketOpts is the compiled socket: block; nil sets nothing.
+type socketOpts struct {
+	fastOpen  bool
+	reusePort bool
+	tos       int // DSCP << 2, 0 = unmarked
+	listeners int // per tunnel port, 0 = one
+}
+
+func newSocketOpts(cfg *SocketConfig) (*socketOpts, error) {
+	dscp, err := parseDSCP(cfg.DSCP)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Listeners < 0 || cfg.Listeners > maxListeners {
+		return nil, fmt.Errorf("listeners %d: want 1-%d", cfg.Listeners, maxListeners)
+	}
+	if !cfg.FastOpen && !cfg.ReusePort && dscp == 0 && cfg.Listeners <= 1 {
+		return nil, nil
+	}
+	return &socketOpts{
+		fastOpen:  cfg.FastOpen,
+		reusePort: cfg.ReusePort || cfg.Listeners > 1,
+		tos:       dscp << 2,
+		listeners: cfg.Listeners,
+	}, nil
+}
+
+// parseDSCP reads a code point name (ef, af11-af43, cs0-cs7) or number.
+func parseDSCP(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "":
+		return 0, nil
+	case s == "ef":
+		return 46, nil
+	case len(s) == 4 && strings.HasPrefix(s, "af") && s[2] >= '1' && s[2] <= '4' && s[3] >= '1' && s[3] <= '3':
+		return int(s[2]-'0')*8 + int(s[3]-'0')*2, nil
+	case len(s) == 3 && strings.HasPrefix(s, "cs") && s[2] >= '0' && s[2] <= '7':
+		return int(s[2]-'0') * 8, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 63 {
+		return 0, fmt.Errorf("dscp %q: want ef, afXY, csN or 0-63", s)
+	}
+	return n, nil
+}
+
+// listen opens a tunnel listener on addr.
+func (o *socketOpts) listen(addr string) (net.Listener, error) {
+	if o == nil || !socketSupported {
+		return net.Listen("tcp", addr)
+	}
+	lc := net.ListenConfig{Control: func(network, _ string, c syscall.RawConn) error {
+		return rawControl(c, func(fd uintptr) error { return o.applyListen(network, fd) })
+	}}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// listenShards opens the configured number of listeners on addr,
+// sharing the port through SO_REUSEPORT.
+func (o *socketOpts) listenShards(addr string) ([]net.Listener, error) {
+	first, err := o.listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	lns := []net.Listener{first}
+	if o == nil || !socketSupported {
+		return lns, nil
+	}
+	// A ":0" addr gets its port from the first listener.
+	addr = first.Addr().String()
+	for len(lns) < o.listeners {
+		ln, err := o.listen(addr)
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// dialTCP is dialPlainTCP with the options set before connecting.
+func (o *socketOpts) dialTCP(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	if o == nil || !socketSupported {
+		return dialPlainTCP(ctx, addr, timeout)
+	}
+	d := net.Dialer{Timeout: timeout, Control: func(network, _ string, c syscall.RawConn) error {
+		return rawControl(c, func(fd uintptr) error { return o.applyDial(network, fd) })
+	}}
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func rawControl(c syscall.RawConn, f func(fd uintptr) error) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) { serr = f(fd) }); err != nil {
+		return err
+	}
+	if serr != nil {
+		return fmt.Errorf("socket: %w", serr)
+	}
+	return nil
+}
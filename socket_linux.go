@@ -0,0 +1,50 @@
+//go:build linux
+
+package httpmux
+
+import (
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	socketSupported = true
+	fastOpenQueue   = 256 // pending fast open handshakes per listener
+)
+
+func (o *socketOpts) applyListen(network string, fd uintptr) error {
+	if o.reusePort {
+		if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return err
+		}
+	}
+	if o.fastOpen {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, fastOpenQueue); err != nil {
+			return err
+		}
+	}
+	return o.applyTOS(network, fd)
+}
+
+func (o *socketOpts) applyDial(network string, fd uintptr) error {
+	if o.fastOpen {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1); err != nil {
+			return err
+		}
+	}
+	return o.applyTOS(network, fd)
+}
+
+// applyTOS marks the socket's packets. IPv6 sockets also carry IPv4
+// (mapped) traffic, so they get both marks.
+func (o *socketOpts) applyTOS(network string, fd uintptr) error {
+	if o.tos == 0 {
+		return nil
+	}
+	if strings.HasSuffix(network, "4") {
+		return unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, o.tos)
+	}
+	unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, o.tos)
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, o.tos)
+}
@@ -0,0 +1,93 @@
+package httpmux
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseDSCP(t *testing.T) {
+	for in, want := range map[string]int{"": 0, "ef": 46, "AF41": 34, "af11": 10, "cs1": 8, "63": 63} {
+		if got, err := parseDSCP(in); err != nil || got != want {
+			t.Errorf("parseDSCP(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"af51", "cs8", "64", "fast"} {
+		if _, err := parseDSCP(in); err == nil {
+			t.Errorf("parseDSCP(%q) accepted", in)
+		}
+	}
+}
+
+func TestSocketOpts(t *testing.T) {
+	o, err := newSocketOpts(&SocketConfig{ReusePort: true, FastOpen: true, DSCP: "ef"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := o.listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	second, err := o.listen(ln.Addr().String())
+	if err != nil {
+		t.Fatalf("reuse_port: second listener: %v", err)
+	}
+	second.Close()
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	conn, err := o.dialTCP(context.Background(), ln.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw, _ := conn.(*net.TCPConn).SyscallConn()
+	var tos int
+	raw.Control(func(fd uintptr) {
+		tos, err = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+	})
+	if err != nil || tos != 46<<2 {
+		t.Fatalf("dialed socket tos %#x, %v; want %#x", tos, err, 46<<2)
+	}
+}
+
+func TestListenShards(t *testing.T) {
+	o, err := newSocketOpts(&SocketConfig{Listeners: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lns, err := o.listenShards("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lns) != 3 {
+		t.Fatalf("%d listeners, want 3", len(lns))
+	}
+	for _, ln := range lns {
+		defer ln.Close()
+		if ln.Addr().String() != lns[0].Addr().String() {
+			t.Fatalf("listener on %s, want %s", ln.Addr(), lns[0].Addr())
+		}
+	}
+	if _, err := newSocketOpts(&SocketConfig{Listeners: maxListeners + 1}); err == nil {
+		t.Fatal("listeners over the maximum accepted")
+	}
+}
+
+func TestTunnelSocketOpts(t *testing.T) {
+	bind := freeAddr(t)
+	socket := "socket: {fast_open: true, listeners: 4, dscp: af41}\n"
+	startTunnel(t, tunnelOpts{server: socket + mapYAML("tcp", bind, tcpEcho(t)), client: socket})
+	checkEcho(t, dialMap(t, "tcp", bind), randomBytes(t, 64<<10))
+}
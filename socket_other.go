@@ -0,0 +1,8 @@
+//go:build !linux
+
+package httpmux
+
+const socketSupported = false
+
+func (o *socketOpts) applyListen(network string, fd uintptr) error { return nil }
+func (o *socketOpts) applyDial(network string, fd uintptr) error   { return nil }
@@ -0,0 +1,680 @@
+package httpmux
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// SOCKS5 frontend (client)
+//
+//   socks5:
+//     listen: "127.0.0.1:1080"
+//     username: ""        # optional RFC 1929 auth
+//     password: ""
+//     disable_udp: false
+//     remote_dns: ""      # see remotedns.go
+//
+// CONNECT opens a normal forward stream to tcp://host:port.
+//
+// UDP ASSOCIATE (RFC 1928 §7) binds a UDP relay next to the control
+// connection and carries every datagram of the association over ONE
+// tunnel stream ("udpassoc://"). The server sends from a single UDP
+// socket per association — endpoint-independent mapping, so STUN and
+// game/VoIP hole punching see one stable address — and only accepts
+// replies from hosts the association has sent to. Fragmented
+// datagrams (FRAG ≠ 0) are reassembled before entering the tunnel.
+// The association ends when the TCP control connection closes.
+//
+// Association stream frames: [2B len][ATYP addr port][payload]
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	udpAssocTarget = "udpassoc://"
+
+	socksVersion = 0x05
+
+	socksCmdConnect      = 0x01
+	socksCmdBind         = 0x02
+	socksCmdUDPAssociate = 0x03
+
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+
+	socksRepSuccess         = 0x00
+	socksRepFailure         = 0x01
+	socksRepCmdNotSupported = 0x07
+	socksRepAtypUnsupported = 0x08
+
+	socksFragTimeout = 5 * time.Second // RFC 1928 minimum
+	socksMaxDatagram = 64 * 1024
+)
+
+type SOCKS5Config struct {
+	Listen     string `yaml:"listen"` // "" = disabled
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	DisableUDP bool   `yaml:"disable_udp"`
+
+	// RemoteDNS answers the apps' lookups with placeholders so names
+	// are resolved on the server (remotedns.go).
+	RemoteDNS      string `yaml:"remote_dns"`
+	RemoteDNSRange string `yaml:"remote_dns_range"`
+}
+
+// ──────────── Address encoding ────────────
+
+// readSocksAddr reads ATYP/DST.ADDR/DST.PORT and returns "host:port".
+func readSocksAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if atyp[0] == socksAtypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errSocksAtyp
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+var errSocksAtyp = errors.New("socks5: unsupported address type")
+
+// parseSocksAddr decodes an address at the start of b and returns it
+// with the number of bytes consumed.
+func parseSocksAddr(b []byte) (string, int, error) {
+	r := &sliceReader{b: b}
+	addr, err := readSocksAddr(r)
+	if err != nil {
+		if err == errSocksAtyp {
+			return "", 0, err
+		}
+		return "", 0, fmt.Errorf("socks5: short address")
+	}
+	return addr, r.off, nil
+}
+
+type sliceReader struct {
+	b   []byte
+	off int
+}
+
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if r.off >= len(r.b) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b[r.off:])
+	r.off += n
+	return n, nil
+}
+
+// appendSocksAddr encodes host:port (IP or domain) onto b.
+func appendSocksAddr(b []byte, addr string) []byte {
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(append(b, socksAtypIPv4), ip4...)
+		} else {
+			b = append(append(b, socksAtypIPv6), ip.To16()...)
+		}
+	} else {
+		b = append(append(b, socksAtypDomain, byte(len(host))), host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// ──────────── Association framing ────────────
+
+// Association frames are datagrams (datagram.go) whose payload starts
+// with a SOCKS address.
+
+func writeAssocFrame(w io.Writer, addr string, data []byte) error {
+	frame := make([]byte, 0, len(addr)+8+len(data))
+	frame = appendSocksAddr(frame, addr)
+	return writeDatagram(w, append(frame, data...))
+}
+
+func readAssocFrame(r io.Reader) (string, []byte, error) {
+	body := make([]byte, maxDatagram)
+	n, err := readDatagram(r, body)
+	if err != nil {
+		return "", nil, err
+	}
+	body = body[:n]
+	addr, n, err := parseSocksAddr(body)
+	if err != nil {
+		return "", nil, err
+	}
+	return addr, body[n:], nil
+}
+
+// ──────────── Client frontend ────────────
+
+func (c *Client) startSOCKS5() {
+	cfg := &c.cfg.SOCKS5
+	if cfg.Listen == "" {
+		return
+	}
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.Printf("[SOCKS5] FAILED listen %s: %v", cfg.Listen, err)
+		return
+	}
+	log.Printf("[SOCKS5] %s (auth=%v udp=%v)", cfg.Listen, cfg.Username != "", !cfg.DisableUDP)
+	c.life.track(ln)
+	c.startRemoteDNS()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if c.life.isClosing() {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			go c.handleSOCKS5(conn)
+		}
+	}()
+}
+
+func (c *Client) handleSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	if !c.life.acquire() {
+		return
+	}
+	defer c.life.release()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := c.socksNegotiate(conn); err != nil {
+		if c.verbose {
+			log.Printf("[SOCKS5] %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	var req [3]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil || req[0] != socksVersion {
+		return
+	}
+	dst, err := readSocksAddr(conn)
+	if err != nil {
+		if err == errSocksAtyp {
+			socksReply(conn, socksRepAtypUnsupported, "")
+		}
+		return
+	}
+	dst = c.remoteDNS.unmap(dst)
+
+	switch req[1] {
+	case socksCmdConnect:
+		c.proxyConnect(conn, dst, "socks5", func(ok bool) {
+			if ok {
+				socksReply(conn, socksRepSuccess, "")
+			} else {
+				socksReply(conn, socksRepFailure, "")
+			}
+		})
+
+	case socksCmdUDPAssociate:
+		if c.cfg.SOCKS5.DisableUDP {
+			socksReply(conn, socksRepCmdNotSupported, "")
+			return
+		}
+		c.socksUDPAssociate(conn, dst)
+
+	default: // BIND isn't meaningful through the tunnel
+		socksReply(conn, socksRepCmdNotSupported, "")
+	}
+}
+
+// proxyConnect relays conn to dst through the tunnel or, as routing:
+// decides, directly. reply tells the frontend (stats and span name)
+// whether the connection went through before any data is relayed.
+func (c *Client) proxyConnect(conn net.Conn, dst, frontend string, reply func(ok bool)) {
+	rt := c.router.route(c.life.ctx, dst)
+	if rt.direct {
+		c.dialDirect(conn, dst, reply)
+		return
+	}
+	if grace := c.router.fallbackGrace(); grace > 0 && c.sessionCount() == 0 {
+		conn.SetDeadline(time.Now().Add(grace + 10*time.Second))
+		c.waitSession(grace)
+	}
+	sp := c.tracer.startStream(frontend, spanKindServer)
+	defer sp.end()
+	sp.attr("client.address", conn.RemoteAddr().String())
+	sp.attr("picotun.target", "tcp://"+dst)
+	target := "tcp://" + dst
+	if rt.prio != "" {
+		target = prioTarget(target, rt.prio)
+	}
+	stream, err := c.openStream(target, sp)
+	if err != nil {
+		sp.fail(err)
+		c.stats.incError("no_session")
+		if rt.failOpen {
+			c.stats.incError("fallback")
+			sp.event("fallback_direct")
+			c.dialDirect(conn, dst, reply)
+			return
+		}
+		reply(false)
+		return
+	}
+	defer stream.Close()
+	sp.event("stream_opened")
+	reply(true)
+	conn.SetDeadline(time.Time{})
+	m, done := c.stats.connOpened(frontend)
+	defer done()
+	relay(&countedConn{ReadWriteCloser: conn, st: c.stats, m: m, span: sp}, stream, streamIdle(c.cfg))
+}
+
+// dialDirect serves a connection that routing: sends around the tunnel.
+func (c *Client) dialDirect(conn net.Conn, dst string, reply func(ok bool)) {
+	remote, err := c.life.dial("tcp", dst, 10*time.Second)
+	if err != nil {
+		c.stats.incError("dial")
+		if c.verbose {
+			logDedupf("direct"+dst, "[ROUTE] direct %s: %v", dst, err)
+		}
+		reply(false)
+		return
+	}
+	defer remote.Close()
+	reply(true)
+	conn.SetDeadline(time.Time{})
+	m, done := c.stats.connOpened("direct")
+	defer done()
+	relay(&countedConn{ReadWriteCloser: conn, st: c.stats, m: m}, remote, streamIdle(c.cfg))
+}
+
+// socksNegotiate performs method selection and optional RFC 1929 auth.
+func (c *Client) socksNegotiate(conn net.Conn) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != socksVersion {
+		return fmt.Errorf("version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+
+	want := byte(0x00)
+	if c.cfg.SOCKS5.Username != "" {
+		want = 0x02
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		conn.Write([]byte{socksVersion, 0xFF})
+		return fmt.Errorf("no acceptable auth method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, want}); err != nil {
+		return err
+	}
+	if want == 0x00 {
+		return nil
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	var ver [2]byte
+	if _, err := io.ReadFull(conn, ver[:]); err != nil {
+		return err
+	}
+	if ver[0] != 0x01 {
+		conn.Write([]byte{0x01, 0x01})
+		return fmt.Errorf("bad auth version %#x", ver[0])
+	}
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return err
+	}
+	var plen [1]byte
+	if _, err := io.ReadFull(conn, plen[:]); err != nil {
+		return err
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return err
+	}
+	userOK := subtle.ConstantTimeCompare(user, []byte(c.cfg.SOCKS5.Username))
+	passOK := subtle.ConstantTimeCompare(pass, []byte(c.cfg.SOCKS5.Password))
+	if userOK&passOK != 1 {
+		conn.Write([]byte{0x01, 0x01})
+		return fmt.Errorf("bad credentials for %q", user)
+	}
+	_, err := conn.Write([]byte{0x01, 0x00})
+	return err
+}
+
+// socksReply writes VER REP RSV BND.ADDR BND.PORT (bind "" = 0.0.0.0:0).
+func socksReply(w io.Writer, rep byte, bind string) error {
+	if bind == "" {
+		bind = "0.0.0.0:0"
+	}
+	_, err := w.Write(appendSocksAddr([]byte{socksVersion, rep, 0x00}, bind))
+	return err
+}
+
+// socksUDPAssociate runs one association until the control connection
+// or the tunnel stream closes.
+func (c *Client) socksUDPAssociate(ctrl net.Conn, requested string) {
+	localIP := ctrl.LocalAddr().(*net.TCPAddr).IP
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		socksReply(ctrl, socksRepFailure, "")
+		return
+	}
+	defer pc.Close()
+
+	if grace := c.router.fallbackGrace(); grace > 0 && c.sessionCount() == 0 {
+		ctrl.SetDeadline(time.Now().Add(grace + 10*time.Second))
+		c.waitSession(grace)
+	}
+	// Without a stream (tunnel down, fallback: direct) only the
+	// datagrams that may fail open are sent, all directly.
+	var st *countedConn
+	stream, err := c.OpenStream(udpAssocTarget)
+	if err != nil {
+		c.stats.incError("no_session")
+		if !c.router.mayFailOpen() {
+			socksReply(ctrl, socksRepFailure, "")
+			return
+		}
+		c.stats.incError("fallback")
+	} else {
+		defer stream.Close()
+	}
+
+	if err := socksReply(ctrl, socksRepSuccess, pc.LocalAddr().String()); err != nil {
+		return
+	}
+	ctrl.SetDeadline(time.Time{})
+	m, done := c.stats.connOpened("socks5-udp")
+	defer done()
+	if stream != nil {
+		st = &countedConn{ReadWriteCloser: stream, st: c.stats, m: m}
+	}
+
+	// The association lives exactly as long as the control connection.
+	go func() {
+		io.Copy(io.Discard, ctrl)
+		pc.Close()
+		if stream != nil {
+			stream.Close()
+		}
+	}()
+
+	// Only the client that asked may use the relay. A zero port (or
+	// address) in the request means "not known yet" — lock onto the
+	// first datagram from the control connection's host.
+	ctrlIP := ctrl.RemoteAddr().(*net.TCPAddr).IP
+	var reqPort int
+	if _, p, err := net.SplitHostPort(requested); err == nil {
+		reqPort, _ = strconv.Atoi(p)
+	}
+	var (
+		peerMu sync.Mutex
+		peer   *net.UDPAddr
+	)
+
+	// tunnel → app
+	tunnelReplies := func() {
+		defer pc.Close()
+		for {
+			from, data, err := readAssocFrame(st)
+			if err != nil {
+				return
+			}
+			peerMu.Lock()
+			to := peer
+			peerMu.Unlock()
+			if to == nil {
+				continue
+			}
+			pkt := appendSocksAddr([]byte{0, 0, 0}, from)
+			pc.WriteToUDP(append(pkt, data...), to)
+		}
+	}
+	if st != nil {
+		go tunnelReplies()
+	}
+
+	// Datagrams routed direct go out of their own socket, which only
+	// takes replies from hosts sent to, like the server's.
+	var (
+		direct    *net.UDPConn
+		contacted sync.Map
+	)
+	directReplies := func(direct *net.UDPConn) {
+		buf := make([]byte, socksMaxDatagram)
+		for {
+			n, from, err := direct.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if _, ok := contacted.Load(from.IP.String()); !ok {
+				continue
+			}
+			peerMu.Lock()
+			to := peer
+			peerMu.Unlock()
+			pkt := appendSocksAddr([]byte{0, 0, 0}, from.String())
+			pc.WriteToUDP(append(pkt, buf[:n]...), to)
+		}
+	}
+
+	// app → tunnel
+	var reasm socksReassembler
+	buf := make([]byte, socksMaxDatagram)
+	for {
+		n, from, err := pc.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.IP.Equal(ctrlIP) || (reqPort != 0 && from.Port != reqPort) {
+			continue
+		}
+		peerMu.Lock()
+		if peer == nil {
+			peer = from
+		}
+		ok := peer.Port == from.Port
+		peerMu.Unlock()
+		if !ok || n < 4 || buf[0] != 0 || buf[1] != 0 {
+			continue
+		}
+		dst, alen, err := parseSocksAddr(buf[3:n])
+		if err != nil {
+			continue
+		}
+		dst, data, ready := reasm.feed(buf[2], dst, buf[3+alen:n])
+		if !ready {
+			continue
+		}
+		dst = c.remoteDNS.unmap(dst)
+		rt := c.router.route(c.life.ctx, dst)
+		if st == nil && !rt.failOpen && !rt.direct {
+			continue
+		}
+		if rt.direct || st == nil {
+			if direct == nil {
+				if direct, err = net.ListenUDP("udp", nil); err != nil {
+					continue
+				}
+				defer direct.Close()
+				go directReplies(direct)
+			}
+			ua, err := c.life.resolver.resolveUDP(c.life.ctx, dst)
+			if err != nil {
+				continue
+			}
+			contacted.Store(ua.IP.String(), true)
+			direct.WriteToUDP(data, ua)
+			continue
+		}
+		if err := writeAssocFrame(st, dst, data); errors.Is(err, errDatagramTooLarge) {
+			continue // dropped, like any oversized UDP datagram
+		} else if err != nil {
+			return
+		}
+	}
+}
+
+// socksReassembler implements the RFC 1928 §7 fragment queue: FRAG
+// 1..127 is the position, the high bit marks the last fragment.
+type socksReassembler struct {
+	dst     string
+	parts   []byte
+	last    byte // highest position seen
+	started time.Time
+}
+
+func (r *socksReassembler) reset() {
+	r.dst, r.parts, r.last = "", nil, 0
+}
+
+// feed takes one datagram and returns a complete payload when ready.
+func (r *socksReassembler) feed(frag byte, dst string, data []byte) (string, []byte, bool) {
+	if frag == 0 {
+		r.reset() // standalone datagram abandons any partial sequence
+		return dst, append([]byte(nil), data...), true
+	}
+	pos := frag & 0x7F
+	if r.last != 0 && time.Since(r.started) > socksFragTimeout {
+		r.reset()
+	}
+	if pos <= r.last {
+		r.reset() // lower FRAG restarts the sequence
+	}
+	if pos != r.last+1 {
+		r.reset() // gap: drop the sequence
+		return "", nil, false
+	}
+	if pos == 1 {
+		r.dst, r.started = dst, time.Now()
+	}
+	// The whole payload must still fit one tunnel frame with its address.
+	if len(r.parts)+len(data) > maxDatagram-len(appendSocksAddr(nil, r.dst)) {
+		r.reset() // oversize: drop the sequence
+		return "", nil, false
+	}
+	r.parts = append(r.parts, data...)
+	r.last = pos
+	if frag&0x80 == 0 {
+		return "", nil, false
+	}
+	dst, out := r.dst, r.parts
+	r.reset()
+	return dst, out, true
+}
+
+// ──────────── Server side ────────────
+
+// serveUDPAssociation relays one SOCKS5 UDP association: a single
+// outbound socket, replies accepted only from hosts we've sent to.
+func (s *Server) serveUDPAssociation(stream io.ReadWriteCloser) {
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		s.stats.incError("dial")
+		stream.Close()
+		return
+	}
+	defer pc.Close()
+	defer stream.Close()
+
+	m, done := s.stats.connOpened("udp-assoc")
+	defer done()
+	st := &countedConn{ReadWriteCloser: stream, st: s.stats, m: m}
+
+	var (
+		mu        sync.Mutex
+		contacted = make(map[string]struct{})
+	)
+
+	go func() {
+		defer stream.Close()
+		buf := make([]byte, socksMaxDatagram)
+		for {
+			n, from, err := pc.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			_, ok := contacted[from.IP.String()]
+			mu.Unlock()
+			if !ok {
+				continue
+			}
+			if err := writeAssocFrame(st, from.String(), buf[:n]); errors.Is(err, errDatagramTooLarge) {
+				continue
+			} else if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		dst, data, err := readAssocFrame(st)
+		if err != nil {
+			return
+		}
+		dial, ok := s.aclCheck("udp", dst)
+		if !ok {
+			continue
+		}
+		ua, err := s.life.resolver.resolveUDP(s.life.ctx, dial)
+		if err != nil {
+			if s.Verbose {
+				logDedupf(dst, "[UDP-ASSOC] resolve %s: %v", dst, err)
+			}
+			continue
+		}
+		mu.Lock()
+		contacted[ua.IP.String()] = struct{}{}
+		mu.Unlock()
+		pc.WriteToUDP(data, ua)
+	}
+}
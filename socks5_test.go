@@ -0,0 +1,76 @@
+package httpmux
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestSOCKS5Auth(t *testing.T) {
+	c := &Client{cfg: &Config{SOCKS5: SOCKS5Config{Username: "user", Password: "secret"}}}
+	auth := func(ver byte, user, pass string) []byte {
+		b := append([]byte{ver, byte(len(user))}, user...)
+		return append(append(b, byte(len(pass))), pass...)
+	}
+	for _, tc := range []struct {
+		name string
+		sub  []byte
+		ok   bool
+	}{
+		{"valid", auth(0x01, "user", "secret"), true},
+		{"wrong password", auth(0x01, "user", "secreT"), false},
+		{"password prefix", auth(0x01, "user", "secre"), false},
+		{"wrong user", auth(0x01, "usr", "secret"), false},
+		{"sub-negotiation version 5", auth(0x05, "user", "secret"), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer a.Close()
+			go func() {
+				a.Write([]byte{socksVersion, 1, 0x02})
+				a.Write(tc.sub)
+			}()
+			errc := make(chan error, 1)
+			go func() { errc <- c.socksNegotiate(b) }()
+
+			reply := make([]byte, 4) // method selection, then auth status
+			if _, err := a.Read(reply[:2]); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.Read(reply[2:]); err != nil {
+				t.Fatal(err)
+			}
+			err := <-errc
+			if ok := err == nil && reply[3] == 0x00; ok != tc.ok {
+				t.Fatalf("accepted=%v (reply %x, %v), want %v", ok, reply, err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestSOCKSReassembler(t *testing.T) {
+	var r socksReassembler
+	if _, _, ready := r.feed(0x01, "10.0.0.1:53", []byte("ab")); ready {
+		t.Fatal("first fragment ready")
+	}
+	dst, data, ready := r.feed(0x82, "10.0.0.1:53", []byte("cd"))
+	if !ready || dst != "10.0.0.1:53" || string(data) != "abcd" {
+		t.Fatalf("got %q %q %v", dst, data, ready)
+	}
+
+	// The reassembled payload plus its address must fit one tunnel frame.
+	limit := maxDatagram - len(appendSocksAddr(nil, "10.0.0.1:53"))
+	half := bytes.Repeat([]byte{'x'}, limit/2)
+	r.feed(0x01, "10.0.0.1:53", half)
+	if _, data, ready := r.feed(0x82, "10.0.0.1:53", append(half, 'y', 'y')); ready {
+		t.Fatalf("reassembled %d bytes past the %d limit", len(data), limit)
+	}
+	r.feed(0x01, "10.0.0.1:53", half)
+	_, data, ready = r.feed(0x82, "10.0.0.1:53", half[:limit-len(half)])
+	if !ready || len(data) != limit {
+		t.Fatalf("payload at the limit: %d bytes, ready %v", len(data), ready)
+	}
+	if err := writeAssocFrame(&bytes.Buffer{}, "10.0.0.1:53", data); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,166 @@
+package httpmux
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Tunnel speed test
+//
+//   picotun speedtest -c client.yaml [-mb 25] [-up]
+//
+// Measures throughput of the tunnel itself, without depending on an
+// outside speedtest site that the censor may treat differently from
+// ordinary traffic. The client opens a forward stream to
+// "speedtest://down/<bytes>" (server sends that many throwaway bytes,
+// then closes) or "speedtest://up/<bytes>" (server reads them and
+// answers with the 8-byte count it received).
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	speedtestTarget = "speedtest://"
+	speedtestMax    = 1 << 30 // per run
+	speedtestChunk  = 64 * 1024
+)
+
+func isSpeedtestTarget(target string) bool {
+	return strings.HasPrefix(target, speedtestTarget)
+}
+
+// parseSpeedtest splits "speedtest://down/1048576".
+func parseSpeedtest(target string) (up bool, size int64, ok bool) {
+	dir, n, found := strings.Cut(strings.TrimPrefix(target, speedtestTarget), "/")
+	if !found || (dir != "up" && dir != "down") {
+		return false, 0, false
+	}
+	size, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || size <= 0 || size > speedtestMax {
+		return false, 0, false
+	}
+	return dir == "up", size, true
+}
+
+// speedtestPayload is random so compression along the path (ours or
+// anyone else's) can't inflate the result.
+func speedtestPayload() []byte {
+	b := make([]byte, speedtestChunk)
+	rand.Read(b)
+	return b
+}
+
+// serveSpeedtest is the server side of one run.
+func serveSpeedtest(rw io.ReadWriteCloser, target string) {
+	defer rw.Close()
+	up, size, ok := parseSpeedtest(target)
+	if !ok {
+		return
+	}
+	if up {
+		n, _ := io.CopyN(io.Discard, rw, size)
+		var ack [8]byte
+		binary.BigEndian.PutUint64(ack[:], uint64(n))
+		rw.Write(ack[:])
+		return
+	}
+	buf := speedtestPayload()
+	for sent := int64(0); sent < size; {
+		chunk := buf[:min(int64(len(buf)), size-sent)]
+		if _, err := rw.Write(chunk); err != nil {
+			return
+		}
+		sent += int64(len(chunk))
+	}
+}
+
+// SpeedResult is one finished speed test run.
+type SpeedResult struct {
+	Upload   bool
+	Bytes    int64
+	Duration time.Duration
+}
+
+// Mbps returns the measured throughput in megabits per second.
+func (r SpeedResult) Mbps() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Bytes) * 8 / r.Duration.Seconds() / 1e6
+}
+
+// SpeedTest transfers size bytes to (upload) or from the server and
+// times it. progress, if set, is called with the running byte count.
+func (c *Client) SpeedTest(upload bool, size int64, progress func(done int64)) (SpeedResult, error) {
+	if size <= 0 || size > speedtestMax {
+		return SpeedResult{}, fmt.Errorf("size must be 1..%d bytes", speedtestMax)
+	}
+	dir := "down"
+	if upload {
+		dir = "up"
+	}
+	stream, err := c.OpenStream(speedtestTarget + dir + "/" + strconv.FormatInt(size, 10))
+	if err != nil {
+		return SpeedResult{}, err
+	}
+	defer stream.Close()
+
+	res := SpeedResult{Upload: upload}
+	report := func(n int64) {
+		if progress != nil {
+			progress(n)
+		}
+	}
+	start := time.Now()
+	if upload {
+		buf := speedtestPayload()
+		for res.Bytes < size {
+			chunk := buf[:min(int64(len(buf)), size-res.Bytes)]
+			if _, err := stream.Write(chunk); err != nil {
+				return res, err
+			}
+			res.Bytes += int64(len(chunk))
+			report(res.Bytes)
+		}
+		// Done only once the server has received everything.
+		var ack [8]byte
+		if _, err := io.ReadFull(stream, ack[:]); err != nil {
+			return res, fmt.Errorf("waiting for server: %w", err)
+		}
+		res.Bytes = int64(binary.BigEndian.Uint64(ack[:]))
+	} else {
+		buf := make([]byte, speedtestChunk)
+		for res.Bytes < size {
+			n, err := stream.Read(buf)
+			res.Bytes += int64(n)
+			report(res.Bytes)
+			if err != nil {
+				break
+			}
+		}
+	}
+	res.Duration = time.Since(start)
+	if res.Bytes < size {
+		return res, fmt.Errorf("transfer cut short at %d of %d bytes", res.Bytes, size)
+	}
+	return res, nil
+}
+
+// WaitReady blocks until the client has a session (min_sessions of
+// them when set) or timeout passes.
+func (c *Client) WaitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if c.ready() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not ready after %v (%d session(s))", timeout, c.sessionCount())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,365 @@
+package httpmux
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Traffic statistics
+//
+// Lightweight in-process counters: total bytes, per-map totals,
+// peak concurrency and error counts. On exit a snapshot is logged
+// and appended (one JSON line per run) to stats_file, so operators
+// keep rough historical numbers across restarts and upgrades.
+// ═══════════════════════════════════════════════════════════════
+
+type Stats struct {
+	start time.Time
+
+	bytesIn  int64 // atomic: visitor/app → tunnel
+	bytesOut int64 // atomic: tunnel → visitor/app
+
+	activeConns  int64 // atomic
+	peakConns    int64 // atomic
+	sessions     int64 // atomic
+	peakSessions int64 // atomic
+	rtt          int64 // atomic: smoothed tunnel RTT in ns (ping.go)
+	udpFlows     int64 // atomic: live reverse UDP flows (udpflows.go)
+
+	mu       sync.Mutex
+	maps     map[string]*mapStats
+	accounts map[string]*mapStats // per user / client (accounting.go)
+	errors   map[string]int64
+	clients  map[string]int64 // client_name → sessions opened
+	history  []SessionEvent   // ring, newest last
+	paths    []PathHealth     // client, per path (pathprobe.go)
+}
+
+// SessionEvent is one tunnel session coming up or going away.
+type SessionEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"` // "up" | "down"
+	ID       uint64    `json:"id"`
+	Remote   string    `json:"remote"`
+	User     string    `json:"user,omitempty"`
+	Client   string    `json:"client,omitempty"`       // client_name, "down" only (announced after "up")
+	Lifetime int64     `json:"lifetime_sec,omitempty"` // "down" only
+}
+
+const sessionHistoryLen = 200
+
+type mapStats struct {
+	conns    int64 // atomic: total accepted
+	bytesIn  int64 // atomic
+	bytesOut int64 // atomic
+	dropped  int64 // atomic: UDP packets dropped at a full flow queue (udpqueue.go)
+}
+
+// add counts bytes on m; nil m counts nothing.
+func (m *mapStats) add(in, out int64) {
+	if m == nil {
+		return
+	}
+	if in > 0 {
+		atomic.AddInt64(&m.bytesIn, in)
+	}
+	if out > 0 {
+		atomic.AddInt64(&m.bytesOut, out)
+	}
+}
+
+func (m *mapStats) snapshot() MapStatsSnapshot {
+	return MapStatsSnapshot{
+		Conns:    atomic.LoadInt64(&m.conns),
+		BytesIn:  atomic.LoadInt64(&m.bytesIn),
+		BytesOut: atomic.LoadInt64(&m.bytesOut),
+		Dropped:  atomic.LoadInt64(&m.dropped),
+	}
+}
+
+// StatsSnapshot is the serialized form written on exit.
+type StatsSnapshot struct {
+	Time         time.Time                   `json:"time"`
+	UptimeSec    int64                       `json:"uptime_sec"`
+	BytesIn      int64                       `json:"bytes_in"`
+	BytesOut     int64                       `json:"bytes_out"`
+	PeakConns    int64                       `json:"peak_conns"`
+	PeakSessions int64                       `json:"peak_sessions"`
+	RTTms        float64                     `json:"rtt_ms,omitempty"`
+	UDPFlows     int64                       `json:"udp_flows,omitempty"`
+	Clients      map[string]int64            `json:"clients,omitempty"` // sessions opened per client_name
+	Errors       map[string]int64            `json:"errors,omitempty"`
+	Maps         map[string]MapStatsSnapshot `json:"maps,omitempty"`
+	Accounts     map[string]MapStatsSnapshot `json:"accounts,omitempty"` // server, per user / client
+	Paths        []PathHealth                `json:"paths,omitempty"`    // client, with path_probe
+}
+
+type MapStatsSnapshot struct {
+	Conns    int64 `json:"conns"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	Dropped  int64 `json:"udp_dropped,omitempty"`
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		start:    time.Now(),
+		maps:     make(map[string]*mapStats),
+		accounts: make(map[string]*mapStats),
+		errors:   make(map[string]int64),
+		clients:  make(map[string]int64),
+	}
+}
+
+func (st *Stats) mapEntry(name string) *mapStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	m, ok := st.maps[name]
+	if !ok {
+		m = &mapStats{}
+		st.maps[name] = m
+	}
+	return m
+}
+
+func (st *Stats) accountEntry(name string) *mapStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	a, ok := st.accounts[name]
+	if !ok {
+		a = &mapStats{}
+		st.accounts[name] = a
+	}
+	return a
+}
+
+// connOpened registers one relayed connection on map name and returns
+// the counters to feed plus a func to call when it ends.
+func (st *Stats) connOpened(name string) (*mapStats, func()) {
+	m := st.mapEntry(name)
+	atomic.AddInt64(&m.conns, 1)
+	storeMax(&st.peakConns, atomic.AddInt64(&st.activeConns, 1))
+	return m, func() { atomic.AddInt64(&st.activeConns, -1) }
+}
+
+func (st *Stats) sessionAdded() {
+	storeMax(&st.peakSessions, atomic.AddInt64(&st.sessions, 1))
+}
+
+func (st *Stats) sessionRemoved() {
+	atomic.AddInt64(&st.sessions, -1)
+}
+
+// sessionEvent records a session change for the history.
+func (st *Stats) sessionEvent(ev SessionEvent) {
+	ev.Time = time.Now()
+	st.mu.Lock()
+	if len(st.history) >= sessionHistoryLen {
+		st.history = append(st.history[:0], st.history[1:]...)
+	}
+	st.history = append(st.history, ev)
+	st.mu.Unlock()
+}
+
+// clientSession counts a session announced as client_name name.
+func (st *Stats) clientSession(name string) {
+	st.mu.Lock()
+	st.clients[name]++
+	st.mu.Unlock()
+}
+
+// History returns recent session events, oldest first.
+func (st *Stats) History() []SessionEvent {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]SessionEvent(nil), st.history...)
+}
+
+// Active returns the current relayed connections and sessions.
+func (st *Stats) Active() (conns, sessions int64) {
+	return atomic.LoadInt64(&st.activeConns), atomic.LoadInt64(&st.sessions)
+}
+
+func (st *Stats) observeRTT(d time.Duration) {
+	smoothRTT(&st.rtt, d)
+}
+
+// setPathHealth records the prober's view of path i.
+func (st *Stats) setPathHealth(i int, h PathHealth) {
+	st.mu.Lock()
+	for len(st.paths) <= i {
+		st.paths = append(st.paths, PathHealth{})
+	}
+	st.paths[i] = h
+	st.mu.Unlock()
+}
+
+func (st *Stats) incError(kind string) {
+	st.mu.Lock()
+	st.errors[kind]++
+	st.mu.Unlock()
+}
+
+func storeMax(addr *int64, v int64) {
+	for {
+		cur := atomic.LoadInt64(addr)
+		if v <= cur || atomic.CompareAndSwapInt64(addr, cur, v) {
+			return
+		}
+	}
+}
+
+func (st *Stats) Snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		Time:         time.Now(),
+		UptimeSec:    int64(time.Since(st.start).Seconds()),
+		BytesIn:      atomic.LoadInt64(&st.bytesIn),
+		BytesOut:     atomic.LoadInt64(&st.bytesOut),
+		PeakConns:    atomic.LoadInt64(&st.peakConns),
+		PeakSessions: atomic.LoadInt64(&st.peakSessions),
+		RTTms:        float64(atomic.LoadInt64(&st.rtt)) / 1e6,
+		UDPFlows:     atomic.LoadInt64(&st.udpFlows),
+		Errors:       map[string]int64{},
+		Maps:         map[string]MapStatsSnapshot{},
+	}
+	st.mu.Lock()
+	for k, v := range st.errors {
+		snap.Errors[k] = v
+	}
+	if len(st.clients) > 0 {
+		snap.Clients = make(map[string]int64, len(st.clients))
+		for k, v := range st.clients {
+			snap.Clients[k] = v
+		}
+	}
+	for k, m := range st.maps {
+		snap.Maps[k] = m.snapshot()
+	}
+	if len(st.accounts) > 0 {
+		snap.Accounts = make(map[string]MapStatsSnapshot, len(st.accounts))
+		for k, a := range st.accounts {
+			snap.Accounts[k] = a.snapshot()
+		}
+	}
+	snap.Paths = append([]PathHealth(nil), st.paths...)
+	st.mu.Unlock()
+	return snap
+}
+
+// LogSnapshot prints the snapshot in the usual log format.
+func (st *Stats) LogSnapshot() {
+	snap := st.Snapshot()
+	log.Printf("[STATS] uptime=%v in=%s out=%s peak_conns=%d peak_sessions=%d rtt=%.1fms",
+		time.Duration(snap.UptimeSec)*time.Second, formatBytes(snap.BytesIn), formatBytes(snap.BytesOut),
+		snap.PeakConns, snap.PeakSessions, snap.RTTms)
+	names := make([]string, 0, len(snap.Maps))
+	for k := range snap.Maps {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		m := snap.Maps[k]
+		log.Printf("[STATS]   map %s: conns=%d in=%s out=%s", k, m.Conns, formatBytes(m.BytesIn), formatBytes(m.BytesOut))
+	}
+	names = names[:0]
+	for k := range snap.Accounts {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		a := snap.Accounts[k]
+		log.Printf("[STATS]   account %s: conns=%d in=%s out=%s", k, a.Conns, formatBytes(a.BytesIn), formatBytes(a.BytesOut))
+	}
+	for i, p := range snap.Paths {
+		log.Printf("[STATS]   path[%d] %s: up=%v rtt=%.1fms", i, p.Addr, p.Up, p.RTTms)
+	}
+	for k, v := range snap.Errors {
+		log.Printf("[STATS]   errors %s=%d", k, v)
+	}
+}
+
+// AppendSnapshot appends the snapshot as one JSON line to path.
+func (st *Stats) AppendSnapshot(path string) error {
+	data, err := json.Marshal(st.Snapshot())
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// SaveOnExit logs the snapshot and, if path is set, appends it there.
+func (st *Stats) SaveOnExit(path string) {
+	st.LogSnapshot()
+	if path == "" {
+		return
+	}
+	if err := st.AppendSnapshot(path); err != nil {
+		log.Printf("[STATS] could not write %s: %v", path, err)
+		return
+	}
+	log.Printf("[STATS] snapshot appended to %s", path)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ──────────── Counting wrapper ────────────
+
+// countedConn counts bytes on the visitor/app side of a relay:
+// reads are "in" (towards the tunnel), writes are "out". sess and acct
+// are the server's per-session and per-account counters, nil elsewhere.
+type countedConn struct {
+	io.ReadWriteCloser
+	st         *Stats
+	m          *mapStats
+	sess, acct *mapStats
+	span       *streamSpan // nil = not traced
+}
+
+func (c *countedConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&c.st.bytesIn, int64(n))
+		c.m.add(int64(n), 0)
+		c.sess.add(int64(n), 0)
+		c.acct.add(int64(n), 0)
+		c.span.add(int64(n), 0)
+	}
+	return n, err
+}
+
+func (c *countedConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	if n > 0 {
+		atomic.AddInt64(&c.st.bytesOut, int64(n))
+		c.m.add(0, int64(n))
+		c.sess.add(0, int64(n))
+		c.acct.add(0, int64(n))
+		c.span.add(0, int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,120 @@
+package httpmux
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Session stickiness (sticky maps)
+//
+//   maps:
+//     - { type: tcp, bind: "21", target: "127.0.0.1:21", sticky: true }
+//     - { type: udp, bind: "5060", target: "127.0.0.1:5060", sticky: true }
+//
+// FTP, SIP and many games open several connections that the far end
+// expects from one client address. Visitors of a sticky map are routed
+// by source IP instead of round-robin: the first connection from an IP
+// picks a session by hashing the IP over the live sessions
+// (rendezvous hashing, so sessions coming and going move few IPs), and
+// later connections from that IP to any sticky map with the same tag
+// ride the same session. The pin lasts while the session is up and
+// until stickyIdle after the IP's last new connection.
+// ═══════════════════════════════════════════════════════════════
+
+const stickyIdle = 10 * time.Minute
+
+type stickyTable struct {
+	mu        sync.Mutex
+	pins      map[string]*stickyPin // tag + "|" + source IP
+	lastSweep time.Time
+}
+
+type stickyPin struct {
+	ss   *serverSession
+	last time.Time
+}
+
+func newStickyTable() *stickyTable {
+	return &stickyTable{pins: map[string]*stickyPin{}, lastSweep: time.Now()}
+}
+
+// session returns the session pinned for key, pinning pick(key) when
+// there is none or it is gone. nil when pick finds no session.
+func (t *stickyTable) session(key string, pick func() *serverSession) *serverSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if now.Sub(t.lastSweep) > stickyIdle {
+		for k, p := range t.pins {
+			if now.Sub(p.last) > stickyIdle || p.ss.sess.IsClosed() {
+				delete(t.pins, k)
+			}
+		}
+		t.lastSweep = now
+	}
+	p := t.pins[key]
+	if p == nil || p.ss.sess.IsClosed() || now.Sub(p.last) > stickyIdle {
+		ss := pick()
+		if ss == nil {
+			delete(t.pins, key)
+			return nil
+		}
+		p = &stickyPin{ss: ss}
+		t.pins[key] = p
+	}
+	p.last = now
+	return p.ss
+}
+
+func (t *stickyTable) forget(key string) {
+	t.mu.Lock()
+	delete(t.pins, key)
+	t.mu.Unlock()
+}
+
+// stickyPick returns the session serving tag with the highest
+// rendezvous score for ip.
+func (s *Server) stickyPick(ip, tag string) *serverSession {
+	s.poolMu.RLock()
+	defer s.poolMu.RUnlock()
+	var best *serverSession
+	var bestScore uint64
+	for _, ss := range s.sessions {
+		if ss.sess.IsClosed() || !ss.serves(tag) {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(ip))
+		binary.Write(h, binary.BigEndian, ss.id)
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = ss, score
+		}
+	}
+	return best
+}
+
+// openStickyStream is openReverseStream for a sticky map visitor from
+// src.
+func (s *Server) openStickyStream(target, tag string, src net.Addr) (net.Conn, *serverSession, error) {
+	ip := src.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	key := tag + "|" + ip
+	for try := 0; try < 2; try++ {
+		ss := s.sticky.session(key, func() *serverSession { return s.stickyPick(ip, tag) })
+		if ss == nil {
+			break
+		}
+		stream, err := s.openReverseStreamOn(ss, target)
+		if err == nil {
+			return stream, ss, nil
+		}
+		s.sticky.forget(key) // the session was dropped; pin another
+	}
+	return s.openReverseStream(target, tag)
+}
@@ -0,0 +1,388 @@
+package httpmux
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// State store (server)
+//
+//   state:
+//     path: /var/lib/picotun/state.db
+//     retention_days: 90     # session log; default 90, -1 = keep all
+//     flush_interval: 30     # seconds between writes (default 30)
+//
+//   users:
+//     - name: alice
+//       psk: "..."
+//       quota: 50GB          # bytes in + out per quota_period
+//       quota_period: monthly  # monthly (default), daily or total
+//
+// An embedded bbolt file that survives restarts. Every flush adds the
+// traffic each account relayed since the last one (accounting.go) to
+// its all-time totals and to its usage in the current quota period,
+// and appends the session ups and downs to a log pruned after
+// retention_days. GET /api/users shows the totals and quota state.
+//
+// A user past its quota has its sessions closed and is refused at
+// auth until the period rolls over (UTC). Usage is only checked on
+// flush, so a user can run over by up to flush_interval of traffic.
+//
+// Built without it (-tags no_store) state.path is reported and
+// ignored, and quotas aren't enforced.
+// ═══════════════════════════════════════════════════════════════
+
+type StateConfig struct {
+	Path          string `yaml:"path"`           // "" = no store
+	RetentionDays int    `yaml:"retention_days"` // session log; 0 = 90, -1 = keep all
+	FlushInterval int    `yaml:"flush_interval"` // seconds, default 30
+}
+
+const (
+	defaultStateRetention = 90
+	defaultStateFlush     = 30
+)
+
+// UserUsage is what the store keeps per account.
+type UserUsage struct {
+	Conns       int64     `json:"conns"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	Period      string    `json:"period,omitempty"` // quota period the next field counts, e.g. "2026-10"
+	PeriodBytes int64     `json:"period_bytes"`     // in + out during Period
+	Since       time.Time `json:"since"`            // first traffic
+	Updated     time.Time `json:"updated"`
+}
+
+// stateDB is the storage backend (store_bolt.go).
+type stateDB interface {
+	// update adds the deltas to their accounts, rolling each over
+	// to periods[account] first, and appends events to the log.
+	update(deltas map[string]MapStatsSnapshot, periods map[string]string, events []SessionEvent, now time.Time) error
+	users() (map[string]UserUsage, error)
+	sessionLog(limit int) ([]SessionEvent, error)
+	prune(before time.Time) (int, error)
+	Close() error
+}
+
+// userQuota is one users: entry's quota_bytes and quota_period.
+type userQuota struct {
+	bytes  int64
+	period string
+}
+
+// stateStore keeps the server's persistent state; nil = no state.path.
+type stateStore struct {
+	db        stateDB
+	path      string
+	every     time.Duration
+	retention time.Duration // 0 = keep all
+	stats     *Stats
+	quotas    map[string]userQuota
+
+	mu      sync.Mutex
+	prev    StatsSnapshot  // what the last flush was diffed against
+	pending []SessionEvent // not written yet
+	over    map[string]bool
+	pruned  time.Time
+}
+
+// openStateStore returns nil unless state.path is set or the file
+// can't be opened; the server then runs without it.
+func openStateStore(cfg *Config, stats *Stats) *stateStore {
+	st := cfg.State
+	if st.Path == "" {
+		return nil
+	}
+	db, err := openStateDB(st.Path)
+	if err != nil {
+		log.Printf("[STATE] %s: %v — running without state, quotas off", st.Path, err)
+		return nil
+	}
+	s := &stateStore{
+		db:     db,
+		path:   st.Path,
+		every:  time.Duration(st.FlushInterval) * time.Second,
+		stats:  stats,
+		quotas: map[string]userQuota{},
+		prev:   stats.Snapshot(),
+		over:   map[string]bool{},
+	}
+	if s.every <= 0 {
+		s.every = defaultStateFlush * time.Second
+	}
+	switch days := st.RetentionDays; {
+	case days == 0:
+		s.retention = defaultStateRetention * 24 * time.Hour
+	case days > 0:
+		s.retention = time.Duration(days) * 24 * time.Hour
+	}
+	for _, u := range cfg.Users {
+		if n, _ := parseByteSize(u.Quota); n > 0 && !u.Disabled {
+			s.quotas[u.Name] = userQuota{bytes: n, period: u.QuotaPeriod}
+		}
+	}
+	s.checkQuotas(time.Now())
+	return s
+}
+
+// run flushes every flush_interval until shutdown begins; Shutdown
+// calls close once the relays have drained.
+func (s *stateStore) run(srv *Server) {
+	if s == nil {
+		return
+	}
+	log.Printf("[STATE] %s, flushing every %v, %d quotas", s.path, s.every, len(s.quotas))
+	for srv.life.sleep(s.every) {
+		for _, user := range s.flush() {
+			srv.kickUser(user)
+		}
+	}
+}
+
+// logSession queues ev for the session log.
+func (s *stateStore) logSession(ev SessionEvent) {
+	if s == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	s.mu.Lock()
+	s.pending = append(s.pending, ev)
+	s.mu.Unlock()
+}
+
+// flush writes what was relayed since the previous flush and the
+// queued session events, prunes the log at most hourly, and returns
+// the users that went over quota with it.
+func (s *stateStore) flush() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.stats.Snapshot()
+	deltas := usageDelta(cur.Accounts, s.prev.Accounts)
+	periods := make(map[string]string, len(deltas))
+	for acct := range deltas {
+		periods[acct] = quotaPeriodKey(s.quotas[acct].period, cur.Time)
+	}
+	if err := s.db.update(deltas, periods, s.pending, cur.Time); err != nil {
+		s.stats.incError("state")
+		logDedupf("state", "[STATE] %s: %v", s.path, err)
+		return nil // retried with the next flush
+	}
+	s.prev = cur
+	s.pending = nil
+
+	if s.retention > 0 && time.Since(s.pruned) > time.Hour {
+		s.pruned = time.Now()
+		if n, err := s.db.prune(s.pruned.Add(-s.retention)); err != nil {
+			logDedupf("state", "[STATE] prune: %v", err)
+		} else if n > 0 {
+			log.Printf("[STATE] pruned %d session log entries", n)
+		}
+	}
+	return s.checkQuotasLocked(cur.Time)
+}
+
+// checkQuotas refreshes the over-quota set; see checkQuotasLocked.
+func (s *stateStore) checkQuotas(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkQuotasLocked(now)
+}
+
+// checkQuotasLocked refreshes the over-quota set and returns the
+// users newly in it. A period that rolled over clears its user.
+func (s *stateStore) checkQuotasLocked(now time.Time) []string {
+	if len(s.quotas) == 0 {
+		return nil
+	}
+	usage, err := s.db.users()
+	if err != nil {
+		logDedupf("state", "[STATE] %s: %v", s.path, err)
+		return nil
+	}
+	var newly []string
+	for user, q := range s.quotas {
+		used := periodUsage(usage[user], q.period, now)
+		over := used >= q.bytes
+		switch {
+		case over && !s.over[user]:
+			log.Printf("[QUOTA] user %q used %s of %s (%s), refusing sessions",
+				user, formatByteSize(used), formatByteSize(q.bytes), quotaPeriodName(q.period))
+			newly = append(newly, user)
+		case !over && s.over[user]:
+			log.Printf("[QUOTA] user %q: new %s period, accepting sessions", user, quotaPeriodName(q.period))
+		}
+		s.over[user] = over
+	}
+	return newly
+}
+
+// overQuota reports whether user is refused until its period rolls
+// over. The period is re-checked so a refused user gets in right
+// after midnight rather than at the next flush.
+func (s *stateStore) overQuota(user string) bool {
+	if s == nil || user == "" {
+		return false
+	}
+	s.mu.Lock()
+	over := s.over[user]
+	s.mu.Unlock()
+	if over {
+		s.checkQuotas(time.Now())
+		s.mu.Lock()
+		over = s.over[user]
+		s.mu.Unlock()
+	}
+	return over
+}
+
+// close writes the last flush and closes the file.
+func (s *stateStore) close() {
+	if s == nil {
+		return
+	}
+	s.flush()
+	if err := s.db.Close(); err != nil {
+		log.Printf("[STATE] close %s: %v", s.path, err)
+	}
+}
+
+// UserState is one account in GET /api/users.
+type UserState struct {
+	UserUsage
+	Quota       int64  `json:"quota,omitempty"`
+	QuotaPeriod string `json:"quota_period,omitempty"`
+	Used        int64  `json:"used,omitempty"` // in the current period
+	OverQuota   bool   `json:"over_quota,omitempty"`
+}
+
+// Users returns the stored totals with the traffic not yet flushed
+// left out, plus the quota state of users: entries with a quota.
+func (s *stateStore) Users() (map[string]UserState, error) {
+	usage, err := s.db.users()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	out := make(map[string]UserState, len(usage))
+	for acct, u := range usage {
+		out[acct] = UserState{UserUsage: u}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for user, q := range s.quotas {
+		us := out[user]
+		us.Quota = q.bytes
+		us.QuotaPeriod = quotaPeriodName(q.period)
+		us.Used = periodUsage(usage[user], q.period, now)
+		us.OverQuota = s.over[user]
+		out[user] = us
+	}
+	return out, nil
+}
+
+// periodUsage is what u counts for the period containing now.
+func periodUsage(u UserUsage, period string, now time.Time) int64 {
+	if u.Period != quotaPeriodKey(period, now) {
+		return 0
+	}
+	return u.PeriodBytes
+}
+
+// kickUser closes the sessions of user, e.g. once it's over quota.
+func (s *Server) kickUser(user string) {
+	s.poolMu.RLock()
+	var victims []*serverSession
+	for _, ss := range s.sessions {
+		if ss.user == user {
+			victims = append(victims, ss)
+		}
+	}
+	s.poolMu.RUnlock()
+	for _, ss := range victims {
+		ss.sess.Close()
+	}
+	if len(victims) > 0 {
+		log.Printf("[QUOTA] closed %d sessions of user %q", len(victims), user)
+	}
+}
+
+// ──────────── Quota periods and sizes ────────────
+
+// quotaPeriodKey names the period containing t: "2026-10" monthly,
+// "2026-10-15" daily, "total" for never.
+func quotaPeriodKey(period string, t time.Time) string {
+	t = t.UTC()
+	switch period {
+	case "daily":
+		return t.Format("2006-01-02")
+	case "total":
+		return "total"
+	}
+	return t.Format("2006-01")
+}
+
+func quotaPeriodName(period string) string {
+	if period == "" {
+		return "monthly"
+	}
+	return period
+}
+
+func validQuotaPeriod(p string) bool {
+	switch p {
+	case "", "monthly", "daily", "total":
+		return true
+	}
+	return false
+}
+
+// parseByteSize reads "500MB", "50GB", "1.5TB" or a plain byte count.
+// Units are powers of 1000; KiB, MiB, GiB and TiB are powers of 1024.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.ToUpper(strings.TrimSpace(s[i:]))
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q (e.g. 500MB, 50GB)", s)
+	}
+	mult := map[string]float64{
+		"": 1, "B": 1,
+		"K": 1e3, "KB": 1e3, "M": 1e6, "MB": 1e6, "G": 1e9, "GB": 1e9, "T": 1e12, "TB": 1e12,
+		"KIB": 1 << 10, "MIB": 1 << 20, "GIB": 1 << 30, "TIB": 1 << 40,
+	}[unit]
+	if mult == 0 {
+		return 0, fmt.Errorf("bad size %q: unknown unit %q", s, unit)
+	}
+	return int64(n * mult), nil
+}
+
+func formatByteSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
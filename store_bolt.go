@@ -0,0 +1,154 @@
+//go:build !no_store
+
+package httpmux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketUsers    = []byte("users")    // account → UserUsage
+	bucketSessions = []byte("sessions") // time (ns) + id → SessionEvent
+)
+
+type boltState struct{ db *bolt.DB }
+
+func openStateDB(path string) (stateDB, error) {
+	// The timeout covers a second server holding the file.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketUsers, bucketSessions} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltState{db: db}, nil
+}
+
+func (b *boltState) update(deltas map[string]MapStatsSnapshot, periods map[string]string, events []SessionEvent, now time.Time) error {
+	if len(deltas) == 0 && len(events) == 0 {
+		return nil
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(bucketUsers)
+		for acct, d := range deltas {
+			var u UserUsage
+			if v := users.Get([]byte(acct)); v != nil {
+				if err := json.Unmarshal(v, &u); err != nil {
+					return err
+				}
+			}
+			if u.Since.IsZero() {
+				u.Since = now
+			}
+			if u.Period != periods[acct] {
+				u.Period, u.PeriodBytes = periods[acct], 0
+			}
+			u.Conns += d.Conns
+			u.BytesIn += d.BytesIn
+			u.BytesOut += d.BytesOut
+			u.PeriodBytes += d.BytesIn + d.BytesOut
+			u.Updated = now
+			v, err := json.Marshal(u)
+			if err != nil {
+				return err
+			}
+			if err := users.Put([]byte(acct), v); err != nil {
+				return err
+			}
+		}
+		log := tx.Bucket(bucketSessions)
+		for _, ev := range events {
+			v, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			if err := log.Put(sessionLogKey(ev.Time, ev.ID), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltState) users() (map[string]UserUsage, error) {
+	out := map[string]UserUsage{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketUsers).ForEach(func(k, v []byte) error {
+			var u UserUsage
+			if err := json.Unmarshal(v, &u); err != nil {
+				return err
+			}
+			out[string(k)] = u
+			return nil
+		})
+	})
+	return out, err
+}
+
+// sessionLog returns the newest limit events, oldest first.
+func (b *boltState) sessionLog(limit int) ([]SessionEvent, error) {
+	var out []SessionEvent
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketSessions).Cursor()
+		for k, v := c.Last(); k != nil && len(out) < limit; k, v = c.Prev() {
+			var ev SessionEvent
+			if err := json.Unmarshal(v, &ev); err != nil {
+				return err
+			}
+			out = append(out, ev)
+		}
+		return nil
+	})
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, err
+}
+
+// prune deletes session log entries from before before.
+func (b *boltState) prune(before time.Time) (int, error) {
+	n := 0
+	cut := sessionLogKey(before, 0)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		log := tx.Bucket(bucketSessions)
+		// Deleting under a cursor skips keys; collect first.
+		var old [][]byte
+		c := log.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, cut) < 0; k, _ = c.Next() {
+			old = append(old, append([]byte(nil), k...))
+		}
+		for _, k := range old {
+			if err := log.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(old)
+		return nil
+	})
+	return n, err
+}
+
+func (b *boltState) Close() error { return b.db.Close() }
+
+// sessionLogKey sorts by time, then session id.
+func sessionLogKey(t time.Time, id uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], id)
+	return k
+}
@@ -0,0 +1,9 @@
+//go:build no_store
+
+package httpmux
+
+import "fmt"
+
+func openStateDB(path string) (stateDB, error) {
+	return nil, fmt.Errorf("state: this binary was built with -tags no_store")
+}
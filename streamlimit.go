@@ -0,0 +1,153 @@
+package httpmux
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Stream open limits (server)
+//
+//   advanced:
+//     stream_rate: 200     # forward streams a session may open per second, -1 = unlimited
+//     stream_burst: 400    # opened at once after a quiet spell (default 2 × stream_rate)
+//     fd_budget: 0         # sockets for relays, all sessions; 0 = from ulimit -n, -1 = off
+//
+// A buggy or hostile client can open thousands of forward streams a
+// second, and every one that is dialed costs the server a socket.
+// stream_rate caps each session with a token bucket; fd_budget caps
+// the sockets all relays hold at once (forward streams and map
+// visitors), by default ulimit -n less a tenth (at least 64) kept for
+// sessions, listeners and files, so the process never hits EMFILE and
+// stops accepting sessions altogether.
+//
+// A refused stream used to be closed without a word, indistinguishable
+// from a dead target. Clients announcing "status" mark their tcp:// and
+// udp:// streams with +status, and the server answers each with one
+// byte before any data: streamOK, or the reason it was refused, which
+// the client logs and returns from the stream's first Read. Refusals
+// are counted as errors["stream_rate"] and errors["fd_budget"].
+// ═══════════════════════════════════════════════════════════════
+
+// Stream status codes, the first byte the server sends on a +status
+// stream. Never renumber them.
+const (
+	streamOK          byte = 0x00
+	streamRateLimited byte = 0x01 // stream_rate
+	streamNoSockets   byte = 0x02 // fd_budget
+	streamConnLimit   byte = 0x03 // max_connections or the map's
+	streamDenied      byte = 0x04 // acl
+	streamDialFailed  byte = 0x05 // target unreachable
+)
+
+var streamStatusText = map[byte]string{
+	streamRateLimited: "stream rate limit",
+	streamNoSockets:   "server out of sockets (fd_budget)",
+	streamConnLimit:   "max_connections reached",
+	streamDenied:      "denied by acl",
+	streamDialFailed:  "target unreachable",
+}
+
+const fdReserveMin = 64
+
+func applyStreamLimitDefaults(a *AdvancedConfig) {
+	if a.StreamRate == 0 {
+		a.StreamRate = 200
+	}
+	if a.StreamBurst <= 0 {
+		a.StreamBurst = 2 * max(a.StreamRate, 1)
+	}
+	if a.FDBudget == 0 {
+		if n := fdLimit(); n > 0 {
+			a.FDBudget = max(n-max(n/10, fdReserveMin), 1)
+		} else {
+			a.FDBudget = -1
+		}
+	}
+}
+
+// newStreamOpens returns a session's stream_rate bucket; nil =
+// unlimited.
+func newStreamOpens(a *AdvancedConfig) *tokenBucket {
+	if a.StreamRate <= 0 {
+		return nil
+	}
+	burst := float64(a.StreamBurst)
+	return &tokenBucket{rate: float64(a.StreamRate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// sendStreamStatus answers a +status stream with code; other streams
+// get nothing and are just closed on a refusal, as before.
+func sendStreamStatus(stream io.Writer, status bool, code byte) {
+	if status {
+		stream.Write([]byte{code})
+	}
+}
+
+// ──────────── Client ────────────
+
+// statusConn reads the server's status byte before the stream's data.
+type statusConn struct {
+	net.Conn
+	target string
+	once   sync.Once
+	err    error
+}
+
+func (c *statusConn) Read(p []byte) (int, error) {
+	c.once.Do(func() {
+		var b [1]byte
+		if _, err := io.ReadFull(c.Conn, b[:]); err != nil {
+			c.err = err
+			return
+		}
+		if b[0] != streamOK {
+			c.err = &streamRefusedError{target: c.target, code: b[0]}
+			logDedupf("refused"+c.target, "[STREAM] %v", c.err)
+		}
+	})
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(p)
+}
+
+// streamRefusedError is a stream the server turned down.
+type streamRefusedError struct {
+	target string
+	code   byte
+}
+
+func (e *streamRefusedError) Error() string {
+	why, ok := streamStatusText[e.code]
+	if !ok {
+		why = fmt.Sprintf("code 0x%02x", e.code)
+	}
+	return fmt.Sprintf("server refused %s: %s", e.target, why)
+}
+
+// statusTarget marks plain relay targets for a status byte; the flag
+// is stripped again for servers that don't announce it.
+func statusTarget(target string) string {
+	scheme, _, ok := strings.Cut(target, "://")
+	if n, _, _ := strings.Cut(scheme, "+"); !ok || (n != "tcp" && n != "udp") {
+		return target
+	}
+	return addTargetFlag(target, "status")
+}
+
+func logStreamLimits(a *AdvancedConfig) {
+	rate, budget := "unlimited", "off"
+	if a.StreamRate > 0 {
+		rate = fmt.Sprintf("%d/s (burst %d)", a.StreamRate, a.StreamBurst)
+	}
+	if a.FDBudget > 0 {
+		budget = fmt.Sprint(a.FDBudget)
+	}
+	log.Printf("[SERVER] limits: stream_rate=%s fd_budget=%s", rate, budget)
+}
@@ -0,0 +1,105 @@
+package httpmux
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// negotiated waits until the client has the server's hello, so its
+// streams carry +status.
+func negotiated(t *testing.T, tun *testTunnel) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if cs := tun.client.orderSessions(); len(cs) > 0 && cs[0].peer.Load() != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no server hello")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// streamStatus opens a stream to target and returns the code the
+// server refused it with, streamOK if it relays.
+func streamStatus(t *testing.T, tun *testTunnel, target string) (net.Conn, byte) {
+	t.Helper()
+	c, err := tun.client.OpenStream(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, ok := c.(*statusConn); !ok {
+		t.Fatalf("%s: no +status on the stream", target)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	var b [1]byte
+	_, err = c.Read(b[:])
+	var re *streamRefusedError
+	switch {
+	case errors.As(err, &re):
+		return c, re.code
+	case err != nil:
+		t.Fatalf("%s: %v", target, err)
+	}
+	return c, streamOK
+}
+
+func TestStreamRefusals(t *testing.T) {
+	echo := "tcp://" + tcpEcho(t)
+	t.Run("rate", func(t *testing.T) {
+		tun := startTunnel(t, tunnelOpts{advanced: "stream_rate: 1\nstream_burst: 2"})
+		negotiated(t, tun)
+		var codes []byte
+		for range 5 {
+			_, code := streamStatus(t, tun, echo)
+			codes = append(codes, code)
+		}
+		if codes[0] != streamOK || codes[4] != streamRateLimited {
+			t.Fatalf("codes %v", codes)
+		}
+		if tun.server.stats.Snapshot().Errors["stream_rate"] == 0 {
+			t.Error("stream_rate not counted")
+		}
+	})
+	t.Run("fd budget", func(t *testing.T) {
+		tun := startTunnel(t, tunnelOpts{advanced: "fd_budget: 1"})
+		negotiated(t, tun)
+		if _, code := streamStatus(t, tun, echo); code != streamOK {
+			t.Fatalf("first stream: code %d", code)
+		}
+		if _, code := streamStatus(t, tun, echo); code != streamNoSockets {
+			t.Fatalf("second stream while the first holds the socket: code %d", code)
+		}
+	})
+	t.Run("dial", func(t *testing.T) {
+		tun := startTunnel(t, tunnelOpts{})
+		negotiated(t, tun)
+		if _, code := streamStatus(t, tun, "tcp://"+freeAddr(t)); code != streamDialFailed {
+			t.Fatalf("code %d, want dial failed", code)
+		}
+	})
+}
+
+func TestStatusTarget(t *testing.T) {
+	for in, want := range map[string]string{
+		"tcp://10.0.0.1:22":           "tcp+status://10.0.0.1:22",
+		"udp+prio-high://10.0.0.1:53": "udp+prio-high+status://10.0.0.1:53",
+		"echo://":                     "echo://",
+		"10.0.0.1:80":                 "10.0.0.1:80",
+	} {
+		if got := statusTarget(in); got != want {
+			t.Errorf("%s: %s, want %s", in, got, want)
+		}
+	}
+	var legacy *sessionInfo
+	if got := legacy.fitTarget("tcp+status://x:1"); got != "tcp://x:1" {
+		t.Errorf("kept +status for a legacy server: %s", got)
+	}
+}
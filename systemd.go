@@ -0,0 +1,123 @@
+package httpmux
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// systemd notify and watchdog (Type=notify)
+//
+//   [Service]
+//   Type=notify
+//   WatchdogSec=30
+//   ExecStart=/usr/local/bin/picotun -c /etc/picotun/config.yaml
+//
+// When systemd passes NOTIFY_SOCKET the tunnel reports
+//
+//   READY=1      server: every listen port is bound
+//                client: the first session is up (min_sessions if set)
+//   WATCHDOG=1   every WatchdogSec/2 — but only while the session
+//                health monitor keeps running, so a process stuck on a
+//                lock stops pinging and systemd restarts it
+//   STOPPING=1   when a graceful shutdown begins
+//
+// A client may never become ready while its server is down, so it can
+// run as Type=simple with NotifyAccess=main: watchdog only. Without
+// NOTIFY_SOCKET nothing changes.
+// ═══════════════════════════════════════════════════════════════
+
+type systemd struct {
+	addr  string        // NOTIFY_SOCKET
+	every time.Duration // watchdog ping interval, 0 = no watchdog
+
+	readyOnce sync.Once
+	beat      atomic.Int64 // unix nanos of the last health monitor pass
+}
+
+// newSystemd returns nil unless started by systemd with a notify
+// socket.
+func newSystemd() *systemd {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	sd := &systemd{addr: addr}
+	usec, _ := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	pid := os.Getenv("WATCHDOG_PID")
+	if usec > 0 && (pid == "" || pid == strconv.Itoa(os.Getpid())) {
+		sd.every = time.Duration(usec) * time.Microsecond / 2
+	}
+	sd.beat.Store(time.Now().UnixNano())
+	return sd
+}
+
+func (sd *systemd) notify(state string) {
+	if sd == nil {
+		return
+	}
+	addr := sd.addr
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		logDedupf("sdnotify", "[SYSTEMD] notify: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+// ready sends READY=1 the first time it is called.
+func (sd *systemd) ready(status string) {
+	if sd == nil {
+		return
+	}
+	sd.readyOnce.Do(func() {
+		sd.notify("READY=1\nSTATUS=" + status)
+		log.Printf("[SYSTEMD] ready: %s", status)
+	})
+}
+
+func (sd *systemd) stopping() {
+	sd.notify("STOPPING=1")
+}
+
+// alive records a health monitor pass.
+func (sd *systemd) alive() {
+	if sd != nil {
+		sd.beat.Store(time.Now().UnixNano())
+	}
+}
+
+// healthEvery shortens a health monitor interval so it passes at
+// least twice per watchdog ping.
+func (sd *systemd) healthEvery(d time.Duration) time.Duration {
+	if sd == nil || sd.every == 0 || d <= sd.every/2 {
+		return d
+	}
+	return max(sd.every/2, 100*time.Millisecond)
+}
+
+// watchdog pings systemd while the health monitor is alive, until
+// shutdown.
+func (sd *systemd) watchdog(life *lifecycle) {
+	if sd == nil || sd.every == 0 {
+		return
+	}
+	log.Printf("[SYSTEMD] watchdog: ping every %v", sd.every)
+	for life.sleep(sd.every) {
+		stale := time.Since(time.Unix(0, sd.beat.Load()))
+		if stale > sd.every {
+			logDedupf("sdwatchdog", "[SYSTEMD] health monitor stalled for %v, not pinging the watchdog", stale.Round(time.Second))
+			continue
+		}
+		sd.notify("WATCHDOG=1")
+	}
+}
@@ -1,6 +1,7 @@
 package httpmux
 
 import (
+	"context"
 	"crypto/rand"
 	"fmt"
 	"net"
@@ -74,8 +75,14 @@ func (c *FragmentedConn) Write(b []byte) (int, error) {
 
 // DialFragmented creates a TCP connection with ClientHello fragmentation.
 func DialFragmented(addr string, cfg *FragmentConfig, timeout time.Duration) (net.Conn, error) {
+	return dialFragmented(context.Background(), addr, cfg, timeout, ipAuto, nil)
+}
+
+// dialFragmented is DialFragmented with an ip_preference for host names
+// and socket options, abandoned when ctx is cancelled.
+func dialFragmented(ctx context.Context, addr string, cfg *FragmentConfig, timeout time.Duration, pref string, opts *socketOpts) (net.Conn, error) {
 	if cfg == nil || !cfg.Enabled {
-		return net.DialTimeout("tcp", addr, timeout)
+		return happyDial(ctx, addr, timeout, pref, opts.dialTCP)
 	}
 
 	minSize := cfg.MinSize
@@ -108,14 +115,13 @@ func DialFragmented(addr string, cfg *FragmentConfig, timeout time.Duration) (ne
 	}
 	delay := time.Duration(delayMs) * time.Millisecond
 
-	// Try raw socket first (TCP_NODELAY before connect)
-	conn, err := dialRawTCP(addr, timeout)
+	dial := dialNoDelay
+	if opts != nil {
+		dial = opts.dialNoDelay
+	}
+	conn, err := happyDial(ctx, addr, timeout, pref, dial)
 	if err != nil {
-		conn, err = net.DialTimeout("tcp", addr, timeout)
-		if err != nil {
-			return nil, fmt.Errorf("dial: %w", err)
-		}
-		setTCPNoDelay(conn, true)
+		return nil, fmt.Errorf("dial: %w", err)
 	}
 
 	return &FragmentedConn{
@@ -126,6 +132,31 @@ func DialFragmented(addr string, cfg *FragmentConfig, timeout time.Duration) (ne
 	}, nil
 }
 
+// dialNoDelay tries a raw socket first (TCP_NODELAY before connect).
+func dialNoDelay(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialRawTCP(addr, timeout)
+	if err == nil {
+		return conn, nil
+	}
+	conn, err = dialPlainTCP(ctx, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	setTCPNoDelay(conn, true)
+	return conn, nil
+}
+
+// dialNoDelay is dialNoDelay for sockets with options, which the raw
+// socket path doesn't set.
+func (o *socketOpts) dialNoDelay(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := o.dialTCP(ctx, addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	setTCPNoDelay(conn, true)
+	return conn, nil
+}
+
 // setTCPNoDelay — single definition for entire package.
 // v2.5: Takes bool param for enable/disable.
 func setTCPNoDelay(conn net.Conn, enable bool) {
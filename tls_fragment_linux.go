@@ -26,14 +26,29 @@ func dialRawTCP(addr string, timeout time.Duration) (net.Conn, error) {
 		return nil, err
 	}
 
-	// Only IPv4 for raw socket; IPv6 falls back
-	ip4 := tcpAddr.IP.To4()
-	if ip4 == nil {
-		return nil, fmt.Errorf("ipv6 not supported for raw dial")
+	// Build sockaddr
+	family := syscall.AF_INET
+	var sa syscall.Sockaddr
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+		sa4 := &syscall.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		family = syscall.AF_INET6
+		sa6 := &syscall.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa6.Addr[:], tcpAddr.IP.To16())
+		if tcpAddr.Zone != "" {
+			ifi, err := net.InterfaceByName(tcpAddr.Zone)
+			if err != nil {
+				return nil, err
+			}
+			sa6.ZoneId = uint32(ifi.Index)
+		}
+		sa = sa6
 	}
 
 	// Create socket
-	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	fd, err := syscall.Socket(family, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
 	if err != nil {
 		return nil, fmt.Errorf("socket: %w", err)
 	}
@@ -41,10 +56,6 @@ func dialRawTCP(addr string, timeout time.Duration) (net.Conn, error) {
 	// TCP_NODELAY before connect (like PicoTun)
 	syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1)
 
-	// Build sockaddr
-	sa := &syscall.SockaddrInet4{Port: tcpAddr.Port}
-	copy(sa.Addr[:], ip4)
-
 	// Non-blocking connect with timeout
 	syscall.SetNonblock(fd, true)
 	err = syscall.Connect(fd, sa)
@@ -0,0 +1,39 @@
+//go:build no_utls
+
+package httpmux
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// clientTLSHandshake without uTLS: Go's own ClientHello. Smaller
+// binary (no uTLS, quic-go, brotli, circl), but the fingerprint is
+// Go's rather than a browser's; tls_fingerprint is ignored.
+func clientTLSHandshake(rawConn net.Conn, opts tlsClientOpts) (net.Conn, error) {
+	cfg := &tls.Config{
+		ServerName:         opts.sni,
+		InsecureSkipVerify: true,
+		NextProtos:         []string{"http/1.1"},
+	}
+	if opts.h2 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	if opts.verify != nil {
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error { return opts.verify(raw) }
+	}
+	conn := tls.Client(rawConn, cfg)
+	if err := conn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func negotiatedProtocol(conn net.Conn) string {
+	if tc, ok := conn.(*tls.Conn); ok {
+		return tc.ConnectionState().NegotiatedProtocol
+	}
+	return ""
+}
@@ -0,0 +1,148 @@
+//go:build !no_utls
+
+package httpmux
+
+import (
+	"crypto/x509"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// clientTLSHandshake runs the client handshake with a browser
+// ClientHello (uTLS), so the TLS fingerprint matches real traffic.
+// Browser hellos always offer h2, so opts.h2 changes nothing here.
+func clientTLSHandshake(rawConn net.Conn, opts tlsClientOpts) (net.Conn, error) {
+	cfg := &utls.Config{
+		ServerName:         opts.sni,
+		InsecureSkipVerify: true,
+	}
+	if opts.verify != nil {
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error { return opts.verify(raw) }
+	}
+	var uConn *utls.UConn
+	if j, err := parseJA3(opts.fingerprint); err == nil {
+		uConn = utls.UClient(rawConn, cfg, utls.HelloCustom)
+		if err := uConn.ApplyPreset(ja3ClientHello(j)); err != nil {
+			uConn.Close()
+			return nil, err
+		}
+	} else {
+		uConn = utls.UClient(rawConn, cfg, namedTLSHello(opts.fingerprint))
+	}
+	if err := uConn.Handshake(); err != nil {
+		uConn.Close()
+		return nil, err
+	}
+	return uConn, nil
+}
+
+func negotiatedProtocol(conn net.Conn) string {
+	if uc, ok := conn.(*utls.UConn); ok {
+		return uc.ConnectionState().NegotiatedProtocol
+	}
+	return ""
+}
+
+func namedTLSHello(name string) utls.ClientHelloID {
+	switch name {
+	case "firefox":
+		return utls.HelloFirefox_120
+	case "safari":
+		return utls.HelloSafari_Auto
+	case "edge":
+		return utls.HelloEdge_Auto
+	case "ios":
+		return utls.HelloIOS_Auto
+	case "android":
+		return utls.HelloAndroid_11_OkHttp
+	}
+	return utls.HelloChrome_120
+}
+
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// ja3ClientHello builds a ClientHello with j's ciphers, extensions,
+// curves and point formats, in j's order. Extensions the handshake
+// can't honestly send (pre_shared_key, early_data, cookie) are left
+// out; unknown ones are sent empty.
+func ja3ClientHello(j *ja3Spec) *utls.ClientHelloSpec {
+	spec := &utls.ClientHelloSpec{CompressionMethods: []uint8{0}}
+	for _, c := range j.ciphers {
+		if isGREASE(c) {
+			c = utls.GREASE_PLACEHOLDER
+		}
+		spec.CipherSuites = append(spec.CipherSuites, c)
+	}
+	var curves []utls.CurveID
+	share := utls.CurveID(0)
+	for _, c := range j.curves {
+		if isGREASE(c) {
+			curves = append(curves, utls.CurveID(utls.GREASE_PLACEHOLDER))
+			continue
+		}
+		curves = append(curves, utls.CurveID(c))
+		if share == 0 || utls.CurveID(c) == utls.X25519 {
+			share = utls.CurveID(c)
+		}
+	}
+	if share == 0 {
+		share = utls.X25519
+	}
+	sigAlgs := []utls.SignatureScheme{
+		utls.ECDSAWithP256AndSHA256, utls.PSSWithSHA256, utls.PKCS1WithSHA256,
+		utls.ECDSAWithP384AndSHA384, utls.PSSWithSHA384, utls.PKCS1WithSHA384,
+		utls.PSSWithSHA512, utls.PKCS1WithSHA512,
+	}
+	for _, id := range j.exts {
+		var ext utls.TLSExtension
+		switch {
+		case isGREASE(id):
+			ext = &utls.UtlsGREASEExtension{}
+		case id == 0:
+			ext = &utls.SNIExtension{}
+		case id == 5:
+			ext = &utls.StatusRequestExtension{}
+		case id == 10:
+			ext = &utls.SupportedCurvesExtension{Curves: curves}
+		case id == 11:
+			ext = &utls.SupportedPointsExtension{SupportedPoints: j.points}
+		case id == 13:
+			ext = &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: sigAlgs}
+		case id == 16:
+			ext = &utls.ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}}
+		case id == 18:
+			ext = &utls.SCTExtension{}
+		case id == 21:
+			ext = &utls.UtlsPaddingExtension{GetPaddingLen: utls.BoringPaddingStyle}
+		case id == 23:
+			ext = &utls.ExtendedMasterSecretExtension{}
+		case id == 27:
+			ext = &utls.UtlsCompressCertExtension{Algorithms: []utls.CertCompressionAlgo{utls.CertCompressionBrotli}}
+		case id == 28:
+			ext = &utls.FakeRecordSizeLimitExtension{Limit: 0x4001}
+		case id == 35:
+			ext = &utls.SessionTicketExtension{}
+		case id == 43:
+			ext = &utls.SupportedVersionsExtension{Versions: []uint16{utls.VersionTLS13, utls.VersionTLS12}}
+		case id == 45:
+			ext = &utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}}
+		case id == 50:
+			ext = &utls.SignatureAlgorithmsCertExtension{SupportedSignatureAlgorithms: sigAlgs}
+		case id == 51:
+			ext = &utls.KeyShareExtension{KeyShares: []utls.KeyShare{{Group: share}}}
+		case id == 17513:
+			ext = &utls.ApplicationSettingsExtension{SupportedProtocols: []string{"h2"}}
+		case id == 65281:
+			ext = &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient}
+		case id == 41, id == 42, id == 44:
+			continue
+		default:
+			ext = &utls.GenericExtension{Id: id}
+		}
+		spec.Extensions = append(spec.Extensions, ext)
+	}
+	return spec
+}
@@ -0,0 +1,188 @@
+package httpmux
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// TLS handshake worker pool (httpsmux server)
+//
+//   advanced:
+//     handshake_workers: 16     # default 4 × CPUs
+//     handshake_queue: 512      # accepted conns waiting for a worker
+//     handshake_timeout: 10     # seconds, queue wait + handshake
+//
+// net/http runs every TLS handshake in its own goroutine as soon as a
+// connection is accepted, so a scan or flood turns into unbounded
+// concurrent handshakes and starves the sessions already up. Here the
+// accept loop only queues raw connections; a fixed set of workers does
+// the handshakes and hands finished *tls.Conn to the HTTP server. When
+// the queue is full new connections are reset immediately (shed) and
+// counted as errors["handshake_shed"].
+// ═══════════════════════════════════════════════════════════════
+
+func applyHandshakeDefaults(a *AdvancedConfig) {
+	if a.HandshakeWorkers <= 0 {
+		a.HandshakeWorkers = 4 * runtime.NumCPU()
+	}
+	if a.HandshakeQueue <= 0 {
+		a.HandshakeQueue = 512
+	}
+	if a.HandshakeTimeout <= 0 {
+		a.HandshakeTimeout = 10
+	}
+}
+
+type queuedConn struct {
+	conn net.Conn
+	at   time.Time
+}
+
+type handshakeListener struct {
+	raw     net.Listener
+	cfg     *tls.Config
+	timeout time.Duration
+	stats   *Stats
+	sni     *sniRouter // nil = every conn is tunnel traffic
+
+	queue chan queuedConn
+	ready chan net.Conn
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newHandshakeListener(raw net.Listener, cfg *tls.Config, adv *AdvancedConfig, stats *Stats, sni *sniRouter) *handshakeListener {
+	l := &handshakeListener{
+		raw:     raw,
+		cfg:     cfg,
+		timeout: time.Duration(adv.HandshakeTimeout) * time.Second,
+		stats:   stats,
+		sni:     sni,
+		queue:   make(chan queuedConn, adv.HandshakeQueue),
+		ready:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	for i := 0; i < adv.HandshakeWorkers; i++ {
+		go l.worker()
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *handshakeListener) acceptLoop() {
+	for {
+		conn, err := l.raw.Accept()
+		if err != nil {
+			select {
+			case <-l.done:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			l.Close()
+			return
+		}
+		select {
+		case l.queue <- queuedConn{conn: conn, at: time.Now()}:
+		default:
+			l.stats.incError("handshake_shed")
+			logDedupf("handshake_shed", "[TLS] handshake queue full (%d) — shedding connections", cap(l.queue))
+			if tc, ok := conn.(*net.TCPConn); ok {
+				tc.SetLinger(0)
+			}
+			conn.Close()
+		}
+	}
+}
+
+func (l *handshakeListener) worker() {
+	for {
+		var q queuedConn
+		select {
+		case q = <-l.queue:
+		case <-l.done:
+			return
+		}
+		// Queue wait counts against the timeout: a conn that waited too
+		// long has likely given up already.
+		deadline := q.at.Add(l.timeout)
+		if time.Now().After(deadline) {
+			l.stats.incError("handshake_timeout")
+			q.conn.Close()
+			continue
+		}
+		conn := q.conn
+		if l.sni != nil {
+			conn.SetReadDeadline(deadline)
+			if conn = l.sni.route(conn); conn == nil {
+				continue
+			}
+			conn.SetReadDeadline(time.Time{})
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		tc := tls.Server(conn, l.cfg)
+		err := tc.HandshakeContext(ctx)
+		cancel()
+		if err != nil {
+			l.stats.incError("tls_handshake")
+			q.conn.Close()
+			continue
+		}
+		select {
+		case l.ready <- tc:
+		case <-l.done:
+			tc.Close()
+			return
+		}
+	}
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.ready:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *handshakeListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.raw.Close()
+		for {
+			select {
+			case q := <-l.queue:
+				q.conn.Close()
+			default:
+				return
+			}
+		}
+	})
+	return err
+}
+
+func (l *handshakeListener) Addr() net.Addr { return l.raw.Addr() }
+
+// tlsListener runs TLS handshakes for server on connections from raw.
+func (s *Server) tlsListener(server *http.Server, raw net.Listener) net.Listener {
+	adv := &s.Config.Advanced
+	log.Printf("[TLS] %s: %d handshake workers, queue %d", raw.Addr(), adv.HandshakeWorkers, adv.HandshakeQueue)
+	sni := newSNIRouter(s.Config, s.stats)
+	if sni != nil {
+		sni.logConfig()
+	}
+	return newHandshakeListener(raw, server.TLSConfig, adv, s.stats, sni)
+}
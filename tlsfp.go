@@ -0,0 +1,149 @@
+package httpmux
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// TLS fingerprint selection (client, httpsmux/wssmux)
+//
+//   tls_fingerprint: chrome     # chrome | firefox | safari | edge | ios |
+//                               # android | random (default) | a JA3 string
+//   paths:
+//     - { transport: httpsmux, addr: "1.2.3.4:443", tls_fingerprint: firefox }
+//     - { transport: httpsmux, addr: "5.6.7.8:443", pin_fingerprint: true }
+//
+// random picks one of chrome, firefox, edge and safari per connection.
+// Some middleboxes treat a client whose fingerprint changes between
+// connections differently from one that stays put; pin_fingerprint
+// makes a path pick once and keep that choice until restart, and a
+// named fingerprint on the path pins it outright.
+//
+// A JA3 string ("771,4865-4866-…,0-23-65281-…,29-23-24,0") copies the
+// cipher, extension, curve and point-format order of any client. Only
+// the order and IDs are taken from it; extension contents are filled
+// in with what browsers send. Builds with no_utls ignore the setting
+// and always send Go's own ClientHello.
+// ═══════════════════════════════════════════════════════════════
+
+const fingerprintRandom = "random"
+
+var namedFingerprints = []string{"chrome", "firefox", "safari", "edge", "ios", "android"}
+
+// randomFingerprints is the pool for "random".
+var randomFingerprints = []string{"chrome", "firefox", "edge", "safari"}
+
+// normalizeFingerprint lowercases a fingerprint name and checks it;
+// JA3 strings are returned as given.
+func normalizeFingerprint(fp string) (string, error) {
+	fp = strings.TrimSpace(fp)
+	if strings.Contains(fp, ",") {
+		if _, err := parseJA3(fp); err != nil {
+			return "", fmt.Errorf("ja3: %w", err)
+		}
+		return fp, nil
+	}
+	fp = strings.ToLower(fp)
+	if fp == "" || fp == fingerprintRandom {
+		return fp, nil
+	}
+	for _, n := range namedFingerprints {
+		if fp == n {
+			return fp, nil
+		}
+	}
+	return "", fmt.Errorf("unknown %q (%s, random or a JA3 string)", fp, strings.Join(namedFingerprints, ", "))
+}
+
+type ja3Spec struct {
+	version uint16
+	ciphers []uint16
+	exts    []uint16
+	curves  []uint16
+	points  []uint8
+}
+
+// parseJA3 splits "version,ciphers,extensions,curves,points", each
+// list dash-separated.
+func parseJA3(s string) (*ja3Spec, error) {
+	f := strings.Split(strings.TrimSpace(s), ",")
+	if len(f) != 5 {
+		return nil, fmt.Errorf("want 5 comma-separated fields, got %d", len(f))
+	}
+	list := func(field string, bits int) ([]uint64, error) {
+		if field == "" {
+			return nil, nil
+		}
+		var out []uint64
+		for _, p := range strings.Split(field, "-") {
+			v, err := strconv.ParseUint(p, 10, bits)
+			if err != nil {
+				return nil, fmt.Errorf("bad value %q", p)
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	}
+	v, err := strconv.ParseUint(f[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad version %q", f[0])
+	}
+	j := &ja3Spec{version: uint16(v)}
+	for i, dst := range []*[]uint16{&j.ciphers, &j.exts, &j.curves} {
+		vals, err := list(f[i+1], 16)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vals {
+			*dst = append(*dst, uint16(v))
+		}
+	}
+	pts, err := list(f[4], 8)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range pts {
+		j.points = append(j.points, uint8(v))
+	}
+	if len(j.ciphers) == 0 {
+		return nil, fmt.Errorf("no cipher suites")
+	}
+	return j, nil
+}
+
+// fingerprintPins holds the pick of each pin_fingerprint path.
+type fingerprintPins struct {
+	mu     sync.Mutex
+	byPath map[int]string
+}
+
+// tlsFingerprint returns the fingerprint for the next connection on
+// path pathIdx: a name from namedFingerprints or a JA3 string.
+func (c *Client) tlsFingerprint(pathIdx int, path PathConfig) string {
+	fp := path.TLSFingerprint
+	if fp == "" {
+		fp = c.cfg.TLSFingerprint
+	}
+	if fp != "" && fp != fingerprintRandom {
+		return fp
+	}
+	if !path.PinFingerprint {
+		return randomFingerprints[secureRandInt(len(randomFingerprints))]
+	}
+	c.fpPins.mu.Lock()
+	defer c.fpPins.mu.Unlock()
+	if c.fpPins.byPath == nil {
+		c.fpPins.byPath = map[int]string{}
+	}
+	if pinned, ok := c.fpPins.byPath[pathIdx]; ok {
+		return pinned
+	}
+	pinned := randomFingerprints[secureRandInt(len(randomFingerprints))]
+	c.fpPins.byPath[pathIdx] = pinned
+	log.Printf("[TLS] path #%d: fingerprint pinned to %s", pathIdx, pinned)
+	return pinned
+}
@@ -0,0 +1,141 @@
+package httpmux
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Server certificate verification (client, httpsmux/wssmux)
+//
+//   tls_verify: true                 # check the chain and the name
+//   ca_file: "/etc/picotun/ca.pem"   # default: system roots
+//   pin_sha256:                      # any one must match
+//     - "sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="
+//
+// By default the client accepts any certificate and relies on the PSK
+// handshake alone, so a middlebox that terminates TLS can sit in the
+// middle unseen. tls_verify checks the chain against ca_file (or the
+// system roots) and the name against mimic.fake_domain (or the host in
+// addr), never against a rotated SNI from stealth.domain_pool.
+// pin_sha256 entries are SHA-256 digests of a certificate or of its
+// public key (SPKI), base64 or hex, with or without the "sha256/"
+// prefix. With tls_verify a pin may name the leaf, an intermediate or
+// the root, but it must sit in a chain Verify built: certificates the
+// server merely sends along prove nothing, since CA certificates are
+// public. Without tls_verify, for self-signed servers, only the
+// server's own certificate (the first) is compared.
+// ═══════════════════════════════════════════════════════════════
+
+type certVerifier struct {
+	verify bool
+	roots  *x509.CertPool // nil = system roots
+	pins   [][]byte
+}
+
+// newCertVerifier returns nil when neither tls_verify nor pin_sha256
+// is set.
+func newCertVerifier(cfg *Config) (*certVerifier, error) {
+	if !cfg.TLSVerify && len(cfg.PinSHA256) == 0 {
+		return nil, nil
+	}
+	v := &certVerifier{verify: cfg.TLSVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ca_file: %w", err)
+		}
+		v.roots = x509.NewCertPool()
+		if !v.roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca_file: no certificates in %s", cfg.CAFile)
+		}
+	}
+	for _, p := range cfg.PinSHA256 {
+		pin, err := parsePin(p)
+		if err != nil {
+			return nil, fmt.Errorf("pin_sha256 %q: %w", p, err)
+		}
+		v.pins = append(v.pins, pin)
+	}
+	return v, nil
+}
+
+func parsePin(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "sha256/"), "sha256:")
+	if b, err := hex.DecodeString(strings.ReplaceAll(s, ":", "")); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(s); err == nil && len(b) == sha256.Size {
+		return b, nil
+	}
+	return nil, errors.New("want a SHA-256 digest in base64 or hex")
+}
+
+// check verifies the raw chain the server sent for sni.
+func (v *certVerifier) check(rawCerts [][]byte, sni string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no server certificate")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("bad server certificate: %w", err)
+		}
+		certs = append(certs, c)
+	}
+	// Without verification only the leaf is the server's; with it, a
+	// pin counts in any chain Verify built up to a trusted root.
+	chains := [][]*x509.Certificate{certs[:1]}
+	if v.verify {
+		inter := x509.NewCertPool()
+		for _, c := range certs[1:] {
+			inter.AddCert(c)
+		}
+		opts := x509.VerifyOptions{Roots: v.roots, Intermediates: inter, DNSName: sni}
+		var err error
+		if chains, err = certs[0].Verify(opts); err != nil {
+			return err
+		}
+	}
+	if len(v.pins) == 0 {
+		return nil
+	}
+	for _, chain := range chains {
+		for _, c := range chain {
+			if v.pinned(c) {
+				return nil
+			}
+		}
+	}
+	return errors.New("server certificate matches no pin_sha256")
+}
+
+// pinned reports whether c's certificate or public key matches a pin.
+func (v *certVerifier) pinned(c *x509.Certificate) bool {
+	certSum := sha256.Sum256(c.Raw)
+	keySum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	for _, pin := range v.pins {
+		if bytes.Equal(pin, certSum[:]) || bytes.Equal(pin, keySum[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerCheck returns the VerifyPeerCertificate hook for sni; nil when
+// certificates aren't checked.
+func (v *certVerifier) peerCheck(sni string) func([][]byte) error {
+	if v == nil {
+		return nil
+	}
+	return func(rawCerts [][]byte) error { return v.check(rawCerts, sni) }
+}
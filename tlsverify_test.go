@@ -0,0 +1,79 @@
+package httpmux
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// TestCertPins sends pinned certificates along with a leaf they don't
+// vouch for, as an attacker holding a public CA certificate would.
+func TestCertPins(t *testing.T) {
+	newCert := func(name string, ca bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(time.Now().UnixNano()),
+			Subject:               pkix.Name{CommonName: name},
+			DNSNames:              []string{name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  ca,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		if parent == nil {
+			parent, parentKey = tmpl, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, _ := x509.ParseCertificate(der)
+		return c, key
+	}
+	ca, caKey := newCert("Test CA", true, nil, nil)
+	leaf, _ := newCert("cdn.example.com", false, ca, caKey)
+	forged, _ := newCert("cdn.example.com", false, nil, nil) // the attacker's own
+	other, _ := newCert("Other CA", true, nil, nil)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	pin := func(c *x509.Certificate) [][]byte {
+		sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+		p, _ := parsePin("sha256/" + base64.StdEncoding.EncodeToString(sum[:]))
+		return [][]byte{p}
+	}
+	raw := func(cs ...*x509.Certificate) [][]byte {
+		var out [][]byte
+		for _, c := range cs {
+			out = append(out, c.Raw)
+		}
+		return out
+	}
+	for _, c := range []struct {
+		name string
+		v    *certVerifier
+		sent [][]byte
+		ok   bool
+	}{
+		{"leaf pin", &certVerifier{pins: pin(leaf)}, raw(leaf, ca), true},
+		{"forged leaf, pinned leaf sent along", &certVerifier{pins: pin(leaf)}, raw(forged, leaf), false},
+		{"forged leaf, pinned CA sent along", &certVerifier{pins: pin(ca)}, raw(forged, ca), false},
+		{"verify, CA pin in the chain", &certVerifier{verify: true, roots: roots, pins: pin(ca)}, raw(leaf), true},
+		{"verify, leaf pin", &certVerifier{verify: true, roots: roots, pins: pin(leaf)}, raw(leaf), true},
+		{"verify, pinned cert outside the chain", &certVerifier{verify: true, roots: roots, pins: pin(other)}, raw(leaf, other), false},
+		{"verify, forged leaf", &certVerifier{verify: true, roots: roots, pins: pin(ca)}, raw(forged, ca), false},
+	} {
+		if err := c.v.check(c.sent, "cdn.example.com"); (err == nil) != c.ok {
+			t.Errorf("%s: err %v, want ok=%v", c.name, err, c.ok)
+		}
+	}
+}
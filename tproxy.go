@@ -0,0 +1,115 @@
+package httpmux
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Transparent proxy inbound (client, Linux)
+//
+//   tproxy:
+//     listen: "0.0.0.0:12345"
+//     mode: redirect         # redirect (default) | tproxy
+//
+// Takes TCP connections that the firewall diverted to listen, finds
+// where each was really going and sends it there like a SOCKS5
+// CONNECT: routing: rules, priority and fallback apply the same way.
+// On a router this tunnels every device behind it without any app
+// knowing.
+//
+// redirect is for iptables/nftables REDIRECT (nat table); the target
+// comes from conntrack through SO_ORIGINAL_DST:
+//
+//   iptables -t nat -N PICOTUN
+//   iptables -t nat -A PICOTUN -d <server ip> -j RETURN
+//   iptables -t nat -A PICOTUN -d 10.0.0.0/8 -j RETURN     # LAN etc.
+//   iptables -t nat -A PICOTUN -p tcp -j REDIRECT --to-ports 12345
+//   iptables -t nat -A PREROUTING -p tcp -j PICOTUN
+//
+// tproxy is for the TPROXY target (mangle table), which keeps the
+// target as the socket's local address; the listener sets
+// IP_TRANSPARENT and needs CAP_NET_ADMIN, plus the usual fwmark rule
+// and local route. It also works for IPv6.
+//
+// Exclude the server's address (and, for OUTPUT rules, picotun's own
+// traffic, e.g. by its uid) or the tunnel is diverted into itself; a
+// connection whose target is the listener itself is dropped. UDP isn't
+// handled: route it through socks5 or leave it direct. With the socks5
+// frontend's remote_dns as the devices' DNS server, connections to the
+// placeholder range go out by name.
+// ═══════════════════════════════════════════════════════════════
+
+type TProxyConfig struct {
+	Listen string `yaml:"listen"` // "" = disabled
+	Mode   string `yaml:"mode"`   // redirect | tproxy
+}
+
+const (
+	tproxyRedirect = "redirect"
+	tproxyTProxy   = "tproxy"
+)
+
+func normalizeTProxyMode(s string) (string, error) {
+	switch s = strings.ToLower(strings.TrimSpace(s)); s {
+	case "", tproxyRedirect:
+		return tproxyRedirect, nil
+	case tproxyTProxy:
+		return s, nil
+	}
+	return "", fmt.Errorf("mode %q: want redirect or tproxy", s)
+}
+
+func (c *Client) startTProxy() {
+	cfg := &c.cfg.TProxy
+	if cfg.Listen == "" {
+		return
+	}
+	mode, _ := normalizeTProxyMode(cfg.Mode) // checked by prepareConfig
+	ln, err := listenTProxy(cfg.Listen, mode)
+	if err != nil {
+		log.Printf("[TPROXY] FAILED listen %s: %v", cfg.Listen, err)
+		return
+	}
+	log.Printf("[TPROXY] %s (%s)", cfg.Listen, mode)
+	c.life.track(ln)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if c.life.isClosing() {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			go c.handleTProxy(conn, mode)
+		}
+	}()
+}
+
+func (c *Client) handleTProxy(conn net.Conn, mode string) {
+	defer conn.Close()
+	if !c.life.acquire() {
+		return
+	}
+	defer c.life.release()
+
+	dst, err := originalDst(conn, mode)
+	if err == nil && dst == conn.LocalAddr().String() {
+		err = fmt.Errorf("not redirected (connected to the listener itself)")
+	}
+	if err != nil {
+		c.stats.incError("tproxy")
+		if c.verbose {
+			logDedupf("tproxy"+conn.RemoteAddr().String(), "[TPROXY] %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c.proxyConnect(conn, c.remoteDNS.unmap(dst), "tproxy", func(bool) {})
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package httpmux
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const tproxySupported = true
+
+// listenTProxy listens on addr; tproxy mode sets IP_TRANSPARENT so the
+// socket accepts connections addressed elsewhere.
+func listenTProxy(addr, mode string) (net.Listener, error) {
+	if mode != tproxyTProxy {
+		return net.Listen("tcp", addr)
+	}
+	lc := net.ListenConfig{Control: func(network, _ string, c syscall.RawConn) error {
+		return rawControl(c, func(fd uintptr) error {
+			if network == "tcp4" {
+				return unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+			}
+			unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+			return unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+		})
+	}}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// originalDst is where a diverted connection was headed, "ip:port".
+func originalDst(conn net.Conn, mode string) (string, error) {
+	if mode == tproxyTProxy {
+		return conn.LocalAddr().String(), nil // TPROXY keeps the target
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", net.UnknownNetworkError("not tcp")
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	v6 := tc.LocalAddr().(*net.TCPAddr).IP.To4() == nil
+	var dst string
+	var serr error
+	err = raw.Control(func(fd uintptr) { dst, serr = soOriginalDst(int(fd), v6) })
+	if err != nil {
+		return "", err
+	}
+	return dst, serr
+}
+
+// soOriginalDst reads conntrack's pre-NAT destination: a sockaddr_in
+// from SO_ORIGINAL_DST, or a sockaddr_in6 from IP6T_SO_ORIGINAL_DST
+// (the same option number on SOL_IPV6).
+func soOriginalDst(fd int, v6 bool) (string, error) {
+	var sa [unix.SizeofSockaddrInet6]byte
+	level, size := unix.SOL_IP, uint32(unix.SizeofSockaddrInet4)
+	if v6 {
+		level, size = unix.SOL_IPV6, uint32(unix.SizeofSockaddrInet6)
+	}
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), unix.SO_ORIGINAL_DST,
+		uintptr(unsafe.Pointer(&sa[0])), uintptr(unsafe.Pointer(&size)), 0)
+	if errno != 0 {
+		return "", errno
+	}
+	port := int(binary.BigEndian.Uint16(sa[2:4]))
+	ip := net.IP(sa[4:8])
+	if v6 {
+		ip = net.IP(sa[8:24])
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port)), nil
+}
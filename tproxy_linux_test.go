@@ -0,0 +1,43 @@
+package httpmux
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestTProxyDropsUndiverted connects straight to the tproxy listener:
+// with no firewall rule in between its target is the listener itself,
+// which must not be proxied.
+func TestTProxyDropsUndiverted(t *testing.T) {
+	for _, mode := range []string{tproxyRedirect, tproxyTProxy} {
+		t.Run(mode, func(t *testing.T) {
+			ln := freeAddr(t)
+			tun := startTunnel(t, tunnelOpts{client: "tproxy: {listen: \"" + ln + "\", mode: " + mode + "}\n"})
+			deadline := time.Now().Add(2 * time.Second)
+			var c net.Conn
+			var err error
+			for {
+				if c, err = net.Dial("tcp", ln); err == nil || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			if err != nil {
+				t.Skipf("tproxy listener: %v", err) // IP_TRANSPARENT needs CAP_NET_ADMIN
+			}
+			defer c.Close()
+			c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			if n, err := c.Read(make([]byte, 1)); n > 0 || os.IsTimeout(err) {
+				t.Fatalf("undiverted connection got %d bytes, %v; want it closed", n, err)
+			}
+			st := tun.client.stats
+			st.mu.Lock()
+			defer st.mu.Unlock()
+			if st.errors["tproxy"] == 0 {
+				t.Error("errors.tproxy not counted")
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package httpmux
+
+import (
+	"errors"
+	"net"
+)
+
+const tproxySupported = false
+
+var errTProxyLinux = errors.New("transparent proxying needs Linux")
+
+func listenTProxy(addr, mode string) (net.Listener, error) { return nil, errTProxyLinux }
+
+func originalDst(conn net.Conn, mode string) (string, error) { return "", errTProxyLinux }
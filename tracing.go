@@ -0,0 +1,387 @@
+package httpmux
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Stream tracing (OTLP)
+//
+//   tracing:
+//     endpoint: http://127.0.0.1:4318   # OTLP/HTTP collector
+//     service: edge-1                   # service.name, default picotun-<mode>
+//     sample: 0.1                       # fraction of streams, default 1
+//     headers: {Authorization: "Bearer ..."}
+//
+// Every relayed stream gets one span on each end that traces it: the
+// side that accepted the connection (a map visitor on the server, a
+// SOCKS5 client on the client) and the side that dialed the target.
+// Events mark the phases — stream_opened on the first, header_read and
+// dialed on the second — and the span ends when the relay closes,
+// with the byte counts and, on failure, the error.
+//
+// Both ends derive the trace and parent span ids from the session's
+// auth nonce and the mux stream id, so their spans join into one trace
+// without anything extra on the wire, and sampling on the trace id
+// keeps the same streams on both ends when they use the same sample.
+//
+// Spans are batched and POSTed as OTLP/HTTP JSON to
+// <endpoint>/v1/traces; spans that don't fit in the queue while the
+// collector is slow or down are dropped and counted.
+// ═══════════════════════════════════════════════════════════════
+
+type TracingConfig struct {
+	Endpoint string            `yaml:"endpoint"` // OTLP/HTTP base URL; "" = off
+	Service  string            `yaml:"service"`
+	Sample   float64           `yaml:"sample"` // 0-1, 0 = 1
+	Headers  map[string]string `yaml:"headers"`
+}
+
+const (
+	traceQueueLen   = 4096
+	traceBatchLen   = 512
+	traceFlushEvery = 5 * time.Second
+
+	// OTLP span kinds and status codes.
+	spanKindServer  = 2
+	spanKindClient  = 3
+	spanStatusError = 2
+)
+
+type tracer struct {
+	url       string
+	service   string
+	threshold uint64 // sampled when the trace id's low half is below
+	headers   map[string]string
+	http      *http.Client
+	stats     *Stats
+
+	queue   chan otlpSpan
+	dropped atomic.Int64
+	flushMu sync.Mutex // one POST at a time
+}
+
+// newTracer returns nil unless tracing.endpoint is set.
+func newTracer(cfg *Config, stats *Stats) *tracer {
+	tc := cfg.Tracing
+	if tc.Endpoint == "" {
+		return nil
+	}
+	service := tc.Service
+	if service == "" {
+		service = "picotun-" + cfg.Mode
+	}
+	sample := tc.Sample
+	if sample <= 0 || sample > 1 {
+		sample = 1
+	}
+	threshold := ^uint64(0)
+	if sample < 1 {
+		threshold = uint64(sample * (1 << 63) * 2)
+	}
+	return &tracer{
+		url:       strings.TrimRight(tc.Endpoint, "/") + "/v1/traces",
+		service:   service,
+		threshold: threshold,
+		headers:   tc.Headers,
+		http:      &http.Client{Timeout: 10 * time.Second},
+		stats:     stats,
+		queue:     make(chan otlpSpan, traceQueueLen),
+	}
+}
+
+// run exports batches until shutdown begins; Shutdown calls close for
+// the spans of the drained relays.
+func (t *tracer) run(life *lifecycle) {
+	if t == nil {
+		return
+	}
+	log.Printf("[TRACE] exporting stream spans to %s as %q", t.url, t.service)
+	for life.sleep(traceFlushEvery) {
+		t.flush()
+	}
+}
+
+func (t *tracer) close() {
+	if t == nil {
+		return
+	}
+	t.flush()
+}
+
+// flush POSTs everything queued, traceBatchLen spans at a time.
+func (t *tracer) flush() {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+	if n := t.dropped.Swap(0); n > 0 {
+		logDedupf("trace-drop", "[TRACE] dropped %d spans, collector too slow", n)
+	}
+	for {
+		batch := make([]otlpSpan, 0, traceBatchLen)
+	fill:
+		for len(batch) < traceBatchLen {
+			select {
+			case sp := <-t.queue:
+				batch = append(batch, sp)
+			default:
+				break fill
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			t.stats.incError("trace_export")
+			logDedupf("trace", "[TRACE] %s: %v", t.url, err)
+			return // the rest waits for the next flush or is dropped
+		}
+	}
+}
+
+func (t *tracer) export(spans []otlpSpan) error {
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttr{strAttr("service.name", t.service)}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "picotun"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// ──────────── Spans ────────────
+
+// streamSpan traces one stream on one end. All methods are no-ops on
+// a nil span, which is what a nil tracer starts.
+type streamSpan struct {
+	t       *tracer
+	name    string
+	kind    int
+	start   time.Time
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte // zero = root
+
+	mu     sync.Mutex
+	attrs  []otlpAttr
+	events []otlpEvent
+	err    string
+
+	in, out atomic.Int64
+}
+
+// startStream begins a span with random ids; link replaces them once
+// the stream is known.
+func (t *tracer) startStream(name string, kind int) *streamSpan {
+	if t == nil {
+		return nil
+	}
+	sp := &streamSpan{t: t, name: name, kind: kind, start: time.Now()}
+	rand.Read(sp.traceID[:])
+	rand.Read(sp.spanID[:])
+	return sp
+}
+
+// link ties sp to stream on the session authenticated with nonce. The
+// end that opened the stream takes the derived span id, the other end
+// makes it its parent.
+func (sp *streamSpan) link(nonce []byte, stream net.Conn, opener bool) {
+	if sp == nil || len(nonce) == 0 {
+		return
+	}
+	id, ok := muxStreamID(stream)
+	if !ok {
+		return
+	}
+	h := sha256.New()
+	h.Write([]byte("picotun trace"))
+	h.Write(nonce)
+	binary.Write(h, binary.BigEndian, id)
+	sum := h.Sum(nil)
+	copy(sp.traceID[:], sum[:16])
+	if opener {
+		copy(sp.spanID[:], sum[16:24])
+	} else {
+		copy(sp.parent[:], sum[16:24])
+	}
+	sp.attr("picotun.stream_id", strconv.FormatUint(uint64(id), 10))
+}
+
+func (sp *streamSpan) attr(key, value string) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.attrs = append(sp.attrs, strAttr(key, value))
+	sp.mu.Unlock()
+}
+
+// event marks a phase as done now.
+func (sp *streamSpan) event(name string) {
+	if sp == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.events = append(sp.events, otlpEvent{Time: nanos(time.Now()), Name: name})
+	sp.mu.Unlock()
+}
+
+func (sp *streamSpan) fail(err error) {
+	if sp == nil || err == nil {
+		return
+	}
+	sp.mu.Lock()
+	sp.err = err.Error()
+	sp.mu.Unlock()
+}
+
+// add counts relayed bytes (countedConn).
+func (sp *streamSpan) add(in, out int64) {
+	if sp == nil {
+		return
+	}
+	sp.in.Add(in)
+	sp.out.Add(out)
+}
+
+// end queues the span for export if its trace is sampled.
+func (sp *streamSpan) end() {
+	if sp == nil || binary.BigEndian.Uint64(sp.traceID[8:]) > sp.t.threshold {
+		return
+	}
+	sp.mu.Lock()
+	o := otlpSpan{
+		TraceID: hex.EncodeToString(sp.traceID[:]),
+		SpanID:  hex.EncodeToString(sp.spanID[:]),
+		Name:    sp.name,
+		Kind:    sp.kind,
+		Start:   nanos(sp.start),
+		End:     nanos(time.Now()),
+		Attributes: append(sp.attrs,
+			intAttr("picotun.bytes_in", sp.in.Load()),
+			intAttr("picotun.bytes_out", sp.out.Load())),
+		Events: sp.events,
+	}
+	if sp.parent != ([8]byte{}) {
+		o.Parent = hex.EncodeToString(sp.parent[:])
+	}
+	if sp.err != "" {
+		o.Status = &otlpStatus{Code: spanStatusError, Message: sp.err}
+	}
+	sp.mu.Unlock()
+	select {
+	case sp.t.queue <- o:
+	default:
+		sp.t.dropped.Add(1)
+	}
+}
+
+// muxStreamID is the stream's id, the same on both ends of the session.
+func muxStreamID(c net.Conn) (uint32, bool) {
+	switch st := c.(type) {
+	case interface{ ID() uint32 }: // smux
+		return st.ID(), true
+	case interface{ StreamID() uint32 }: // yamux
+		return st.StreamID(), true
+	}
+	return 0, false
+}
+
+// ──────────── OTLP/HTTP JSON ────────────
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID    string      `json:"traceId"`
+	SpanID     string      `json:"spanId"`
+	Parent     string      `json:"parentSpanId,omitempty"`
+	Name       string      `json:"name"`
+	Kind       int         `json:"kind"`
+	Start      string      `json:"startTimeUnixNano"`
+	End        string      `json:"endTimeUnixNano"`
+	Attributes []otlpAttr  `json:"attributes,omitempty"`
+	Events     []otlpEvent `json:"events,omitempty"`
+	Status     *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpEvent struct {
+	Time string `json:"timeUnixNano"`
+	Name string `json:"name"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	String *string `json:"stringValue,omitempty"`
+	Int    *string `json:"intValue,omitempty"` // int64 as a decimal string
+}
+
+func strAttr(k, v string) otlpAttr { return otlpAttr{Key: k, Value: otlpValue{String: &v}} }
+
+func intAttr(k string, v int64) otlpAttr {
+	s := strconv.FormatInt(v, 10)
+	return otlpAttr{Key: k, Value: otlpValue{Int: &s}}
+}
+
+func nanos(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
@@ -0,0 +1,145 @@
+package httpmux
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Traffic models (both ends)
+//
+//   stealth:
+//     traffic_model: web   # web | video | call ("" = random_padding as before)
+//
+// random_padding draws every pad from one uniform range, and a flow
+// whose record sizes are spread evenly over [min, max] looks like
+// nothing a real application sends — uniform randomness is a
+// signature of its own. A traffic model instead cuts every write into
+// records whose on-the-wire sizes follow the shape of a real protocol,
+// padding short ones up to the drawn size, and paces them like it:
+//
+//   web    a quarter small records (requests, acks), the rest mostly
+//          full-size; bursts of 4-16 records a few ms apart
+//   video  nearly all full-size records in long bursts (segment
+//          downloads), a few small ones (acks, manifest requests)
+//   call   mid-size records at a steady 15-25ms, like an RTP media
+//          stream — caps a session near 0.3 Mbit/s each way
+//
+// The model only shapes what this end sends; set it on both ends. Its
+// records use the random_padding framing, so either end strips them
+// whatever the other's random_padding says, but a peer without either
+// setting can't read them. It replaces random_padding and burst_split,
+// and obfuscation (obfs:) wins over it. TCP may still merge records
+// when the socket is backlogged.
+// ═══════════════════════════════════════════════════════════════
+
+// sizeRange is a weighted range of record sizes on the wire.
+type sizeRange struct {
+	lo, hi, weight int
+}
+
+type trafficModel struct {
+	sizes []sizeRange
+	burst [2]int           // records per burst (min, max); 0 = no bursts
+	pause [2]time.Duration // between bursts
+	space [2]time.Duration // between any two records; 0 = unpaced
+}
+
+var trafficModels = map[string]*trafficModel{
+	"web": {
+		sizes: []sizeRange{{80, 400, 25}, {400, 1200, 15}, {1200, 1460, 60}},
+		burst: [2]int{4, 16},
+		pause: [2]time.Duration{1 * time.Millisecond, 8 * time.Millisecond},
+	},
+	"video": {
+		sizes: []sizeRange{{60, 200, 10}, {1380, 1460, 90}},
+		burst: [2]int{24, 96},
+		pause: [2]time.Duration{2 * time.Millisecond, 15 * time.Millisecond},
+	},
+	"call": {
+		sizes: []sizeRange{{80, 240, 10}, {600, 1100, 90}},
+		space: [2]time.Duration{15 * time.Millisecond, 25 * time.Millisecond},
+	},
+}
+
+// normalizeTrafficModel lowercases a traffic_model name and checks it.
+func normalizeTrafficModel(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if _, ok := trafficModels[name]; ok || name == "" {
+		return name, nil
+	}
+	names := make([]string, 0, len(trafficModels))
+	for n := range trafficModels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("unknown %q (%s)", name, strings.Join(names, ", "))
+}
+
+// size draws a record size.
+func (m *trafficModel) size() int {
+	total := 0
+	for _, r := range m.sizes {
+		total += r.weight
+	}
+	n := secureRandInt(total)
+	for _, r := range m.sizes {
+		if n < r.weight {
+			return r.lo + secureRandInt(r.hi-r.lo+1)
+		}
+		n -= r.weight
+	}
+	return m.sizes[len(m.sizes)-1].hi
+}
+
+func randBetween(d [2]time.Duration) time.Duration {
+	return d[0] + time.Duration(secureRandInt(int(d[1]-d[0])+1))
+}
+
+// shapeState is a connection's position in its model's timing.
+type shapeState struct {
+	last time.Time // previous record sent
+	left int       // records left in the current burst
+}
+
+// pace waits until the next record may go.
+func (s *shapeState) pace(m *trafficModel) {
+	var gap time.Duration
+	switch {
+	case m.space[1] > 0:
+		gap = randBetween(m.space)
+	case m.burst[1] > 0:
+		if s.left == 0 {
+			gap = randBetween(m.pause)
+			s.left = m.burst[0] + secureRandInt(m.burst[1]-m.burst[0]+1)
+		}
+		s.left--
+	}
+	if wait := gap - time.Since(s.last); wait > 0 && !s.last.IsZero() {
+		time.Sleep(wait) // an idle flow already had its gap
+	}
+	s.last = time.Now()
+}
+
+// modelWrite sends data as records sized and paced by c.model. Caller
+// holds writeMu.
+func (c *EncryptedConn) modelWrite(data []byte) (int, error) {
+	overhead := 4 + 2 // length prefix, padding header
+	if c.gcm != nil {
+		overhead += c.gcm.NonceSize() + c.gcm.Overhead()
+	}
+	total := 0
+	for len(data) > 0 {
+		room := max(c.model.size()-overhead, 1)
+		n := min(room, len(data))
+		c.shape.pace(c.model)
+		if err := c.seal(padFrame(data[:n], room-n)); err != nil {
+			return total, err
+		}
+		total += n
+		data = data[n:]
+	}
+	return total, nil
+}
@@ -0,0 +1,69 @@
+package httpmux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestTrafficModelSizes checks every record a model writes against the
+// model's size ranges, and that a random_padding peer reads them.
+func TestTrafficModelSizes(t *testing.T) {
+	for name, m := range trafficModels {
+		if name == "call" {
+			continue // paced; TestTrafficModelPacing
+		}
+		var wire bytes.Buffer
+		w, _ := NewEncryptedConn(&bufConn{w: &wire}, testPSK, nil, &StealthConfig{TrafficModel: name})
+		want := randomBytes(t, 64<<10)
+		for _, n := range []int{8, 100, len(want) - 108} { // a smux header, a small and a big frame
+			if _, err := w.Write(want[:n]); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want[n:], want[:n]...)
+		}
+
+		raw := wire.Bytes()
+		for len(raw) > 0 {
+			size := 4 + int(binary.BigEndian.Uint32(raw))
+			inModel := false
+			for _, r := range m.sizes {
+				inModel = inModel || size >= r.lo && size <= r.hi
+			}
+			if !inModel {
+				t.Fatalf("%s: %d-byte record", name, size)
+			}
+			raw = raw[size:]
+		}
+
+		r, _ := NewEncryptedConn(&bufConn{r: &wire}, testPSK, nil, &StealthConfig{RandomPadding: true})
+		got := make([]byte, len(want))
+		if _, err := io.ReadFull(r, got); err != nil || !bytes.Equal(got, want) {
+			t.Fatalf("%s: read back %v, equal=%v", name, err, bytes.Equal(got, want))
+		}
+	}
+}
+
+func TestTrafficModelPacing(t *testing.T) {
+	var wire bytes.Buffer
+	w, _ := NewEncryptedConn(&bufConn{w: &wire}, testPSK, nil, &StealthConfig{TrafficModel: "call"})
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		w.Write([]byte("voice frame"))
+	}
+	// 4 gaps of at least 15ms after the first record.
+	if took := time.Since(start); took < 60*time.Millisecond {
+		t.Fatalf("5 call records took %v", took)
+	}
+}
+
+func TestTrafficModelConfig(t *testing.T) {
+	if got, err := normalizeTrafficModel(" Video "); err != nil || got != "video" {
+		t.Errorf("normalizeTrafficModel(Video) = %q, %v", got, err)
+	}
+	if _, err := normalizeTrafficModel("telnet"); err == nil {
+		t.Error("unknown model accepted")
+	}
+}
@@ -0,0 +1,140 @@
+package httpmux
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// UDP flow table (server, per UDP map)
+//
+//   advanced:
+//     max_udp_flows: 300       # per map
+//     udp_flow_timeout: 120    # seconds without packets
+//
+// Each visitor address holds a stream (or fallback socket) and a reader
+// goroutine, so a flood from spoofed sources must not grow the table
+// without bound. Flows are kept in least-recently-seen order; at
+// max_udp_flows the oldest one is closed to make room (counted as
+// errors["udp_evicted"]). The live count across maps is udp_flows in
+// the stats.
+// ═══════════════════════════════════════════════════════════════
+
+type udpPeer struct {
+	key      string
+	stream   io.ReadWriteCloser // datagram-framed mux stream, or a direct conn to fallback_target
+	queue    *udpQueue          // visitor → stream (udpqueue.go)
+	ss       *serverSession
+	m        *mapStats
+	sm, am   *mapStats // session and account (accounting.go), nil for fallback flows
+	lastSeen int64     // atomic: unix seconds
+	elem     *list.Element
+}
+
+type udpFlows struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // of *udpPeer, front = most recently seen
+	byKey map[string]*udpPeer
+	stats *Stats
+}
+
+func newUDPFlows(max int, st *Stats) *udpFlows {
+	return &udpFlows{max: max, order: list.New(), byKey: map[string]*udpPeer{}, stats: st}
+}
+
+// get returns the flow for key, marking it seen.
+func (f *udpFlows) get(key string) *udpPeer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.byKey[key]
+	if p != nil {
+		atomic.StoreInt64(&p.lastSeen, time.Now().Unix())
+		f.order.MoveToFront(p.elem)
+	}
+	return p
+}
+
+// touch marks p seen (reply direction).
+func (f *udpFlows) touch(p *udpPeer) {
+	atomic.StoreInt64(&p.lastSeen, time.Now().Unix())
+	f.mu.Lock()
+	if f.byKey[p.key] == p {
+		f.order.MoveToFront(p.elem)
+	}
+	f.mu.Unlock()
+}
+
+// add inserts p, evicting the least recently seen flow when full.
+func (f *udpFlows) add(p *udpPeer) {
+	atomic.StoreInt64(&p.lastSeen, time.Now().Unix())
+	f.mu.Lock()
+	var evicted *udpPeer
+	if f.max > 0 && len(f.byKey) >= f.max {
+		if back := f.order.Back(); back != nil {
+			evicted = back.Value.(*udpPeer)
+			f.unlink(evicted)
+		}
+	}
+	p.elem = f.order.PushFront(p)
+	f.byKey[p.key] = p
+	f.mu.Unlock()
+	atomic.AddInt64(&f.stats.udpFlows, 1)
+	if evicted != nil {
+		f.stats.incError("udp_evicted")
+		evicted.stream.Close()
+	}
+}
+
+// remove drops p if it is still the flow for its key.
+func (f *udpFlows) remove(p *udpPeer) {
+	f.mu.Lock()
+	if f.byKey[p.key] == p {
+		f.unlink(p)
+	}
+	f.mu.Unlock()
+}
+
+func (f *udpFlows) unlink(p *udpPeer) {
+	f.order.Remove(p.elem)
+	delete(f.byKey, p.key)
+	atomic.AddInt64(&f.stats.udpFlows, -1)
+}
+
+// expire closes flows idle for longer than idle.
+func (f *udpFlows) expire(idle time.Duration) {
+	cutoff := time.Now().Add(-idle).Unix()
+	var stale []*udpPeer
+	f.mu.Lock()
+	for e := f.order.Back(); e != nil; e = e.Prev() {
+		p := e.Value.(*udpPeer)
+		if atomic.LoadInt64(&p.lastSeen) >= cutoff {
+			break
+		}
+		stale = append(stale, p)
+	}
+	for _, p := range stale {
+		f.unlink(p)
+	}
+	f.mu.Unlock()
+	for _, p := range stale {
+		p.stream.Close() // triggers reader goroutine exit + cleanup
+	}
+}
+
+// closeAll drops every flow (listener stopped).
+func (f *udpFlows) closeAll() {
+	f.mu.Lock()
+	all := make([]*udpPeer, 0, len(f.byKey))
+	for _, p := range f.byKey {
+		all = append(all, p)
+		f.unlink(p)
+	}
+	f.mu.Unlock()
+	for _, p := range all {
+		p.stream.Close()
+	}
+}
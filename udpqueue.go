@@ -0,0 +1,122 @@
+package httpmux
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Per-flow UDP send queues (server, UDP maps)
+//
+//   maps:
+//     - { type: udp, bind: "27015", target: "127.0.0.1:27015",
+//         udp_queue: 64, udp_drop: head }
+//
+// A UDP map reads every visitor's packets on one socket. Writing them
+// straight into the flows' streams let one flow whose stream is stalled
+// (mux window full, slow client) block the socket for every other
+// flow, and the kernel then dropped packets with nobody the wiser.
+// Each flow now has a bounded queue of udp_queue packets (default 128)
+// drained by its own writer; the reader never waits.
+//
+// When a queue is full a packet is dropped: the new one (udp_drop:
+// tail, the default — what a router does) or the oldest queued one
+// (udp_drop: head — for games and voice, where a fresh packet beats a
+// stale one). Drops are counted per map (dropped in the stats,
+// picotun_map_udp_dropped_total in /metrics) and logged at most once
+// a minute per map.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	udpQueueDefault = 128
+	udpQueueMax     = 4096
+
+	udpDropTail = "tail"
+	udpDropHead = "head"
+)
+
+func validUDPDrop(policy string) bool {
+	return policy == "" || policy == udpDropTail || policy == udpDropHead
+}
+
+type udpQueue struct {
+	ch   chan []byte
+	head bool      // drop the oldest packet instead of the new one
+	m    *mapStats // counts drops
+	bind string    // for the drop log
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newUDPQueue starts a writer feeding w from a queue sized for pm.
+func newUDPQueue(w io.Writer, pm *PortMap, bind string, m *mapStats) *udpQueue {
+	size := pm.UDPQueue
+	if size <= 0 {
+		size = udpQueueDefault
+	}
+	q := &udpQueue{
+		ch:   make(chan []byte, min(size, udpQueueMax)),
+		head: pm.UDPDrop == udpDropHead,
+		m:    m,
+		bind: bind,
+		done: make(chan struct{}),
+	}
+	go q.run(w)
+	return q
+}
+
+func (q *udpQueue) run(w io.Writer) {
+	for {
+		select {
+		case p := <-q.ch:
+			if _, err := w.Write(p); err != nil {
+				q.close()
+				return
+			}
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// push queues a copy of p, dropping a packet if the queue is full.
+func (q *udpQueue) push(p []byte) {
+	pkt := append([]byte(nil), p...)
+	select {
+	case q.ch <- pkt:
+		return
+	default:
+	}
+	if q.head {
+		select {
+		case <-q.ch: // the oldest goes
+			q.drop()
+		default:
+		}
+		select {
+		case q.ch <- pkt:
+			return
+		default: // another push took the slot
+		}
+	}
+	q.drop()
+}
+
+func (q *udpQueue) drop() {
+	if q.m != nil {
+		atomic.AddInt64(&q.m.dropped, 1)
+	}
+	policy := udpDropTail
+	if q.head {
+		policy = udpDropHead
+	}
+	logDedupf("udp-queue "+q.bind, "[RUDP] %s: flow queue full (%d packets), dropping at the %s",
+		q.bind, cap(q.ch), policy)
+}
+
+// close stops the writer; queued packets are discarded.
+func (q *udpQueue) close() {
+	q.closeOnce.Do(func() { close(q.done) })
+}
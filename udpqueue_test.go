@@ -0,0 +1,69 @@
+package httpmux
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stallWriter blocks every Write until release is closed.
+type stallWriter struct {
+	release chan struct{}
+	got     chan []byte
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.got <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func TestUDPQueueDrops(t *testing.T) {
+	for _, policy := range []string{udpDropTail, udpDropHead} {
+		t.Run(policy, func(t *testing.T) {
+			w := &stallWriter{release: make(chan struct{}), got: make(chan []byte, 16)}
+			m := &mapStats{}
+			q := newUDPQueue(w, &PortMap{UDPQueue: 2, UDPDrop: policy}, "test", m)
+			defer q.close()
+
+			// The writer holds packet 0; 1 and 2 fill the queue; 3 and 4
+			// overflow it. push must never block.
+			done := make(chan struct{})
+			go func() {
+				q.push([]byte{0})
+				time.Sleep(50 * time.Millisecond) // writer picks up 0
+				for i := byte(1); i <= 4; i++ {
+					q.push([]byte{i})
+				}
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("push blocked on a stalled flow")
+			}
+			if n := atomic.LoadInt64(&m.dropped); n != 2 {
+				t.Fatalf("dropped %d packets, want 2", n)
+			}
+
+			close(w.release)
+			var order []byte
+			for i := 0; i < 3; i++ {
+				order = append(order, (<-w.got)[0])
+			}
+			want := []byte{0, 1, 2} // tail keeps the oldest
+			if policy == udpDropHead {
+				want = []byte{0, 3, 4} // head keeps the newest
+			}
+			if string(order) != string(want) {
+				t.Fatalf("delivered %v, want %v", order, want)
+			}
+		})
+	}
+}
+
+func TestReverseUDPMapHeadDrop(t *testing.T) {
+	bind := freeUDPAddr(t)
+	startTunnel(t, tunnelOpts{server: "maps:\n  - {type: udp, bind: \"" + bind + "\", target: \"" + udpEcho(t) + "\", udp_queue: 8, udp_drop: head}\n"})
+	checkDatagrams(t, dialMap(t, "udp", bind))
+}
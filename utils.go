@@ -3,6 +3,7 @@ package httpmux
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"net"
 	"strings"
 )
 
@@ -21,6 +22,14 @@ func RandString(n int) string {
 	return s
 }
 
+// hostOnly strips the port from a host:port address.
+func hostOnly(addr string) string {
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		return h
+	}
+	return addr
+}
+
 // SplitMap parses "bind->target".
 // bind can be "1412" or "0.0.0.0:1412"
 func SplitMap(s string) (bind string, target string, ok bool) {
@@ -0,0 +1,90 @@
+package httpmux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Host-based routing for HTTP maps (TCP maps, server)
+//
+//   maps:
+//     - type: tcp
+//       bind: "80"
+//       target: "127.0.0.1:8080"          # no Host or an unlisted one
+//       vhosts:
+//         "blog.example.com": "127.0.0.1:2368"
+//         "*.apps.example.com": "10.0.0.7:80"
+//
+// The plaintext sibling of tls_passthrough (maptls.go): the server
+// reads the visitor's first request head, picks the target by its Host
+// header and replays the head to it, so several web apps behind one
+// client share one public port. Names match like tls_passthrough's.
+// With tls: true the Host is read after the map's TLS is terminated.
+//
+// The choice is made once per connection: a keep-alive connection
+// that later asks for another Host still goes to the first target.
+// Browsers keep to one host per connection, so in practice it holds.
+// ═══════════════════════════════════════════════════════════════
+
+const maxVHostHead = 16 * 1024
+
+var errVHostHead = errors.New("no request head")
+
+// readHTTPHost reads up to the end of the request head and returns
+// what it read and the Host header's name, lower case without port.
+func readHTTPHost(r io.Reader) ([]byte, string, error) {
+	buf := make([]byte, 0, 1024)
+	chunk := make([]byte, 1024)
+	for len(buf) < maxVHostHead {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if end := bytes.Index(buf, []byte("\r\n\r\n")); end >= 0 {
+			return buf, headHost(buf[:end]), nil
+		}
+		if err != nil {
+			return buf, "", err
+		}
+	}
+	return buf, "", errVHostHead
+}
+
+func headHost(head []byte) string {
+	lines := strings.Split(string(head), "\r\n")
+	for _, line := range lines[1:] {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "host") {
+			continue
+		}
+		host := strings.TrimSpace(v)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		return strings.ToLower(strings.TrimSuffix(host, "."))
+	}
+	return ""
+}
+
+// mapVHost routes a vhosts: visitor by its Host. It returns the conn
+// to relay and its target; ok is false when the visitor should be
+// dropped.
+func (s *Server) mapVHost(conn net.Conn, pm *PortMap, target string) (net.Conn, string, bool) {
+	if len(pm.VHosts) == 0 {
+		return conn, target, true
+	}
+	conn.SetReadDeadline(time.Now().Add(mapHelloTimeout))
+	head, host, err := readHTTPHost(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil && len(head) == 0 {
+		return nil, "", false
+	}
+	if err != nil {
+		s.stats.incError("map_vhost")
+	}
+	pc := &prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(head), conn)}
+	return pc, passthroughTarget(pm.VHosts, host, target), true
+}
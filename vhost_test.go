@@ -0,0 +1,75 @@
+package httpmux
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadHTTPHost(t *testing.T) {
+	req := "GET / HTTP/1.1\r\nUser-Agent: x\r\nHOST: Blog.Example.com:8080\r\n\r\nbody"
+	head, host, err := readHTTPHost(strings.NewReader(req))
+	if err != nil || host != "blog.example.com" {
+		t.Fatalf("host %q, %v", host, err)
+	}
+	if !strings.HasPrefix(req, string(head)) {
+		t.Fatalf("head %q isn't what was read", head)
+	}
+	if _, host, _ := readHTTPHost(strings.NewReader("GET / HTTP/1.0\r\n\r\n")); host != "" {
+		t.Fatalf("no Host header gave %q", host)
+	}
+}
+
+// httpNamed serves one request per connection, answering with name.
+func httpNamed(t *testing.T, name string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				r := bufio.NewReader(c)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == "\r\n" {
+						break
+					}
+				}
+				fmt.Fprintf(c, "HTTP/1.0 200 OK\r\n\r\n%s", name)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestMapVHosts(t *testing.T) {
+	bind := freeAddr(t)
+	startTunnel(t, tunnelOpts{server: fmt.Sprintf("maps:\n"+
+		"  - type: tcp\n    bind: %q\n    target: %q\n    vhosts:\n"+
+		"      \"blog.example.com\": %q\n      \"*.apps.example.com\": %q\n",
+		bind, httpNamed(t, "default"), httpNamed(t, "blog"), httpNamed(t, "apps"))})
+
+	for host, want := range map[string]string{
+		"blog.example.com":     "blog",
+		"a.apps.example.com":   "apps",
+		"other.example.com:80": "default",
+	} {
+		c := dialMap(t, "tcp", bind)
+		fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", host)
+		resp, _ := io.ReadAll(c)
+		if !strings.HasSuffix(string(resp), "\r\n\r\n"+want) {
+			t.Errorf("Host %s reached %q, want %s", host, resp, want)
+		}
+	}
+}
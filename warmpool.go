@@ -0,0 +1,216 @@
+package httpmux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// Warm target pools (exit side)
+//
+//   maps:
+//     - { type: tcp, bind: "80", target: "127.0.0.1:8080", warm_pool: 4 }
+//
+// Every visitor of a reverse map already pays one tunnel round trip;
+// dialing the target on the client adds another. For HTTP-like
+// backends with many short connections the client keeps warm_pool
+// connections to the target pre-dialed, and hands one out instead of
+// dialing. Each connection is still used for exactly one visitor —
+// reusing a backend connection across visitors would leak protocol
+// state between them — and idle ones are replaced after warmMaxIdle
+// so backend keep-alive timeouts never bite.
+//
+// The server marks such targets "tcp+warmN://"; the client sizes the
+// pool from N.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	warmMaxIdle    = 30 * time.Second
+	warmPoolUnused = 10 * time.Minute // drop a pool nobody used for this long
+	warmMaxSize    = 64
+)
+
+// warmTarget marks target for a warm pool of size n.
+func warmTarget(target string, n int) string {
+	return addTargetFlag(target, "warm"+strconv.Itoa(min(n, warmMaxSize)))
+}
+
+// splitWarmTarget strips the warm pool marker and returns its size.
+func splitWarmTarget(target string) (string, int) {
+	rest, v, ok := takeTargetFlag(target, "warm")
+	if !ok {
+		return target, 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return rest, 0
+	}
+	return rest, min(n, warmMaxSize)
+}
+
+type warmConn struct {
+	conn  net.Conn
+	since time.Time
+}
+
+type warmPool struct {
+	addr string
+
+	mu       sync.Mutex
+	size     int
+	idle     []warmConn
+	dialing  int
+	lastUsed time.Time
+	retired  bool
+}
+
+type warmPools struct {
+	mu    sync.Mutex
+	pools map[string]*warmPool
+}
+
+func newWarmPools() *warmPools {
+	return &warmPools{pools: map[string]*warmPool{}}
+}
+
+// dialWarm returns a pooled connection to addr, or dials one.
+func (c *Client) dialWarm(addr string, size int) (net.Conn, error) {
+	c.warm.mu.Lock()
+	p, ok := c.warm.pools[addr]
+	if !ok {
+		p = &warmPool{addr: addr}
+		c.warm.pools[addr] = p
+		go c.maintainWarm(p)
+	}
+	p.mu.Lock()
+	p.size = size
+	p.lastUsed = time.Now()
+	p.mu.Unlock()
+	c.warm.mu.Unlock()
+
+	conn := p.take()
+	go p.fill(c.life)
+	if conn != nil {
+		return conn, nil
+	}
+	return c.life.dial("tcp", addr, 10*time.Second)
+}
+
+// take returns a live idle connection, discarding stale ones.
+func (p *warmPool) take() net.Conn {
+	for {
+		p.mu.Lock()
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			return nil
+		}
+		w := p.idle[0]
+		p.idle = p.idle[1:]
+		p.mu.Unlock()
+		if time.Since(w.since) > warmMaxIdle {
+			w.conn.Close()
+			continue
+		}
+		if conn := checkWarm(w.conn); conn != nil {
+			return conn
+		}
+	}
+}
+
+// checkWarm makes sure the backend hasn't closed an idle connection.
+// A server-first protocol may have sent a banner; it is kept.
+func checkWarm(conn net.Conn) net.Conn {
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	n, err := conn.Read(buf)
+	conn.SetReadDeadline(time.Time{})
+	if n > 0 {
+		return &prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(buf[:n]), conn)}
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return conn
+	}
+	conn.Close()
+	return nil
+}
+
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (p *prefixConn) Read(b []byte) (int, error) { return p.r.Read(b) }
+
+// fill dials until the pool holds size idle connections.
+func (p *warmPool) fill(life *lifecycle) {
+	for !life.isClosing() {
+		p.mu.Lock()
+		if len(p.idle)+p.dialing >= p.size {
+			p.mu.Unlock()
+			return
+		}
+		p.dialing++
+		p.mu.Unlock()
+
+		conn, err := life.dial("tcp", p.addr, 10*time.Second)
+
+		p.mu.Lock()
+		p.dialing--
+		retired := p.retired
+		if err == nil && !retired {
+			p.idle = append(p.idle, warmConn{conn: conn, since: time.Now()})
+		}
+		p.mu.Unlock()
+		if err != nil {
+			return // the breaker and normal dials report target trouble
+		}
+		if retired {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// maintainWarm recycles stale idle connections and retires the pool
+// once nobody has used it for warmPoolUnused.
+func (c *Client) maintainWarm(p *warmPool) {
+	for c.life.sleep(warmMaxIdle / 3) {
+		c.warm.mu.Lock()
+		p.mu.Lock()
+		unused := time.Since(p.lastUsed) > warmPoolUnused
+		if unused {
+			p.retired = true
+			delete(c.warm.pools, p.addr)
+		}
+		c.warm.mu.Unlock()
+		var stale []net.Conn
+		fresh := p.idle[:0]
+		for _, w := range p.idle {
+			if unused || time.Since(w.since) > warmMaxIdle*2/3 {
+				stale = append(stale, w.conn)
+			} else {
+				fresh = append(fresh, w)
+			}
+		}
+		p.idle = fresh
+		p.mu.Unlock()
+		for _, conn := range stale {
+			conn.Close()
+		}
+		if unused {
+			return
+		}
+		p.fill(c.life)
+	}
+	p.mu.Lock()
+	p.retired = true
+	for _, w := range p.idle {
+		w.conn.Close()
+	}
+	p.idle = nil
+	p.mu.Unlock()
+}
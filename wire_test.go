@@ -0,0 +1,394 @@
+package httpmux
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdh"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Wire format: the framing layers on their own, over net.Pipe, so a
+// change to the bytes they produce fails here before it fails end to end.
+
+func TestEncryptedConnRoundTrip(t *testing.T) {
+	obfs := &ObfsConfig{Enabled: true, MinPadding: 8, MaxPadding: 64}
+	stealth := &StealthConfig{RandomPadding: true, MinPadding: 4, MaxPadding: 32, BurstSplit: true, MaxBurstSize: 2048}
+	cases := []struct {
+		name    string
+		psk     string
+		obfs    *ObfsConfig
+		stealth *StealthConfig
+	}{
+		{"aes", testPSK, nil, nil},
+		{"plain", "", nil, nil},
+		{"obfs padding", testPSK, obfs, nil},
+		{"stealth padding and burst split", testPSK, nil, stealth},
+		{"traffic model", testPSK, nil, &StealthConfig{TrafficModel: "web"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer a.Close()
+			defer b.Close()
+			ca, err := NewEncryptedConn(a, tc.psk, tc.obfs, tc.stealth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cb, err := NewEncryptedConn(b, tc.psk, tc.obfs, tc.stealth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce := randomBytes(t, 16)
+			ca.BindSession(nonce, false)
+			cb.BindSession(nonce, true)
+			for _, size := range []int{1, 5, 1000, 32 << 10} { // up to a full smux frame
+				want := randomBytes(t, size)
+				go ca.Write(want)
+				got := make([]byte, size)
+				if _, err := io.ReadFull(cb, got); err != nil {
+					t.Fatalf("%d bytes: %v", size, err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Fatalf("%d bytes: payload changed", size)
+				}
+			}
+		})
+	}
+}
+
+func TestEncryptedConnRejectsReplay(t *testing.T) {
+	nonce := randomBytes(t, 16)
+	var wire bytes.Buffer
+	w, _ := NewEncryptedConn(&bufConn{w: &wire}, testPSK, nil)
+	w.BindSession(nonce, false)
+	w.Write([]byte("first"))
+	first := append([]byte(nil), wire.Bytes()...)
+	w.Write([]byte("second"))
+
+	// The first packet sent twice: the copy must not decrypt.
+	replayed := append(append([]byte(nil), first...), first...)
+	r, _ := NewEncryptedConn(&bufConn{r: bytes.NewReader(replayed)}, testPSK, nil)
+	r.BindSession(nonce, true)
+	buf := make([]byte, 64)
+	if n, err := r.Read(buf); err != nil || string(buf[:n]) != "first" {
+		t.Fatalf("first packet: %q %v", buf[:n], err)
+	}
+	if _, err := r.Read(buf); err == nil {
+		t.Fatal("replayed packet accepted")
+	}
+
+	// Same packets into a session with another nonce.
+	other, _ := NewEncryptedConn(&bufConn{r: bytes.NewReader(wire.Bytes())}, testPSK, nil)
+	other.BindSession(randomBytes(t, 16), true)
+	if _, err := other.Read(buf); err == nil {
+		t.Fatal("packet accepted in another session")
+	}
+
+	// Reflected back to the sender.
+	self, _ := NewEncryptedConn(&bufConn{r: bytes.NewReader(wire.Bytes())}, testPSK, nil)
+	self.BindSession(nonce, false)
+	if _, err := self.Read(buf); err == nil {
+		t.Fatal("packet accepted in the direction it was sent")
+	}
+}
+
+func TestEncryptedConnWrongKey(t *testing.T) {
+	var wire bytes.Buffer
+	w, _ := NewEncryptedConn(&bufConn{w: &wire}, testPSK, nil)
+	w.Write([]byte("secret"))
+	if bytes.Contains(wire.Bytes(), []byte("secret")) {
+		t.Fatal("plaintext on the wire")
+	}
+	r, _ := NewEncryptedConn(&bufConn{r: bytes.NewReader(wire.Bytes())}, "another-psk", nil)
+	if _, err := r.Read(make([]byte, 64)); err == nil {
+		t.Fatal("packet decrypted with the wrong psk")
+	}
+}
+
+func TestFragmentedConnSplitsFirstWrite(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	fc := &FragmentedConn{Conn: a, fragmentSize: 40, delay: time.Millisecond}
+	hello := randomBytes(t, 300)
+	go func() {
+		fc.Write(hello)
+		fc.Write([]byte("after"))
+	}()
+
+	// net.Pipe hands over each Write separately, so reads show the cut.
+	buf := make([]byte, 1024)
+	n, _ := b.Read(buf)
+	if n != 40 {
+		t.Fatalf("first fragment is %d bytes, want 40", n)
+	}
+	rest := make([]byte, len(hello)-40)
+	if _, err := io.ReadFull(b, rest); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(buf[:40:40], rest...), hello) {
+		t.Fatal("fragments don't reassemble to the original write")
+	}
+	if n, _ := b.Read(buf); string(buf[:n]) != "after" {
+		t.Fatalf("later write was split: %q", buf[:n])
+	}
+}
+
+func TestDatagramFraming(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	da, db := newDatagramConn(a), newDatagramConn(b)
+	sizes := []int{0, 1, 1400, 65000}
+	go func() {
+		for _, n := range sizes {
+			da.Write(bytes.Repeat([]byte{byte(n)}, n))
+		}
+	}()
+	buf := make([]byte, 65535)
+	for _, want := range sizes {
+		n, err := db.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Fatalf("datagram of %d bytes read as %d", want, n)
+		}
+	}
+}
+
+func TestStreamTags(t *testing.T) {
+	t.Run("forward", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+		go openTargetStream(&pipeSession{conn: a}, "tcp://example.com:443")
+		checkStreamHeader(t, b, StreamTypeForward, "tcp://example.com:443")
+	})
+	t.Run("reverse", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+		s := &Server{}
+		ss := &serverSession{sess: &pipeSession{conn: a}}
+		go s.openReverseStreamOn(ss, "udp://10.0.0.1:53")
+		checkStreamHeader(t, b, StreamTypeReverse, "udp://10.0.0.1:53")
+	})
+}
+
+// checkStreamHeader reads [1B tag][2B len][target] from c.
+func checkStreamHeader(t *testing.T, c net.Conn, tag byte, target string) {
+	t.Helper()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	hdr := make([]byte, 3)
+	if _, err := io.ReadFull(c, hdr); err != nil {
+		t.Fatal(err)
+	}
+	if hdr[0] != tag {
+		t.Fatalf("stream tag 0x%02x, want 0x%02x", hdr[0], tag)
+	}
+	got := make([]byte, binary.BigEndian.Uint16(hdr[1:]))
+	if _, err := io.ReadFull(c, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != target {
+		t.Fatalf("target %q, want %q", got, target)
+	}
+}
+
+func TestTargetFlags(t *testing.T) {
+	target := addTargetFlag(addTargetFlag("tcp://10.0.0.1:22", "keep"), "warm4")
+	if target != "tcp+keep+warm4://10.0.0.1:22" {
+		t.Fatalf("flags: %s", target)
+	}
+	rest, v, ok := takeTargetFlag(target, "warm")
+	if !ok || v != "4" || rest != "tcp+keep://10.0.0.1:22" {
+		t.Fatalf("take warm: %s %q %v", rest, v, ok)
+	}
+	if _, _, ok := takeTargetFlag(rest, "bond"); ok {
+		t.Fatal("took a flag that isn't there")
+	}
+	if n, a := splitTarget("udp://[::1]:53"); n != "udp" || a != "[::1]:53" {
+		t.Fatalf("splitTarget: %s %s", n, a)
+	}
+	if n, a := splitTarget("10.0.0.1:80"); n != "tcp" || !strings.HasSuffix(a, ":80") {
+		t.Fatalf("splitTarget: %s %s", n, a)
+	}
+}
+
+// ──────────── Fakes ────────────
+
+// pipeSession is a muxSession whose one stream is conn.
+type pipeSession struct{ conn net.Conn }
+
+func (p *pipeSession) OpenStream() (net.Conn, error)   { return p.conn, nil }
+func (p *pipeSession) AcceptStream() (net.Conn, error) { return nil, io.EOF }
+func (p *pipeSession) NumStreams() int                 { return 1 }
+func (p *pipeSession) IsClosed() bool                  { return false }
+func (p *pipeSession) Close() error                    { return p.conn.Close() }
+
+// bufConn is a net.Conn reading from r and writing to w.
+type bufConn struct {
+	net.Conn // nil; only Read and Write are used
+	r        io.Reader
+	w        io.Writer
+}
+
+func (c *bufConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *bufConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func TestTargetFeatures(t *testing.T) {
+	cases := map[string]string{
+		"tcp://10.0.0.1:22":                          "",
+		"udp://10.0.0.1:53":                          "datagram",
+		"tcp+keep+compress-zstd://10.0.0.1:143":      "keep,compress",
+		"tcp+from1-1.2.3.4:5,6.7.8.9:80://x:80":      "from",
+		"bond://ab12/0/2/tcp+resume30://10.0.0.1:22": "bond,resume",
+		"echo://": "echo",
+	}
+	for target, want := range cases {
+		if got := strings.Join(targetFeatures(target), ","); got != want {
+			t.Errorf("%s: %q, want %q", target, got, want)
+		}
+	}
+
+	var legacy *sessionInfo
+	if f := legacy.lacks("tcp+compress-zstd://x:1"); f != "" {
+		t.Fatalf("legacy peer lacks %s", f)
+	}
+	small := parseSessionInfo(sessionInfo{Proto: 1, Features: []string{featDatagram}}.encode())
+	if f := small.lacks("udp://x:53"); f != "" {
+		t.Fatalf("peer announcing datagram lacks %s", f)
+	}
+	if f := small.lacks("tcp+compress-zstd://x:1"); f != featCompress {
+		t.Fatalf("peer without compress: lacks %q", f)
+	}
+	// Optional flags don't rule a peer out; they are dropped for it.
+	if f := small.lacks("tcp+prio-bulk://x:1"); f != "" {
+		t.Fatalf("peer without prio: lacks %q", f)
+	}
+	if got := small.fitTarget("tcp+keep+prio-bulk://x:1"); got != "tcp+keep://x:1" {
+		t.Fatalf("fitTarget = %q", got)
+	}
+	var current sessionInfo
+	protoAnnounce(&current)
+	if got := current.fitTarget("tcp+prio-high://x:1"); got != "tcp+prio-high://x:1" {
+		t.Fatalf("fitTarget stripped a supported flag: %q", got)
+	}
+
+	// A server with discovery off doesn't offer it.
+	var srv Server
+	off := sessionInfo{Proto: protoVersion, Features: srv.features()}
+	if f := off.lacks(discoveryTarget); f != featDiscovery {
+		t.Fatalf("discovery off: lacks %q", f)
+	}
+	srv.discovery = &discoveryRelay{}
+	on := sessionInfo{Proto: protoVersion, Features: srv.features()}
+	if f := on.lacks(discoveryTarget); f != "" || len(on.Features) != len(protoFeatures) {
+		t.Fatalf("discovery on: lacks %q, features %v", f, on.Features)
+	}
+}
+
+func TestUpgradeResponse(t *testing.T) {
+	const key = "dGhlIHNhbXBsZSBub25jZQ==" // RFC 6455 section 1.3
+	orders := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		raw := upgradeResponse(key, true)
+		resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkUpgradeResponse(resp, key); err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Fatalf("accept %q", got)
+		}
+		var names []string
+		for _, line := range strings.Split(raw, "\r\n")[1:] {
+			if name, _, ok := strings.Cut(line, ":"); ok {
+				names = append(names, name)
+			}
+		}
+		orders[strings.Join(names[:3], ",")] = true
+	}
+	if len(orders) < 2 {
+		t.Errorf("20 answers all started with the same headers: %v", orders)
+	}
+}
+
+func TestAuthTime(t *testing.T) {
+	const skew = time.Minute
+	creds := []authCredential{{psk: "other"}, {psk: testPSK}}
+
+	// In step: both ends derive the same key.
+	hello, _ := newServerHello()
+	cc, sc := net.Pipe()
+	done := make(chan []byte, 1)
+	go func() {
+		defer sc.Close()
+		sc.Write(hello.bytes())
+		_, _, key, err := serverVerifyAuth(sc, creds, []byte{encModeAES}, hello, skew)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- key
+	}()
+	_, key, err := clientAuthenticate(cc, testPSK, encModeAES, skew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := <-done; !bytes.Equal(key, want) {
+		t.Fatal("keys differ")
+	}
+
+	// A client 3h behind: a valid proof, refused for the time, and
+	// the server's time still sent back.
+	hello, _ = newServerHello()
+	cc, sc = net.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		defer sc.Close()
+		_, _, _, err := serverVerifyAuth(sc, creds, []byte{encModeAES}, hello, skew)
+		errc <- err
+	}()
+	cpub := hello.priv.PublicKey().Bytes() // any key; the proof is what counts
+	stamp := timeStamp(time.Now().Add(-3 * time.Hour))
+	msg := append(append([]byte(nil), cpub...), maskTime(stamp, []byte(testPSK), hello.nonce)...)
+	msg = append(msg, authProof(testPSK, hello.nonce, hello.priv.PublicKey().Bytes(), cpub, encModeAES, stamp)...)
+	go cc.Write(msg)
+	reply := make([]byte, authTimeSize)
+	if _, err := io.ReadFull(cc, reply); err != nil {
+		t.Fatalf("no time back: %v", err)
+	}
+	var se *clockSkewError
+	if err := <-errc; !errors.As(err, &se) || se.offset > -3*time.Hour+time.Minute {
+		t.Fatalf("got %v, want the client 3h behind", err)
+	}
+	if !strings.Contains(se.Error(), "client clock is 3h0m") {
+		t.Errorf("message %q", se.Error())
+	}
+
+	// A server 2h ahead, seen from the client.
+	hello, _ = newServerHello()
+	cc, sc = net.Pipe()
+	go func() {
+		defer sc.Close()
+		sc.Write(hello.bytes())
+		buf := make([]byte, authPubSize+authTimeSize+authProofSize)
+		io.ReadFull(sc, buf)
+		peer, _ := ecdh.X25519().NewPublicKey(buf[:authPubSize])
+		shared, _ := hello.priv.ECDH(peer)
+		key := sessionKey(testPSK, shared, hello.nonce)
+		sc.Write(maskTime(timeStamp(time.Now().Add(2*time.Hour)), key, nil))
+	}()
+	_, _, err = clientAuthenticate(cc, testPSK, encModeAES, skew)
+	if !errors.As(err, &se) || se.peer != "server" || se.offset < 2*time.Hour-time.Minute {
+		t.Fatalf("got %v, want the server 2h ahead", err)
+	}
+}
@@ -0,0 +1,293 @@
+package httpmux
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ═══════════════════════════════════════════════════════════════
+// xhttp transport (split upload/download)
+//
+//   transport: xhttpmux     # plain HTTP, both ends
+//   transport: xhttpsmux    # TLS, both ends
+//
+// For networks that block WebSocket upgrades but let ordinary HTTP
+// streaming through, like v2ray's splithttp/xhttp. The client opens
+// two plain HTTP/1.1 requests to the tunnel path, tagged with the
+// same random id in the query:
+//
+//   GET  /search?…&sid=<id>  download: an endless text/event-stream
+//   POST /search?…&sid=<id>  upload: an endless chunked request body
+//
+// The server pairs them and runs the usual auth → encryption → mux
+// stack with reads from the POST body and writes to the GET response.
+// Each half waits up to xhttpPairTimeout for the other. Servers only
+// answer this on an xhttpmux/xhttpsmux transport; WebSocket-upgrade
+// clients keep working there. CDNs that buffer request bodies won't
+// carry the upload; use h2mux behind those.
+// ═══════════════════════════════════════════════════════════════
+
+const (
+	xhttpIDParam      = "sid"
+	xhttpPairTimeout  = 15 * time.Second
+	xhttpHandshakeTTL = 15 * time.Second
+)
+
+func isXHTTP(transport string) bool {
+	return transport == "xhttpmux" || transport == "xhttpsmux"
+}
+
+func validXHTTPID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// ──────────── Client ────────────
+
+// clientXHTTPTunnel uses conn for the download and dials a second
+// connection for the upload.
+func clientXHTTPTunnel(conn net.Conn, dial func() (net.Conn, error), cfg *MimicConfig, stealth *StealthConfig) (net.Conn, error) {
+	var idb [16]byte
+	rand.Read(idb[:])
+	id := hex.EncodeToString(idb[:])
+	domain, ua, fullURL := mimicRequest(cfg, stealth)
+	sep := "?"
+	if strings.Contains(fullURL, "?") {
+		sep = "&"
+	}
+	fullURL += sep + xhttpIDParam + "=" + id
+
+	get, err := http.NewRequest(http.MethodGet, fullURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	get.Host = domain
+	get.Header.Set("User-Agent", ua)
+	get.Header.Set("Accept", "text/event-stream")
+	get.Header.Set("Cache-Control", "no-cache")
+
+	conn.SetDeadline(time.Now().Add(xhttpHandshakeTTL))
+	if err := get.Write(conn); err != nil {
+		return nil, err
+	}
+
+	up, err := dial()
+	if err != nil {
+		return nil, fmt.Errorf("upload dial: %w", err)
+	}
+	head := "POST " + get.URL.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + domain + "\r\n" +
+		"User-Agent: " + ua + "\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n"
+	up.SetWriteDeadline(time.Now().Add(xhttpHandshakeTTL))
+	if _, err := io.WriteString(up, head); err != nil {
+		up.Close()
+		return nil, err
+	}
+	up.SetWriteDeadline(time.Time{})
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, get)
+	conn.SetDeadline(time.Time{})
+	if err == nil {
+		err = checkFraming(resp.Header)
+	}
+	if err != nil {
+		up.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		up.Close()
+		return nil, fmt.Errorf("xhttp: status %d", resp.StatusCode)
+	}
+	return &xhttpClientConn{Conn: conn, body: resp.Body, up: up}, nil
+}
+
+// xhttpClientConn reads the download body and writes upload chunks.
+type xhttpClientConn struct {
+	net.Conn // download
+	body     io.ReadCloser
+	up       net.Conn
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+func (c *xhttpClientConn) Read(p []byte) (int, error) { return c.body.Read(p) }
+
+// Write sends p as one chunk in a single write.
+func (c *xhttpClientConn) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil // a zero chunk would end the body
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	b := strconv.AppendInt(c.wbuf[:0], int64(len(p)), 16)
+	b = append(b, '\r', '\n')
+	b = append(b, p...)
+	b = append(b, '\r', '\n')
+	c.wbuf = b
+	if _, err := c.up.Write(b); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *xhttpClientConn) Close() error {
+	c.up.Close()
+	c.body.Close()
+	return c.Conn.Close()
+}
+
+func (c *xhttpClientConn) SetDeadline(t time.Time) error {
+	c.up.SetDeadline(t)
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *xhttpClientConn) SetWriteDeadline(t time.Time) error { return c.up.SetWriteDeadline(t) }
+
+// ──────────── Server ────────────
+
+type xhttpUpload struct {
+	r  *http.Request
+	rc *http.ResponseController
+}
+
+// xhttpPair joins the two halves of one tunnel.
+type xhttpPair struct {
+	upload  chan xhttpUpload // the POST, handed to the GET side
+	claimed chan struct{}    // GET side took the upload
+	done    chan struct{}    // session over; the POST handler may return
+}
+
+type xhttpPairs struct {
+	mu   sync.Mutex
+	byID map[string]*xhttpPair
+}
+
+func newXHTTPPairs() *xhttpPairs {
+	return &xhttpPairs{byID: map[string]*xhttpPair{}}
+}
+
+func (x *xhttpPairs) get(id string) *xhttpPair {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	p := x.byID[id]
+	if p == nil {
+		p = &xhttpPair{
+			upload:  make(chan xhttpUpload, 1),
+			claimed: make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		x.byID[id] = p
+	}
+	return p
+}
+
+func (x *xhttpPairs) remove(id string, p *xhttpPair) {
+	x.mu.Lock()
+	if x.byID[id] == p {
+		delete(x.byID, id)
+	}
+	x.mu.Unlock()
+}
+
+func (s *Server) handleXHTTP(w http.ResponseWriter, r *http.Request, id string) {
+	if !validXHTTPID(id) || !s.validHost(r.Host) {
+		s.rejectUpgrade(w, r, "bad_xhttp")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.xhttpDownload(w, r, id)
+	case http.MethodPost:
+		s.xhttpUpload(w, r, id)
+	default:
+		s.rejectUpgrade(w, r, "bad_method")
+	}
+}
+
+// xhttpDownload runs the session once the upload half arrives.
+func (s *Server) xhttpDownload(w http.ResponseWriter, r *http.Request, id string) {
+	p := s.xhttp.get(id)
+	defer s.xhttp.remove(id, p)
+
+	hello, err := newServerHello()
+	if err != nil {
+		return
+	}
+	wrc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(hello.bytes()); err != nil {
+		return
+	}
+	if err := wrc.Flush(); err != nil {
+		return
+	}
+
+	t := time.NewTimer(xhttpPairTimeout)
+	defer t.Stop()
+	var up xhttpUpload
+	select {
+	case up = <-p.upload:
+	case <-t.C:
+		s.stats.incError("xhttp_unpaired")
+		return
+	case <-r.Context().Done():
+		return
+	}
+	close(p.claimed)
+	defer close(p.done)
+
+	conn := newHTTPStreamConn(up.r, up.rc, w, wrc)
+	conn.remote = httpAddr(r.RemoteAddr)
+	s.serveTunnel(conn, r.RemoteAddr, hello)
+	conn.Close() // no writes may follow the handler's return
+}
+
+// xhttpUpload hands the request body to the download half and holds
+// the request open until the session ends.
+func (s *Server) xhttpUpload(w http.ResponseWriter, r *http.Request, id string) {
+	p := s.xhttp.get(id)
+	select {
+	case p.upload <- xhttpUpload{r: r, rc: http.NewResponseController(w)}:
+	default:
+		logDedupf("xhttp_dup", "[XHTTP] %s: second upload for one tunnel", r.RemoteAddr)
+		s.rejectUpgrade(w, r, "bad_xhttp")
+		return
+	}
+	t := time.NewTimer(xhttpPairTimeout)
+	defer t.Stop()
+	select {
+	case <-p.claimed:
+	case <-t.C:
+		s.stats.incError("xhttp_unpaired")
+	case <-r.Context().Done():
+	}
+	// Take the body back unless the download side already has it.
+	select {
+	case <-p.upload:
+		s.xhttp.remove(id, p)
+		return
+	default:
+	}
+	<-p.claimed
+	<-p.done
+}